@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"MyChatServer/internal/database"
+)
+
+const adminUsage = `usage: server admin <grant|revoke> <email>
+
+Grants or revokes the admin role, which is needed for the
+/api/admin endpoints. Storage is selected as for the server itself.`
+
+// runAdmin implements the "admin" subcommand.
+func runAdmin(ctx context.Context, args []string) {
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		os.Exit(2)
+	}
+
+	db, _, closeStorage := openStorage(ctx)
+	defer closeStorage()
+
+	user, err := db.Users().GetByEmail(ctx, args[1])
+	if err != nil {
+		log.Fatalf("Failed to find user %s: %v", args[1], err)
+	}
+
+	roles := []string{}
+	for _, role := range user.Roles {
+		if role != database.RoleAdmin {
+			roles = append(roles, role)
+		}
+	}
+	if args[0] == "grant" {
+		roles = append(roles, database.RoleAdmin)
+	}
+	user.Roles = roles
+
+	if err := db.Users().Save(ctx, user); err != nil {
+		log.Fatalf("Failed to save user: %v", err)
+	}
+	log.Printf("User %s (%s) roles: %v", user.Email, user.UID, user.Roles)
+}
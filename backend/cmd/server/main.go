@@ -7,10 +7,13 @@ import (
 	"os"
 	"time"
 
+	"MyChatServer/internal/admin"
+	"MyChatServer/internal/attachment"
 	"MyChatServer/internal/authentication"
 	"MyChatServer/internal/chat"
 	"MyChatServer/internal/contact"
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/delta"
 	"MyChatServer/internal/registration"
 	"MyChatServer/internal/websocket"
 
@@ -20,25 +23,33 @@ import (
 )
 
 // Entry point of the chat server application.
-// Initializes Firebase, sets up Echo web framework routes
-// and starts WebSocket + REST API server.
+// Initializes storage (see openStorage), sets up Echo web framework
+// routes and starts WebSocket + REST API server.
+// "server migrate ..." manages the SQL schema, "server admin ..."
+// grants the admin role and "server reindex" rebuilds the message
+// search index instead of serving.
 func main() {
 	ctx := context.Background()
 
-	apiKey := os.Getenv("FIREBASE_API_KEY")
-	if apiKey == "" {
-		log.Fatal("FIREBASE_API_KEY environment variable is required")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(ctx, os.Args[2:])
+		return
 	}
 
-	db, err := database.NewClient(ctx, "myChatAdminKey.json", apiKey)
-	if err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdmin(ctx, os.Args[2:])
+		return
 	}
-	defer db.Close()
 
-	log.Println("Firebase connected successfully!")
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		runReindex(ctx, os.Args[2:])
+		return
+	}
+
+	db, authenticator, closeStorage := openStorage(ctx)
+	defer closeStorage()
 
-	wsServer := websocket.NewServer(db)
+	wsServer := websocket.NewServer(db, authenticator)
 
 	e := echo.New()
 
@@ -70,35 +81,98 @@ func main() {
 		},
 	}))
 
+	requireAuth := authentication.RequireAuth(authenticator, db)
+	api := e.Group("/api", requireAuth)
+
 	e.GET("/ws", func(c echo.Context) error {
-		wsServer.HandleConnection(c.Response(), c.Request())
+		wsServer.HandleConnection(c.Response(), c.Request(), authentication.CurrentUser(c).Token)
 		return nil
-	})
+	}, authentication.RequireSocketAuth(authenticator, db))
 
-	regService := registration.NewService(db)
+	regService := registration.NewService(db, authenticator)
 	regHandler := registration.NewHandler(regService)
 	e.POST("/api/auth/register", regHandler.RegisterHandler)
 
-	authService := authentication.NewService(db)
+	authService := authentication.NewService(db, authenticator, wsServer)
 	authHandler := authentication.NewHandler(authService)
 	e.POST("/api/auth/login", authHandler.LoginHandler)
-	e.GET("/api/auth/initial-data", authHandler.VerifyAndGetChatsHandler)
-
-	chatService := chat.NewService(db, wsServer)
+	e.POST("/api/auth/refresh", authHandler.RefreshHandler)
+	api.GET("/auth/initial-data", authHandler.VerifyAndGetChatsHandler)
+	api.POST("/auth/logout", authHandler.LogoutHandler)
+	api.GET("/chats", authHandler.ListChatsHandler)
+	api.GET("/auth/sessions", authHandler.ListSessionsHandler)
+	api.DELETE("/auth/sessions", authHandler.RevokeAllSessionsHandler)
+	api.DELETE("/auth/sessions/:sessionId", authHandler.RevokeSessionHandler)
+
+	adminService := admin.NewService(db, authenticator, wsServer)
+	adminHandler := admin.NewHandler(adminService)
+	adminAPI := api.Group("/admin", authentication.RequireRole(database.RoleAdmin))
+	adminAPI.POST("/users/:uid/ban", adminHandler.BanUser)
+	adminAPI.DELETE("/users/:uid/ban", adminHandler.UnbanUser)
+
+	attachmentService := attachment.NewService(db, openBlobStore())
+	defer attachmentService.Close()
+	wsServer.SetFileReleaser(attachmentService)
+
+	chatService := chat.NewService(db, authenticator, wsServer, attachmentService)
 	chatHandler := chat.NewHandler(chatService)
-	e.GET("/api/chats/:chatId/messages", chatHandler.GetMessages)
-
-	contactService := contact.NewContactService(db)
+	api.GET("/chats/:chatId/messages", chatHandler.GetMessages)
+	api.POST("/chats/:chatId/messages/forward", chatHandler.ForwardMessages)
+	api.POST("/chats/:chatId/messages/image", chatHandler.SendImage)
+	api.PATCH("/chats/:chatId/messages/:messageId", chatHandler.EditMessage)
+	api.DELETE("/chats/:chatId/messages/:messageId", chatHandler.DeleteMessage)
+	api.GET("/chats/:chatId/messages/:messageId/edits", chatHandler.GetMessageEdits)
+	api.GET("/chats/:chatId/messages/:messageId/thread", chatHandler.GetThread)
+	api.POST("/chats/:chatId/messages/:messageId/listened", chatHandler.MarkListened)
+	api.POST("/chats/:chatId/messages/:messageId/pin", chatHandler.PinMessage)
+	api.DELETE("/chats/:chatId/messages/:messageId/pin", chatHandler.UnpinMessage)
+
+	attachmentHandler := attachment.NewHandler(attachmentService)
+	api.POST("/chats/:chatId/attachments", attachmentHandler.Upload)
+	api.POST("/chats/:chatId/attachments/voice", attachmentHandler.UploadVoice)
+	api.GET("/chats/:chatId/attachments/:attachmentId", attachmentHandler.Download)
+	api.GET("/chats/:chatId/attachments/:attachmentId/thumbnail", attachmentHandler.Thumbnail)
+
+	contactService := contact.NewContactService(db, authenticator)
 	contactHandler := contact.NewContactHandler(contactService)
 
-	e.GET("/api/contacts", contactHandler.GetContacts)
-	e.POST("/api/contacts", contactHandler.AddContact)
-	e.DELETE("/api/contacts/:contactId", contactHandler.DeleteContact)
-	e.GET("/api/contacts/search", contactHandler.SearchUsers)
-	e.GET("/api/chats/contacts", chatHandler.GetUserContacts)
-
-	e.POST("/api/chats/create-from-contacts", chatHandler.CreateChatFromContacts)
-	e.POST("/api/chats/create-private/:contactId", chatHandler.CreatePrivateChat)
+	api.GET("/contacts", contactHandler.GetContacts)
+	api.POST("/contacts", contactHandler.AddContact)
+	api.DELETE("/contacts/:contactId", contactHandler.DeleteContact)
+	api.GET("/contacts/search", contactHandler.SearchUsers)
+	api.GET("/chats/contacts", chatHandler.GetUserContacts)
+
+	api.POST("/chats/create-from-contacts", chatHandler.CreateChatFromContacts)
+	api.POST("/chats/create-private/:contactId", chatHandler.CreatePrivateChat)
+	api.PATCH("/chats/:chatId", chatHandler.RenameChat)
+	api.DELETE("/chats/:chatId", chatHandler.DeleteChat)
+	api.POST("/chats/:chatId/owner", chatHandler.TransferOwnership)
+	api.POST("/chats/:chatId/leave", chatHandler.LeaveChat)
+	api.POST("/chats/:chatId/participants", chatHandler.AddParticipants)
+	api.DELETE("/chats/:chatId/participants/:userId", chatHandler.RemoveParticipant)
+	api.PUT("/chats/:chatId/participants/:userId/role", chatHandler.SetRole)
+	api.POST("/chats/:chatId/invites", chatHandler.CreateInvite)
+	api.GET("/chats/:chatId/invites", chatHandler.ListInvites)
+	api.DELETE("/chats/:chatId/invites/:token", chatHandler.RevokeInvite)
+	api.POST("/chats/:chatId/invites/:token/requests/:userId", chatHandler.ApproveJoinRequest)
+	api.DELETE("/chats/:chatId/invites/:token/requests/:userId", chatHandler.DeclineJoinRequest)
+	api.POST("/chats/join/:token", chatHandler.JoinByInvite)
+	api.PUT("/chats/:chatId/handle", chatHandler.SetHandle)
+	api.POST("/chats/:chatId/subscription", chatHandler.Subscribe)
+	api.DELETE("/chats/:chatId/subscription", chatHandler.Unsubscribe)
+	api.POST("/chats/:chatId/messages/:messageId/view", chatHandler.ViewPosts)
+	api.POST("/channels", chatHandler.CreateChannel)
+	api.GET("/channels", chatHandler.SearchChannels)
+	api.GET("/channels/:handle", chatHandler.GetChannel)
+	api.GET("/chats/:chatId/settings", chatHandler.GetChatSettings)
+	api.PATCH("/chats/:chatId/settings", chatHandler.UpdateChatSettings)
+	api.PUT("/chats/pinned", chatHandler.ReorderPinned)
+
+	api.GET("/search/messages", chatHandler.SearchMessages)
+
+	syncService := delta.NewService(db)
+	syncHandler := delta.NewHandler(syncService)
+	api.GET("/sync", syncHandler.Sync)
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"MyChatServer/internal/database/sqlstore"
+)
+
+const migrateUsage = `usage: server migrate <up|down [steps]|status>
+
+Applies or rolls back SQL schema migrations. The database is selected by
+STORAGE_BACKEND ("postgres" or "sqlite") and DATABASE_URL.`
+
+// runMigrate implements the "migrate" subcommand.
+func runMigrate(ctx context.Context, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	driver := os.Getenv("STORAGE_BACKEND")
+	if driver != "postgres" && driver != "sqlite" {
+		log.Fatalf("STORAGE_BACKEND must be \"postgres\" or \"sqlite\" to run migrations, got %q", driver)
+	}
+
+	store := openSQLStore(ctx, driver)
+	defer store.Close()
+
+	migrator := sqlstore.NewMigrator(store)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed after %d applied: %v", applied, err)
+		}
+		log.Printf("Applied %d migration(s)", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed after %d rolled back: %v", rolledBack, err)
+		}
+		log.Printf("Rolled back %d migration(s)", rolledBack)
+
+	case "status":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read schema version: %v", err)
+		}
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list pending migrations: %v", err)
+		}
+
+		fmt.Printf("current version: %d\n", version)
+		for _, m := range pending {
+			fmt.Printf("pending: %04d_%s\n", m.Version, m.Name)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
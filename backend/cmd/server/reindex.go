@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"MyChatServer/internal/search"
+)
+
+const reindexUsage = `usage: server reindex
+
+Drops the full-text message index and rebuilds it from every chat's
+history. Storage is selected as for the server itself.`
+
+// runReindex implements the "reindex" subcommand.
+func runReindex(ctx context.Context, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, reindexUsage)
+		os.Exit(2)
+	}
+
+	db, _, closeStorage := openStorage(ctx)
+	defer closeStorage()
+
+	indexed, err := search.NewService(db).Rebuild(ctx)
+	if err != nil {
+		log.Fatalf("Reindex failed after %d message(s): %v", indexed, err)
+	}
+	log.Printf("Indexed %d message(s)", indexed)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"os"
+
+	"MyChatServer/internal/blob"
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+	"MyChatServer/internal/database/sqlstore"
+	"MyChatServer/internal/identity"
+)
+
+// openStorage selects the storage backend from STORAGE_BACKEND:
+//   - "firestore" (default) needs myChatAdminKey.json;
+//   - "memory" needs nothing, so the server can boot fully offline;
+//   - "postgres" and "sqlite" read DATABASE_URL.
+//
+// The identity provider is chosen separately by openIdentity.
+func openStorage(ctx context.Context) (database.Store, identity.Provider, func()) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "memory":
+		log.Println("Using in-memory storage, all data will be lost on shutdown")
+		store := memory.NewStore()
+		provider, closeProvider := openIdentity(ctx, store, "local")
+		return store, provider, closeProvider
+
+	case "", "firestore":
+		db := openFirebase(ctx)
+		provider, closeProvider := openIdentity(ctx, db, "firebase")
+		return db, provider, func() {
+			closeProvider()
+			db.Close()
+		}
+
+	case "postgres", "sqlite":
+		store := openSQLStore(ctx, backend)
+
+		pending, err := sqlstore.NewMigrator(store).Pending(ctx)
+		if err != nil {
+			log.Fatalf("Failed to check schema version: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database schema is %d migration(s) behind, run \"server migrate up\" first", len(pending))
+		}
+
+		provider, closeProvider := openIdentity(ctx, store, "local")
+		return store, provider, func() {
+			closeProvider()
+			store.Close()
+		}
+
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q", backend)
+		return nil, nil, nil
+	}
+}
+
+// openIdentity selects the identity provider from AUTH_PROVIDER,
+// falling back to defaultProvider, and makes it reject banned users:
+//   - "firebase" uses Firebase Authentication and needs
+//     myChatAdminKey.json and FIREBASE_API_KEY;
+//   - "local" keeps password hashes in db and signs tokens with
+//     AUTH_JWT_SECRET, so no outside service is contacted.
+func openIdentity(ctx context.Context, db database.Store, defaultProvider string) (identity.Provider, func()) {
+	provider, closeProvider := openProvider(ctx, db, defaultProvider)
+	return identity.WithBans(provider, db), closeProvider
+}
+
+func openProvider(ctx context.Context, db database.Store, defaultProvider string) (identity.Provider, func()) {
+	name := os.Getenv("AUTH_PROVIDER")
+	if name == "" {
+		name = defaultProvider
+	}
+
+	switch name {
+	case "firebase":
+		if os.Getenv("FIREBASE_API_KEY") == "" {
+			log.Fatal("FIREBASE_API_KEY environment variable is required")
+		}
+		if client, ok := db.(*database.Client); ok {
+			return identity.NewFirebase(db, client.Auth, client.APIKey), func() {}
+		}
+		client := openFirebase(ctx)
+		return identity.NewFirebase(db, client.Auth, client.APIKey), func() { client.Close() }
+
+	case "local":
+		log.Println("Using built-in password authentication")
+		return identity.NewLocal(db, jwtSecret(db)), func() {}
+
+	default:
+		log.Fatalf("Unknown AUTH_PROVIDER %q", name)
+		return nil, nil
+	}
+}
+
+// jwtSecret reads AUTH_JWT_SECRET. In memory mode a random secret is
+// generated instead, since tokens cannot outlive the data anyway.
+func jwtSecret(db database.Store) []byte {
+	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	if _, ok := db.(*memory.Store); !ok {
+		log.Fatal("AUTH_JWT_SECRET environment variable is required for AUTH_PROVIDER=local")
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+func openFirebase(ctx context.Context) *database.Client {
+	db, err := database.NewClient(ctx, "myChatAdminKey.json", os.Getenv("FIREBASE_API_KEY"))
+	if err != nil {
+		log.Fatalf("Failed to initialize Firebase: %v", err)
+	}
+
+	log.Println("Firebase connected successfully!")
+	return db
+}
+
+func openSQLStore(ctx context.Context, driver string) *sqlstore.Store {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Fatal("DATABASE_URL environment variable is required")
+	}
+
+	store, err := sqlstore.Open(ctx, driver, dsn)
+	if err != nil {
+		log.Fatalf("Failed to open %s database: %v", driver, err)
+	}
+
+	log.Printf("Connected to %s database", driver)
+	return store
+}
+
+// openBlobStore selects where uploaded files are kept from BLOB_STORE:
+//   - "local" (default) writes under BLOB_DIR, "uploads" by default;
+//   - "s3" uses the bucket S3_BUCKET at S3_ENDPOINT in S3_REGION with
+//     S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY, and works with any
+//     S3-compatible service.
+func openBlobStore() blob.Store {
+	switch backend := os.Getenv("BLOB_STORE"); backend {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+
+		store, err := blob.NewLocalStore(dir)
+		if err != nil {
+			log.Fatalf("Failed to open blob store: %v", err)
+		}
+		return store
+
+	case "s3":
+		store, err := blob.NewS3Store(blob.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+		if err != nil {
+			log.Fatalf("Failed to open blob store: %v", err)
+		}
+		return store
+
+	default:
+		log.Fatalf("Unknown BLOB_STORE %q", backend)
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+	"MyChatServer/internal/identity"
+)
+
+func TestBanAndUnban(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := identity.WithBans(identity.NewLocal(store, []byte("test-secret")), store)
+	service := NewService(store, provider, nil)
+
+	store.Users().Save(ctx, &database.User{UID: "admin", Email: "admin@test.com", Roles: []string{database.RoleAdmin}})
+
+	uid, _ := provider.CreateUserInAuth(ctx, "bob@test.com", "secret123", "Bob")
+	store.Users().Save(ctx, &database.User{UID: uid, Email: "bob@test.com"})
+
+	signIn, err := provider.SignInWithPassword(ctx, "bob@test.com", "secret123", identity.Device{})
+	if err != nil {
+		t.Fatalf("SignInWithPassword: %v", err)
+	}
+
+	if _, err := service.Ban(ctx, "admin", "admin", BanRequest{}); !errors.Is(err, ErrBanSelf) {
+		t.Errorf("self Ban error = %v, want ErrBanSelf", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, err := service.Ban(ctx, "admin", uid, BanRequest{ExpiresAt: &past}); !errors.Is(err, ErrPastExpiry) {
+		t.Errorf("Ban with past expiry error = %v, want ErrPastExpiry", err)
+	}
+
+	until := time.Now().Add(time.Hour)
+	ban, err := service.Ban(ctx, "admin", uid, BanRequest{Reason: "spam", ExpiresAt: &until})
+	if err != nil || !ban.IsBanned || ban.BanReason != "spam" {
+		t.Fatalf("Ban = %+v, %v", ban, err)
+	}
+
+	var banErr *identity.BanError
+	if _, err := provider.ValidateIdToken(ctx, signIn.IDToken); !errors.As(err, &banErr) || banErr.Reason != "spam" {
+		t.Errorf("ValidateIdToken of banned user error = %v, want BanError with reason", err)
+	}
+	if _, err := provider.SignInWithPassword(ctx, "bob@test.com", "secret123", identity.Device{}); !errors.Is(err, identity.ErrUserBanned) {
+		t.Errorf("SignInWithPassword of banned user error = %v, want ErrUserBanned", err)
+	}
+
+	if _, err := service.Unban(ctx, uid); err != nil {
+		t.Fatalf("Unban: %v", err)
+	}
+	if _, err := provider.ValidateIdToken(ctx, signIn.IDToken); err != nil {
+		t.Errorf("ValidateIdToken after Unban: %v", err)
+	}
+}
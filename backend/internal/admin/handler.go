@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) BanUser(c echo.Context) error {
+	adminUID := authentication.CurrentUser(c).UID
+
+	var req BanRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	response, err := h.service.Ban(c.Request().Context(), adminUID, c.Param("uid"), req)
+	if err != nil {
+		return h.errorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) UnbanUser(c echo.Context) error {
+	response, err := h.service.Unban(c.Request().Context(), c.Param("uid"))
+	if err != nil {
+		return h.errorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) errorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrBanSelf), errors.Is(err, ErrPastExpiry):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	default:
+		log.Printf("Admin action error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Internal server error",
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/websocket"
+)
+
+var (
+	ErrBanSelf    = errors.New("cannot ban yourself")
+	ErrPastExpiry = errors.New("ban expiry is in the past")
+)
+
+// Service implements moderation actions. Its routes are restricted
+// to the database.RoleAdmin role by authentication.RequireRole.
+type Service struct {
+	db       database.Store
+	auth     identity.Provider
+	wsServer *websocket.Server
+}
+
+type BanRequest struct {
+	Reason string `json:"reason"`
+	// ExpiresAt is optional; without it the ban is permanent.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type BanResponse struct {
+	UID         string     `json:"uid"`
+	IsBanned    bool       `json:"is_banned"`
+	BanReason   string     `json:"ban_reason,omitempty"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+}
+
+func NewService(db database.Store, auth identity.Provider, wsServer *websocket.Server) *Service {
+	return &Service{db: db, auth: auth, wsServer: wsServer}
+}
+
+// Ban bans targetUID and drops the user's WebSocket right away;
+// the REST API rejects the user from the next request on.
+func (s *Service) Ban(ctx context.Context, adminUID, targetUID string, req BanRequest) (*BanResponse, error) {
+	if adminUID == targetUID {
+		return nil, ErrBanSelf
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrPastExpiry
+	}
+
+	user, err := s.db.Users().Get(ctx, targetUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user.IsBanned = true
+	user.BanReason = req.Reason
+	user.BannedUntil = time.Time{}
+	if req.ExpiresAt != nil {
+		user.BannedUntil = *req.ExpiresAt
+	}
+
+	if err := s.db.Users().Save(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to save user: %v", err)
+	}
+
+	if s.wsServer != nil {
+		s.wsServer.DisconnectUser(targetUID, websocket.WSEvent{
+			Type: "banned",
+			Data: map[string]interface{}{
+				"reason":       req.Reason,
+				"banned_until": req.ExpiresAt,
+			},
+		})
+	}
+
+	return banResponse(user), nil
+}
+
+func (s *Service) Unban(ctx context.Context, targetUID string) (*BanResponse, error) {
+	user, err := s.db.Users().Get(ctx, targetUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	user.IsBanned = false
+	user.BanReason = ""
+	user.BannedUntil = time.Time{}
+
+	if err := s.db.Users().Save(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to save user: %v", err)
+	}
+
+	return banResponse(user), nil
+}
+
+func banResponse(user *database.User) *BanResponse {
+	response := &BanResponse{
+		UID:       user.UID,
+		IsBanned:  user.IsBanned,
+		BanReason: user.BanReason,
+	}
+	if !user.BannedUntil.IsZero() {
+		until := user.BannedUntil
+		response.BannedUntil = &until
+	}
+	return response
+}
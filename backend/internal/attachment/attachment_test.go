@@ -0,0 +1,415 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/blob"
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+)
+
+func newTestService(t *testing.T) (*Service, *memory.Store) {
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	store := memory.NewStore()
+	return NewService(store, blobs), store
+}
+
+func TestUploadAndOpen(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	otherID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+
+	content := "<html><body>hi</body></html>"
+	uploaded, err := service.Upload(ctx, chatID, "u1", `C:\Users\me\page.html`, "application/octet-stream",
+		strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	if uploaded.Name != "page.html" || uploaded.Checksum != hex.EncodeToString(sum[:]) || uploaded.Size != int64(len(content)) {
+		t.Errorf("uploaded = %+v", uploaded)
+	}
+	if !strings.HasPrefix(uploaded.MIMEType, "text/html") {
+		t.Errorf("MIMEType = %q, want it sniffed as text/html", uploaded.MIMEType)
+	}
+
+	attachment, r, err := service.Open(ctx, chatID, "u2", uploaded.ID)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != content || attachment.ID != uploaded.ID {
+		t.Errorf("Open = %+v, %q", attachment, data)
+	}
+
+	tests := []struct {
+		name   string
+		chatID string
+		userID string
+		want   error
+	}{
+		{"stranger", chatID, "u3", ErrNotParticipant},
+		{"other chat", otherID, "u1", ErrNotFound},
+		{"missing chat", "missing", "u1", ErrNotParticipant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := service.Open(ctx, tt.chatID, tt.userID, uploaded.ID); !errors.Is(err, tt.want) {
+				t.Errorf("Open error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	uploaded, err := service.Upload(ctx, chatID, "u1", "a.txt", "text/plain", strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	// A forwarded copy shares the file.
+	copied := *uploaded
+	copied.ID = ""
+	store.Attachments().Create(ctx, &copied)
+
+	exists := func() bool {
+		r, err := service.blobs.Open(ctx, uploaded.Key)
+		if err != nil {
+			return false
+		}
+		r.Close()
+		return true
+	}
+
+	store.Attachments().Delete(ctx, uploaded.ID)
+	service.Release(ctx, []database.Attachment{*uploaded})
+	if !exists() {
+		t.Fatal("file removed while a copy still uses it")
+	}
+	if _, _, err := service.Open(ctx, chatID, "u1", uploaded.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open of a removed attachment = %v, want ErrNotFound", err)
+	}
+
+	store.Attachments().Delete(ctx, copied.ID)
+	service.Release(ctx, []database.Attachment{copied})
+	if exists() {
+		t.Error("file kept after its last attachment was removed")
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+
+	tests := []struct {
+		name   string
+		userID string
+		size   int64
+		want   error
+	}{
+		{"empty", "u1", 0, ErrEmptyFile},
+		{"too large", "u1", MaxSize + 1, ErrTooLarge},
+		{"stranger", "u2", 1, ErrNotParticipant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.Upload(ctx, chatID, tt.userID, "f.txt", "text/plain", strings.NewReader("x"), tt.size)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Upload error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	// Size flag, maximum AC value, four characters of DC colour and
+	// two per AC component.
+	hash := blurhash(img, 4, 3)
+	if len(hash) != 1+1+4+2*11 || hash[0] != 'L' {
+		t.Fatalf("blurhash = %q, want 28 characters for 4x3 components", hash)
+	}
+	if dc := hash[2:6]; dc != encode83(0xFF0000, 4) {
+		t.Errorf("DC = %q, want pure red %q", dc, encode83(0xFF0000, 4))
+	}
+	if portrait := blurhash(img, 3, 4); portrait[0] != encode83(2+3*9, 1)[0] {
+		t.Errorf("size flag of 3x4 = %c", portrait[0])
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	tests := []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{640, 480, 320, 240},
+		{480, 640, 240, 320},
+		{100, 50, 100, 50},
+		{2000, 1, 320, 1},
+	}
+	for _, tt := range tests {
+		got := scaleDown(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), thumbnailSize).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("scaleDown(%dx%d) = %dx%d, want %dx%d", tt.width, tt.height, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// gpsMarker stands in for coordinates in the test EXIF block.
+var gpsMarker = []byte("LATITUDE+LONGITUDE+ALT!!")
+
+// exifWithGPS builds a little-endian TIFF block whose IFD0 holds an
+// orientation tag and a pointer to a GPS IFD with one rational
+// triple stored out of line.
+func exifWithGPS() []byte {
+	tiff := make([]byte, 8+2+2*12+4+2+12+4+len(gpsMarker))
+	le := binary.LittleEndian
+	copy(tiff, "II*\x00")
+	le.PutUint32(tiff[4:], 8)
+
+	le.PutUint16(tiff[8:], 2)
+	entry := tiff[10:]
+	le.PutUint16(entry[0:], 0x0112) // Orientation
+	le.PutUint16(entry[2:], 3)
+	le.PutUint32(entry[4:], 1)
+	le.PutUint16(entry[8:], 6)
+	entry = tiff[22:]
+	gpsIFD := 38
+	le.PutUint16(entry[0:], gpsIFDTag)
+	le.PutUint16(entry[2:], 4)
+	le.PutUint32(entry[4:], 1)
+	le.PutUint32(entry[8:], uint32(gpsIFD))
+
+	le.PutUint16(tiff[gpsIFD:], 1)
+	entry = tiff[gpsIFD+2:]
+	value := gpsIFD + 2 + 12 + 4
+	le.PutUint16(entry[0:], 0x0002) // GPSLatitude
+	le.PutUint16(entry[2:], 5)
+	le.PutUint32(entry[4:], 3)
+	le.PutUint32(entry[8:], uint32(value))
+	copy(tiff[value:], gpsMarker)
+	return tiff
+}
+
+func TestStripLocation(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	plain := encoded.Bytes()
+
+	segment := func(marker byte, payload []byte) []byte {
+		header := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+		return append(header, payload...)
+	}
+	exif := append(append([]byte(nil), exifHeader...), exifWithGPS()...)
+	xmp := append(append([]byte(nil), xmpJPEG...), []byte("<x:xmpmeta>exif:GPSLatitude</x:xmpmeta>")...)
+
+	var withMeta []byte
+	withMeta = append(withMeta, plain[:2]...)
+	withMeta = append(withMeta, segment(0xE1, exif)...)
+	withMeta = append(withMeta, segment(0xE1, xmp)...)
+	withMeta = append(withMeta, plain[2:]...)
+
+	stripped, err := stripLocation("image/jpeg", withMeta)
+	if err != nil {
+		t.Fatalf("stripLocation: %v", err)
+	}
+	if bytes.Contains(stripped, gpsMarker) || bytes.Contains(stripped, []byte("GPSLatitude")) {
+		t.Error("location data survived stripping")
+	}
+	if !bytes.Contains(stripped, exifHeader) {
+		t.Error("EXIF block was dropped, want only its GPS data removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+
+	if _, err := stripLocation("image/jpeg", []byte("not a jpeg")); err == nil {
+		t.Error("stripLocation accepted a malformed JPEG")
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+	defer service.Close()
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 640, 400)))
+
+	uploaded, err := service.UploadImage(ctx, chatID, "u1", "photo.png", bytes.NewReader(encoded.Bytes()), int64(encoded.Len()))
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	if uploaded.MIMEType != "image/png" || uploaded.Width != 640 || uploaded.Height != 400 {
+		t.Errorf("uploaded = %+v, want a 640x400 PNG", uploaded)
+	}
+
+	done := make(chan *database.Attachment, 1)
+	if !service.ProcessImage(uploaded, func(a *database.Attachment) { done <- a }) {
+		t.Fatal("ProcessImage rejected the job")
+	}
+	processed := <-done
+	if processed.Thumbnail == nil || processed.Thumbnail.Width != 320 || processed.Thumbnail.Height != 200 || processed.Blurhash == "" {
+		t.Fatalf("processed = %+v, want a 320x200 thumbnail and a blurhash", processed)
+	}
+
+	if _, r, err := service.OpenThumbnail(ctx, chatID, "u1", uploaded.ID); err != nil {
+		t.Errorf("OpenThumbnail: %v", err)
+	} else {
+		r.Close()
+	}
+
+	text := "plain text"
+	if _, err := service.UploadImage(ctx, chatID, "u1", "a.txt", strings.NewReader(text), int64(len(text))); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("UploadImage(text) error = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := newPool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	p.submit(func() { close(started); <-release })
+	<-started
+	if !p.submit(func() {}) {
+		t.Error("submit rejected a job with room in the queue")
+	}
+	if p.submit(func() {}) {
+		t.Error("submit accepted a job with the queue full")
+	}
+
+	close(release)
+	p.close()
+	if p.submit(func() {}) {
+		t.Error("submit accepted a job after close")
+	}
+}
+
+// wav builds a mono 16-bit PCM recording at 8 kHz: silence for the
+// first half, a full-scale square wave for the second.
+func wav(seconds int) []byte {
+	const rate = 8000
+	samples := make([]byte, rate*seconds*2)
+	for i := len(samples) / 2; i+1 < len(samples); i += 2 {
+		v := int16(32767)
+		if (i/2)%2 == 1 {
+			v = -32767
+		}
+		binary.LittleEndian.PutUint16(samples[i:], uint16(v))
+	}
+
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("RIFF")
+	binary.Write(&b, le, uint32(36+len(samples)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, le, []uint32{16})
+	binary.Write(&b, le, []uint16{1, 1})
+	binary.Write(&b, le, []uint32{rate, rate * 2})
+	binary.Write(&b, le, []uint16{2, 16})
+	b.WriteString("data")
+	binary.Write(&b, le, uint32(len(samples)))
+	b.Write(samples)
+	return b.Bytes()
+}
+
+// oggPage wraps packets of at most 254 bytes into one Ogg page.
+func oggPage(granule int64, packets ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("OggS")
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.LittleEndian, granule)
+	b.Write(make([]byte, 12))
+	b.WriteByte(byte(len(packets)))
+	for _, p := range packets {
+		b.WriteByte(byte(len(p)))
+	}
+	for _, p := range packets {
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func TestParseAudio(t *testing.T) {
+	info, err := parseWAV(wav(2))
+	if err != nil {
+		t.Fatalf("parseWAV: %v", err)
+	}
+	if info.duration != 2*time.Second || len(info.waveform) != waveformLength {
+		t.Fatalf("WAV info = %v with %d bars, want 2s with %d", info.duration, len(info.waveform), waveformLength)
+	}
+	if info.waveform[0] != 0 || info.waveform[waveformLength-1] != waveformMax {
+		t.Errorf("WAV waveform = %v, want silence then full scale", info.waveform)
+	}
+
+	head := append([]byte("OpusHead\x01\x01"), 0x38, 0x01) // pre-skip 312
+	head = append(head, make([]byte, 7)...)
+	pages := oggPage(0, head)
+	pages = append(pages, oggPage(0, []byte("OpusTags"))...)
+	pages = append(pages, oggPage(312+48000, bytes.Repeat([]byte{1}, 10), bytes.Repeat([]byte{1}, 200))...)
+
+	info, err = parseOpus(pages)
+	if err != nil {
+		t.Fatalf("parseOpus: %v", err)
+	}
+	if info.duration != time.Second || len(info.waveform) != 2 || info.waveform[0] != 5 || info.waveform[1] != waveformMax {
+		t.Errorf("Opus info = %v %v, want 1s and [5 100]", info.duration, info.waveform)
+	}
+
+	if _, err := parseOpus(oggPage(0, []byte("vorbis"))); err == nil {
+		t.Error("parseOpus accepted a non-Opus stream")
+	}
+}
+
+func TestUploadVoice(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+
+	recording := wav(1)
+	voice, err := service.UploadVoice(ctx, chatID, "u1", "note.wav", bytes.NewReader(recording), int64(len(recording)))
+	if err != nil {
+		t.Fatalf("UploadVoice: %v", err)
+	}
+	if voice.Kind != database.AttachmentVoice || voice.MIMEType != "audio/wav" || voice.DurationMS != 1000 {
+		t.Errorf("voice = %+v, want a 1000 ms audio/wav recording", voice)
+	}
+
+	text := "not audio"
+	if _, err := service.UploadVoice(ctx, chatID, "u1", "a.txt", strings.NewReader(text), int64(len(text))); !errors.Is(err, ErrUnsupportedAudio) {
+		t.Errorf("UploadVoice(text) error = %v, want ErrUnsupportedAudio", err)
+	}
+}
@@ -0,0 +1,157 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// formOverhead is how much larger than MaxSize a multipart body may
+// be to fit boundaries and part headers.
+const formOverhead = 1 << 20
+
+type Response struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	Name      string    `json:"name"`
+	MIMEType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Kind       string `json:"kind,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	Waveform   []int  `json:"waveform,omitempty"`
+}
+
+// Upload handles POST /api/chats/:chatId/attachments with the file
+// in the "file" field of a multipart form.
+func (h *Handler) Upload(c echo.Context) error {
+	return h.upload(c, MaxSize, h.service.Upload)
+}
+
+// UploadVoice handles POST /api/chats/:chatId/attachments/voice with
+// an Ogg/Opus or WAV recording in the "file" field. The returned ID
+// is sent in attachment_ids of send_message.
+func (h *Handler) UploadVoice(c echo.Context) error {
+	return h.upload(c, MaxVoiceSize, func(ctx context.Context, chatID, userID, name, contentType string, r io.Reader, size int64) (*database.Attachment, error) {
+		return h.service.UploadVoice(ctx, chatID, userID, name, r, size)
+	})
+}
+
+type uploadFunc func(ctx context.Context, chatID, userID, name, contentType string, r io.Reader, size int64) (*database.Attachment, error)
+
+func (h *Handler) upload(c echo.Context, limit int64, store uploadFunc) error {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, limit+formOverhead)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "file is required",
+		})
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Failed to read file",
+		})
+	}
+	defer content.Close()
+
+	userID := authentication.CurrentUser(c).UID
+
+	attachment, err := store(c.Request().Context(), c.Param("chatId"), userID,
+		file.Filename, file.Header.Get("Content-Type"), content, file.Size)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, Response{
+		ID:         attachment.ID,
+		ChatID:     attachment.ChatID,
+		Name:       attachment.Name,
+		MIMEType:   attachment.MIMEType,
+		Size:       attachment.Size,
+		Checksum:   attachment.Checksum,
+		CreatedAt:  attachment.CreatedAt,
+		Kind:       attachment.Kind,
+		DurationMS: attachment.DurationMS,
+		Waveform:   attachment.Waveform,
+	})
+}
+
+// Download handles GET /api/chats/:chatId/attachments/:attachmentId.
+func (h *Handler) Download(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	attachment, content, err := h.service.Open(c.Request().Context(), c.Param("chatId"), userID, c.Param("attachmentId"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	defer content.Close()
+
+	return stream(c, content, attachment.MIMEType, attachment.Size, attachment.Name, attachment.Checksum)
+}
+
+// Thumbnail handles GET /api/chats/:chatId/attachments/:attachmentId/thumbnail.
+// It answers 404 until the thumbnail of an image has been generated.
+func (h *Handler) Thumbnail(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	attachment, content, err := h.service.OpenThumbnail(c.Request().Context(), c.Param("chatId"), userID, c.Param("attachmentId"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	defer content.Close()
+
+	thumbnail := attachment.Thumbnail
+	return stream(c, content, thumbnail.MIMEType, thumbnail.Size, "thumbnail-"+attachment.Name, attachment.Checksum+"-thumbnail")
+}
+
+func stream(c echo.Context, content io.Reader, mimeType string, size int64, name, etag string) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(size, 10))
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	header.Set("ETag", `"`+etag+`"`)
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	return c.Stream(http.StatusOK, mimeType, content)
+}
+
+func errorResponse(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrEmptyFile):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedImage), errors.Is(err, ErrUnsupportedAudio):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrNotParticipant), errors.Is(err, ErrNoPermission):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	}
+
+	return c.JSON(status, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,205 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrUnsupportedImage = errors.New("only JPEG, PNG and GIF images are supported")
+
+const (
+	// MaxImageSize is the largest file accepted by UploadImage. Images
+	// are held in memory while their metadata is cleaned.
+	MaxImageSize = 10 << 20
+	// maxImagePixels guards against small files that decode into
+	// huge bitmaps.
+	maxImagePixels = 40_000_000
+	// thumbnailSize bounds the longer side of a thumbnail.
+	thumbnailSize = 320
+	// blurhashSize bounds the image the placeholder is computed from.
+	blurhashSize = 32
+	// processTimeout bounds the background work for one image.
+	processTimeout = time.Minute
+)
+
+// UploadImage stores an image of the chat after removing location
+// data from it. Its dimensions are known right away; the thumbnail
+// and placeholder are produced later by ProcessImage.
+func (s *Service) UploadImage(ctx context.Context, chatID, userID, name string, r io.Reader, size int64) (*database.Attachment, error) {
+	if size > MaxImageSize {
+		return nil, ErrTooLarge
+	}
+	if size <= 0 {
+		return nil, ErrEmptyFile
+	}
+	if err := s.authorize(ctx, chatID, userID, database.PermSend); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+
+	mimeType := http.DetectContentType(data)
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrTooLarge
+	}
+
+	data, err = stripLocation(mimeType, data)
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	attachment := &database.Attachment{
+		ID:         database.NewID(),
+		ChatID:     chatID,
+		UploaderID: userID,
+		Name:       cleanName(name),
+		MIMEType:   mimeType,
+		Size:       int64(len(data)),
+		CreatedAt:  time.Now(),
+		Kind:       database.AttachmentImage,
+		Width:      config.Width,
+		Height:     config.Height,
+	}
+	if err := s.save(ctx, attachment, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// ProcessImage queues the thumbnail and placeholder of an image
+// attachment and calls done with the updated attachment when they are
+// stored. It reports false when the queue is full.
+func (s *Service) ProcessImage(attachment *database.Attachment, done func(*database.Attachment)) bool {
+	return s.workers.submit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), processTimeout)
+		defer cancel()
+
+		updated, err := s.processImage(ctx, attachment)
+		if err != nil {
+			log.Printf("Failed to process image %s: %v", attachment.ID, err)
+			return
+		}
+		done(updated)
+	})
+}
+
+func (s *Service) processImage(ctx context.Context, attachment *database.Attachment) (*database.Attachment, error) {
+	content, err := s.blobs.Open(ctx, attachment.Key)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(content)
+	content.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	thumb := scaleDown(img, thumbnailSize)
+	var encoded bytes.Buffer
+	thumbType := "image/png"
+	if attachment.MIMEType == "image/jpeg" {
+		thumbType = "image/jpeg"
+		err = jpeg.Encode(&encoded, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&encoded, thumb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+
+	key := "thumbnails/" + attachment.ChatID + "/" + attachment.ID
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(encoded.Bytes()), int64(encoded.Len()), thumbType); err != nil {
+		return nil, fmt.Errorf("failed to store thumbnail: %v", err)
+	}
+
+	xComponents, yComponents := 4, 3
+	if thumb.Bounds().Dy() > thumb.Bounds().Dx() {
+		xComponents, yComponents = 3, 4
+	}
+
+	updated := *attachment
+	updated.ThumbnailKey = key
+	updated.Thumbnail = &database.Thumbnail{
+		MIMEType: thumbType,
+		Width:    thumb.Bounds().Dx(),
+		Height:   thumb.Bounds().Dy(),
+		Size:     int64(encoded.Len()),
+	}
+	updated.Blurhash = blurhash(scaleDown(thumb, blurhashSize), xComponents, yComponents)
+
+	if err := s.db.Attachments().Update(ctx, &updated); err != nil {
+		// The attachment may have been deleted in the meantime.
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to remove orphaned blob %s: %v", key, err)
+		}
+		return nil, fmt.Errorf("failed to save thumbnail: %v", err)
+	}
+	return &updated, nil
+}
+
+// Close waits for queued image processing to finish.
+func (s *Service) Close() {
+	s.workers.close()
+}
+
+// scaleDown fits img into a limit by limit box, averaging the source
+// pixels that fall into each target pixel. Smaller images are copied
+// at their size.
+func scaleDown(img image.Image, limit int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > limit || height > limit {
+		if width >= height {
+			width, height = limit, max(1, height*limit/bounds.Dx())
+		} else {
+			width, height = max(1, width*limit/bounds.Dy()), limit
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
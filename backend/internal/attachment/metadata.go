@@ -0,0 +1,192 @@
+package attachment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var errMalformedImage = errors.New("malformed image metadata")
+
+// gpsIFDTag is the EXIF tag pointing at the GPS information IFD.
+const gpsIFDTag = 0x8825
+
+var (
+	exifHeader  = []byte("Exif\x00\x00")
+	xmpJPEG     = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpPNG      = []byte("XML:com.adobe.xmp\x00")
+	pngMagic    = []byte("\x89PNG\r\n\x1a\n")
+	tiffTypeLen = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+)
+
+// stripLocation removes location data from an encoded image: the GPS
+// tags of its EXIF block and any XMP packet, which may repeat them.
+// The rest of the metadata, such as orientation, is kept. GIF has no
+// EXIF and is returned unchanged.
+func stripLocation(mimeType string, data []byte) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errMalformedImage
+		}
+
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		// Everything from the start of scan on is image data.
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, data[pos:pos+2]...)
+			pos += 2
+			continue
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end < pos+4 || end > len(data) {
+			return nil, errMalformedImage
+		}
+		segment := data[pos:end]
+
+		if marker == 0xE1 {
+			payload := segment[4:]
+			switch {
+			case bytes.HasPrefix(payload, exifHeader):
+				segment = append([]byte(nil), segment...)
+				if err := stripGPS(segment[4+len(exifHeader):]); err != nil {
+					return nil, err
+				}
+			case bytes.HasPrefix(payload, xmpJPEG):
+				pos = end
+				continue
+			}
+		}
+
+		out = append(out, segment...)
+		pos = end
+	}
+
+	return append(out, data[pos:]...), nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngMagic) {
+		return nil, errMalformedImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngMagic...)
+	pos := len(pngMagic)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformedImage
+		}
+
+		chunk := data[pos:end]
+		switch string(chunk[4:8]) {
+		case "eXIf":
+			chunk = append([]byte(nil), chunk...)
+			if err := stripGPS(chunk[8 : 8+length]); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(chunk[8+length:], crc32.ChecksumIEEE(chunk[4:8+length]))
+		case "iTXt":
+			if bytes.HasPrefix(chunk[8:], xmpPNG) {
+				pos = end
+				continue
+			}
+		}
+
+		out = append(out, chunk...)
+		pos = end
+	}
+
+	return out, nil
+}
+
+// stripGPS empties the GPS IFD of a TIFF structure in place, zeroing
+// its entries and the values they point at. Offsets elsewhere stay
+// valid because nothing moves.
+func stripGPS(tiff []byte) error {
+	if len(tiff) < 8 {
+		return errMalformedImage
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errMalformedImage
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return errMalformedImage
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return errMalformedImage
+		}
+		if order.Uint16(tiff[entry:]) == gpsIFDTag {
+			return clearIFD(tiff, order, int(order.Uint32(tiff[entry+8:])))
+		}
+	}
+	return nil
+}
+
+func clearIFD(tiff []byte, order binary.ByteOrder, offset int) error {
+	if offset < 8 || offset+2 > len(tiff) {
+		return errMalformedImage
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	if count*12 > len(entries) {
+		return errMalformedImage
+	}
+
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : (i+1)*12]
+		size := tiffTypeLen[order.Uint16(entry[2:])] * int(order.Uint32(entry[4:]))
+		if size > 4 {
+			value := int(order.Uint32(entry[8:]))
+			if value >= 0 && value <= len(tiff) && size <= len(tiff)-value {
+				clear(tiff[value : value+size])
+			}
+		}
+		clear(entry)
+	}
+	order.PutUint16(tiff[offset:], 0)
+	return nil
+}
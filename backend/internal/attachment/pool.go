@@ -0,0 +1,55 @@
+package attachment
+
+import "sync"
+
+// pool runs jobs on a fixed number of goroutines with a bounded
+// queue, so that a burst of uploads cannot pile up unbounded work.
+type pool struct {
+	mu     sync.Mutex
+	jobs   chan func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newPool(workers, queue int) *pool {
+	p := &pool{jobs: make(chan func(), queue)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues job and reports whether it was accepted. It never
+// blocks: a full queue or a closed pool rejects the job.
+func (p *pool) submit(job func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting jobs and waits for the queued ones to finish.
+func (p *pool) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,237 @@
+package attachment
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/blob"
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNotParticipant = errors.New("not a chat participant")
+	ErrNoPermission   = errors.New("your role in this chat does not allow this")
+	ErrNotFound       = errors.New("attachment not found")
+	ErrTooLarge       = errors.New("file is too large")
+	ErrEmptyFile      = errors.New("file is empty")
+)
+
+// MaxSize is the largest file accepted by Upload.
+const MaxSize = 25 << 20
+
+// sniffLength is how much of a file http.DetectContentType looks at.
+const sniffLength = 512
+
+// Service stores uploaded files in a blob store and their metadata
+// in the database. Both directions are limited to chat participants.
+type Service struct {
+	db      database.Store
+	blobs   blob.Store
+	workers *pool
+}
+
+// Image processing runs on imageWorkers goroutines with room for
+// imageQueue waiting images.
+const (
+	imageWorkers = 2
+	imageQueue   = 64
+)
+
+func NewService(db database.Store, blobs blob.Store) *Service {
+	return &Service{db: db, blobs: blobs, workers: newPool(imageWorkers, imageQueue)}
+}
+
+// Upload stores size bytes of r as a file of the chat. The MIME type
+// is sniffed from the content when contentType is missing or generic.
+func (s *Service) Upload(ctx context.Context, chatID, userID, name, contentType string, r io.Reader, size int64) (*database.Attachment, error) {
+	if size > MaxSize {
+		return nil, ErrTooLarge
+	}
+	if size <= 0 {
+		return nil, ErrEmptyFile
+	}
+	if err := s.authorize(ctx, chatID, userID, database.PermSend); err != nil {
+		return nil, err
+	}
+
+	content := bufio.NewReaderSize(r, sniffLength)
+	head, _ := content.Peek(sniffLength)
+
+	attachment := &database.Attachment{
+		ID:         database.NewID(),
+		ChatID:     chatID,
+		UploaderID: userID,
+		Name:       cleanName(name),
+		MIMEType:   detectType(contentType, head),
+		Size:       size,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.save(ctx, attachment, io.LimitReader(content, size)); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// save writes content to the blob store and records the attachment,
+// filling in its key and checksum.
+func (s *Service) save(ctx context.Context, attachment *database.Attachment, content io.Reader) error {
+	attachment.Key = "attachments/" + attachment.ChatID + "/" + attachment.ID
+
+	hash := sha256.New()
+	err := s.blobs.Put(ctx, attachment.Key, io.TeeReader(content, hash), attachment.Size, attachment.MIMEType)
+	if err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if err := s.db.Attachments().Create(ctx, attachment); err != nil {
+		if err := s.blobs.Delete(ctx, attachment.Key); err != nil {
+			log.Printf("Failed to remove orphaned blob %s: %v", attachment.Key, err)
+		}
+		return fmt.Errorf("failed to save attachment: %v", err)
+	}
+	return nil
+}
+
+// Release deletes the files of removed attachments that no remaining
+// attachment refers to, with their thumbnails. Failures are only
+// logged: the attachments are already gone.
+func (s *Service) Release(ctx context.Context, attachments []database.Attachment) {
+	for _, attachment := range attachments {
+		inUse, err := s.db.Attachments().KeyInUse(ctx, attachment.Key)
+		if err != nil {
+			log.Printf("Failed to check blob %s: %v", attachment.Key, err)
+			continue
+		}
+		if inUse {
+			continue
+		}
+
+		for _, key := range []string{attachment.Key, attachment.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := s.blobs.Delete(ctx, key); err != nil && !errors.Is(err, blob.ErrNotFound) {
+				log.Printf("Failed to remove blob %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// Open returns an attachment of the chat with its content. The
+// caller closes the reader.
+func (s *Service) Open(ctx context.Context, chatID, userID, attachmentID string) (*database.Attachment, io.ReadCloser, error) {
+	attachment, err := s.get(ctx, chatID, userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.openBlob(ctx, attachment.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// OpenThumbnail is Open for the thumbnail of an image attachment.
+func (s *Service) OpenThumbnail(ctx context.Context, chatID, userID, attachmentID string) (*database.Attachment, io.ReadCloser, error) {
+	attachment, err := s.get(ctx, chatID, userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attachment.Thumbnail == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	content, err := s.openBlob(ctx, attachment.ThumbnailKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+func (s *Service) get(ctx context.Context, chatID, userID, attachmentID string) (*database.Attachment, error) {
+	if err := s.authorize(ctx, chatID, userID, database.PermRead); err != nil {
+		return nil, err
+	}
+
+	attachment, err := s.db.Attachments().Get(ctx, attachmentID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && attachment.ChatID != chatID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachment: %v", err)
+	}
+	return attachment, nil
+}
+
+func (s *Service) openBlob(ctx context.Context, key string) (io.ReadCloser, error) {
+	content, err := s.blobs.Open(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	return content, nil
+}
+
+// authorize checks that userID takes part in the chat and that their
+// role there allows perm.
+func (s *Service) authorize(ctx context.Context, chatID, userID string, perm database.ChatPermission) error {
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load chat: %v", err)
+	}
+
+	if chat.Role(userID) == "" {
+		// Channel subscribers may download what is posted.
+		if chat.Type != "channel" || perm != database.PermRead {
+			return ErrNotParticipant
+		}
+		_, err := s.db.Subscriptions().Get(ctx, chatID, userID)
+		if errors.Is(err, database.ErrNotFound) {
+			return ErrNotParticipant
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load subscription: %v", err)
+		}
+		return nil
+	}
+	if !chat.Can(userID, perm) {
+		return ErrNoPermission
+	}
+	return nil
+}
+
+func detectType(contentType string, head []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return http.DetectContentType(head)
+	}
+	return mediaType
+}
+
+// cleanName keeps only the base name of an uploaded file, as some
+// clients send full paths.
+func cleanName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,241 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrUnsupportedAudio = errors.New("only Ogg/Opus and PCM WAV voice messages are supported")
+
+const (
+	// MaxVoiceSize is the largest recording accepted by UploadVoice.
+	MaxVoiceSize = 10 << 20
+	// waveformLength is the number of bars in a voice waveform; each
+	// bar is 0 to waveformMax.
+	waveformLength = 64
+	waveformMax    = 100
+	// opusRate is the granule rate of every Ogg/Opus stream.
+	opusRate = 48000
+)
+
+// UploadVoice stores a voice recording of the chat together with its
+// duration and waveform.
+func (s *Service) UploadVoice(ctx context.Context, chatID, userID, name string, r io.Reader, size int64) (*database.Attachment, error) {
+	if size > MaxVoiceSize {
+		return nil, ErrTooLarge
+	}
+	if size <= 0 {
+		return nil, ErrEmptyFile
+	}
+	if err := s.authorize(ctx, chatID, userID, database.PermSend); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording: %v", err)
+	}
+
+	var info *audioInfo
+	var mimeType string
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		info, err = parseOpus(data)
+		mimeType = "audio/ogg"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		info, err = parseWAV(data)
+		mimeType = "audio/wav"
+	default:
+		err = ErrUnsupportedAudio
+	}
+	if err != nil {
+		return nil, ErrUnsupportedAudio
+	}
+
+	attachment := &database.Attachment{
+		ID:         database.NewID(),
+		ChatID:     chatID,
+		UploaderID: userID,
+		Name:       cleanName(name),
+		MIMEType:   mimeType,
+		Size:       int64(len(data)),
+		CreatedAt:  time.Now(),
+		Kind:       database.AttachmentVoice,
+		DurationMS: info.duration.Milliseconds(),
+		Waveform:   info.waveform,
+	}
+	if err := s.save(ctx, attachment, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+type audioInfo struct {
+	duration time.Duration
+	waveform []int
+}
+
+// parseWAV reads an uncompressed PCM WAV file. The waveform shows the
+// peak amplitude of each slice of the recording.
+func parseWAV(data []byte) (*audioInfo, error) {
+	var channels, bits int
+	var rate int
+	var samples []byte
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		body := data[pos+8:]
+		if size < 0 || size > len(body) {
+			if id != "data" {
+				return nil, ErrUnsupportedAudio
+			}
+			// Streaming recorders leave the data size unset.
+			size = len(body)
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(body) != 1 {
+				return nil, ErrUnsupportedAudio
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = int(binary.LittleEndian.Uint16(body[14:]))
+		case "data":
+			samples = body[:size]
+		}
+		// Chunks are padded to an even size.
+		pos += 8 + size + size%2
+	}
+
+	if channels == 0 || rate == 0 || samples == nil {
+		return nil, ErrUnsupportedAudio
+	}
+	if bits != 8 && bits != 16 && bits != 24 && bits != 32 {
+		return nil, ErrUnsupportedAudio
+	}
+
+	frameSize := channels * bits / 8
+	frames := len(samples) / frameSize
+	peaks := make([]float64, frames)
+	for i := range peaks {
+		frame := samples[i*frameSize : (i+1)*frameSize]
+		for c := 0; c < channels; c++ {
+			peaks[i] = max(peaks[i], pcmLevel(frame[c*bits/8:(c+1)*bits/8]))
+		}
+	}
+
+	return &audioInfo{
+		duration: time.Duration(frames) * time.Second / time.Duration(rate),
+		waveform: downsample(peaks),
+	}, nil
+}
+
+// pcmLevel returns the absolute level of one little-endian sample,
+// from 0 to 1. 8-bit samples are unsigned, wider ones signed.
+func pcmLevel(sample []byte) float64 {
+	switch len(sample) {
+	case 1:
+		v := float64(int(sample[0])-128) / 128
+		return max(v, -v)
+	case 2:
+		v := float64(int16(binary.LittleEndian.Uint16(sample))) / (1 << 15)
+		return max(v, -v)
+	case 3:
+		v := float64(int32(uint32(sample[0])<<8|uint32(sample[1])<<16|uint32(sample[2])<<24)>>8) / (1 << 23)
+		return max(v, -v)
+	default:
+		v := float64(int32(binary.LittleEndian.Uint32(sample))) / (1 << 31)
+		return max(v, -v)
+	}
+}
+
+// parseOpus reads the Ogg pages of an Opus stream. The duration comes
+// from the last granule position. Decoding Opus is out of reach
+// without a codec, so the waveform follows the size of the audio
+// packets instead: with Opus's variable bitrate louder speech takes
+// more bytes.
+func parseOpus(data []byte) (*audioInfo, error) {
+	var packets [][]byte
+	var packet []byte
+	var granule int64
+
+	for pos := 0; pos < len(data); {
+		if pos+27 > len(data) || string(data[pos:pos+4]) != "OggS" {
+			return nil, ErrUnsupportedAudio
+		}
+		segments := int(data[pos+26])
+		if pos+27+segments > len(data) {
+			return nil, ErrUnsupportedAudio
+		}
+		if g := int64(binary.LittleEndian.Uint64(data[pos+6:])); g >= 0 {
+			granule = g
+		}
+
+		lacing := data[pos+27 : pos+27+segments]
+		body := pos + 27 + segments
+		for _, n := range lacing {
+			if body+int(n) > len(data) {
+				return nil, ErrUnsupportedAudio
+			}
+			packet = append(packet, data[body:body+int(n)]...)
+			body += int(n)
+			// A lacing value below 255 ends the packet.
+			if n < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+		pos = body
+	}
+
+	if len(packets) < 2 || len(packets[0]) < 19 || string(packets[0][:8]) != "OpusHead" {
+		return nil, ErrUnsupportedAudio
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(packets[0][10:]))
+
+	// The second packet holds the OpusTags comments.
+	audio := packets[2:]
+	sizes := make([]float64, len(audio))
+	for i, p := range audio {
+		sizes[i] = float64(len(p))
+	}
+
+	samples := max(granule-preSkip, 0)
+	return &audioInfo{
+		duration: time.Duration(samples) * time.Second / opusRate,
+		waveform: downsample(sizes),
+	}, nil
+}
+
+// downsample splits levels into waveformLength slices, takes the peak
+// of each and scales the loudest slice to waveformMax. Short inputs
+// give fewer bars.
+func downsample(levels []float64) []int {
+	bars := min(len(levels), waveformLength)
+	peaks := make([]float64, bars)
+	loudest := 0.0
+	for i := range peaks {
+		for _, v := range levels[i*len(levels)/bars : (i+1)*len(levels)/bars] {
+			peaks[i] = max(peaks[i], v)
+		}
+		loudest = max(loudest, peaks[i])
+	}
+
+	waveform := make([]int, bars)
+	if loudest == 0 {
+		return waveform
+	}
+	for i, peak := range peaks {
+		waveform[i] = int(peak/loudest*waveformMax + 0.5)
+	}
+	return waveform
+}
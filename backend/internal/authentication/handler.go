@@ -1,11 +1,15 @@
 package authentication
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -18,29 +22,43 @@ func NewHandler(service *Service) *Handler {
 }
 
 func (h *Handler) VerifyAndGetChatsHandler(c echo.Context) error {
-	authHeader := c.Request().Header.Get("Authorization")
-	if authHeader == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Authorization header required",
+	response, err := h.service.GetInitialData(c.Request().Context(), CurrentUser(c).UID)
+	if err != nil {
+		log.Printf("Initial data error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to load initial data",
 		})
 	}
 
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	if token == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Bearer token required",
-		})
+	return c.JSON(http.StatusOK, response)
+}
+
+// ListChatsHandler handles GET /api/chats?archived=<bool>&folder=<name>.
+func (h *Handler) ListChatsHandler(c echo.Context) error {
+	var filter ChatFilter
+	if raw := c.QueryParam("archived"); raw != "" {
+		archived, err := strconv.ParseBool(raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "archived must be true or false",
+			})
+		}
+		filter.Archived = &archived
 	}
+	filter.Folder = c.QueryParam("folder")
 
-	response, err := h.service.VerifyAndGetChats(c.Request().Context(), token)
+	chats, err := h.service.ListChats(c.Request().Context(), CurrentUser(c).UID, filter)
 	if err != nil {
-		fmt.Println(err)
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": err.Error(),
+		log.Printf("List chats error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list chats",
 		})
 	}
 
-	return c.JSON(http.StatusOK, response)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chats": chats,
+		"count": len(chats),
+	})
 }
 
 func (h *Handler) LoginHandler(c echo.Context) error {
@@ -61,7 +79,16 @@ func (h *Handler) LoginHandler(c echo.Context) error {
 		})
 	}
 
-	response, err := h.service.Login(c.Request().Context(), req.Email, req.Password)
+	device := identity.Device{
+		Name: c.Request().UserAgent(),
+		IP:   c.RealIP(),
+	}
+
+	response, err := h.service.Login(c.Request().Context(), req.Email, req.Password, device)
+	var banErr *identity.BanError
+	if errors.As(err, &banErr) {
+		return c.JSON(http.StatusForbidden, banDetails(banErr))
+	}
 	if err != nil {
 		errorMsg := err.Error()
 
@@ -85,3 +112,100 @@ func (h *Handler) LoginHandler(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, response)
 }
+
+func (h *Handler) RefreshHandler(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "refresh_token is required",
+		})
+	}
+
+	response, err := h.service.Refresh(c.Request().Context(), req.RefreshToken)
+	var banErr *identity.BanError
+	if errors.As(err, &banErr) {
+		return c.JSON(http.StatusForbidden, banDetails(banErr))
+	}
+	if err != nil {
+		if !errors.Is(err, identity.ErrInvalidRefresh) {
+			log.Printf("Token refresh error (internal): %v", err)
+		}
+
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Invalid or expired refresh token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) ListSessionsHandler(c echo.Context) error {
+	token := CurrentUser(c).Token
+
+	sessions, err := h.service.ListSessions(c.Request().Context(), token.UID, token.SessionID)
+	if err != nil {
+		log.Printf("List sessions error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list sessions",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+func (h *Handler) RevokeSessionHandler(c echo.Context) error {
+	token := CurrentUser(c).Token
+
+	err := h.service.RevokeSession(c.Request().Context(), token.UID, c.Param("sessionId"))
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Session not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Revoke session error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RevokeAllSessionsHandler(c echo.Context) error {
+	token := CurrentUser(c).Token
+
+	if err := h.service.RevokeAllSessions(c.Request().Context(), token.UID); err != nil {
+		log.Printf("Revoke sessions error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LogoutHandler revokes the session of the token it is called with.
+func (h *Handler) LogoutHandler(c echo.Context) error {
+	token := CurrentUser(c).Token
+
+	if err := h.service.RevokeSession(c.Request().Context(), token.UID, token.SessionID); err != nil {
+		log.Printf("Logout error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package authentication
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+const authUserKey = "auth_user"
+
+// AuthUser is the caller of an authenticated request.
+type AuthUser struct {
+	UID    string
+	Roles  []string
+	Banned bool
+	Token  *identity.Token
+}
+
+func (u *AuthUser) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentUser returns the user stored by RequireAuth,
+// or nil on routes without it.
+func CurrentUser(c echo.Context) *AuthUser {
+	user, _ := c.Get(authUserKey).(*AuthUser)
+	return user
+}
+
+// RequireAuth validates the ID token once per request and stores the
+// caller in the context (see CurrentUser). The token is read from the
+// "Authorization: Bearer" header. Missing or invalid tokens get 401,
+// banned users get 403.
+func RequireAuth(auth identity.Provider, db database.Store) echo.MiddlewareFunc {
+	return requireAuth(auth, db, false)
+}
+
+// RequireSocketAuth is RequireAuth for the WebSocket upgrade, where
+// browsers cannot set headers: the token may also come from the
+// "token" query parameter. Other routes must not accept it there, as
+// request URIs end up in the logs.
+func RequireSocketAuth(auth identity.Provider, db database.Store) echo.MiddlewareFunc {
+	return requireAuth(auth, db, true)
+}
+
+func requireAuth(auth identity.Provider, db database.Store, allowQuery bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			idToken := bearerToken(c.Request(), allowQuery)
+			if idToken == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Authorization required",
+				})
+			}
+
+			ctx := c.Request().Context()
+			token, err := auth.VerifyIdToken(ctx, idToken)
+			var banErr *identity.BanError
+			if errors.As(err, &banErr) {
+				return c.JSON(http.StatusForbidden, banDetails(banErr))
+			}
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Invalid or expired token",
+				})
+			}
+
+			user := &AuthUser{UID: token.UID, Token: token}
+
+			profile, err := db.Users().Get(ctx, token.UID)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				log.Printf("Failed to load user %s: %v", token.UID, err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{
+					"error": "Internal server error",
+				})
+			}
+			if profile != nil {
+				user.Roles = profile.Roles
+				user.Banned = profile.Banned(time.Now())
+			}
+
+			if user.Banned {
+				return c.JSON(http.StatusForbidden, banDetails(&identity.BanError{
+					Reason: profile.BanReason,
+					Until:  profile.BannedUntil,
+				}))
+			}
+
+			c.Set(authUserKey, user)
+			return next(c)
+		}
+	}
+}
+
+// RequireRole must run after RequireAuth.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := CurrentUser(c)
+			if user == nil || !user.HasRole(role) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": "Insufficient permissions",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(r *http.Request, allowQuery bool) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if allowQuery {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// banDetails is the 403 body returned to banned users.
+func banDetails(banErr *identity.BanError) map[string]interface{} {
+	details := map[string]interface{}{
+		"error":  "Account is banned",
+		"reason": banErr.Reason,
+	}
+	if !banErr.Until.IsZero() {
+		details["banned_until"] = banErr.Until
+	}
+	return details
+}
@@ -0,0 +1,80 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+	"MyChatServer/internal/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireAuth(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := identity.WithBans(identity.NewLocal(store, []byte("test-secret")), store)
+
+	signIn := func(email string, user database.User) string {
+		uid, _ := provider.CreateUserInAuth(ctx, email, "secret123", user.Name)
+		user.UID = uid
+		user.Email = email
+		store.Users().Save(ctx, &user)
+		result, err := provider.SignInWithPassword(ctx, email, "secret123", identity.Device{})
+		if err != nil {
+			t.Fatalf("SignInWithPassword(%s): %v", email, err)
+		}
+		return result.IDToken
+	}
+
+	memberToken := signIn("member@test.com", database.User{Name: "Member"})
+	adminToken := signIn("admin@test.com", database.User{Name: "Admin", Roles: []string{database.RoleAdmin}})
+	bannedToken := signIn("banned@test.com", database.User{Name: "Banned"})
+	banned, _ := store.Users().GetByEmail(ctx, "banned@test.com")
+	banned.IsBanned = true
+	store.Users().Save(ctx, banned)
+
+	e := echo.New()
+	requireAuth := RequireAuth(provider, store)
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, CurrentUser(c).UID)
+	}
+	e.GET("/me", ok, requireAuth)
+	e.GET("/admin", ok, requireAuth, RequireRole(database.RoleAdmin))
+	e.GET("/ws", ok, RequireSocketAuth(provider, store))
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   int
+	}{
+		{"no token", "/me", "", http.StatusUnauthorized},
+		{"garbage token", "/me", "Bearer garbage", http.StatusUnauthorized},
+		{"valid header", "/me", "Bearer " + memberToken, http.StatusOK},
+		{"query token on api route", "/me?token=" + memberToken, "", http.StatusUnauthorized},
+		{"query token on socket", "/ws?token=" + memberToken, "", http.StatusOK},
+		{"header on socket", "/ws", "Bearer " + memberToken, http.StatusOK},
+		{"banned user", "/me", "Bearer " + bannedToken, http.StatusForbidden},
+		{"member on admin route", "/admin", "Bearer " + memberToken, http.StatusForbidden},
+		{"admin on admin route", "/admin", "Bearer " + adminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
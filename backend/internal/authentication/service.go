@@ -2,20 +2,24 @@ package authentication
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
+	"slices"
+	"sort"
+	"strconv"
 	"time"
 
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/websocket"
 )
 
 // Service handles user authentication logic:
-// Firebase sign-in, token validation, fetching user data and initial chats.
+// sign-in, token validation, sessions, fetching user data and initial chats.
 type Service struct {
-	db *database.Client
+	db       database.Store
+	auth     identity.Provider
+	wsServer *websocket.Server
 }
 
 type LoginRequest struct {
@@ -24,23 +28,28 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string         `json:"token"`
-	User  UserResponse   `json:"user"`
-	Chats []ChatResponse `json:"chats"`
+	Token        string         `json:"token"`
+	RefreshToken string         `json:"refresh_token"`
+	ExpiresIn    int            `json:"expires_in"`
+	User         UserResponse   `json:"user"`
+	Chats        []ChatResponse `json:"chats"`
 }
 
-type FirebaseAuthResponse struct {
-	IDToken      string `json:"idToken"`
-	RefreshToken string `json:"refreshToken"`
-	ExpiresIn    string `json:"expiresIn"`
-	LocalID      string `json:"localId"`
-	Email        string `json:"email"`
+type AuthResponse struct {
+	User         UserResponse   `json:"user"`
+	Chats        []ChatResponse `json:"chats"`
+	Token        string         `json:"token"`
+	RefreshToken string         `json:"refresh_token,omitempty"`
+	ExpiresIn    int            `json:"expires_in,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
 }
 
-type AuthResponse struct {
-	User  UserResponse   `json:"user"`
-	Chats []ChatResponse `json:"chats"`
-	Token string         `json:"token"`
+// TokenResponse is returned by Refresh. ExpiresIn is the ID token
+// lifetime in seconds.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type UserResponse struct {
@@ -55,99 +64,144 @@ type ChatResponse struct {
 	Type            string    `json:"type"`
 	LastMessage     string    `json:"last_message,omitempty"`
 	LastMessageTime time.Time `json:"last_message_time,omitempty"`
+	UnreadMentions  int       `json:"unread_mentions"`
+	// Role is the user's role in a group chat.
+	Role      string    `json:"role,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// The user's own settings of the chat.
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	PinOrder   int        `json:"pin_order,omitempty"`
+	Folders    []string   `json:"folders"`
 }
 
-func NewService(db *database.Client) *Service {
-	return &Service{db: db}
+// ChatFilter narrows ListChats. The zero value lists every chat.
+type ChatFilter struct {
+	// Archived, when set, keeps only archived or only unarchived
+	// chats.
+	Archived *bool
+	// Folder keeps only the chats in this folder.
+	Folder string
 }
 
-func (s *Service) FirebaseLogin(ctx context.Context, email, password string) (*FirebaseAuthResponse, error) {
-	url := fmt.Sprintf("https://identitytoolkit.googleapis.com/v1/accounts:signInWithPassword?key=%s", s.db.APIKey)
-
-	payload := map[string]interface{}{
-		"email":             email,
-		"password":          password,
-		"returnSecureToken": true,
-	}
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
 
-	payloadBytes, _ := json.Marshal(payload)
+func NewService(db database.Store, auth identity.Provider, wsServer *websocket.Server) *Service {
+	return &Service{db: db, auth: auth, wsServer: wsServer}
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(payloadBytes)))
+func (s *Service) Login(ctx context.Context, email, password string, device identity.Device) (*AuthResponse, error) {
+	signIn, err := s.auth.SignInWithPassword(ctx, email, password, device)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("authentication failed: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	_, err = s.auth.ValidateIdToken(ctx, signIn.IDToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call Firebase API: %v", err)
+		return nil, fmt.Errorf("token verification failed: %v", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	user, err := s.getUserData(ctx, signIn.UID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-
-	if resp.StatusCode != 200 {
-		var errorResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-		json.Unmarshal(body, &errorResp)
-		return nil, fmt.Errorf("firebase auth error: %s (code: %d)", errorResp.Error.Message, errorResp.Error.Code)
+		return nil, fmt.Errorf("failed to get user data: %v", err)
 	}
 
-	var authResp FirebaseAuthResponse
-	if err := json.Unmarshal(body, &authResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+	chats, err := s.ListChats(ctx, signIn.UID, ChatFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user chats: %v", err)
 	}
 
-	return &authResp, nil
+	return &AuthResponse{
+		User:         user,
+		Chats:        chats,
+		Token:        signIn.IDToken,
+		RefreshToken: signIn.RefreshToken,
+		ExpiresIn:    expiresInSeconds(signIn.ExpiresIn),
+		SessionID:    signIn.SessionID,
+	}, nil
 }
 
-func (s *Service) Login(ctx context.Context, email, password string) (*AuthResponse, error) {
-	firebaseResponse, err := s.FirebaseLogin(ctx, email, password)
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	refreshed, err := s.auth.RefreshIdToken(ctx, refreshToken)
 	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %v", err)
+		return nil, fmt.Errorf("token refresh failed: %w", err)
 	}
 
-	_, err = s.db.Auth.VerifyIDToken(ctx, firebaseResponse.IDToken)
+	return &TokenResponse{
+		Token:        refreshed.IDToken,
+		RefreshToken: refreshed.RefreshToken,
+		ExpiresIn:    expiresInSeconds(refreshed.ExpiresIn),
+	}, nil
+}
+
+func (s *Service) ListSessions(ctx context.Context, uid, currentSessionID string) ([]SessionResponse, error) {
+	sessions, err := s.auth.ListSessions(ctx, uid)
 	if err != nil {
-		return nil, fmt.Errorf("token verification failed: %v", err)
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
 	}
 
-	user, err := s.getUserData(ctx, firebaseResponse.LocalID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user data: %v", err)
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Current:    session.ID == currentSessionID,
+		})
 	}
 
-	chats, err := s.getUserChats(ctx, firebaseResponse.LocalID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user chats: %v", err)
+	return response, nil
+}
+
+// RevokeSession signs one device out and closes its WebSocket.
+func (s *Service) RevokeSession(ctx context.Context, uid, sessionID string) error {
+	if err := s.auth.RevokeSession(ctx, uid, sessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
 	}
 
-	return &AuthResponse{
-		User:  user,
-		Chats: chats,
-		Token: firebaseResponse.IDToken,
-	}, nil
+	if s.wsServer != nil {
+		s.wsServer.DisconnectSession(uid, sessionID)
+	}
+	return nil
 }
 
-func (s *Service) VerifyAndGetChats(ctx context.Context, idToken string) (*AuthResponse, error) {
-	userUID, err := s.db.ValidateIdToken(ctx, idToken)
-	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %v: ", err)
+// RevokeAllSessions signs every device of the user out.
+func (s *Service) RevokeAllSessions(ctx context.Context, uid string) error {
+	if err := s.auth.RevokeAllSessions(ctx, uid); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	if s.wsServer != nil {
+		s.wsServer.DisconnectSession(uid, "")
 	}
+	return nil
+}
+
+// expiresInSeconds parses the ExpiresIn string both providers return.
+func expiresInSeconds(expiresIn string) int {
+	seconds, _ := strconv.Atoi(expiresIn)
+	return seconds
+}
 
+// GetInitialData returns the profile and chats of an authenticated user.
+func (s *Service) GetInitialData(ctx context.Context, userUID string) (*AuthResponse, error) {
 	user, err := s.getUserData(ctx, userUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user data: %v", err)
 	}
 
-	chats, err := s.getUserChats(ctx, userUID)
+	chats, err := s.ListChats(ctx, userUID, ChatFilter{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user chats: %v", err)
 	}
@@ -159,16 +213,11 @@ func (s *Service) VerifyAndGetChats(ctx context.Context, idToken string) (*AuthR
 }
 
 func (s *Service) getUserData(ctx context.Context, userUID string) (UserResponse, error) {
-	doc, err := s.db.Firestore.Collection("users").Doc(userUID).Get(ctx)
+	user, err := s.db.Users().Get(ctx, userUID)
 	if err != nil {
 		return UserResponse{}, err
 	}
 
-	var user database.User
-	if err := doc.DataTo(&user); err != nil {
-		return UserResponse{}, err
-	}
-
 	return UserResponse{
 		UID:   user.UID,
 		Name:  user.Name,
@@ -176,34 +225,94 @@ func (s *Service) getUserData(ctx context.Context, userUID string) (UserResponse
 	}, nil
 }
 
-func (s *Service) getUserChats(ctx context.Context, userUID string) ([]ChatResponse, error) {
-	docs, err := s.db.Firestore.Collection("chats").Where("participants", "array-contains", userUID).Documents(ctx).GetAll()
+// ListChats returns the user's chats in the order of their chat
+// list: unarchived chats before archived ones, pinned chats first in
+// their pin order, then the most recently updated.
+func (s *Service) ListChats(ctx context.Context, userUID string, filter ChatFilter) ([]ChatResponse, error) {
+	docs, err := s.db.Chats().ListByParticipant(ctx, userUID)
 	if err != nil {
 		return nil, err
 	}
 
-	chats := make([]ChatResponse, len(docs))
+	// Subscribed channels are not in ListByParticipant.
+	subscriptions, err := s.db.Subscriptions().ListByUser(ctx, userUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		doc, err := s.db.Chats().Get(ctx, subscription.ChatID)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, *doc)
+	}
 
-	for i, doc := range docs {
-		data := doc.Data()
+	settings, err := s.db.ChatSettings().ListByUser(ctx, userUID)
+	if err != nil {
+		return nil, err
+	}
+	byChat := make(map[string]*database.ChatSettings, len(settings))
+	for i := range settings {
+		byChat[settings[i].ChatID] = &settings[i]
+	}
+
+	now := time.Now()
+	chats := make([]ChatResponse, 0, len(docs))
 
+	for _, doc := range docs {
 		chat := ChatResponse{
-			ID:   doc.Ref.ID,
-			Name: data["name"].(string),
-			Type: data["type"].(string),
+			ID:             doc.ID,
+			Name:           doc.Name,
+			Type:           doc.Type,
+			UnreadMentions: doc.UnreadMentions[userUID],
+			UpdatedAt:      doc.UpdatedAt,
+			Folders:        []string{},
 		}
-
-		if lastMessage, ok := data["last_message"].(map[string]interface{}); ok {
-			if text, ok := lastMessage["text"].(string); ok {
-				chat.LastMessage = text
+		if settings, ok := byChat[doc.ID]; ok {
+			if settings.Muted(now) {
+				mutedUntil := settings.MutedUntil
+				chat.MutedUntil = &mutedUntil
 			}
-			if timestamp, ok := lastMessage["timestamp"].(time.Time); ok {
-				chat.LastMessageTime = timestamp
+			chat.Archived = settings.Archived
+			chat.Pinned = settings.Pinned
+			chat.PinOrder = settings.PinOrder
+			if len(settings.Folders) > 0 {
+				chat.Folders = settings.Folders
 			}
 		}
+		if filter.Archived != nil && chat.Archived != *filter.Archived {
+			continue
+		}
+		if filter.Folder != "" && !slices.Contains(chat.Folders, filter.Folder) {
+			continue
+		}
+		if doc.Type != "private" {
+			chat.Role = doc.Role(userUID)
+		}
 
-		chats[i] = chat
+		if doc.LastMessage != nil {
+			chat.LastMessage = doc.LastMessage.Text
+			chat.LastMessageTime = doc.LastMessage.Timestamp
+		}
+
+		chats = append(chats, chat)
 	}
 
+	sort.SliceStable(chats, func(i, j int) bool {
+		a, b := chats[i], chats[j]
+		switch {
+		case a.Archived != b.Archived:
+			return b.Archived
+		case a.Pinned != b.Pinned:
+			return a.Pinned
+		case a.Pinned && a.PinOrder != b.PinOrder:
+			return a.PinOrder < b.PinOrder
+		}
+		return a.UpdatedAt.After(b.UpdatedAt)
+	})
+
 	return chats, nil
 }
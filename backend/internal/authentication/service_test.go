@@ -2,7 +2,13 @@ package authentication
 
 import (
 	"context"
+	"slices"
 	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+	"MyChatServer/internal/identity"
 )
 
 func TestLoginLogic(t *testing.T) {
@@ -48,3 +54,129 @@ func TestLoginLogic(t *testing.T) {
 		}
 	})
 }
+
+func TestLoginReturnsRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := identity.NewLocal(store, []byte("test-secret"))
+	service := NewService(store, provider, nil)
+
+	uid, _ := provider.CreateUserInAuth(ctx, "test@example.com", "secret123", "Test User")
+	store.Users().Save(ctx, &database.User{UID: uid, Name: "Test User", Email: "test@example.com"})
+
+	login, err := service.Login(ctx, "test@example.com", "secret123", identity.Device{Name: "test"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if login.RefreshToken == "" || login.ExpiresIn != 3600 {
+		t.Fatalf("Login refresh_token = %q, expires_in = %d", login.RefreshToken, login.ExpiresIn)
+	}
+
+	refreshed, err := service.Refresh(ctx, login.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, err := provider.ValidateIdToken(ctx, refreshed.Token); err != nil {
+		t.Errorf("refreshed token rejected: %v", err)
+	}
+
+	if _, err := service.Refresh(ctx, "garbage"); err == nil {
+		t.Error("Refresh accepted an invalid token")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	provider := identity.NewLocal(store, []byte("test-secret"))
+	service := NewService(store, provider, nil)
+
+	uid, _ := provider.CreateUserInAuth(ctx, "test@example.com", "secret123", "Test User")
+	store.Users().Save(ctx, &database.User{UID: uid, Name: "Test User", Email: "test@example.com"})
+
+	phone, _ := service.Login(ctx, "test@example.com", "secret123", identity.Device{Name: "phone"})
+	laptop, _ := service.Login(ctx, "test@example.com", "secret123", identity.Device{Name: "laptop"})
+
+	sessions, err := service.ListSessions(ctx, uid, laptop.SessionID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("ListSessions = %+v, %v; want 2 sessions", sessions, err)
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID == laptop.SessionID) {
+			t.Errorf("session %s (%s) current = %v", session.ID, session.Device, session.Current)
+		}
+	}
+
+	if err := service.RevokeSession(ctx, uid, phone.SessionID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := provider.ValidateIdToken(ctx, phone.Token); err == nil {
+		t.Error("token of revoked session still accepted")
+	}
+	if _, err := provider.ValidateIdToken(ctx, laptop.Token); err != nil {
+		t.Errorf("token of other session rejected: %v", err)
+	}
+
+	service.RevokeAllSessions(ctx, uid)
+	if _, err := provider.ValidateIdToken(ctx, laptop.Token); err == nil {
+		t.Error("token accepted after RevokeAllSessions")
+	}
+}
+
+func TestListChatsOrder(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store, nil, nil)
+
+	now := time.Now()
+	create := func(name string, updated time.Duration) string {
+		id, _ := store.Chats().Create(ctx, &database.Chat{
+			Name: name, Type: "group", Participants: []string{"u1"}, UpdatedAt: now.Add(updated),
+		})
+		return id
+	}
+	old := create("old", -time.Hour)
+	recent := create("recent", 0)
+	pinnedSecond := create("pinned second", -2*time.Hour)
+	pinnedFirst := create("pinned first", -3*time.Hour)
+	archived := create("archived", time.Minute)
+
+	store.ChatSettings().Save(ctx, &database.ChatSettings{UserID: "u1", ChatID: pinnedFirst, Pinned: true, PinOrder: 1})
+	store.ChatSettings().Save(ctx, &database.ChatSettings{UserID: "u1", ChatID: pinnedSecond, Pinned: true, PinOrder: 2,
+		Folders: []string{"work"}, MutedUntil: now.Add(time.Hour)})
+	store.ChatSettings().Save(ctx, &database.ChatSettings{UserID: "u1", ChatID: archived, Archived: true})
+
+	chats, err := service.ListChats(ctx, "u1", ChatFilter{})
+	if err != nil {
+		t.Fatalf("ListChats: %v", err)
+	}
+	var order []string
+	for _, chat := range chats {
+		order = append(order, chat.ID)
+	}
+	if want := []string{pinnedFirst, pinnedSecond, recent, old, archived}; !slices.Equal(order, want) {
+		t.Errorf("ListChats order = %v, want %v", order, want)
+	}
+	if chats[1].MutedUntil == nil || chats[0].MutedUntil != nil {
+		t.Errorf("muted_until = %v, %v; want only the second chat muted", chats[0].MutedUntil, chats[1].MutedUntil)
+	}
+
+	unarchived := false
+	tests := []struct {
+		name   string
+		filter ChatFilter
+		want   int
+	}{
+		{"unarchived", ChatFilter{Archived: &unarchived}, 4},
+		{"folder", ChatFilter{Folder: "work"}, 1},
+		{"unknown folder", ChatFilter{Folder: "home"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, _ := service.ListChats(ctx, "u1", tt.filter)
+			if len(chats) != tt.want {
+				t.Errorf("got %d chats, want %d", len(chats), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package blob stores the bytes of uploaded files. Metadata about
+// them is kept by the database package.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps opaque objects under slash-separated keys such as
+// "attachments/<chatID>/<id>".
+type Store interface {
+	// Put writes size bytes read from r under key, replacing any
+	// previous object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object's content. The caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey rejects keys that could escape the store's root, so that
+// every implementation can map keys to paths directly.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, "\\\x00") {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
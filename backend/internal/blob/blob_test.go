@@ -0,0 +1,133 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"attachments/chat/file", nil},
+		{"a", nil},
+		{"", ErrInvalidKey},
+		{"/abs", ErrInvalidKey},
+		{"dir/", ErrInvalidKey},
+		{"a//b", ErrInvalidKey},
+		{"a/../b", ErrInvalidKey},
+		{"a\\b", ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		if err := validKey(tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("validKey(%q) = %v, want %v", tt.key, err, tt.want)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t, "chat-files"))
+	defer server.Close()
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "chat-files",
+		AccessKeyID:     "test-key",
+		SecretAccessKey: "test-secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	testStore(t, store)
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	key := "attachments/c1/a1"
+
+	if err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, key, strings.NewReader("hello, world"), 12, "text/plain"); err != nil {
+		t.Fatalf("Put over existing: %v", err)
+	}
+
+	r, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "hello, world" {
+		t.Errorf("content = %q, want the latest upload", data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put(../escape) error = %v, want ErrInvalidKey", err)
+	}
+}
+
+// newFakeS3 serves a single in-memory bucket and rejects unsigned
+// requests, standing in for MinIO or S3.
+func newFakeS3(t *testing.T, bucket string) http.Handler {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-key/") || r.Header.Get("X-Amz-Date") == "" {
+			t.Errorf("%s %s: unsigned request", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key, ok := strings.CutPrefix(r.URL.Path, "/"+bucket+"/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = data
+		case http.MethodGet:
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -1,7 +1,17 @@
 package chat
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/websocket"
 )
 
 func TestChatNameValidation(t *testing.T) {
@@ -255,3 +265,222 @@ func TestValidateMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMessagesWithMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store, identity.NewLocal(store, []byte("test-secret")), websocket.NewServer(store, nil), nil)
+
+	chatID, err := service.createChatDocument(ctx, "user1", "Team", []string{"user1", "user2"}, "group")
+	if err != nil {
+		t.Fatalf("createChatDocument: %v", err)
+	}
+
+	store.Messages().Add(ctx, chatID, &database.Message{SenderID: "user2", Text: "hello", Timestamp: time.Now()})
+
+	page, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Limit: 50})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	messages := page.Messages
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want welcome + 1", len(messages))
+	}
+	if messages[0].SenderID != "system" || messages[1].Text != "hello" {
+		t.Errorf("unexpected messages: %+v", messages)
+	}
+
+	if _, err := service.GetMessages(ctx, chatID, "stranger", MessagesQuery{Limit: 50}); !errors.Is(err, websocket.ErrNotParticipant) {
+		t.Errorf("GetMessages(stranger) error = %v, want ErrNotParticipant", err)
+	}
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store, identity.NewLocal(store, []byte("test-secret")), websocket.NewServer(store, nil), nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"user1"}})
+	base := time.Now()
+	for i := 0; i < 10; i++ {
+		store.Messages().Add(ctx, chatID, &database.Message{
+			ID:        fmt.Sprintf("m%d", i),
+			SenderID:  "user1",
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	// Same timestamp as m9: ties are broken by ID.
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "m9b", SenderID: "user1", Timestamp: base.Add(9 * time.Second)})
+
+	ids := func(page *MessagesPage) string {
+		var out []string
+		for _, m := range page.Messages {
+			out = append(out, m.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	latest, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Limit: 4})
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if got := ids(latest); got != "m7,m8,m9,m9b" || !latest.HasMoreBefore || latest.HasMoreAfter {
+		t.Errorf("latest = %s before=%v after=%v", got, latest.HasMoreBefore, latest.HasMoreAfter)
+	}
+
+	older, _ := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Before: latest.PrevCursor, Limit: 4})
+	if got := ids(older); got != "m3,m4,m5,m6" || !older.HasMoreAfter {
+		t.Errorf("before = %s", got)
+	}
+
+	first, _ := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Before: older.PrevCursor, Limit: 4})
+	if got := ids(first); got != "m0,m1,m2" || first.HasMoreBefore {
+		t.Errorf("first page = %s hasMoreBefore=%v", got, first.HasMoreBefore)
+	}
+
+	newer, _ := service.GetMessages(ctx, chatID, "user1", MessagesQuery{After: first.NextCursor, Limit: 2})
+	if got := ids(newer); got != "m3,m4" || !newer.HasMoreAfter {
+		t.Errorf("after = %s", got)
+	}
+
+	around, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Around: "m5", Limit: 5})
+	if err != nil {
+		t.Fatalf("around: %v", err)
+	}
+	if got := ids(around); got != "m3,m4,m5,m6,m7" || !around.HasMoreBefore || !around.HasMoreAfter {
+		t.Errorf("around = %s", got)
+	}
+
+	if _, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Around: "missing", Limit: 5}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("around missing error = %v, want ErrMessageNotFound", err)
+	}
+	if _, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Before: "!!", Limit: 5}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("bad cursor error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestRepliesAndThreads(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store, identity.NewLocal(store, []byte("test-secret")), websocket.NewServer(store, nil), nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"user1", "user2"}})
+	base := time.Now()
+	long := strings.Repeat("я", database.QuoteLength+10)
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "root", SenderID: "user1", Text: long, Timestamp: base,
+		ReplyCount: 2, LastReplyAt: base.Add(2 * time.Second)})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "r1", SenderID: "user2", Text: "first", Timestamp: base.Add(time.Second), ThreadID: "root"})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "r2", SenderID: "user1", Text: "second", Timestamp: base.Add(2 * time.Second), ThreadID: "root", ReplyTo: "r1"})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "quote", SenderID: "user2", Text: "re", Timestamp: base.Add(3 * time.Second), ReplyTo: "root"})
+
+	page, err := service.GetMessages(ctx, chatID, "user1", MessagesQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(page.Messages) != 2 {
+		t.Fatalf("timeline = %+v, want root and quote without thread replies", page.Messages)
+	}
+	if page.Messages[0].ReplyCount != 2 || page.Messages[0].LastReplyAt == nil {
+		t.Errorf("root = %+v, want reply count and last reply time", page.Messages[0])
+	}
+	quote := page.Messages[1].ReplyTo
+	if quote == nil || quote.ID != "root" || len([]rune(quote.Text)) != database.QuoteLength+1 {
+		t.Errorf("reply_to = %+v, want a shortened snippet of root", quote)
+	}
+
+	thread, err := service.GetThread(ctx, chatID, "user2", "root", MessagesQuery{Limit: 1})
+	if err != nil {
+		t.Fatalf("GetThread: %v", err)
+	}
+	if thread.Root == nil || thread.Root.ID != "root" || len(thread.Messages) != 1 || thread.Messages[0].ID != "r2" || !thread.HasMoreBefore {
+		t.Errorf("thread page = %+v, want root and the latest reply", thread)
+	}
+	if thread.Messages[0].ReplyTo == nil || thread.Messages[0].ReplyTo.Text != "first" {
+		t.Errorf("thread reply_to = %+v, want quote of r1", thread.Messages[0].ReplyTo)
+	}
+
+	if _, err := service.GetThread(ctx, chatID, "user1", "r1", MessagesQuery{Limit: 10}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("GetThread(reply) error = %v, want ErrMessageNotFound", err)
+	}
+	if _, err := service.GetThread(ctx, chatID, "stranger", "root", MessagesQuery{Limit: 10}); !errors.Is(err, websocket.ErrNotParticipant) {
+		t.Errorf("GetThread(stranger) error = %v, want ErrNotParticipant", err)
+	}
+	if _, err := service.GetMessageEdits(ctx, chatID, "stranger", "root"); !errors.Is(err, websocket.ErrNotParticipant) {
+		t.Errorf("GetMessageEdits(stranger) error = %v, want ErrNotParticipant", err)
+	}
+}
+
+func TestChatSettings(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store, nil, websocket.NewServer(store, nil), nil)
+
+	var chatIDs []string
+	for i := 0; i < maxPinnedChats+1; i++ {
+		id, _ := store.Chats().Create(ctx, &database.Chat{Type: "group", Participants: []string{"u1"}})
+		chatIDs = append(chatIDs, id)
+	}
+
+	yes := true
+	forever := int64(-1)
+	settings, err := service.UpdateChatSettings(ctx, chatIDs[0], "u1", SettingsUpdate{
+		MuteFor: &forever, Pinned: &yes, Folders: &[]string{" work ", "work", "news"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateChatSettings: %v", err)
+	}
+	if settings.MutedUntil == nil || !settings.Pinned || settings.PinOrder != 1 || len(settings.Folders) != 2 {
+		t.Errorf("settings = %+v, want muted, pinned first, folders work and news", settings)
+	}
+
+	for _, chatID := range chatIDs[1:maxPinnedChats] {
+		if _, err := service.UpdateChatSettings(ctx, chatID, "u1", SettingsUpdate{Pinned: &yes}); err != nil {
+			t.Fatalf("pin %s: %v", chatID, err)
+		}
+	}
+	if _, err := service.UpdateChatSettings(ctx, chatIDs[maxPinnedChats], "u1", SettingsUpdate{Pinned: &yes}); !errors.Is(err, ErrTooManyPinned) {
+		t.Errorf("pin over the limit: err = %v, want ErrTooManyPinned", err)
+	}
+
+	pinned, err := service.ReorderPinned(ctx, "u1", []string{chatIDs[2], chatIDs[0]})
+	if err != nil {
+		t.Fatalf("ReorderPinned: %v", err)
+	}
+	if pinned[0].ChatID != chatIDs[2] || pinned[1].ChatID != chatIDs[0] || pinned[2].ChatID != chatIDs[1] || pinned[2].PinOrder != 3 {
+		t.Errorf("ReorderPinned = %+v, want chats 2, 0, 1 first", pinned[:3])
+	}
+	if _, err := service.ReorderPinned(ctx, "u1", []string{chatIDs[maxPinnedChats]}); !errors.Is(err, ErrNotPinned) {
+		t.Errorf("reorder an unpinned chat: err = %v, want ErrNotPinned", err)
+	}
+
+	negative := int64(-5)
+	tests := []struct {
+		name   string
+		update SettingsUpdate
+		err    error
+	}{
+		{"negative mute", SettingsUpdate{MuteFor: &negative}, ErrInvalidMute},
+		{"empty folder", SettingsUpdate{Folders: &[]string{" "}}, ErrInvalidFolder},
+		{"long folder", SettingsUpdate{Folders: &[]string{strings.Repeat("x", maxFolderLength+1)}}, ErrInvalidFolder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.UpdateChatSettings(ctx, chatIDs[0], "u1", tt.update); !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	huge := int64(1e11)
+	settings, err = service.UpdateChatSettings(ctx, chatIDs[0], "u1", SettingsUpdate{MuteFor: &huge})
+	if err != nil || settings.MutedUntil == nil || settings.MutedUntil.Before(time.Now().Add(muteForever-time.Hour)) {
+		t.Errorf("huge mute_for = %+v, %v; want muted for muteForever", settings, err)
+	}
+
+	if _, err := service.GetChatSettings(ctx, chatIDs[0], "stranger"); !errors.Is(err, websocket.ErrNotParticipant) {
+		t.Errorf("stranger: err = %v, want ErrNotParticipant", err)
+	}
+	if changes, _ := store.Changes().ListSince(ctx, "u1", 0, 100); len(changes) == 0 {
+		t.Error("settings changes were not journaled")
+	}
+}
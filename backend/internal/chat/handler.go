@@ -1,12 +1,23 @@
 package chat
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
+
+	"MyChatServer/internal/attachment"
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/websocket"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
 type Handler struct {
 	service *Service
 }
@@ -23,22 +34,265 @@ func (h *Handler) GetMessages(c echo.Context) error {
 		})
 	}
 
-	userId, err := h.getUserIDFromToken(c.Request())
+	query, errMsg := parseMessagesQuery(c)
+	if errMsg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": errMsg,
+		})
+	}
+
+	userId := authentication.CurrentUser(c).UID
+
+	page, err := h.service.GetMessages(c.Request().Context(), chatId, userId, query)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "invalid token",
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetThread handles GET /api/chats/:chatId/messages/:messageId/thread
+// with the same paging parameters as GetMessages.
+func (h *Handler) GetThread(c echo.Context) error {
+	query, errMsg := parseMessagesQuery(c)
+	if errMsg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": errMsg,
 		})
 	}
 
-	messages, err := h.service.GetMessages(c.Request().Context(), chatId, userId)
+	userID := authentication.CurrentUser(c).UID
+
+	page, err := h.service.GetThread(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"), query)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// parseMessagesQuery reads the paging parameters shared by chat
+// history and threads. A non-empty string describes a bad request.
+func parseMessagesQuery(c echo.Context) (MessagesQuery, string) {
+	query := MessagesQuery{
+		Before: c.QueryParam("before"),
+		After:  c.QueryParam("after"),
+		Around: c.QueryParam("around"),
+		Limit:  defaultMessagesLimit,
+	}
+
+	set := 0
+	for _, v := range []string{query.Before, query.After, query.Around} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return query, "Only one of before, after and around can be used"
+	}
+
+	if raw := c.QueryParam("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxMessagesLimit {
+			return query, fmt.Sprintf("limit must be between 1 and %d", maxMessagesLimit)
+		}
+		query.Limit = limit
+	}
+
+	return query, ""
+}
+
+func (h *Handler) EditMessage(c echo.Context) error {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	msg, err := h.service.EditMessage(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"), req.Text)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, msg)
+}
+
+// SendImage handles POST /api/chats/:chatId/messages/image with the
+// image in the "file" field of a multipart form and an optional
+// caption in "text".
+func (h *Handler) SendImage(c echo.Context) error {
+	// Leave 1 MiB for the multipart framing and the caption.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, attachment.MaxImageSize+1<<20)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "file is required",
+		})
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Failed to read file",
 		})
 	}
+	defer content.Close()
+
+	userID := authentication.CurrentUser(c).UID
+
+	msg, err := h.service.SendImage(c.Request().Context(), c.Param("chatId"), userID,
+		file.Filename, content, file.Size, c.FormValue("text"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, msg)
+}
+
+// ForwardMessages handles POST /api/chats/:chatId/messages/forward.
+func (h *Handler) ForwardMessages(c echo.Context) error {
+	var req struct {
+		MessageIDs    []string `json:"message_ids"`
+		TargetChatIDs []string `json:"target_chat_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	results, err := h.service.ForwardMessages(c.Request().Context(), c.Param("chatId"), userID, req.MessageIDs, req.TargetChatIDs)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"messages": messages,
+		"results": results,
+	})
+}
+
+// DeleteMessage hides the message for the caller, or removes it for
+// everyone with ?for_everyone=true.
+func (h *Handler) DeleteMessage(c echo.Context) error {
+	forEveryone := c.QueryParam("for_everyone") == "true"
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.DeleteMessage(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"), forEveryone)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PinMessage handles POST /api/chats/:chatId/messages/:messageId/pin.
+func (h *Handler) PinMessage(c echo.Context) error {
+	return h.pinMessage(c, true)
+}
+
+// UnpinMessage handles DELETE /api/chats/:chatId/messages/:messageId/pin.
+func (h *Handler) UnpinMessage(c echo.Context) error {
+	return h.pinMessage(c, false)
+}
+
+func (h *Handler) pinMessage(c echo.Context, pin bool) error {
+	userID := authentication.CurrentUser(c).UID
+
+	msg, err := h.service.PinMessage(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"), pin)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": msg,
+	})
+}
+
+// MarkListened handles POST /api/chats/:chatId/messages/:messageId/listened.
+func (h *Handler) MarkListened(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.MarkListened(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) GetMessageEdits(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	edits, err := h.service.GetMessageEdits(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"edits": edits,
+	})
+}
+
+func messageErrorResponse(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, websocket.ErrEmptyText), errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, websocket.ErrNothingToForward), errors.Is(err, websocket.ErrTooManyForwards):
+		status = http.StatusBadRequest
+	case errors.Is(err, websocket.ErrNotParticipant), errors.Is(err, websocket.ErrNotAllowed),
+		errors.Is(err, attachment.ErrNotParticipant), errors.Is(err, attachment.ErrNoPermission):
+		status = http.StatusForbidden
+	case errors.Is(err, attachment.ErrEmptyFile), errors.Is(err, websocket.ErrNotVoice):
+		status = http.StatusBadRequest
+	case errors.Is(err, attachment.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, attachment.ErrUnsupportedImage):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, websocket.ErrMessageNotFound), errors.Is(err, ErrMessageNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, websocket.ErrMessageDeleted):
+		status = http.StatusConflict
+	case errors.Is(err, websocket.ErrNoUsers), errors.Is(err, websocket.ErrTooManyUsers),
+		errors.Is(err, websocket.ErrNotGroup), errors.Is(err, websocket.ErrInvalidRole),
+		errors.Is(err, websocket.ErrInvalidName):
+		status = http.StatusBadRequest
+	case errors.Is(err, websocket.ErrNoPermission), errors.Is(err, websocket.ErrCannotRemoveOwner):
+		status = http.StatusForbidden
+	case errors.Is(err, websocket.ErrUnknownUser), errors.Is(err, websocket.ErrNotMember):
+		status = http.StatusNotFound
+	case errors.Is(err, websocket.ErrOwnerCannotLeave):
+		status = http.StatusConflict
+	case errors.Is(err, websocket.ErrInvalidInvite):
+		status = http.StatusBadRequest
+	case errors.Is(err, websocket.ErrInviteNotFound), errors.Is(err, websocket.ErrNoJoinRequest):
+		status = http.StatusNotFound
+	case errors.Is(err, websocket.ErrInviteExpired), errors.Is(err, websocket.ErrInviteUsedUp):
+		status = http.StatusGone
+	case errors.Is(err, websocket.ErrInvalidHandle), errors.Is(err, websocket.ErrNotChannel):
+		status = http.StatusBadRequest
+	case errors.Is(err, websocket.ErrHandleTaken):
+		status = http.StatusConflict
+	case errors.Is(err, websocket.ErrPrivateChannel):
+		status = http.StatusForbidden
+	case errors.Is(err, websocket.ErrNotSubscribed), errors.Is(err, ErrChannelNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidMute), errors.Is(err, ErrInvalidFolder),
+		errors.Is(err, ErrTooManyFolders), errors.Is(err, ErrNotPinned):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrTooManyPinned):
+		status = http.StatusConflict
+	}
+
+	return c.JSON(status, map[string]string{
+		"error": err.Error(),
 	})
 }
 
@@ -55,12 +309,7 @@ func (h *Handler) CreateChat(c echo.Context) error {
 		})
 	}
 
-	userID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	userID := authentication.CurrentUser(c).UID
 
 	chatID, err := h.service.createSimpleChat(c.Request().Context(), userID, req.ChatName, req.Emails, req.ChatType)
 	if err != nil {
@@ -74,9 +323,3 @@ func (h *Handler) CreateChat(c echo.Context) error {
 		"chat_name": req.ChatName,
 	})
 }
-
-func (h *Handler) getUserIDFromToken(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	return h.service.ValidateToken(r.Context(), token)
-}
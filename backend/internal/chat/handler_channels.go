@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/search"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ChannelResponse is what anyone can see of a public channel before
+// subscribing.
+type ChannelResponse struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Handle          string    `json:"handle"`
+	SubscriberCount int       `json:"subscriber_count"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CreateChannel handles POST /api/channels.
+func (h *Handler) CreateChannel(c echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+		// Handle is optional; a channel without one is private.
+		Handle string `json:"handle"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.CreateChannel(c.Request().Context(), userID, req.Name, req.Handle)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// SearchChannels handles GET /api/channels?q=<handle prefix>&limit=<n>.
+func (h *Handler) SearchChannels(c echo.Context) error {
+	limit := search.DefaultLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > search.MaxLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("limit must be between 1 and %d", search.MaxLimit),
+			})
+		}
+		limit = n
+	}
+
+	channels, err := h.service.SearchChannels(c.Request().Context(), c.QueryParam("q"), limit)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"channels": channels,
+		"count":    len(channels),
+	})
+}
+
+// GetChannel handles GET /api/channels/:handle.
+func (h *Handler) GetChannel(c echo.Context) error {
+	channel, err := h.service.FindChannel(c.Request().Context(), c.Param("handle"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"channel": channel,
+	})
+}
+
+// SetHandle handles PUT /api/chats/:chatId/handle. An empty handle
+// makes the channel private.
+func (h *Handler) SetHandle(c echo.Context) error {
+	var req struct {
+		Handle string `json:"handle"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.SetHandle(c.Request().Context(), c.Param("chatId"), userID, req.Handle)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// Subscribe handles POST /api/chats/:chatId/subscription.
+func (h *Handler) Subscribe(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.Subscribe(c.Request().Context(), c.Param("chatId"), userID)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// Unsubscribe handles DELETE /api/chats/:chatId/subscription.
+func (h *Handler) Unsubscribe(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	if err := h.service.Unsubscribe(c.Request().Context(), c.Param("chatId"), userID); err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ViewPosts handles POST /api/chats/:chatId/messages/:messageId/view:
+// the caller has seen the channel up to this post.
+func (h *Handler) ViewPosts(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.ViewPosts(c.Request().Context(), c.Param("chatId"), userID, c.Param("messageId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
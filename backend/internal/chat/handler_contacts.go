@@ -5,6 +5,8 @@ import (
 	"strings"
 	"time"
 
+	"MyChatServer/internal/authentication"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -21,6 +23,11 @@ type ChatResponse struct {
 	CreatedBy    string    `json:"created_by"`
 	CreatedAt    time.Time `json:"created_at"`
 	Participants []string  `json:"participants"`
+	// Roles maps each participant of a group chat to its role.
+	Roles map[string]string `json:"roles,omitempty"`
+	// Handle and SubscriberCount are set for channels.
+	Handle          string `json:"handle,omitempty"`
+	SubscriberCount int    `json:"subscriber_count,omitempty"`
 }
 
 func (h *Handler) CreateChatFromContacts(c echo.Context) error {
@@ -56,12 +63,7 @@ func (h *Handler) CreateChatFromContacts(c echo.Context) error {
 		})
 	}
 
-	userID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	userID := authentication.CurrentUser(c).UID
 
 	chatResponse, err := h.service.CreateChatFromContacts(
 		c.Request().Context(),
@@ -104,12 +106,7 @@ func (h *Handler) CreatePrivateChat(c echo.Context) error {
 		})
 	}
 
-	userID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	userID := authentication.CurrentUser(c).UID
 
 	chatResponse, err := h.service.CreatePrivateChat(c.Request().Context(), userID, contactID)
 	if err != nil {
@@ -140,12 +137,7 @@ func (h *Handler) CreatePrivateChat(c echo.Context) error {
 }
 
 func (h *Handler) GetUserContacts(c echo.Context) error {
-	userID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	userID := authentication.CurrentUser(c).UID
 
 	contacts, err := h.service.GetUserContacts(c.Request().Context(), userID)
 	if err != nil {
@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"net/http"
+	"time"
+
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/websocket"
+
+	"github.com/labstack/echo/v4"
+)
+
+type InviteResponse struct {
+	Token     string    `json:"token"`
+	ChatID    string    `json:"chat_id"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is omitted for a link that does not expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxUses is 0 for a link without a usage limit.
+	MaxUses          int      `json:"max_uses"`
+	Uses             int      `json:"uses"`
+	RequiresApproval bool     `json:"requires_approval"`
+	Pending          []string `json:"pending"`
+}
+
+// CreateInvite handles POST /api/chats/:chatId/invites.
+func (h *Handler) CreateInvite(c echo.Context) error {
+	var req struct {
+		// ExpiresIn is the lifetime of the link in seconds.
+		ExpiresIn        int64 `json:"expires_in"`
+		MaxUses          int   `json:"max_uses"`
+		RequiresApproval bool  `json:"requires_approval"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	invite, err := h.service.CreateInvite(c.Request().Context(), c.Param("chatId"), userID, websocket.InviteOptions{
+		ExpiresIn:        time.Duration(req.ExpiresIn) * time.Second,
+		MaxUses:          req.MaxUses,
+		RequiresApproval: req.RequiresApproval,
+	})
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"invite": invite,
+	})
+}
+
+// ListInvites handles GET /api/chats/:chatId/invites.
+func (h *Handler) ListInvites(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	invites, err := h.service.ListInvites(c.Request().Context(), c.Param("chatId"), userID)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"invites": invites,
+		"count":   len(invites),
+	})
+}
+
+// RevokeInvite handles DELETE /api/chats/:chatId/invites/:token.
+func (h *Handler) RevokeInvite(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.RevokeInvite(c.Request().Context(), c.Param("chatId"), userID, c.Param("token"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// JoinByInvite handles POST /api/chats/join/:token. It answers 202
+// when the link needs an admin's approval.
+func (h *Handler) JoinByInvite(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.JoinByInvite(c.Request().Context(), userID, c.Param("token"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+	if chat == nil {
+		return c.JSON(http.StatusAccepted, map[string]string{
+			"status": "pending",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status": "joined",
+		"chat":   chat,
+	})
+}
+
+// ApproveJoinRequest handles
+// POST /api/chats/:chatId/invites/:token/requests/:userId.
+func (h *Handler) ApproveJoinRequest(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.ApproveJoinRequest(c.Request().Context(), c.Param("chatId"), userID,
+		c.Param("token"), c.Param("userId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// DeclineJoinRequest handles
+// DELETE /api/chats/:chatId/invites/:token/requests/:userId.
+func (h *Handler) DeclineJoinRequest(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.DeclineJoinRequest(c.Request().Context(), c.Param("chatId"), userID,
+		c.Param("token"), c.Param("userId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"net/http"
+
+	"MyChatServer/internal/authentication"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AddParticipants handles POST /api/chats/:chatId/participants.
+func (h *Handler) AddParticipants(c echo.Context) error {
+	var req struct {
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.AddParticipants(c.Request().Context(), c.Param("chatId"), userID, req.UserIDs)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// RemoveParticipant handles DELETE /api/chats/:chatId/participants/:userId.
+func (h *Handler) RemoveParticipant(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	err := h.service.RemoveParticipant(c.Request().Context(), c.Param("chatId"), userID, c.Param("userId"))
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LeaveChat handles POST /api/chats/:chatId/leave.
+func (h *Handler) LeaveChat(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	if err := h.service.LeaveChat(c.Request().Context(), c.Param("chatId"), userID); err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteChat handles DELETE /api/chats/:chatId.
+func (h *Handler) DeleteChat(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	if err := h.service.DeleteChat(c.Request().Context(), c.Param("chatId"), userID); err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RenameChat handles PATCH /api/chats/:chatId.
+func (h *Handler) RenameChat(c echo.Context) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.RenameChat(c.Request().Context(), c.Param("chatId"), userID, req.Name)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// SetRole handles PUT /api/chats/:chatId/participants/:userId/role.
+func (h *Handler) SetRole(c echo.Context) error {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.SetRole(c.Request().Context(), c.Param("chatId"), userID, c.Param("userId"), req.Role)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
+
+// TransferOwnership handles POST /api/chats/:chatId/owner.
+func (h *Handler) TransferOwnership(c echo.Context) error {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	chat, err := h.service.TransferOwnership(c.Request().Context(), c.Param("chatId"), userID, req.UserID)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"chat": chat,
+	})
+}
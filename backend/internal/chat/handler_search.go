@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"MyChatServer/internal/authentication"
+	"MyChatServer/internal/search"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SearchMessages handles GET /api/search/messages?q=<query>&limit=<n>.
+// Only chats the caller participates in are searched.
+func (h *Handler) SearchMessages(c echo.Context) error {
+	query := c.QueryParam("q")
+	if query == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Search query is required",
+		})
+	}
+
+	limit := search.DefaultLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > search.MaxLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("limit must be between 1 and %d", search.MaxLimit),
+			})
+		}
+		limit = n
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	hits, err := h.service.SearchMessages(c.Request().Context(), userID, query, limit)
+	if err != nil {
+		if errors.Is(err, search.ErrEmptyQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": hits,
+	})
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"net/http"
+
+	"MyChatServer/internal/authentication"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetChatSettings handles GET /api/chats/:chatId/settings.
+func (h *Handler) GetChatSettings(c echo.Context) error {
+	userID := authentication.CurrentUser(c).UID
+
+	settings, err := h.service.GetChatSettings(c.Request().Context(), c.Param("chatId"), userID)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"settings": settings,
+	})
+}
+
+// UpdateChatSettings handles PATCH /api/chats/:chatId/settings. Only
+// the fields present in the body change.
+func (h *Handler) UpdateChatSettings(c echo.Context) error {
+	var req SettingsUpdate
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	settings, err := h.service.UpdateChatSettings(c.Request().Context(), c.Param("chatId"), userID, req)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"settings": settings,
+	})
+}
+
+// ReorderPinned handles PUT /api/chats/pinned.
+func (h *Handler) ReorderPinned(c echo.Context) error {
+	var req struct {
+		ChatIDs []string `json:"chat_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid JSON format",
+		})
+	}
+
+	userID := authentication.CurrentUser(c).UID
+
+	pinned, err := h.service.ReorderPinned(c.Request().Context(), userID, req.ChatIDs)
+	if err != nil {
+		return messageErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pinned": pinned,
+	})
+}
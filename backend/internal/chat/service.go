@@ -2,113 +2,716 @@ package chat
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"MyChatServer/internal/attachment"
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/search"
 	"MyChatServer/internal/websocket"
+)
 
-	"cloud.google.com/go/firestore"
+var (
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrChannelNotFound = errors.New("channel not found")
 )
 
 // Service contains business logic for chat operations:
 // creating chats, sending messages, retrieving message history,
 // checking participant access.
 type Service struct {
-	db       *database.Client
+	db       database.Store
+	auth     identity.Provider
 	wsServer *websocket.Server
+	media    *attachment.Service
+	search   *search.Service
 }
 
 type MessageResponse struct {
-	ID        string    `json:"id"`
-	ChatID    string    `json:"chat_id"`
-	SenderID  string    `json:"sender_id"`
-	Text      string    `json:"text"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        string     `json:"id"`
+	ChatID    string     `json:"chat_id"`
+	SenderID  string     `json:"sender_id"`
+	Text      string     `json:"text"`
+	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	// Reactions are aggregated for the user the response is built
+	// for, most popular first.
+	Reactions   []ReactionResponse `json:"reactions"`
+	ReplyTo     *ReplyPreview      `json:"reply_to,omitempty"`
+	ThreadID    string             `json:"thread_id,omitempty"`
+	ReplyCount  int                `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time         `json:"last_reply_at,omitempty"`
+	// ForwardedFrom credits the original sender and chat of a
+	// forwarded message.
+	ForwardedFrom *database.MessageOrigin      `json:"forwarded_from,omitempty"`
+	Attachments   []database.MessageAttachment `json:"attachments,omitempty"`
+	// Voice is set for voice messages; ListenedBy is their
+	// "listened" receipt, separate from read receipts.
+	Voice      *VoiceResponse `json:"voice,omitempty"`
+	ListenedBy []string       `json:"listened_by,omitempty"`
+	// Mentions lists the participants mentioned in the text.
+	Mentions []string `json:"mentions,omitempty"`
+	// TempID is the client-generated ID the message was sent with.
+	TempID string `json:"temp_id,omitempty"`
+	// Views counts the subscribers who have seen a channel post.
+	Views int `json:"views,omitempty"`
+	// PinnedAt and PinnedBy are set while the message is pinned.
+	PinnedAt *time.Time `json:"pinned_at,omitempty"`
+	PinnedBy string     `json:"pinned_by,omitempty"`
+
+	replyTo string
+}
+
+// VoiceResponse describes the recording of a voice message.
+type VoiceResponse struct {
+	AttachmentID string `json:"attachment_id"`
+	MIMEType     string `json:"mime_type"`
+	DurationMS   int64  `json:"duration_ms"`
+	Waveform     []int  `json:"waveform"`
+}
+
+// ReplyPreview quotes the message being replied to.
+type ReplyPreview = database.MessageQuote
+
+type ReactionResponse struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+type MessageEditResponse struct {
+	Text     string    `json:"text"`
+	EditedAt time.Time `json:"edited_at"`
 }
 
-func NewService(db *database.Client, wsServer *websocket.Server) *Service {
+func NewService(db database.Store, auth identity.Provider, wsServer *websocket.Server, media *attachment.Service) *Service {
 	return &Service{db: db,
+		auth:     auth,
 		wsServer: wsServer,
+		media:    media,
+		search:   search.NewService(db),
 	}
 }
 
-func (s *Service) ValidateToken(ctx context.Context, idToken string) (string, error) {
-	return s.db.ValidateIdToken(ctx, idToken)
+// MessagesQuery selects a page of chat history. At most one of
+// Before, After and Around is set; an empty query returns the latest
+// messages.
+type MessagesQuery struct {
+	Before string
+	After  string
+	// Around is a message ID to center the page on.
+	Around string
+	Limit  int
+	// ThreadID pages through the replies of one thread instead of
+	// the chat itself.
+	ThreadID string
 }
 
-func (s *Service) GetMessages(ctx context.Context, chatID, userID string) ([]MessageResponse, error) {
-	isParticipant, err := s.isChatParticipant(ctx, chatID, userID)
-	if !isParticipant || err != nil {
-		return nil, fmt.Errorf("access denied or chat not found")
-	}
+// MessagesPage is a slice of chat history, oldest first. PrevCursor
+// and NextCursor are passed back as before/after to continue paging.
+type MessagesPage struct {
+	// Root is the thread's first message when paging a thread.
+	Root          *MessageResponse  `json:"root,omitempty"`
+	Messages      []MessageResponse `json:"messages"`
+	PrevCursor    string            `json:"prev_cursor,omitempty"`
+	NextCursor    string            `json:"next_cursor,omitempty"`
+	HasMoreBefore bool              `json:"has_more_before"`
+	HasMoreAfter  bool              `json:"has_more_after"`
+}
 
-	docs, err := s.db.Firestore.Collection("chats").
-		Doc(chatID).
-		Collection("messages").
-		OrderBy("timestamp", firestore.Asc).
-		Documents(ctx).GetAll()
+func (s *Service) GetMessages(ctx context.Context, chatID, userID string, query MessagesQuery) (*MessagesPage, error) {
+	_, err := s.wsServer.AuthorizeRead(ctx, userID, chatID)
 	if err != nil {
 		return nil, err
 	}
 
-	messages := make([]MessageResponse, 0, len(docs))
+	var older, newer []database.Message
+	var moreBefore, moreAfter bool
+
+	switch {
+	case query.Around != "":
+		anchor, err := s.db.Messages().Get(ctx, chatID, query.Around)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil, ErrMessageNotFound
+			}
+			return nil, err
+		}
+		if anchor.ThreadID != query.ThreadID {
+			return nil, ErrMessageNotFound
+		}
+		cursor := anchor.Cursor()
+
+		half := (query.Limit - 1) / 2
+		older, moreBefore, err = s.pageBefore(ctx, chatID, query.ThreadID, &cursor, half)
+		if err != nil {
+			return nil, err
+		}
+		newer, moreAfter, err = s.pageAfter(ctx, chatID, query.ThreadID, cursor, query.Limit-1-len(older))
+		if err != nil {
+			return nil, err
+		}
+		older = append(older, *anchor)
+
+	case query.After != "":
+		cursor, err := decodeCursor(query.After)
+		if err != nil {
+			return nil, err
+		}
+		newer, moreAfter, err = s.pageAfter(ctx, chatID, query.ThreadID, cursor, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+		moreBefore = true
+
+	default:
+		var cursor *database.MessageCursor
+		if query.Before != "" {
+			c, err := decodeCursor(query.Before)
+			if err != nil {
+				return nil, err
+			}
+			cursor = &c
+			moreAfter = true
+		}
+		older, moreBefore, err = s.pageBefore(ctx, chatID, query.ThreadID, cursor, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	docs := append(older, newer...)
+	page := &MessagesPage{
+		Messages:      make([]MessageResponse, 0, len(docs)),
+		HasMoreBefore: moreBefore,
+		HasMoreAfter:  moreAfter,
+	}
+	if len(docs) > 0 {
+		page.PrevCursor = encodeCursor(docs[0].Cursor())
+		page.NextCursor = encodeCursor(docs[len(docs)-1].Cursor())
+	} else {
+		page.PrevCursor, page.NextCursor = query.After, query.Before
+	}
 
 	for _, doc := range docs {
-		data := doc.Data()
+		if doc.HiddenFrom(userID) {
+			continue
+		}
+		page.Messages = append(page.Messages, messageResponse(chatID, userID, &doc))
+	}
+
+	if err := s.attachReplies(ctx, chatID, userID, page.Messages, docs); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// GetThread pages through the replies of the thread rooted at rootID
+// and includes the root message itself.
+func (s *Service) GetThread(ctx context.Context, chatID, userID, rootID string, query MessagesQuery) (*MessagesPage, error) {
+	if _, err := s.wsServer.AuthorizeRead(ctx, userID, chatID); err != nil {
+		return nil, err
+	}
+
+	root, err := s.db.Messages().Get(ctx, chatID, rootID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if root.ThreadID != "" || root.HiddenFrom(userID) {
+		return nil, ErrMessageNotFound
+	}
+
+	query.ThreadID = rootID
+	page, err := s.GetMessages(ctx, chatID, userID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []MessageResponse{messageResponse(chatID, userID, root)}
+	if err := s.attachReplies(ctx, chatID, userID, roots, nil); err != nil {
+		return nil, err
+	}
+	page.Root = &roots[0]
+	return page, nil
+}
+
+// attachReplies fills in the quoted snippets of messages that reply
+// to another one, reusing loaded when the quoted message is among
+// them.
+func (s *Service) attachReplies(ctx context.Context, chatID, userID string, messages []MessageResponse, loaded []database.Message) error {
+	byID := make(map[string]*database.Message, len(loaded))
+	for i := range loaded {
+		byID[loaded[i].ID] = &loaded[i]
+	}
 
-		msg := MessageResponse{
-			ID:     doc.Ref.ID,
-			ChatID: chatID,
+	for i := range messages {
+		replyTo := messages[i].replyTo
+		if replyTo == "" {
+			continue
 		}
 
-		if sender, ok := data["sender_id"].(string); ok {
-			msg.SenderID = sender
-		} else {
-			log.Printf("Invalid sender_id type in message %s: got %T, value %v", doc.Ref.ID, data["sender_id"], data["sender_id"])
-			msg.SenderID = "unknown"
+		quoted, ok := byID[replyTo]
+		if !ok {
+			msg, err := s.db.Messages().Get(ctx, chatID, replyTo)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+			quoted = msg
+			byID[replyTo] = msg
 		}
 
-		if text, ok := data["text"].(string); ok {
-			msg.Text = text
-		} else {
-			log.Printf("Invalid text type in message %s: got %T, value %v", doc.Ref.ID, data["text"], data["text"])
-			msg.Text = ""
+		messages[i].ReplyTo = database.QuoteFor(replyTo, quoted, userID)
+	}
+	return nil
+}
+
+// messageResponse converts a stored message as seen by userID.
+func messageResponse(chatID, userID string, doc *database.Message) MessageResponse {
+	msg := MessageResponse{
+		ID:        doc.ID,
+		ChatID:    chatID,
+		SenderID:  doc.SenderID,
+		Text:      doc.Text,
+		Timestamp: doc.Timestamp,
+		Deleted:   doc.Deleted(),
+		Reactions: []ReactionResponse{},
+		ThreadID:  doc.ThreadID,
+		replyTo:   doc.ReplyTo,
+
+		ForwardedFrom: doc.ForwardedFrom,
+		TempID:        doc.TempID,
+		Views:         doc.Views,
+	}
+
+	// A message deleted for everyone keeps none of its content.
+	if !msg.Deleted {
+		msg.Attachments = doc.Attachments
+		msg.Mentions = doc.Mentions
+		if voice := doc.Voice(); voice != nil {
+			msg.Voice = &VoiceResponse{
+				AttachmentID: voice.ID,
+				MIMEType:     voice.MIMEType,
+				DurationMS:   voice.DurationMS,
+				Waveform:     voice.Waveform,
+			}
+			msg.ListenedBy = doc.ListenedBy
 		}
+	}
+
+	if doc.ReplyCount > 0 {
+		msg.ReplyCount = doc.ReplyCount
+		lastReplyAt := doc.LastReplyAt
+		msg.LastReplyAt = &lastReplyAt
+	}
+
+	for _, r := range doc.ReactionsFor(userID) {
+		msg.Reactions = append(msg.Reactions, ReactionResponse{Emoji: r.Emoji, Count: r.Count, Reacted: r.Reacted})
+	}
+
+	if editedAt := doc.EditedAt(); !editedAt.IsZero() {
+		msg.EditedAt = &editedAt
+	}
+
+	if doc.Pinned() {
+		pinnedAt := doc.PinnedAt
+		msg.PinnedAt = &pinnedAt
+		msg.PinnedBy = doc.PinnedBy
+	}
+
+	if msg.SenderID == "" {
+		log.Printf("Missing sender_id in message %s", doc.ID)
+		msg.SenderID = "unknown"
+	}
+
+	if msg.Timestamp.IsZero() {
+		log.Printf("Missing timestamp in message %s", doc.ID)
+		msg.Timestamp = time.Now()
+	}
+
+	return msg
+}
+
+// EditMessage and DeleteMessage go through the WebSocket server so
+// that REST and socket clients share one write path.
+func (s *Service) EditMessage(ctx context.Context, chatID, userID, messageID, text string) (*MessageResponse, error) {
+	msg, err := s.wsServer.EditMessage(ctx, userID, chatID, messageID, text)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := []MessageResponse{messageResponse(chatID, userID, msg)}
+	if err := s.attachReplies(ctx, chatID, userID, resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp[0], nil
+}
+
+// SendImage posts an image message. The image is stored without its
+// location metadata; the thumbnail and blurhash follow in a
+// message_updated event once the background workers produce them.
+func (s *Service) SendImage(ctx context.Context, chatID, userID, name string, r io.Reader, size int64, text string) (*MessageResponse, error) {
+	image, err := s.media.UploadImage(ctx, chatID, userID, name, r, size)
+	if err != nil {
+		return nil, err
+	}
 
-		if ts, ok := data["timestamp"].(time.Time); ok {
-			msg.Timestamp = ts
-		} else {
-			log.Printf("Invalid timestamp type in message %s: got %T, value %v", doc.Ref.ID, data["timestamp"], data["timestamp"])
-			msg.Timestamp = time.Now()
+	msg, err := s.wsServer.SendMessage(ctx, userID, chatID, text, []database.MessageAttachment{image.Ref()})
+	if err != nil {
+		return nil, err
+	}
+
+	queued := s.media.ProcessImage(image, func(updated *database.Attachment) {
+		if err := s.wsServer.UpdateAttachment(context.Background(), chatID, msg.ID, updated); err != nil {
+			log.Printf("Failed to attach thumbnail to message %s: %v", msg.ID, err)
 		}
+	})
+	if !queued {
+		log.Printf("Image queue is full, message %s stays without a thumbnail", msg.ID)
+	}
+
+	resp := messageResponse(chatID, userID, msg)
+	return &resp, nil
+}
+
+// ForwardMessages copies messages of chatID into the target chats.
+func (s *Service) ForwardMessages(ctx context.Context, chatID, userID string, messageIDs, targetChatIDs []string) ([]websocket.ForwardResult, error) {
+	return s.wsServer.ForwardMessages(ctx, userID, chatID, messageIDs, targetChatIDs)
+}
+
+// PinMessage pins or unpins a message of the chat.
+func (s *Service) PinMessage(ctx context.Context, chatID, userID, messageID string, pin bool) (*MessageResponse, error) {
+	msg, err := s.wsServer.PinMessage(ctx, userID, chatID, messageID, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := messageResponse(chatID, userID, msg)
+	return &resp, nil
+}
+
+func (s *Service) MarkListened(ctx context.Context, chatID, userID, messageID string) error {
+	return s.wsServer.MarkListened(ctx, userID, chatID, messageID)
+}
+
+// AddParticipants adds users to a group chat and returns the chat
+// with its new member list.
+func (s *Service) AddParticipants(ctx context.Context, chatID, userID string, uids []string) (*ChatResponse, error) {
+	chat, err := s.wsServer.AddParticipants(ctx, userID, chatID, uids)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) RemoveParticipant(ctx context.Context, chatID, userID, uid string) error {
+	return s.wsServer.RemoveParticipant(ctx, userID, chatID, uid)
+}
+
+func (s *Service) LeaveChat(ctx context.Context, chatID, userID string) error {
+	return s.wsServer.LeaveChat(ctx, userID, chatID)
+}
+
+func (s *Service) DeleteChat(ctx context.Context, chatID, userID string) error {
+	return s.wsServer.DeleteChat(ctx, userID, chatID)
+}
+
+func (s *Service) RenameChat(ctx context.Context, chatID, userID, name string) (*ChatResponse, error) {
+	chat, err := s.wsServer.RenameChat(ctx, userID, chatID, name)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) SetRole(ctx context.Context, chatID, userID, uid, role string) (*ChatResponse, error) {
+	chat, err := s.wsServer.SetRole(ctx, userID, chatID, uid, role)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) TransferOwnership(ctx context.Context, chatID, userID, uid string) (*ChatResponse, error) {
+	chat, err := s.wsServer.TransferOwnership(ctx, userID, chatID, uid)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+// CreateInvite makes an invite link for a group chat.
+func (s *Service) CreateInvite(ctx context.Context, chatID, userID string, opts websocket.InviteOptions) (*InviteResponse, error) {
+	invite, err := s.wsServer.CreateInvite(ctx, userID, chatID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return inviteResponse(invite), nil
+}
+
+func (s *Service) ListInvites(ctx context.Context, chatID, userID string) ([]InviteResponse, error) {
+	invites, err := s.wsServer.ListInvites(ctx, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]InviteResponse, 0, len(invites))
+	for i := range invites {
+		result = append(result, *inviteResponse(&invites[i]))
+	}
+	return result, nil
+}
+
+func (s *Service) RevokeInvite(ctx context.Context, chatID, userID, token string) error {
+	return s.wsServer.RevokeInvite(ctx, userID, chatID, token)
+}
+
+// JoinByInvite redeems an invite link. The chat is nil when the link
+// needs an admin's approval first.
+func (s *Service) JoinByInvite(ctx context.Context, userID, token string) (*ChatResponse, error) {
+	chat, pending, err := s.wsServer.JoinByInvite(ctx, userID, token)
+	if err != nil || pending {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) ApproveJoinRequest(ctx context.Context, chatID, userID, token, uid string) (*ChatResponse, error) {
+	chat, err := s.wsServer.ApproveJoinRequest(ctx, userID, chatID, token, uid)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) DeclineJoinRequest(ctx context.Context, chatID, userID, token, uid string) error {
+	return s.wsServer.DeclineJoinRequest(ctx, userID, chatID, token, uid)
+}
+
+// CreateChannel makes a new channel owned by userID; a handle makes
+// it public.
+func (s *Service) CreateChannel(ctx context.Context, userID, name, handle string) (*ChatResponse, error) {
+	chat, err := s.wsServer.CreateChannel(ctx, userID, name, handle)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) SetHandle(ctx context.Context, chatID, userID, handle string) (*ChatResponse, error) {
+	chat, err := s.wsServer.SetHandle(ctx, userID, chatID, handle)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) Subscribe(ctx context.Context, chatID, userID string) (*ChatResponse, error) {
+	chat, err := s.wsServer.Subscribe(ctx, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return chatResponse(chat), nil
+}
+
+func (s *Service) Unsubscribe(ctx context.Context, chatID, userID string) error {
+	return s.wsServer.Unsubscribe(ctx, userID, chatID)
+}
+
+func (s *Service) ViewPosts(ctx context.Context, chatID, userID, messageID string) error {
+	return s.wsServer.ViewPosts(ctx, userID, chatID, messageID)
+}
 
-		messages = append(messages, msg)
+// FindChannel looks up a public channel by its handle.
+func (s *Service) FindChannel(ctx context.Context, handle string) (*ChannelResponse, error) {
+	handle, err := websocket.NormalizeHandle(handle)
+	if err != nil {
+		return nil, ErrChannelNotFound
 	}
 
-	return messages, nil
+	chat, err := s.db.Chats().GetByHandle(ctx, handle)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load channel: %v", err)
+	}
+	return channelResponse(chat), nil
 }
 
-func (s *Service) isChatParticipant(ctx context.Context, chatID, userID string) (bool, error) {
-	doc, err := s.db.Firestore.Collection("chats").Doc(chatID).Get(ctx)
+// SearchChannels lists public channels whose handle starts with
+// prefix.
+func (s *Service) SearchChannels(ctx context.Context, prefix string, limit int) ([]ChannelResponse, error) {
+	prefix = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(prefix), "@"))
+	if prefix == "" {
+		return []ChannelResponse{}, nil
+	}
+
+	chats, err := s.db.Chats().SearchByHandle(ctx, prefix, limit)
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("failed to search channels: %v", err)
+	}
+
+	result := make([]ChannelResponse, 0, len(chats))
+	for i := range chats {
+		result = append(result, *channelResponse(&chats[i]))
 	}
+	return result, nil
+}
+
+func channelResponse(chat *database.Chat) *ChannelResponse {
+	return &ChannelResponse{
+		ID:              chat.ID,
+		Name:            chat.Name,
+		Handle:          chat.Handle,
+		SubscriberCount: chat.SubscriberCount,
+		CreatedAt:       chat.CreatedAt,
+	}
+}
+
+func inviteResponse(invite *database.Invite) *InviteResponse {
+	response := &InviteResponse{
+		Token:            invite.Token,
+		ChatID:           invite.ChatID,
+		CreatedBy:        invite.CreatedBy,
+		CreatedAt:        invite.CreatedAt,
+		MaxUses:          invite.MaxUses,
+		Uses:             invite.Uses,
+		RequiresApproval: invite.RequiresApproval,
+		Pending:          invite.Pending,
+	}
+	if !invite.ExpiresAt.IsZero() {
+		expiresAt := invite.ExpiresAt
+		response.ExpiresAt = &expiresAt
+	}
+	if response.Pending == nil {
+		response.Pending = []string{}
+	}
+	return response
+}
+
+func chatResponse(chat *database.Chat) *ChatResponse {
+	return &ChatResponse{
+		ID:           chat.ID,
+		Name:         chat.Name,
+		Type:         chat.Type,
+		CreatedBy:    chat.CreatedBy,
+		CreatedAt:    chat.CreatedAt,
+		Participants: chat.Participants,
+		Roles:        chat.ParticipantRoles(),
+
+		Handle:          chat.Handle,
+		SubscriberCount: chat.SubscriberCount,
+	}
+}
 
-	data := doc.Data()
-	participants, ok := data["participants"].([]interface{})
-	if !ok {
-		return false, nil
+func (s *Service) DeleteMessage(ctx context.Context, chatID, userID, messageID string, forEveryone bool) error {
+	return s.wsServer.DeleteMessage(ctx, userID, chatID, messageID, forEveryone)
+}
+
+// GetMessageEdits returns the earlier versions of a message's text,
+// oldest first.
+func (s *Service) GetMessageEdits(ctx context.Context, chatID, userID, messageID string) ([]MessageEditResponse, error) {
+	if _, err := s.wsServer.AuthorizeRead(ctx, userID, chatID); err != nil {
+		return nil, err
 	}
 
-	for _, p := range participants {
-		if p.(string) == userID {
-			return true, nil
+	msg, err := s.db.Messages().Get(ctx, chatID, messageID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrMessageNotFound
 		}
+		return nil, err
+	}
+	if msg.HiddenFrom(userID) {
+		return nil, ErrMessageNotFound
+	}
+
+	edits := make([]MessageEditResponse, len(msg.Edits))
+	for i, edit := range msg.Edits {
+		edits[i] = MessageEditResponse{Text: edit.Text, EditedAt: edit.EditedAt}
+	}
+	return edits, nil
+}
+
+// pageBefore returns up to limit messages preceding cursor (or the
+// latest ones) and whether older messages remain.
+func (s *Service) pageBefore(ctx context.Context, chatID, threadID string, cursor *database.MessageCursor, limit int) ([]database.Message, bool, error) {
+	page := database.MessagePage{Before: cursor, Limit: limit + 1, ThreadID: threadID}
+	if limit <= 0 {
+		page.Limit = 1
+		return nil, s.hasMessages(ctx, chatID, page), nil
+	}
+
+	docs, err := s.db.Messages().ListPage(ctx, chatID, page)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(docs) > limit {
+		return docs[1:], true, nil
+	}
+	return docs, false, nil
+}
+
+// pageAfter returns up to limit messages following cursor and whether
+// newer messages remain.
+func (s *Service) pageAfter(ctx context.Context, chatID, threadID string, cursor database.MessageCursor, limit int) ([]database.Message, bool, error) {
+	page := database.MessagePage{After: &cursor, Limit: limit + 1, ThreadID: threadID}
+	if limit <= 0 {
+		page.Limit = 1
+		return nil, s.hasMessages(ctx, chatID, page), nil
+	}
+
+	docs, err := s.db.Messages().ListPage(ctx, chatID, page)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(docs) > limit {
+		return docs[:limit], true, nil
+	}
+	return docs, false, nil
+}
+
+func (s *Service) hasMessages(ctx context.Context, chatID string, page database.MessagePage) bool {
+	docs, err := s.db.Messages().ListPage(ctx, chatID, page)
+	return err == nil && len(docs) > 0
+}
+
+// encodeCursor turns a position into the opaque string handed to
+// clients.
+func encodeCursor(c database.MessageCursor) string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + "_" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (database.MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.MessageCursor{}, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), "_")
+	if !ok || id == "" {
+		return database.MessageCursor{}, ErrInvalidCursor
+	}
+	ts, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return database.MessageCursor{}, ErrInvalidCursor
 	}
 
-	return false, nil
+	return database.MessageCursor{Timestamp: time.Unix(0, ts).UTC(), ID: id}, nil
 }
 
 func (s *Service) createSimpleChat(ctx context.Context, creatorID, chatName string, emails []string, chatType string) (string, error) {
@@ -119,7 +722,7 @@ func (s *Service) createSimpleChat(ctx context.Context, creatorID, chatName stri
 			continue
 		}
 
-		user, err := s.db.GetUserByEmail(ctx, email)
+		user, err := s.db.Users().GetByEmail(ctx, email)
 		if err != nil {
 			continue
 		}
@@ -147,78 +750,83 @@ func (s *Service) createSimpleChat(ctx context.Context, creatorID, chatName stri
 func (s *Service) createChatDocument(ctx context.Context, creatorID, chatName string, participants []string, chatType string) (string, error) {
 	now := time.Now()
 
-	chatData := map[string]interface{}{
-		"name":         chatName,
-		"type":         chatType,
-		"participants": participants,
-		"created_by":   creatorID,
-		"created_at":   now,
-		"updated_at":   now,
-	}
-
-	docRef, _, err := s.db.Firestore.Collection("chats").Add(ctx, chatData)
+	chatID, err := s.db.Chats().Create(ctx, &database.Chat{
+		Name:         chatName,
+		Type:         chatType,
+		Participants: participants,
+		CreatedBy:    creatorID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create chat: %v", err)
 	}
 
-	_, err = docRef.Collection("messages").Doc("welcome").Set(ctx, map[string]interface{}{
-		"sender_id": "system",
-		"text":      fmt.Sprintf("Chat '%s' created", chatName),
-		"timestamp": now,
-	})
+	_, err = s.db.Messages().Add(ctx, chatID, welcomeMessage(chatName, now))
+	s.recordChatCreated(ctx, chatID, creatorID, participants)
 
-	return docRef.ID, err
+	return chatID, err
 }
 
-func (s *Service) getUserProfile(ctx context.Context, userID string) (*database.User, error) {
-	doc, err := s.db.Firestore.Collection("users").Doc(userID).Get(ctx)
+// recordChatCreated adds the new chat to the participants' sync
+// journals. A failure is logged and does not undo the chat.
+func (s *Service) recordChatCreated(ctx context.Context, chatID, creatorID string, participants []string) {
+	err := s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatCreated,
+		ChatID: chatID,
+		UserID: creatorID,
+	}, participants)
 	if err != nil {
-		return nil, err
+		log.Printf("Failed to record chat_created change: %v", err)
 	}
+}
 
-	var user database.User
-	if err := doc.DataTo(&user); err != nil {
-		return nil, err
+func welcomeMessage(chatName string, now time.Time) *database.Message {
+	return &database.Message{
+		ID:        "welcome",
+		SenderID:  "system",
+		Text:      fmt.Sprintf("Chat '%s' created", chatName),
+		Timestamp: now,
 	}
-
-	return &user, nil
 }
 
-func (s *Service) findExistingPrivateChat(ctx context.Context, userID1, userID2 string) (string, error) {
-	docs, err := s.db.Firestore.Collection("chats").
-		Where("type", "==", "private").
-		Where("participants", "array-contains", userID1).
-		Documents(ctx).GetAll()
+func (s *Service) getUserProfile(ctx context.Context, userID string) (*database.User, error) {
+	return s.db.Users().Get(ctx, userID)
+}
 
+func (s *Service) findExistingPrivateChat(ctx context.Context, userID1, userID2 string) (*database.Chat, error) {
+	chats, err := s.db.Chats().ListByParticipant(ctx, userID1)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	for _, doc := range docs {
-		data := doc.Data()
-		participants, ok := data["participants"].([]interface{})
-		if !ok {
+	for i := range chats {
+		chat := &chats[i]
+		if chat.Type != "private" || len(chat.Participants) != 2 {
 			continue
 		}
 
 		hasUser1 := false
 		hasUser2 := false
 
-		for _, p := range participants {
-			if pStr, ok := p.(string); ok {
-				if pStr == userID1 {
-					hasUser1 = true
-				}
-				if pStr == userID2 {
-					hasUser2 = true
-				}
+		for _, p := range chat.Participants {
+			if p == userID1 {
+				hasUser1 = true
+			}
+			if p == userID2 {
+				hasUser2 = true
 			}
 		}
 
-		if hasUser1 && hasUser2 && len(participants) == 2 {
-			return doc.Ref.ID, nil
+		if hasUser1 && hasUser2 {
+			return chat, nil
 		}
 	}
 
-	return "", nil
+	return nil, nil
+}
+
+// SearchMessages runs a full-text query over the user's chats.
+func (s *Service) SearchMessages(ctx context.Context, userID, query string, limit int) ([]search.Hit, error) {
+	return s.search.Search(ctx, userID, query, limit)
 }
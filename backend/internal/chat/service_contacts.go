@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"MyChatServer/internal/database"
 	"MyChatServer/internal/websocket"
 	"context"
 	"fmt"
@@ -17,23 +18,11 @@ type ContactInfo struct {
 }
 
 func (s *Service) GetContactByID(ctx context.Context, contactID string) (*ContactInfo, error) {
-	doc, err := s.db.Firestore.Collection("contacts").Doc(contactID).Get(ctx)
+	contact, err := s.db.Contacts().Get(ctx, contactID)
 	if err != nil {
 		return nil, fmt.Errorf("contact not found: %v", err)
 	}
 
-	var contact struct {
-		OwnerUID     string    `firestore:"OwnerUID"`
-		ContactUID   string    `firestore:"ContactUID"`
-		ContactEmail string    `firestore:"ContactEmail"`
-		ContactName  string    `firestore:"ContactName"`
-		CreatedAt    time.Time `firestore:"CreatedAt"`
-	}
-
-	if err := doc.DataTo(&contact); err != nil {
-		return nil, fmt.Errorf("failed to parse contact: %v", err)
-	}
-
 	return &ContactInfo{
 		ContactID:    contactID,
 		OwnerUID:     contact.OwnerUID,
@@ -44,30 +33,15 @@ func (s *Service) GetContactByID(ctx context.Context, contactID string) (*Contac
 }
 
 func (s *Service) GetUserContacts(ctx context.Context, userID string) ([]ContactInfo, error) {
-	docs, err := s.db.Firestore.Collection("contacts").
-		Where("OwnerUID", "==", userID).
-		Documents(ctx).GetAll()
-
+	docs, err := s.db.Contacts().ListByOwner(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get contacts: %v", err)
 	}
 
 	contacts := make([]ContactInfo, 0, len(docs))
-	for _, doc := range docs {
-		var contact struct {
-			OwnerUID     string    `firestore:"owner_uid"`
-			ContactUID   string    `firestore:"contact_uid"`
-			ContactEmail string    `firestore:"contact_email"`
-			ContactName  string    `firestore:"contact_name"`
-			CreatedAt    time.Time `firestore:"created_at"`
-		}
-
-		if err := doc.DataTo(&contact); err != nil {
-			continue
-		}
-
+	for _, contact := range docs {
 		contacts = append(contacts, ContactInfo{
-			ContactID:    doc.Ref.ID,
+			ContactID:    contact.ID,
 			OwnerUID:     contact.OwnerUID,
 			ContactUID:   contact.ContactUID,
 			ContactEmail: contact.ContactEmail,
@@ -84,7 +58,7 @@ func (s *Service) CreateChatFromContacts(ctx context.Context, creatorID, chatNam
 	for _, contactID := range contactIDs {
 		contact, err := s.GetContactByID(ctx, contactID)
 		if err != nil {
-			_, err := s.db.Firestore.Collection("users").Doc(contactID).Get(ctx)
+			_, err := s.db.Users().Get(ctx, contactID)
 			if err != nil {
 				continue
 			}
@@ -149,20 +123,16 @@ func (s *Service) CreatePrivateChat(ctx context.Context, creatorID, contactID st
 		return nil, fmt.Errorf("contact does not belong to user")
 	}
 
-	existingChatID, err := s.findExistingPrivateChat(ctx, creatorID, contact.ContactUID)
-	if err == nil && existingChatID != "" {
-		chatDoc, err := s.db.Firestore.Collection("chats").Doc(existingChatID).Get(ctx)
-		if err == nil {
-			data := chatDoc.Data()
-			return &ChatResponse{
-				ID:           existingChatID,
-				Name:         data["name"].(string),
-				Type:         data["type"].(string),
-				CreatedBy:    data["created_by"].(string),
-				CreatedAt:    data["created_at"].(time.Time),
-				Participants: convertToStringSlice(data["participants"]),
-			}, nil
-		}
+	existingChat, err := s.findExistingPrivateChat(ctx, creatorID, contact.ContactUID)
+	if err == nil && existingChat != nil {
+		return &ChatResponse{
+			ID:           existingChat.ID,
+			Name:         existingChat.Name,
+			Type:         existingChat.Type,
+			CreatedBy:    existingChat.CreatedBy,
+			CreatedAt:    existingChat.CreatedAt,
+			Participants: existingChat.Participants,
+		}, nil
 	}
 
 	userProfile, err1 := s.getUserProfile(ctx, creatorID)
@@ -202,19 +172,22 @@ func (s *Service) createChatWithData(ctx context.Context, creatorID, chatName st
 		"updated_at":   now,
 	}
 
-	docRef, _, err := s.db.Firestore.Collection("chats").Add(ctx, chatData)
+	chatID, err := s.db.Chats().Create(ctx, &database.Chat{
+		Name:         chatName,
+		Type:         chatType,
+		Participants: participants,
+		CreatedBy:    creatorID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to create chat: %v", err)
 	}
 
-	chatID := docRef.ID
 	chatData["chat_id"] = chatID
 
-	_, err = docRef.Collection("messages").Doc("welcome").Set(ctx, map[string]interface{}{
-		"sender_id": "system",
-		"text":      fmt.Sprintf("Chat '%s' created", chatName),
-		"timestamp": now,
-	})
+	_, err = s.db.Messages().Add(ctx, chatID, welcomeMessage(chatName, now))
+	s.recordChatCreated(ctx, chatID, creatorID, participants)
 
 	if s.wsServer != nil {
 		go s.notifyChatCreated(chatID, chatData, creatorID)
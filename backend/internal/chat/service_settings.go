@@ -0,0 +1,260 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/websocket"
+)
+
+var (
+	ErrTooManyPinned  = errors.New("too many pinned chats")
+	ErrNotPinned      = errors.New("chat is not pinned")
+	ErrInvalidFolder  = errors.New("folder names must be 1 to 32 characters")
+	ErrTooManyFolders = errors.New("too many folders")
+	ErrInvalidMute    = errors.New("mute_for must be -1, 0 or a number of seconds")
+)
+
+const (
+	maxPinnedChats  = 10
+	maxFolders      = 20
+	maxFolderLength = 32
+	// muteForever is how far ahead mute_for = -1 mutes a chat.
+	muteForever = 100 * 365 * 24 * time.Hour
+)
+
+// SettingsUpdate changes some of a user's settings of a chat; nil
+// fields are left as they are.
+type SettingsUpdate struct {
+	// MuteFor mutes the chat for this many seconds, at most
+	// muteForever; 0 unmutes it and -1 mutes it until it is unmuted.
+	MuteFor  *int64    `json:"mute_for"`
+	Archived *bool     `json:"archived"`
+	Pinned   *bool     `json:"pinned"`
+	Folders  *[]string `json:"folders"`
+}
+
+type ChatSettingsResponse struct {
+	ChatID string `json:"chat_id"`
+	// MutedUntil is omitted when the chat is not muted.
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	PinOrder   int        `json:"pin_order,omitempty"`
+	Folders    []string   `json:"folders"`
+}
+
+// GetChatSettings returns userID's settings of a chat, the defaults
+// when they never changed them.
+func (s *Service) GetChatSettings(ctx context.Context, chatID, userID string) (*ChatSettingsResponse, error) {
+	settings, err := s.chatSettings(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return chatSettingsResponse(settings), nil
+}
+
+// UpdateChatSettings applies update to userID's settings of a chat.
+// A newly pinned chat goes after the chats pinned before it.
+func (s *Service) UpdateChatSettings(ctx context.Context, chatID, userID string, update SettingsUpdate) (*ChatSettingsResponse, error) {
+	settings, err := s.chatSettings(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if update.MuteFor != nil {
+		switch muteFor := *update.MuteFor; {
+		case muteFor == -1, muteFor >= int64(muteForever/time.Second):
+			// Longer mutes would overflow time.Duration.
+			settings.MutedUntil = now.Add(muteForever)
+		case muteFor == 0:
+			settings.MutedUntil = time.Time{}
+		case muteFor > 0:
+			settings.MutedUntil = now.Add(time.Duration(muteFor) * time.Second)
+		default:
+			return nil, ErrInvalidMute
+		}
+	}
+	if update.Folders != nil {
+		folders, err := normalizeFolders(*update.Folders)
+		if err != nil {
+			return nil, err
+		}
+		settings.Folders = folders
+	}
+	if update.Archived != nil {
+		settings.Archived = *update.Archived
+	}
+	if update.Pinned != nil && *update.Pinned != settings.Pinned {
+		settings.Pinned = *update.Pinned
+		settings.PinOrder = 0
+		if settings.Pinned {
+			last, count, err := s.lastPin(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			if count >= maxPinnedChats {
+				return nil, ErrTooManyPinned
+			}
+			settings.PinOrder = last + 1
+		}
+	}
+
+	settings.UpdatedAt = now
+	if err := s.db.ChatSettings().Save(ctx, settings); err != nil {
+		return nil, fmt.Errorf("failed to save chat settings: %v", err)
+	}
+
+	s.notifySettingsChanged(ctx, userID, []*database.ChatSettings{settings})
+	return chatSettingsResponse(settings), nil
+}
+
+// ReorderPinned sets the pin order of userID's pinned chats to the
+// order of chatIDs. Pinned chats that are not listed keep their
+// relative order after the listed ones.
+func (s *Service) ReorderPinned(ctx context.Context, userID string, chatIDs []string) ([]ChatSettingsResponse, error) {
+	all, err := s.db.ChatSettings().ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat settings: %v", err)
+	}
+
+	var pinned []*database.ChatSettings
+	for i := range all {
+		if all[i].Pinned {
+			pinned = append(pinned, &all[i])
+		}
+	}
+	slices.SortStableFunc(pinned, func(a, b *database.ChatSettings) int {
+		return a.PinOrder - b.PinOrder
+	})
+
+	ordered := make([]*database.ChatSettings, 0, len(pinned))
+	for _, chatID := range chatIDs {
+		i := slices.IndexFunc(pinned, func(settings *database.ChatSettings) bool {
+			return settings.ChatID == chatID
+		})
+		if i < 0 {
+			return nil, ErrNotPinned
+		}
+		ordered = append(ordered, pinned[i])
+		pinned = slices.Delete(pinned, i, i+1)
+	}
+	ordered = append(ordered, pinned...)
+
+	now := time.Now()
+	var changed []*database.ChatSettings
+	result := make([]ChatSettingsResponse, 0, len(ordered))
+	for i, settings := range ordered {
+		if settings.PinOrder != i+1 {
+			settings.PinOrder = i + 1
+			settings.UpdatedAt = now
+			if err := s.db.ChatSettings().Save(ctx, settings); err != nil {
+				return nil, fmt.Errorf("failed to save chat settings: %v", err)
+			}
+			changed = append(changed, settings)
+		}
+		result = append(result, *chatSettingsResponse(settings))
+	}
+
+	s.notifySettingsChanged(ctx, userID, changed)
+	return result, nil
+}
+
+// chatSettings loads userID's settings of a chat they can read, or
+// fresh defaults when there are none yet.
+func (s *Service) chatSettings(ctx context.Context, chatID, userID string) (*database.ChatSettings, error) {
+	if _, err := s.wsServer.AuthorizeRead(ctx, userID, chatID); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.db.ChatSettings().Get(ctx, userID, chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return &database.ChatSettings{ChatID: chatID, UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat settings: %v", err)
+	}
+	return settings, nil
+}
+
+// lastPin returns the highest pin order among userID's pinned chats
+// and how many chats are pinned.
+func (s *Service) lastPin(ctx context.Context, userID string) (last, count int, err error) {
+	all, err := s.db.ChatSettings().ListByUser(ctx, userID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load chat settings: %v", err)
+	}
+	for _, settings := range all {
+		if settings.Pinned {
+			count++
+			last = max(last, settings.PinOrder)
+		}
+	}
+	return last, count, nil
+}
+
+// notifySettingsChanged records the change in the user's journal, so
+// that their other devices pick it up on sync, and sends
+// chat_settings_changed for each chat.
+func (s *Service) notifySettingsChanged(ctx context.Context, userID string, changed []*database.ChatSettings) {
+	for _, settings := range changed {
+		err := s.db.Changes().Append(ctx, &database.Change{
+			Type:   database.ChangeChatUpdated,
+			ChatID: settings.ChatID,
+			UserID: userID,
+		}, []string{userID})
+		if err != nil {
+			log.Printf("Failed to record chat_updated change: %v", err)
+		}
+
+		s.wsServer.SendToUser(userID, websocket.WSEvent{
+			Type:   "chat_settings_changed",
+			ChatID: settings.ChatID,
+			UserID: userID,
+			Data:   chatSettingsResponse(settings),
+		})
+	}
+}
+
+// normalizeFolders trims folder names and drops duplicates.
+func normalizeFolders(names []string) ([]string, error) {
+	folders := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || len([]rune(name)) > maxFolderLength {
+			return nil, ErrInvalidFolder
+		}
+		if !slices.Contains(folders, name) {
+			folders = append(folders, name)
+		}
+	}
+	if len(folders) > maxFolders {
+		return nil, ErrTooManyFolders
+	}
+	return folders, nil
+}
+
+func chatSettingsResponse(settings *database.ChatSettings) *ChatSettingsResponse {
+	response := &ChatSettingsResponse{
+		ChatID:   settings.ChatID,
+		Archived: settings.Archived,
+		Pinned:   settings.Pinned,
+		PinOrder: settings.PinOrder,
+		Folders:  settings.Folders,
+	}
+	if settings.Muted(time.Now()) {
+		mutedUntil := settings.MutedUntil
+		response.MutedUntil = &mutedUntil
+	}
+	if response.Folders == nil {
+		response.Folders = []string{}
+	}
+	return response
+}
@@ -2,7 +2,8 @@ package contact
 
 import (
 	"net/http"
-	"strings"
+
+	"MyChatServer/internal/authentication"
 
 	"github.com/labstack/echo/v4"
 )
@@ -29,12 +30,7 @@ func (h *ContactHandler) AddContact(c echo.Context) error {
 		})
 	}
 
-	ownerUID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	ownerUID := authentication.CurrentUser(c).UID
 
 	contact, err := h.service.AddContact(c.Request().Context(), ownerUID, req.Email, req.Notes)
 	if err != nil {
@@ -50,12 +46,7 @@ func (h *ContactHandler) AddContact(c echo.Context) error {
 }
 
 func (h *ContactHandler) GetContacts(c echo.Context) error {
-	ownerUID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	ownerUID := authentication.CurrentUser(c).UID
 
 	contacts, err := h.service.GetContacts(c.Request().Context(), ownerUID)
 	if err != nil {
@@ -77,12 +68,7 @@ func (h *ContactHandler) SearchUsers(c echo.Context) error {
 		})
 	}
 
-	ownerUID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	ownerUID := authentication.CurrentUser(c).UID
 
 	users, err := h.service.SearchUsers(c.Request().Context(), ownerUID, query)
 	if err != nil {
@@ -104,12 +90,7 @@ func (h *ContactHandler) DeleteContact(c echo.Context) error {
 		})
 	}
 
-	ownerUID, err := h.getUserIDFromToken(c.Request())
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": "Invalid token",
-		})
-	}
+	ownerUID := authentication.CurrentUser(c).UID
 
 	contact, err := h.service.GetContactByID(c.Request().Context(), contactID)
 	if err != nil {
@@ -135,9 +116,3 @@ func (h *ContactHandler) DeleteContact(c echo.Context) error {
 		"message": "Contact deleted successfully",
 	})
 }
-
-func (h *ContactHandler) getUserIDFromToken(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	return h.service.db.ValidateIdToken(r.Context(), token)
-}
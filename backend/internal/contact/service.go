@@ -2,22 +2,21 @@ package contact
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"MyChatServer/internal/database"
-
-	"cloud.google.com/go/firestore"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"MyChatServer/internal/identity"
 )
 
 // ContactService manages user's contact list:
 // adding, removing, searching users, checking if contact already exists.
 type ContactService struct {
-	db *database.Client
+	db   database.Store
+	auth identity.Provider
 }
 
 type Contact struct {
@@ -35,12 +34,12 @@ type ContactRequest struct {
 	Notes string `json:"notes,omitempty"`
 }
 
-func NewContactService(db *database.Client) *ContactService {
-	return &ContactService{db: db}
+func NewContactService(db database.Store, auth identity.Provider) *ContactService {
+	return &ContactService{db: db, auth: auth}
 }
 
 func (s *ContactService) AddContact(ctx context.Context, ownerUID, email, notes string) (*Contact, error) {
-	user, err := s.db.GetUserByEmail(ctx, email)
+	user, err := s.db.Users().GetByEmail(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
@@ -69,44 +68,46 @@ func (s *ContactService) AddContact(ctx context.Context, ownerUID, email, notes
 		Notes:        notes,
 	}
 
-	_, err = s.db.Firestore.Collection("contacts").Doc(contactID).Set(ctx, contact)
+	err = s.db.Contacts().Save(ctx, &database.Contact{
+		ID:           contact.ID,
+		OwnerUID:     contact.OwnerUID,
+		ContactUID:   contact.ContactUID,
+		ContactEmail: contact.ContactEmail,
+		ContactName:  contact.ContactName,
+		CreatedAt:    contact.CreatedAt,
+		Notes:        contact.Notes,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to save contact: %v", err)
 	}
 
+	s.recordChange(ctx, ownerUID, database.ChangeContactSaved, contactID)
+
 	return &contact, nil
 }
 
 func (s *ContactService) isContactExists(ctx context.Context, ownerUID, contactUID string) (bool, error) {
 	contactID := fmt.Sprintf("%s_%s", ownerUID, contactUID)
-	doc, err := s.db.Firestore.Collection("contacts").Doc(contactID).Get(ctx)
+	_, err := s.db.Contacts().Get(ctx, contactID)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		if errors.Is(err, database.ErrNotFound) {
 			log.Printf("[DEBUG] Contact does not exist: %s", contactID)
 			return false, nil
 		}
 		return false, err
 	}
-	return doc.Exists(), nil
+	return true, nil
 }
 
 func (s *ContactService) GetContacts(ctx context.Context, ownerUID string) ([]Contact, error) {
-	docs, err := s.db.Firestore.Collection("contacts").
-		Where("OwnerUID", "==", ownerUID).
-		OrderBy("CreatedAt", firestore.Desc).
-		Documents(ctx).GetAll()
-
+	docs, err := s.db.Contacts().ListByOwner(ctx, ownerUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get contacts: %v", err)
 	}
 
 	contacts := make([]Contact, len(docs))
 	for i, doc := range docs {
-		var contact Contact
-		if err := doc.DataTo(&contact); err != nil {
-			continue
-		}
-		contacts[i] = contact
+		contacts[i] = contactFromRecord(&doc)
 	}
 
 	return contacts, nil
@@ -116,7 +117,7 @@ func (s *ContactService) SearchUsers(ctx context.Context, ownerUID, query string
 	results := []UserSearchResult{}
 
 	if len(query) > 3 && strings.Contains(query, "@") {
-		user, err := s.db.GetUserByEmail(ctx, query)
+		user, err := s.db.Users().GetByEmail(ctx, query)
 		if err == nil && user.UID != ownerUID {
 			isContact, _ := s.isContactExists(ctx, ownerUID, user.UID)
 			results = append(results, UserSearchResult{
@@ -128,18 +129,9 @@ func (s *ContactService) SearchUsers(ctx context.Context, ownerUID, query string
 		}
 	}
 
-	docs, err := s.db.Firestore.Collection("users").
-		Where("name", ">=", query).
-		Where("name", "<=", query+"\uf8ff").
-		Documents(ctx).GetAll()
-
+	users, err := s.db.Users().SearchByName(ctx, query)
 	if err == nil {
-		for _, doc := range docs {
-			var user database.User
-			if err := doc.DataTo(&user); err != nil {
-				continue
-			}
-
+		for _, user := range users {
 			if user.UID == ownerUID {
 				continue
 			}
@@ -170,24 +162,48 @@ func (s *ContactService) SearchUsers(ctx context.Context, ownerUID, query string
 
 func (s *ContactService) DeleteContact(ctx context.Context, ownerUID, contactUID string) error {
 	contactID := fmt.Sprintf("%s_%s", ownerUID, contactUID)
-	_, err := s.db.Firestore.Collection("contacts").Doc(contactID).Delete(ctx)
-	return err
+	if err := s.db.Contacts().Delete(ctx, contactID); err != nil {
+		return err
+	}
+
+	s.recordChange(ctx, ownerUID, database.ChangeContactRemoved, contactID)
+	return nil
 }
 
-func (s *ContactService) GetContactByID(ctx context.Context, contactID string) (*Contact, error) {
-	doc, err := s.db.Firestore.Collection("contacts").Doc(contactID).Get(ctx)
+// recordChange adds a contact change to the owner's sync journal.
+func (s *ContactService) recordChange(ctx context.Context, ownerUID, changeType, contactID string) {
+	err := s.db.Changes().Append(ctx, &database.Change{
+		Type:      changeType,
+		ContactID: contactID,
+		UserID:    ownerUID,
+	}, []string{ownerUID})
 	if err != nil {
-		return nil, err
+		log.Printf("Failed to record %s change: %v", changeType, err)
 	}
+}
 
-	var contact Contact
-	if err := doc.DataTo(&contact); err != nil {
+func (s *ContactService) GetContactByID(ctx context.Context, contactID string) (*Contact, error) {
+	record, err := s.db.Contacts().Get(ctx, contactID)
+	if err != nil {
 		return nil, err
 	}
 
+	contact := contactFromRecord(record)
 	return &contact, nil
 }
 
+func contactFromRecord(record *database.Contact) Contact {
+	return Contact{
+		ID:           record.ID,
+		OwnerUID:     record.OwnerUID,
+		ContactUID:   record.ContactUID,
+		ContactEmail: record.ContactEmail,
+		ContactName:  record.ContactName,
+		CreatedAt:    record.CreatedAt,
+		Notes:        record.Notes,
+	}
+}
+
 type UserSearchResult struct {
 	UID       string `json:"uid"`
 	Email     string `json:"email"`
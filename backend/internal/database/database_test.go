@@ -152,3 +152,74 @@ func TestUserFields(t *testing.T) {
 		t.Error("IsBanned should be false")
 	}
 }
+
+func TestUserBanned(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		user User
+		want bool
+	}{
+		{"not banned", User{}, false},
+		{"permanent ban", User{IsBanned: true}, true},
+		{"ban in effect", User{IsBanned: true, BannedUntil: now.Add(time.Hour)}, true},
+		{"ban expired", User{IsBanned: true, BannedUntil: now.Add(-time.Hour)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.Banned(now); got != tt.want {
+				t.Errorf("Banned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatRoles(t *testing.T) {
+	chat := &Chat{
+		Type:         "group",
+		CreatedBy:    "creator",
+		Participants: []string{"creator", "admin", "member", "reader"},
+		Roles:        map[string]string{"admin": ChatRoleAdmin, "reader": ChatRoleReadOnly},
+	}
+	private := &Chat{Type: "private", CreatedBy: "a", Participants: []string{"a", "b"}}
+	channel := &Chat{Type: "channel", CreatedBy: "owner", Participants: []string{"owner", "staff"}}
+
+	tests := []struct {
+		name string
+		chat *Chat
+		uid  string
+		perm ChatPermission
+		want bool
+	}{
+		{"creator owns", chat, "creator", PermChangeRoles, true},
+		{"admin renames", chat, "admin", PermRename, true},
+		{"admin cannot change roles", chat, "admin", PermChangeRoles, false},
+		{"member sends", chat, "member", PermSend, true},
+		{"member cannot invite", chat, "member", PermInvite, false},
+		{"read-only reads", chat, "reader", PermRead, true},
+		{"read-only cannot send", chat, "reader", PermSend, false},
+		{"stranger reads", chat, "stranger", PermRead, false},
+		{"private side deletes chat", private, "b", PermDeleteChat, true},
+		{"private creator cannot invite", private, "a", PermInvite, false},
+		{"channel owner posts", channel, "owner", PermSend, true},
+		{"channel member cannot post", channel, "staff", PermSend, false},
+		{"channel member reads", channel, "staff", PermRead, true},
+	}
+	for _, tt := range tests {
+		if got := tt.chat.Can(tt.uid, tt.perm); got != tt.want {
+			t.Errorf("%s: Can(%s, %s) = %v, want %v", tt.name, tt.uid, tt.perm, got, tt.want)
+		}
+	}
+
+	if !chat.Outranks("admin", "member") || chat.Outranks("admin", "creator") || chat.Outranks("member", "member") {
+		t.Error("Outranks does not follow owner > admin > member > read_only")
+	}
+
+	chat.Roles["member"] = ChatRoleOwner
+	if chat.Role("creator") != ChatRoleMember || chat.Role("member") != ChatRoleOwner {
+		t.Errorf("after transfer: creator is %s and member is %s, want member and owner",
+			chat.Role("creator"), chat.Role("member"))
+	}
+}
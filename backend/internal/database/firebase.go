@@ -3,7 +3,6 @@ package database
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
@@ -20,13 +19,7 @@ type Client struct {
 	APIKey    string
 }
 
-type User struct {
-	UID       string    `firestore:"uid"`
-	Name      string    `firestore:"name"`
-	Email     string    `firestore:"email"`
-	CreatedAt time.Time `firestore:"created_at"`
-	IsBanned  bool      `firestore:"is_banned"`
-}
+var _ Store = (*Client)(nil)
 
 func NewClient(ctx context.Context, serviceAccountPath string, apiKey string) (*Client, error) {
 	sa := option.WithCredentialsFile(serviceAccountPath)
@@ -59,57 +52,50 @@ func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) CreateUserInAuth(ctx context.Context, email, password, name string) (*auth.UserRecord, error) {
-	user, err := c.Auth.CreateUser(ctx, (&auth.UserToCreate{}).
-		Email(email).
-		Password(password).
-		DisplayName(name))
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) Users() UserRepository {
+	return &firestoreUsers{fs: c.Firestore}
+}
 
-	return user, nil
+func (c *Client) Credentials() CredentialRepository {
+	return &firestoreCredentials{fs: c.Firestore}
 }
 
-func (c *Client) SaveUserInFirestore(ctx context.Context, user *User) error {
-	_, err := c.Firestore.Collection("users").Doc(user.UID).Set(ctx, map[string]interface{}{
-		"uid":        user.UID,
-		"name":       user.Name,
-		"email":      user.Email,
-		"created_at": user.CreatedAt,
-		"is_banned":  user.IsBanned,
-	})
-	return err
+func (c *Client) Sessions() SessionRepository {
+	return &firestoreSessions{fs: c.Firestore}
 }
 
-func (c *Client) DeleteAuthUser(ctx context.Context, uid string) error {
-	return c.Auth.DeleteUser(ctx, uid)
+func (c *Client) Contacts() ContactRepository {
+	return &firestoreContacts{fs: c.Firestore}
 }
 
-func (c *Client) GetUserByEmail(ctx context.Context, email string) (*User, error) {
-	authUser, err := c.Auth.GetUserByEmail(ctx, email)
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) Chats() ChatRepository {
+	return &firestoreChats{fs: c.Firestore}
+}
 
-	doc, err := c.Firestore.Collection("users").Doc(authUser.UID).Get(ctx)
-	if err != nil {
-		return nil, err
-	}
+func (c *Client) Messages() MessageRepository {
+	return &firestoreMessages{fs: c.Firestore}
+}
 
-	var user User
-	if err := doc.DataTo(&user); err != nil {
-		return nil, err
-	}
+func (c *Client) Attachments() AttachmentRepository {
+	return &firestoreAttachments{fs: c.Firestore}
+}
 
-	return &user, nil
+func (c *Client) Changes() ChangeRepository {
+	return &firestoreChanges{fs: c.Firestore}
 }
 
-func (c *Client) ValidateIdToken(ctx context.Context, idToken string) (string, error) {
-	token, err := c.Auth.VerifyIDToken(ctx, idToken)
-	if err != nil {
-		return "", fmt.Errorf("invalid idToken: %v", err)
-	}
+func (c *Client) Search() SearchRepository {
+	return &firestoreSearch{fs: c.Firestore}
+}
+
+func (c *Client) Invites() InviteRepository {
+	return &firestoreInvites{fs: c.Firestore}
+}
+
+func (c *Client) Subscriptions() SubscriptionRepository {
+	return &firestoreSubscriptions{fs: c.Firestore}
+}
 
-	return token.UID, nil
+func (c *Client) ChatSettings() ChatSettingsRepository {
+	return &firestoreChatSettings{fs: c.Firestore}
 }
@@ -0,0 +1,1293 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreUsers struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreUsers) Save(ctx context.Context, user *User) error {
+	_, err := r.fs.Collection("users").Doc(user.UID).Set(ctx, user)
+	return err
+}
+
+func (r *firestoreUsers) Get(ctx context.Context, uid string) (*User, error) {
+	doc, err := r.fs.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *firestoreUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	doc, err := r.fs.Collection("users").
+		Where("email", "==", email).
+		Limit(1).
+		Documents(ctx).Next()
+	if err == iterator.Done {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *firestoreUsers) SearchByName(ctx context.Context, prefix string) ([]User, error) {
+	docs, err := r.fs.Collection("users").
+		Where("name", ">=", prefix).
+		Where("name", "<=", prefix+"\uf8ff").
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(docs))
+	for _, doc := range docs {
+		var user User
+		if err := doc.DataTo(&user); err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+type firestoreCredentials struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreCredentials) Save(ctx context.Context, credential *Credential) error {
+	_, err := r.fs.Collection("credentials").Doc(credential.UID).Set(ctx, credential)
+	return err
+}
+
+func (r *firestoreCredentials) GetByEmail(ctx context.Context, email string) (*Credential, error) {
+	doc, err := r.fs.Collection("credentials").
+		Where("email", "==", email).
+		Limit(1).
+		Documents(ctx).Next()
+	if err == iterator.Done {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var credential Credential
+	if err := doc.DataTo(&credential); err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+func (r *firestoreCredentials) Delete(ctx context.Context, uid string) error {
+	_, err := r.fs.Collection("credentials").Doc(uid).Delete(ctx)
+	return err
+}
+
+type firestoreSessions struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreSessions) Save(ctx context.Context, session *Session) error {
+	_, err := r.fs.Collection("sessions").Doc(session.ID).Set(ctx, session)
+	return err
+}
+
+func (r *firestoreSessions) Get(ctx context.Context, id string) (*Session, error) {
+	doc, err := r.fs.Collection("sessions").Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var session Session
+	if err := doc.DataTo(&session); err != nil {
+		return nil, err
+	}
+	session.ID = doc.Ref.ID
+
+	return &session, nil
+}
+
+// ListByUser sorts in memory to avoid requiring a composite index.
+func (r *firestoreSessions) ListByUser(ctx context.Context, uid string) ([]Session, error) {
+	docs, err := r.fs.Collection("sessions").
+		Where("uid", "==", uid).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(docs))
+	for _, doc := range docs {
+		var session Session
+		if err := doc.DataTo(&session); err != nil {
+			continue
+		}
+		session.ID = doc.Ref.ID
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (r *firestoreSessions) Delete(ctx context.Context, id string) error {
+	_, err := r.fs.Collection("sessions").Doc(id).Delete(ctx)
+	return err
+}
+
+func (r *firestoreSessions) DeleteByUser(ctx context.Context, uid string) error {
+	docs, err := r.fs.Collection("sessions").
+		Where("uid", "==", uid).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type firestoreContacts struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreContacts) Save(ctx context.Context, contact *Contact) error {
+	_, err := r.fs.Collection("contacts").Doc(contact.ID).Set(ctx, contact)
+	return err
+}
+
+func (r *firestoreContacts) Get(ctx context.Context, id string) (*Contact, error) {
+	doc, err := r.fs.Collection("contacts").Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var contact Contact
+	if err := doc.DataTo(&contact); err != nil {
+		return nil, err
+	}
+	contact.ID = doc.Ref.ID
+
+	return &contact, nil
+}
+
+func (r *firestoreContacts) ListByOwner(ctx context.Context, ownerUID string) ([]Contact, error) {
+	docs, err := r.fs.Collection("contacts").
+		Where("OwnerUID", "==", ownerUID).
+		OrderBy("CreatedAt", firestore.Desc).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make([]Contact, 0, len(docs))
+	for _, doc := range docs {
+		var contact Contact
+		if err := doc.DataTo(&contact); err != nil {
+			continue
+		}
+		contact.ID = doc.Ref.ID
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, nil
+}
+
+func (r *firestoreContacts) Delete(ctx context.Context, id string) error {
+	_, err := r.fs.Collection("contacts").Doc(id).Delete(ctx)
+	return err
+}
+
+type firestoreChats struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreChats) Create(ctx context.Context, chat *Chat) (string, error) {
+	docRef, _, err := r.fs.Collection("chats").Add(ctx, chat)
+	if err != nil {
+		return "", err
+	}
+
+	chat.ID = docRef.ID
+	return docRef.ID, nil
+}
+
+func (r *firestoreChats) Get(ctx context.Context, id string) (*Chat, error) {
+	doc, err := r.fs.Collection("chats").Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	return chatFromSnapshot(doc)
+}
+
+func (r *firestoreChats) ListByParticipant(ctx context.Context, uid string) ([]Chat, error) {
+	docs, err := r.fs.Collection("chats").
+		Where("participants", "array-contains", uid).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]Chat, 0, len(docs))
+	for _, doc := range docs {
+		chat, err := chatFromSnapshot(doc)
+		if err != nil {
+			log.Printf("Skipping malformed chat %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		chats = append(chats, *chat)
+	}
+
+	return chats, nil
+}
+
+func (r *firestoreChats) List(ctx context.Context) ([]Chat, error) {
+	docs, err := r.fs.Collection("chats").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]Chat, 0, len(docs))
+	for _, doc := range docs {
+		chat, err := chatFromSnapshot(doc)
+		if err != nil {
+			log.Printf("Skipping malformed chat %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		chats = append(chats, *chat)
+	}
+
+	return chats, nil
+}
+
+func (r *firestoreChats) SetLastMessage(ctx context.Context, chatID string, msg *Message) error {
+	_, err := r.fs.Collection("chats").Doc(chatID).Update(ctx, []firestore.Update{
+		{
+			Path:  "last_message",
+			Value: msg,
+		},
+		{
+			Path:  "updated_at",
+			Value: firestore.ServerTimestamp,
+		},
+	})
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreChats) AddUnreadMentions(ctx context.Context, chatID string, uids []string, delta int) error {
+	ref := r.fs.Collection("chats").Doc(chatID)
+
+	return r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return wrapFirestoreError(err)
+		}
+
+		chat, err := chatFromSnapshot(doc)
+		if err != nil {
+			return err
+		}
+
+		updates := make([]firestore.Update, 0, len(uids))
+		for _, uid := range uids {
+			updates = append(updates, firestore.Update{
+				FieldPath: firestore.FieldPath{"unread_mentions", uid},
+				Value:     max(chat.UnreadMentions[uid]+delta, 0),
+			})
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
+func (r *firestoreChats) AddParticipants(ctx context.Context, chatID string, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(uids))
+	for i, uid := range uids {
+		values[i] = uid
+	}
+	_, err := r.fs.Collection("chats").Doc(chatID).Update(ctx, []firestore.Update{
+		{
+			Path:  "participants",
+			Value: firestore.ArrayUnion(values...),
+		},
+		{
+			Path:  "updated_at",
+			Value: firestore.ServerTimestamp,
+		},
+	})
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreChats) RemoveParticipant(ctx context.Context, chatID, uid string) error {
+	_, err := r.fs.Collection("chats").Doc(chatID).Update(ctx, []firestore.Update{
+		{
+			Path:  "participants",
+			Value: firestore.ArrayRemove(uid),
+		},
+		{
+			FieldPath: firestore.FieldPath{"unread_mentions", uid},
+			Value:     firestore.Delete,
+		},
+		{
+			FieldPath: firestore.FieldPath{"roles", uid},
+			Value:     firestore.Delete,
+		},
+		{
+			Path:  "updated_at",
+			Value: firestore.ServerTimestamp,
+		},
+	})
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreChats) SetRoles(ctx context.Context, chatID string, roles map[string]string) error {
+	updates := make([]firestore.Update, 0, len(roles))
+	for uid, role := range roles {
+		updates = append(updates, firestore.Update{
+			FieldPath: firestore.FieldPath{"roles", uid},
+			Value:     role,
+		})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	_, err := r.fs.Collection("chats").Doc(chatID).Update(ctx, updates)
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreChats) Rename(ctx context.Context, chatID, name string) error {
+	_, err := r.fs.Collection("chats").Doc(chatID).Update(ctx, []firestore.Update{
+		{
+			Path:  "name",
+			Value: name,
+		},
+		{
+			Path:  "updated_at",
+			Value: firestore.ServerTimestamp,
+		},
+	})
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreChats) SetHandle(ctx context.Context, chatID, handle string) error {
+	ref := r.fs.Collection("chats").Doc(chatID)
+
+	return r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if handle != "" {
+			docs, err := tx.Documents(r.fs.Collection("chats").Where("handle", "==", handle).Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) > 0 && docs[0].Ref.ID != chatID {
+				return ErrHandleTaken
+			}
+		}
+
+		var value interface{} = handle
+		if handle == "" {
+			value = firestore.Delete
+		}
+		return wrapFirestoreError(tx.Update(ref, []firestore.Update{
+			{Path: "handle", Value: value},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}))
+	})
+}
+
+func (r *firestoreChats) GetByHandle(ctx context.Context, handle string) (*Chat, error) {
+	docs, err := r.fs.Collection("chats").Where("handle", "==", handle).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("chat @%s: %w", handle, ErrNotFound)
+	}
+
+	return chatFromSnapshot(docs[0])
+}
+
+func (r *firestoreChats) SearchByHandle(ctx context.Context, prefix string, limit int) ([]Chat, error) {
+	docs, err := r.fs.Collection("chats").
+		Where("handle", ">=", prefix).
+		Where("handle", "<=", prefix+"\uf8ff").
+		OrderBy("handle", firestore.Asc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]Chat, 0, len(docs))
+	for _, doc := range docs {
+		chat, err := chatFromSnapshot(doc)
+		if err != nil {
+			log.Printf("Skipping malformed chat %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		chats = append(chats, *chat)
+	}
+
+	return chats, nil
+}
+
+func (r *firestoreChats) Delete(ctx context.Context, chatID string) error {
+	ref := r.fs.Collection("chats").Doc(chatID)
+	if _, err := ref.Get(ctx); err != nil {
+		return wrapFirestoreError(err)
+	}
+
+	queries := []firestore.Query{
+		ref.Collection("messages").Query,
+		r.fs.Collection("attachments").Where("chat_id", "==", chatID),
+		r.fs.Collection("search_index").Where("chat_id", "==", chatID),
+		r.fs.Collection("invites").Where("chat_id", "==", chatID),
+		r.fs.Collection("subscriptions").Where("chat_id", "==", chatID),
+		r.fs.Collection("chat_settings").Where("chat_id", "==", chatID),
+	}
+	for _, q := range queries {
+		if err := deleteAll(ctx, r.fs, q); err != nil {
+			return err
+		}
+	}
+
+	_, err := ref.Delete(ctx)
+	return err
+}
+
+// maxBatchWrites is the most writes Firestore accepts in one batch.
+const maxBatchWrites = 500
+
+// deleteAll deletes the documents matched by q in batches.
+func deleteAll(ctx context.Context, fs *firestore.Client, q firestore.Query) error {
+	for {
+		docs, err := q.Limit(maxBatchWrites).Documents(ctx).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		batch := fs.Batch()
+		for _, doc := range docs {
+			batch.Delete(doc.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func chatFromSnapshot(doc *firestore.DocumentSnapshot) (*Chat, error) {
+	var chat Chat
+	if err := doc.DataTo(&chat); err != nil {
+		return nil, err
+	}
+	chat.ID = doc.Ref.ID
+
+	return &chat, nil
+}
+
+type firestoreMessages struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreMessages) collection(chatID string) *firestore.CollectionRef {
+	return r.fs.Collection("chats").Doc(chatID).Collection("messages")
+}
+
+func (r *firestoreMessages) Add(ctx context.Context, chatID string, msg *Message) (string, error) {
+	docRef := r.collection(chatID).NewDoc()
+	if msg.ID != "" {
+		docRef = r.collection(chatID).Doc(msg.ID)
+	}
+
+	if _, err := docRef.Create(ctx, msg); err != nil {
+		return "", wrapFirestoreError(err)
+	}
+
+	msg.ID = docRef.ID
+	msg.ChatID = chatID
+	return docRef.ID, nil
+}
+
+func (r *firestoreMessages) AddAll(ctx context.Context, chatID string, msgs []*Message) error {
+	batch := r.fs.Batch()
+	refs := make([]*firestore.DocumentRef, len(msgs))
+	for i, msg := range msgs {
+		refs[i] = r.collection(chatID).NewDoc()
+		if msg.ID != "" {
+			refs[i] = r.collection(chatID).Doc(msg.ID)
+		}
+		batch.Create(refs[i], msg)
+	}
+
+	if _, err := batch.Commit(ctx); err != nil {
+		return wrapFirestoreError(err)
+	}
+
+	for i, msg := range msgs {
+		msg.ID = refs[i].ID
+		msg.ChatID = chatID
+	}
+	return nil
+}
+
+func (r *firestoreMessages) List(ctx context.Context, chatID string) ([]Message, error) {
+	docs, err := r.collection(chatID).
+		OrderBy("timestamp", firestore.Asc).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(docs))
+	for _, doc := range docs {
+		msg, err := messageFromSnapshot(chatID, doc)
+		if err != nil {
+			log.Printf("Skipping malformed message %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		messages = append(messages, *msg)
+	}
+
+	return messages, nil
+}
+
+func (r *firestoreMessages) Get(ctx context.Context, chatID, id string) (*Message, error) {
+	doc, err := r.collection(chatID).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	return messageFromSnapshot(chatID, doc)
+}
+
+func (r *firestoreMessages) ListPage(ctx context.Context, chatID string, page MessagePage) ([]Message, error) {
+	descending := page.After == nil
+	direction := firestore.Asc
+	if descending {
+		direction = firestore.Desc
+	}
+
+	query := r.collection(chatID).
+		OrderBy("timestamp", direction).
+		OrderBy(firestore.DocumentID, direction)
+	if page.ThreadID != "" {
+		query = query.Where("thread_id", "==", page.ThreadID)
+	}
+	if descending {
+		if page.Before != nil {
+			query = query.StartAfter(page.Before.Timestamp, page.Before.ID)
+		}
+	} else {
+		query = query.StartAfter(page.After.Timestamp, page.After.ID)
+		if page.Before != nil {
+			query = query.EndBefore(page.Before.Timestamp, page.Before.ID)
+		}
+	}
+
+	// Messages written before threads existed have no thread_id
+	// field, so the main timeline cannot be filtered by the query;
+	// thread replies are skipped here and the next batch is fetched
+	// until the page is full.
+	messages := make([]Message, 0, page.Limit)
+	for len(messages) < page.Limit {
+		docs, err := query.Limit(page.Limit).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, doc := range docs {
+			msg, err := messageFromSnapshot(chatID, doc)
+			if err != nil {
+				log.Printf("Skipping malformed message %s: %v", doc.Ref.ID, err)
+				continue
+			}
+			if msg.ThreadID == page.ThreadID && len(messages) < page.Limit {
+				messages = append(messages, *msg)
+			}
+		}
+
+		if len(docs) < page.Limit {
+			break
+		}
+		query = query.StartAfter(docs[len(docs)-1])
+	}
+
+	if descending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, nil
+}
+
+func (r *firestoreMessages) Update(ctx context.Context, chatID, id string, fn func(msg *Message) error) (*Message, error) {
+	ref := r.collection(chatID).Doc(id)
+
+	var msg *Message
+	err := r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return wrapFirestoreError(err)
+		}
+
+		msg, err = messageFromSnapshot(chatID, doc)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "text", Value: msg.Text},
+			{Path: "edits", Value: msg.Edits},
+			{Path: "deleted_at", Value: msg.DeletedAt},
+			{Path: "hidden_for", Value: msg.HiddenFor},
+			{Path: "reactions", Value: msg.Reactions},
+			{Path: "reply_count", Value: msg.ReplyCount},
+			{Path: "last_reply_at", Value: msg.LastReplyAt},
+			{Path: "attachments", Value: msg.Attachments},
+			{Path: "listened_by", Value: msg.ListenedBy},
+			{Path: "mentions", Value: msg.Mentions},
+			{Path: "pinned_at", Value: msg.PinnedAt},
+			{Path: "pinned_by", Value: msg.PinnedBy},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func (r *firestoreMessages) MarkRead(ctx context.Context, chatID, messageID, uid string) error {
+	_, err := r.collection(chatID).Doc(messageID).Update(ctx, []firestore.Update{
+		{
+			Path:  "read_by",
+			Value: firestore.ArrayUnion(uid),
+		},
+	})
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreMessages) AddViews(ctx context.Context, chatID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	batch := r.fs.Batch()
+	for _, id := range ids {
+		batch.Update(r.collection(chatID).Doc(id), []firestore.Update{
+			{
+				Path:  "views",
+				Value: firestore.Increment(1),
+			},
+		})
+	}
+
+	_, err := batch.Commit(ctx)
+	return wrapFirestoreError(err)
+}
+
+func (r *firestoreMessages) Watch(ctx context.Context, chatID string) (<-chan MessageChange, error) {
+	query := r.collection(chatID).
+		OrderBy("timestamp", firestore.Desc).Limit(1)
+
+	snapshot := query.Snapshots(ctx)
+	changes := make(chan MessageChange)
+
+	go func() {
+		defer close(changes)
+		defer snapshot.Stop()
+
+		for {
+			iter, err := snapshot.Next()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Error in chat %s listener: %v", chatID, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+				continue
+			}
+
+			for _, change := range iter.Changes {
+				msg, err := messageFromSnapshot(chatID, change.Doc)
+				if err != nil {
+					continue
+				}
+
+				select {
+				case changes <- MessageChange{Kind: changeKind(change.Kind), Message: *msg}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func messageFromSnapshot(chatID string, doc *firestore.DocumentSnapshot) (*Message, error) {
+	var msg Message
+	if err := doc.DataTo(&msg); err != nil {
+		return nil, err
+	}
+	msg.ID = doc.Ref.ID
+	msg.ChatID = chatID
+
+	return &msg, nil
+}
+
+func changeKind(kind firestore.DocumentChangeKind) ChangeKind {
+	switch kind {
+	case firestore.DocumentModified:
+		return MessageModified
+	case firestore.DocumentRemoved:
+		return MessageRemoved
+	default:
+		return MessageAdded
+	}
+}
+
+func wrapFirestoreError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case codes.AlreadyExists:
+		return fmt.Errorf("%w: %v", ErrAlreadyExists, err)
+	}
+	return err
+}
+
+type firestoreAttachments struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreAttachments) Create(ctx context.Context, attachment *Attachment) error {
+	ref := r.fs.Collection("attachments").NewDoc()
+	if attachment.ID != "" {
+		ref = r.fs.Collection("attachments").Doc(attachment.ID)
+	}
+
+	if _, err := ref.Create(ctx, attachment); err != nil {
+		return err
+	}
+	attachment.ID = ref.ID
+	return nil
+}
+
+func (r *firestoreAttachments) Get(ctx context.Context, id string) (*Attachment, error) {
+	doc, err := r.fs.Collection("attachments").Doc(id).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var attachment Attachment
+	if err := doc.DataTo(&attachment); err != nil {
+		return nil, err
+	}
+	attachment.ID = doc.Ref.ID
+
+	return &attachment, nil
+}
+
+func (r *firestoreAttachments) Update(ctx context.Context, attachment *Attachment) error {
+	_, err := r.fs.Collection("attachments").Doc(attachment.ID).Set(ctx, attachment)
+	return err
+}
+
+func (r *firestoreAttachments) ListByChat(ctx context.Context, chatID string) ([]Attachment, error) {
+	docs, err := r.fs.Collection("attachments").Where("chat_id", "==", chatID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Attachment, 0, len(docs))
+	for _, doc := range docs {
+		var attachment Attachment
+		if err := doc.DataTo(&attachment); err != nil {
+			return nil, err
+		}
+		attachment.ID = doc.Ref.ID
+		result = append(result, attachment)
+	}
+	return result, nil
+}
+
+func (r *firestoreAttachments) KeyInUse(ctx context.Context, key string) (bool, error) {
+	docs, err := r.fs.Collection("attachments").Where("key", "==", key).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	return len(docs) > 0, nil
+}
+
+func (r *firestoreAttachments) Delete(ctx context.Context, id string) error {
+	_, err := r.fs.Collection("attachments").Doc(id).Delete(ctx)
+	return err
+}
+
+// firestoreChanges keeps each user's journal in users/{uid}/changes.
+type firestoreChanges struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreChanges) collection(uid string) *firestore.CollectionRef {
+	return r.fs.Collection("users").Doc(uid).Collection("changes")
+}
+
+func (r *firestoreChanges) Append(ctx context.Context, change *Change, uids []string) error {
+	if change.Seq == 0 {
+		change.Seq = NewChangeSeq()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+
+	for start := 0; start < len(uids); start += maxBatchWrites {
+		batch := r.fs.Batch()
+		for _, uid := range uids[start:min(start+maxBatchWrites, len(uids))] {
+			batch.Set(r.collection(uid).NewDoc(), change)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *firestoreChanges) ListSince(ctx context.Context, uid string, after int64, limit int) ([]Change, error) {
+	docs, err := r.collection(uid).
+		Where("seq", ">", after).
+		OrderBy("seq", firestore.Asc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, 0, len(docs))
+	for _, doc := range docs {
+		var change Change
+		if err := doc.DataTo(&change); err != nil {
+			log.Printf("Skipping malformed change %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Firestore "in" filters accept at most 30 values.
+const firestoreInLimit = 30
+
+// firestoreSearch keeps one search_index document per message, with
+// its terms in an array. A query can only filter on one term with
+// array-contains, so the remaining terms are checked here.
+type firestoreSearch struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreSearch) doc(chatID, messageID string) *firestore.DocumentRef {
+	return r.fs.Collection("search_index").Doc(chatID + "_" + messageID)
+}
+
+func (r *firestoreSearch) Index(ctx context.Context, entry *SearchEntry) error {
+	_, err := r.doc(entry.ChatID, entry.MessageID).Set(ctx, entry)
+	return err
+}
+
+func (r *firestoreSearch) Remove(ctx context.Context, chatID, messageID string) error {
+	_, err := r.doc(chatID, messageID).Delete(ctx)
+	return err
+}
+
+func (r *firestoreSearch) Query(ctx context.Context, chatIDs, terms []string, limit int) ([]SearchEntry, error) {
+	if len(chatIDs) == 0 || len(terms) == 0 {
+		return []SearchEntry{}, nil
+	}
+
+	result := []SearchEntry{}
+	for start := 0; start < len(chatIDs); start += firestoreInLimit {
+		chunk := chatIDs[start:min(start+firestoreInLimit, len(chatIDs))]
+		found, err := r.query(ctx, chunk, terms, limit)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, found...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.After(result[j].Timestamp)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (r *firestoreSearch) query(ctx context.Context, chatIDs, terms []string, limit int) ([]SearchEntry, error) {
+	iter := r.fs.Collection("search_index").
+		Where("chat_id", "in", chatIDs).
+		Where("terms", "array-contains", terms[0]).
+		OrderBy("timestamp", firestore.Desc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	result := []SearchEntry{}
+	for len(result) < limit {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var entry SearchEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Skipping malformed search entry %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if containsAll(entry.Terms, terms[1:]) {
+			entry.Terms = nil
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *firestoreSearch) Clear(ctx context.Context) error {
+	return deleteAll(ctx, r.fs, r.fs.Collection("search_index").Query)
+}
+
+type firestoreInvites struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreInvites) Create(ctx context.Context, invite *Invite) error {
+	_, err := r.fs.Collection("invites").Doc(invite.Token).Create(ctx, invite)
+	return err
+}
+
+func (r *firestoreInvites) Get(ctx context.Context, token string) (*Invite, error) {
+	doc, err := r.fs.Collection("invites").Doc(token).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	return inviteFromSnapshot(doc)
+}
+
+func (r *firestoreInvites) ListByChat(ctx context.Context, chatID string) ([]Invite, error) {
+	docs, err := r.fs.Collection("invites").Where("chat_id", "==", chatID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	invites := make([]Invite, 0, len(docs))
+	for _, doc := range docs {
+		invite, err := inviteFromSnapshot(doc)
+		if err != nil {
+			log.Printf("Skipping malformed invite %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		invites = append(invites, *invite)
+	}
+
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.After(invites[j].CreatedAt)
+	})
+	return invites, nil
+}
+
+func (r *firestoreInvites) Update(ctx context.Context, token string, fn func(invite *Invite) error) (*Invite, error) {
+	ref := r.fs.Collection("invites").Doc(token)
+
+	var invite *Invite
+	err := r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return wrapFirestoreError(err)
+		}
+
+		invite, err = inviteFromSnapshot(doc)
+		if err != nil {
+			return err
+		}
+		if err := fn(invite); err != nil {
+			return err
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "uses", Value: invite.Uses},
+			{Path: "pending", Value: invite.Pending},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func (r *firestoreInvites) Delete(ctx context.Context, token string) error {
+	_, err := r.fs.Collection("invites").Doc(token).Delete(ctx)
+	return err
+}
+
+func inviteFromSnapshot(doc *firestore.DocumentSnapshot) (*Invite, error) {
+	var invite Invite
+	if err := doc.DataTo(&invite); err != nil {
+		return nil, err
+	}
+	invite.Token = doc.Ref.ID
+
+	return &invite, nil
+}
+
+// firestoreSubscriptions keeps one document per subscriber, with the
+// ID chatId_uid, so that a channel's readers never have to fit into
+// the chat document.
+type firestoreSubscriptions struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreSubscriptions) doc(chatID, uid string) *firestore.DocumentRef {
+	return r.fs.Collection("subscriptions").Doc(chatID + "_" + uid)
+}
+
+func (r *firestoreSubscriptions) Subscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	chatRef := r.fs.Collection("chats").Doc(chatID)
+	ref := r.doc(chatID, uid)
+
+	created := false
+	err := r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		created = false
+		if _, err := tx.Get(chatRef); err != nil {
+			return wrapFirestoreError(err)
+		}
+		if _, err := tx.Get(ref); err == nil {
+			return nil
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		created = true
+		if err := tx.Create(ref, &Subscription{ChatID: chatID, UserID: uid, SubscribedAt: time.Now()}); err != nil {
+			return err
+		}
+		return tx.Update(chatRef, []firestore.Update{
+			{Path: "subscriber_count", Value: firestore.Increment(1)},
+		})
+	})
+	return created, err
+}
+
+func (r *firestoreSubscriptions) Unsubscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	chatRef := r.fs.Collection("chats").Doc(chatID)
+	ref := r.doc(chatID, uid)
+
+	removed := false
+	err := r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		removed = false
+		if _, err := tx.Get(ref); status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		removed = true
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		return tx.Update(chatRef, []firestore.Update{
+			{Path: "subscriber_count", Value: firestore.Increment(-1)},
+		})
+	})
+	return removed, wrapFirestoreError(err)
+}
+
+func (r *firestoreSubscriptions) Get(ctx context.Context, chatID, uid string) (*Subscription, error) {
+	doc, err := r.doc(chatID, uid).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var subscription Subscription
+	if err := doc.DataTo(&subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (r *firestoreSubscriptions) ListByUser(ctx context.Context, uid string) ([]Subscription, error) {
+	return r.list(ctx, r.fs.Collection("subscriptions").Where("user_id", "==", uid))
+}
+
+func (r *firestoreSubscriptions) ListByChat(ctx context.Context, chatID, afterUID string, limit int) ([]Subscription, error) {
+	return r.list(ctx, r.fs.Collection("subscriptions").
+		Where("chat_id", "==", chatID).
+		Where("user_id", ">", afterUID).
+		OrderBy("user_id", firestore.Asc).
+		Limit(limit))
+}
+
+func (r *firestoreSubscriptions) list(ctx context.Context, q firestore.Query) ([]Subscription, error) {
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make([]Subscription, 0, len(docs))
+	for _, doc := range docs {
+		var subscription Subscription
+		if err := doc.DataTo(&subscription); err != nil {
+			log.Printf("Skipping malformed subscription %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (r *firestoreSubscriptions) AdvanceViewed(ctx context.Context, chatID, uid string, at time.Time) (time.Time, error) {
+	ref := r.doc(chatID, uid)
+
+	var previous time.Time
+	err := r.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return wrapFirestoreError(err)
+		}
+
+		var subscription Subscription
+		if err := doc.DataTo(&subscription); err != nil {
+			return err
+		}
+		previous = subscription.ViewedAt
+		if !at.After(previous) {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "viewed_at", Value: at},
+		})
+	})
+	return previous, err
+}
+
+// firestoreChatSettings keeps one document per user and chat, with
+// the ID uid_chatId.
+type firestoreChatSettings struct {
+	fs *firestore.Client
+}
+
+func (r *firestoreChatSettings) doc(uid, chatID string) *firestore.DocumentRef {
+	return r.fs.Collection("chat_settings").Doc(uid + "_" + chatID)
+}
+
+func (r *firestoreChatSettings) Get(ctx context.Context, uid, chatID string) (*ChatSettings, error) {
+	doc, err := r.doc(uid, chatID).Get(ctx)
+	if err != nil {
+		return nil, wrapFirestoreError(err)
+	}
+
+	var settings ChatSettings
+	if err := doc.DataTo(&settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (r *firestoreChatSettings) ListByUser(ctx context.Context, uid string) ([]ChatSettings, error) {
+	docs, err := r.fs.Collection("chat_settings").Where("user_id", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ChatSettings, 0, len(docs))
+	for _, doc := range docs {
+		var settings ChatSettings
+		if err := doc.DataTo(&settings); err != nil {
+			log.Printf("Skipping malformed chat settings %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		result = append(result, settings)
+	}
+	return result, nil
+}
+
+func (r *firestoreChatSettings) Save(ctx context.Context, settings *ChatSettings) error {
+	_, err := r.doc(settings.UserID, settings.ChatID).Set(ctx, settings)
+	return err
+}
+
+func (r *firestoreChatSettings) Delete(ctx context.Context, uid, chatID string) error {
+	_, err := r.doc(uid, chatID).Delete(ctx)
+	return err
+}
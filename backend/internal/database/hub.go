@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+const hubBuffer = 64
+
+// MessageHub fans message changes out to in-process watchers.
+// Backends without native change streams publish to it from
+// their write path and serve MessageRepository.Watch from it.
+type MessageHub struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan MessageChange]context.Context
+}
+
+func NewMessageHub() *MessageHub {
+	return &MessageHub{
+		watchers: make(map[string]map[chan MessageChange]context.Context),
+	}
+}
+
+// Subscribe registers a watcher for chatID. The initial changes are
+// delivered before anything published afterwards. The returned channel
+// is closed once ctx is done.
+func (h *MessageHub) Subscribe(ctx context.Context, chatID string, initial ...MessageChange) <-chan MessageChange {
+	changes := make(chan MessageChange, hubBuffer+len(initial))
+	for _, change := range initial {
+		changes <- change
+	}
+
+	h.mu.Lock()
+	if h.watchers[chatID] == nil {
+		h.watchers[chatID] = make(map[chan MessageChange]context.Context)
+	}
+	h.watchers[chatID][changes] = ctx
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		h.mu.Lock()
+		delete(h.watchers[chatID], changes)
+		if len(h.watchers[chatID]) == 0 {
+			delete(h.watchers, chatID)
+		}
+		close(changes)
+		h.mu.Unlock()
+	}()
+
+	return changes
+}
+
+// Publish delivers change to every watcher of chatID without blocking.
+// Changes for a watcher whose buffer is full are dropped.
+func (h *MessageHub) Publish(chatID string, change MessageChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for changes, ctx := range h.watchers[chatID] {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		select {
+		case changes <- change:
+		default:
+			log.Printf("Dropping change for slow watcher of chat %s", chatID)
+		}
+	}
+}
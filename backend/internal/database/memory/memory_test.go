@@ -0,0 +1,189 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+func TestUsersAndContacts(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	store.Users().Save(ctx, &database.User{UID: "u1", Name: "Alice", Email: "alice@test.com"})
+	store.Users().Save(ctx, &database.User{UID: "u2", Name: "Bob", Email: "bob@test.com"})
+
+	user, err := store.Users().GetByEmail(ctx, "bob@test.com")
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	if user.UID != "u2" {
+		t.Errorf("GetByEmail UID = %q, want %q", user.UID, "u2")
+	}
+
+	if _, err := store.Users().Get(ctx, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+
+	found, _ := store.Users().SearchByName(ctx, "Al")
+	if len(found) != 1 || found[0].UID != "u1" {
+		t.Errorf("SearchByName(Al) = %+v, want only u1", found)
+	}
+
+	now := time.Now()
+	store.Contacts().Save(ctx, &database.Contact{ID: "u1_u2", OwnerUID: "u1", ContactUID: "u2", CreatedAt: now.Add(-time.Minute)})
+	store.Contacts().Save(ctx, &database.Contact{ID: "u1_u3", OwnerUID: "u1", ContactUID: "u3", CreatedAt: now})
+
+	contacts, _ := store.Contacts().ListByOwner(ctx, "u1")
+	if len(contacts) != 2 || contacts[0].ID != "u1_u3" {
+		t.Errorf("ListByOwner = %+v, want newest first", contacts)
+	}
+
+	store.Contacts().Delete(ctx, "u1_u3")
+	if _, err := store.Contacts().Get(ctx, "u1_u3"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestChatsAndMessages(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	chatID, err := store.Chats().Create(ctx, &database.Chat{
+		Name:         "Group",
+		Type:         "group",
+		Participants: []string{"u1", "u2"},
+	})
+	if err != nil || chatID == "" {
+		t.Fatalf("Create: id=%q err=%v", chatID, err)
+	}
+
+	chats, _ := store.Chats().ListByParticipant(ctx, "u2")
+	if len(chats) != 1 || chats[0].ID != chatID {
+		t.Errorf("ListByParticipant(u2) = %+v, want chat %s", chats, chatID)
+	}
+
+	base := time.Now()
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "welcome", SenderID: "system", Timestamp: base})
+	msg := &database.Message{SenderID: "u1", Text: "hi", Timestamp: base.Add(time.Second), ReadBy: []string{"u1"}}
+	msgID, _ := store.Messages().Add(ctx, chatID, msg)
+
+	if err := store.Messages().MarkRead(ctx, chatID, msgID, "u2"); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	store.Chats().SetLastMessage(ctx, chatID, msg)
+
+	list, _ := store.Messages().List(ctx, chatID)
+	if len(list) != 2 || list[0].ID != "welcome" || list[1].ID != msgID {
+		t.Fatalf("List = %+v, want welcome then %s", list, msgID)
+	}
+	if len(list[1].ReadBy) != 2 {
+		t.Errorf("ReadBy = %v, want u1 and u2", list[1].ReadBy)
+	}
+
+	chat, _ := store.Chats().Get(ctx, chatID)
+	if chat.LastMessage == nil || chat.LastMessage.Text != "hi" {
+		t.Errorf("LastMessage = %+v, want text %q", chat.LastMessage, "hi")
+	}
+}
+
+func TestWatchDeliversSnapshotChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := NewStore()
+
+	store.Messages().Add(ctx, "chat1", &database.Message{ID: "first", Timestamp: time.Now()})
+
+	changes, err := store.Messages().Watch(ctx, "chat1")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	if change := <-changes; change.Kind != database.MessageAdded || change.Message.ID != "first" {
+		t.Errorf("initial change = %+v, want latest message added", change)
+	}
+
+	store.Messages().Add(ctx, "chat1", &database.Message{ID: "second", Timestamp: time.Now()})
+	if change := <-changes; change.Kind != database.MessageAdded || change.Message.ChatID != "chat1" {
+		t.Errorf("change = %+v, want new message in chat1", change)
+	}
+
+	store.Messages().MarkRead(ctx, "chat1", "second", "u2")
+	if change := <-changes; change.Kind != database.MessageModified {
+		t.Errorf("change kind = %v, want MessageModified", change.Kind)
+	}
+
+	cancel()
+	select {
+	case _, open := <-changes:
+		if open {
+			t.Error("expected channel to be closed after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("watch channel was not closed after cancel")
+	}
+}
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	base := time.Now()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		store.Messages().Add(ctx, chatID, &database.Message{ID: id, SenderID: "u1", Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	// "e" shares its timestamp with "d" and sorts after it by ID.
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "e", SenderID: "u1", Timestamp: base.Add(3 * time.Second)})
+	store.Messages().MarkRead(ctx, chatID, "b", "u2")
+
+	at := func(id string, i int) *database.MessageCursor {
+		return &database.MessageCursor{ID: id, Timestamp: base.Add(time.Duration(i) * time.Second)}
+	}
+
+	tests := []struct {
+		name string
+		page database.MessagePage
+		want string
+	}{
+		{"latest", database.MessagePage{Limit: 2}, "de"},
+		{"before", database.MessagePage{Before: at("d", 3), Limit: 2}, "bc"},
+		{"after", database.MessagePage{After: at("a", 0), Limit: 2}, "bc"},
+		{"after tie", database.MessagePage{After: at("d", 3), Limit: 5}, "e"},
+		{"between", database.MessagePage{After: at("a", 0), Before: at("e", 3), Limit: 10}, "bcd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := store.Messages().ListPage(ctx, chatID, tt.page)
+			if err != nil {
+				t.Fatalf("ListPage: %v", err)
+			}
+			got := ""
+			for _, m := range list {
+				got += m.ID
+			}
+			if got != tt.want {
+				t.Errorf("ListPage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "t1", SenderID: "u1", Timestamp: base.Add(4 * time.Second), ThreadID: "a"})
+	if list, _ := store.Messages().ListPage(ctx, chatID, database.MessagePage{Limit: 1}); len(list) != 1 || list[0].ID != "e" {
+		t.Errorf("timeline = %+v, want thread replies left out", list)
+	}
+	if list, _ := store.Messages().ListPage(ctx, chatID, database.MessagePage{Limit: 5, ThreadID: "a"}); len(list) != 1 || list[0].ID != "t1" {
+		t.Errorf("thread = %+v, want only t1", list)
+	}
+
+	msg, err := store.Messages().Get(ctx, chatID, "b")
+	if err != nil || len(msg.ReadBy) != 1 {
+		t.Errorf("Get(b) = %+v, %v; want one reader", msg, err)
+	}
+	if _, err := store.Messages().Get(ctx, chatID, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
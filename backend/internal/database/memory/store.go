@@ -0,0 +1,1188 @@
+// Package memory provides an in-process implementation of database.Store
+// for local development and tests.
+// Nothing is persisted: all data is lost when the process exits.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+// Store keeps users, contacts, chats and messages in maps guarded by one mutex.
+type Store struct {
+	mu          sync.RWMutex
+	users       map[string]database.User
+	creds       map[string]database.Credential
+	sessions    map[string]database.Session
+	contacts    map[string]database.Contact
+	chats       map[string]database.Chat
+	messages    map[string][]database.Message
+	changes     map[string][]database.Change
+	attachments map[string]database.Attachment
+	search      map[string]database.SearchEntry
+	invites     map[string]database.Invite
+	subs        map[string]database.Subscription
+	settings    map[string]database.ChatSettings
+	hub         *database.MessageHub
+}
+
+var _ database.Store = (*Store)(nil)
+
+func NewStore() *Store {
+	return &Store{
+		users:       make(map[string]database.User),
+		creds:       make(map[string]database.Credential),
+		sessions:    make(map[string]database.Session),
+		contacts:    make(map[string]database.Contact),
+		chats:       make(map[string]database.Chat),
+		messages:    make(map[string][]database.Message),
+		changes:     make(map[string][]database.Change),
+		attachments: make(map[string]database.Attachment),
+		search:      make(map[string]database.SearchEntry),
+		invites:     make(map[string]database.Invite),
+		subs:        make(map[string]database.Subscription),
+		settings:    make(map[string]database.ChatSettings),
+		hub:         database.NewMessageHub(),
+	}
+}
+
+func (s *Store) Users() database.UserRepository {
+	return (*users)(s)
+}
+
+func (s *Store) Credentials() database.CredentialRepository {
+	return (*credentials)(s)
+}
+
+func (s *Store) Sessions() database.SessionRepository {
+	return (*sessions)(s)
+}
+
+func (s *Store) Attachments() database.AttachmentRepository {
+	return (*attachments)(s)
+}
+
+func (s *Store) Changes() database.ChangeRepository {
+	return (*changes)(s)
+}
+
+func (s *Store) Search() database.SearchRepository {
+	return (*search)(s)
+}
+
+func (s *Store) Invites() database.InviteRepository {
+	return (*invites)(s)
+}
+
+func (s *Store) Subscriptions() database.SubscriptionRepository {
+	return (*subscriptions)(s)
+}
+
+func (s *Store) ChatSettings() database.ChatSettingsRepository {
+	return (*chatSettings)(s)
+}
+
+func (s *Store) Contacts() database.ContactRepository {
+	return (*contacts)(s)
+}
+
+func (s *Store) Chats() database.ChatRepository {
+	return (*chats)(s)
+}
+
+func (s *Store) Messages() database.MessageRepository {
+	return (*messages)(s)
+}
+
+type users Store
+
+func (r *users) Save(ctx context.Context, user *database.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[user.UID] = copyUser(user)
+	return nil
+}
+
+func (r *users) Get(ctx context.Context, uid string) (*database.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[uid]
+	if !ok {
+		return nil, fmt.Errorf("user %s: %w", uid, database.ErrNotFound)
+	}
+	user = copyUser(&user)
+	return &user, nil
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (*database.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			user = copyUser(&user)
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("user %s: %w", email, database.ErrNotFound)
+}
+
+func (r *users) SearchByName(ctx context.Context, prefix string) ([]database.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.User{}
+	for _, user := range r.users {
+		if strings.HasPrefix(user.Name, prefix) {
+			result = append(result, copyUser(&user))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+type credentials Store
+
+func (r *credentials) Save(ctx context.Context, credential *database.Credential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.creds[credential.UID] = *credential
+	return nil
+}
+
+func (r *credentials) GetByEmail(ctx context.Context, email string) (*database.Credential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, credential := range r.creds {
+		if credential.Email == email {
+			return &credential, nil
+		}
+	}
+	return nil, fmt.Errorf("credential %s: %w", email, database.ErrNotFound)
+}
+
+func (r *credentials) Delete(ctx context.Context, uid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.creds, uid)
+	return nil
+}
+
+type sessions Store
+
+func (r *sessions) Save(ctx context.Context, session *database.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *sessions) Get(ctx context.Context, id string) (*database.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", id, database.ErrNotFound)
+	}
+	return &session, nil
+}
+
+func (r *sessions) ListByUser(ctx context.Context, uid string) ([]database.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Session{}
+	for _, session := range r.sessions {
+		if session.UID == uid {
+			result = append(result, session)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastUsedAt.After(result[j].LastUsedAt)
+	})
+	return result, nil
+}
+
+func (r *sessions) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, id)
+	return nil
+}
+
+func (r *sessions) DeleteByUser(ctx context.Context, uid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, session := range r.sessions {
+		if session.UID == uid {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+type contacts Store
+
+func (r *contacts) Save(ctx context.Context, contact *database.Contact) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.contacts[contact.ID] = *contact
+	return nil
+}
+
+func (r *contacts) Get(ctx context.Context, id string) (*database.Contact, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contact, ok := r.contacts[id]
+	if !ok {
+		return nil, fmt.Errorf("contact %s: %w", id, database.ErrNotFound)
+	}
+	return &contact, nil
+}
+
+func (r *contacts) ListByOwner(ctx context.Context, ownerUID string) ([]database.Contact, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Contact{}
+	for _, contact := range r.contacts {
+		if contact.OwnerUID == ownerUID {
+			result = append(result, contact)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (r *contacts) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.contacts, id)
+	return nil
+}
+
+type chats Store
+
+func (r *chats) Create(ctx context.Context, chat *database.Chat) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat.ID = database.NewID()
+	r.chats[chat.ID] = copyChat(chat)
+	return chat.ID, nil
+}
+
+func (r *chats) Get(ctx context.Context, id string) (*database.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chat, ok := r.chats[id]
+	if !ok {
+		return nil, fmt.Errorf("chat %s: %w", id, database.ErrNotFound)
+	}
+
+	result := copyChat(&chat)
+	return &result, nil
+}
+
+func (r *chats) ListByParticipant(ctx context.Context, uid string) ([]database.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Chat{}
+	for _, chat := range r.chats {
+		for _, p := range chat.Participants {
+			if p == uid {
+				result = append(result, copyChat(&chat))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *chats) List(ctx context.Context) ([]database.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]database.Chat, 0, len(r.chats))
+	for _, chat := range r.chats {
+		result = append(result, copyChat(&chat))
+	}
+	return result, nil
+}
+
+func (r *chats) SetLastMessage(ctx context.Context, chatID string, msg *database.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	last := copyMessage(msg)
+	chat.LastMessage = &last
+	chat.UpdatedAt = time.Now()
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) AddUnreadMentions(ctx context.Context, chatID string, uids []string, delta int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	if chat.UnreadMentions == nil {
+		chat.UnreadMentions = make(map[string]int)
+	}
+	for _, uid := range uids {
+		chat.UnreadMentions[uid] = max(chat.UnreadMentions[uid]+delta, 0)
+	}
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) AddParticipants(ctx context.Context, chatID string, uids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	for _, uid := range uids {
+		if !slices.Contains(chat.Participants, uid) {
+			chat.Participants = append(chat.Participants, uid)
+		}
+	}
+	chat.UpdatedAt = time.Now()
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) RemoveParticipant(ctx context.Context, chatID, uid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	chat.Participants = slices.DeleteFunc(chat.Participants, func(p string) bool {
+		return p == uid
+	})
+	delete(chat.UnreadMentions, uid)
+	delete(chat.Roles, uid)
+	chat.UpdatedAt = time.Now()
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) SetRoles(ctx context.Context, chatID string, roles map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	if chat.Roles == nil {
+		chat.Roles = make(map[string]string)
+	}
+	for uid, role := range roles {
+		if slices.Contains(chat.Participants, uid) {
+			chat.Roles[uid] = role
+		}
+	}
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) Rename(ctx context.Context, chatID, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	chat.Name = name
+	chat.UpdatedAt = time.Now()
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) SetHandle(ctx context.Context, chatID, handle string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+	if handle != "" {
+		for id, other := range r.chats {
+			if id != chatID && other.Handle == handle {
+				return database.ErrHandleTaken
+			}
+		}
+	}
+
+	chat.Handle = handle
+	chat.UpdatedAt = time.Now()
+	r.chats[chatID] = chat
+	return nil
+}
+
+func (r *chats) GetByHandle(ctx context.Context, handle string) (*database.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, chat := range r.chats {
+		if handle != "" && chat.Handle == handle {
+			result := copyChat(&chat)
+			return &result, nil
+		}
+	}
+	return nil, fmt.Errorf("chat @%s: %w", handle, database.ErrNotFound)
+}
+
+func (r *chats) SearchByHandle(ctx context.Context, prefix string, limit int) ([]database.Chat, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Chat{}
+	for _, chat := range r.chats {
+		if chat.Handle != "" && strings.HasPrefix(chat.Handle, prefix) {
+			result = append(result, copyChat(&chat))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Handle < result[j].Handle
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (r *chats) Delete(ctx context.Context, chatID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.chats[chatID]; !ok {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+
+	delete(r.chats, chatID)
+	delete(r.messages, chatID)
+	for id, attachment := range r.attachments {
+		if attachment.ChatID == chatID {
+			delete(r.attachments, id)
+		}
+	}
+	for key, entry := range r.search {
+		if entry.ChatID == chatID {
+			delete(r.search, key)
+		}
+	}
+	for token, invite := range r.invites {
+		if invite.ChatID == chatID {
+			delete(r.invites, token)
+		}
+	}
+	for key, subscription := range r.subs {
+		if subscription.ChatID == chatID {
+			delete(r.subs, key)
+		}
+	}
+	for key, settings := range r.settings {
+		if settings.ChatID == chatID {
+			delete(r.settings, key)
+		}
+	}
+	return nil
+}
+
+type messages Store
+
+func (r *messages) Add(ctx context.Context, chatID string, msg *database.Message) (string, error) {
+	if err := r.AddAll(ctx, chatID, []*database.Message{msg}); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+func (r *messages) AddAll(ctx context.Context, chatID string, msgs []*database.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.messages[chatID]
+	for _, msg := range msgs {
+		for i := range list {
+			if msg.ID != "" && list[i].ID == msg.ID {
+				return fmt.Errorf("message %s: %w", msg.ID, database.ErrAlreadyExists)
+			}
+		}
+	}
+
+	for _, msg := range msgs {
+		if msg.ID == "" {
+			msg.ID = database.NewID()
+		}
+		msg.ChatID = chatID
+		list = append(list, copyMessage(msg))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Cursor().Before(list[j].Cursor())
+	})
+	r.messages[chatID] = list
+
+	for _, msg := range msgs {
+		r.hub.Publish(chatID, database.MessageChange{Kind: database.MessageAdded, Message: copyMessage(msg)})
+	}
+	return nil
+}
+
+func (r *messages) List(ctx context.Context, chatID string) ([]database.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := r.messages[chatID]
+	result := make([]database.Message, len(list))
+	for i := range list {
+		result[i] = copyMessage(&list[i])
+	}
+	return result, nil
+}
+
+func (r *messages) Get(ctx context.Context, chatID, id string) (*database.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, msg := range r.messages[chatID] {
+		if msg.ID == id {
+			result := copyMessage(&msg)
+			return &result, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s: %w", id, database.ErrNotFound)
+}
+
+func (r *messages) ListPage(ctx context.Context, chatID string, page database.MessagePage) ([]database.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var window []database.Message
+	for _, msg := range r.messages[chatID] {
+		cursor := msg.Cursor()
+		if page.After != nil && !page.After.Before(cursor) {
+			continue
+		}
+		if page.Before != nil && !cursor.Before(*page.Before) {
+			continue
+		}
+		if msg.ThreadID != page.ThreadID {
+			continue
+		}
+		window = append(window, msg)
+	}
+
+	if len(window) > page.Limit {
+		if page.After != nil {
+			window = window[:page.Limit]
+		} else {
+			window = window[len(window)-page.Limit:]
+		}
+	}
+
+	result := make([]database.Message, len(window))
+	for i := range window {
+		result[i] = copyMessage(&window[i])
+	}
+	return result, nil
+}
+
+func (r *messages) Update(ctx context.Context, chatID, id string, fn func(msg *database.Message) error) (*database.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.messages[chatID]
+	for i := range list {
+		if list[i].ID != id {
+			continue
+		}
+
+		msg := copyMessage(&list[i])
+		if err := fn(&msg); err != nil {
+			return nil, err
+		}
+		list[i].Text = msg.Text
+		list[i].Edits = msg.Edits
+		list[i].DeletedAt = msg.DeletedAt
+		list[i].HiddenFor = msg.HiddenFor
+		list[i].Reactions = msg.Reactions
+		list[i].ReplyCount = msg.ReplyCount
+		list[i].LastReplyAt = msg.LastReplyAt
+		list[i].Attachments = msg.Attachments
+		list[i].ListenedBy = msg.ListenedBy
+		list[i].Mentions = msg.Mentions
+		list[i].PinnedAt = msg.PinnedAt
+		list[i].PinnedBy = msg.PinnedBy
+		list[i] = copyMessage(&list[i])
+
+		if i == len(list)-1 {
+			r.hub.Publish(chatID, database.MessageChange{Kind: database.MessageModified, Message: copyMessage(&list[i])})
+		}
+		result := copyMessage(&list[i])
+		return &result, nil
+	}
+
+	return nil, fmt.Errorf("message %s: %w", id, database.ErrNotFound)
+}
+
+func (r *messages) MarkRead(ctx context.Context, chatID, messageID, uid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.messages[chatID]
+	for i := range list {
+		if list[i].ID != messageID {
+			continue
+		}
+
+		for _, reader := range list[i].ReadBy {
+			if reader == uid {
+				return nil
+			}
+		}
+		list[i].ReadBy = append(list[i].ReadBy, uid)
+
+		if i == len(list)-1 {
+			r.hub.Publish(chatID, database.MessageChange{Kind: database.MessageModified, Message: copyMessage(&list[i])})
+		}
+		return nil
+	}
+
+	return fmt.Errorf("message %s: %w", messageID, database.ErrNotFound)
+}
+
+func (r *messages) AddViews(ctx context.Context, chatID string, ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.messages[chatID]
+	for i := range list {
+		if slices.Contains(ids, list[i].ID) {
+			list[i].Views++
+		}
+	}
+	return nil
+}
+
+// Watch mirrors the Firestore "latest message" snapshot query: the current
+// latest message is delivered first as MessageAdded, followed by every
+// message added afterwards and read receipts on the latest one.
+func (r *messages) Watch(ctx context.Context, chatID string) (<-chan database.MessageChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var initial []database.MessageChange
+	if list := r.messages[chatID]; len(list) > 0 {
+		initial = append(initial, database.MessageChange{Kind: database.MessageAdded, Message: copyMessage(&list[len(list)-1])})
+	}
+
+	return r.hub.Subscribe(ctx, chatID, initial...), nil
+}
+
+func copyUser(user *database.User) database.User {
+	result := *user
+	result.Roles = append([]string(nil), user.Roles...)
+	return result
+}
+
+func copyChat(chat *database.Chat) database.Chat {
+	result := *chat
+	result.Participants = append([]string(nil), chat.Participants...)
+	if chat.UnreadMentions != nil {
+		result.UnreadMentions = maps.Clone(chat.UnreadMentions)
+	}
+	if chat.Roles != nil {
+		result.Roles = maps.Clone(chat.Roles)
+	}
+	if chat.LastMessage != nil {
+		last := copyMessage(chat.LastMessage)
+		result.LastMessage = &last
+	}
+	return result
+}
+
+func copyMessage(msg *database.Message) database.Message {
+	result := *msg
+	result.ReadBy = append([]string(nil), msg.ReadBy...)
+	result.Edits = append([]database.MessageEdit(nil), msg.Edits...)
+	result.HiddenFor = append([]string(nil), msg.HiddenFor...)
+	result.ListenedBy = append([]string(nil), msg.ListenedBy...)
+	result.Mentions = append([]string(nil), msg.Mentions...)
+	result.Attachments = nil
+	for _, attachment := range msg.Attachments {
+		if attachment.Thumbnail != nil {
+			thumbnail := *attachment.Thumbnail
+			attachment.Thumbnail = &thumbnail
+		}
+		attachment.Waveform = append([]int(nil), attachment.Waveform...)
+		result.Attachments = append(result.Attachments, attachment)
+	}
+	if msg.ForwardedFrom != nil {
+		origin := *msg.ForwardedFrom
+		result.ForwardedFrom = &origin
+	}
+	if msg.Reactions != nil {
+		result.Reactions = make(map[string][]string, len(msg.Reactions))
+		for emoji, users := range msg.Reactions {
+			result.Reactions[emoji] = append([]string(nil), users...)
+		}
+	}
+	return result
+}
+
+type changes Store
+
+func (r *changes) Append(ctx context.Context, change *database.Change, uids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if change.Seq == 0 {
+		change.Seq = database.NewChangeSeq()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+	for _, uid := range uids {
+		r.changes[uid] = append(r.changes[uid], *change)
+	}
+	return nil
+}
+
+func (r *changes) ListSince(ctx context.Context, uid string, after int64, limit int) ([]database.Change, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Change{}
+	for _, change := range r.changes[uid] {
+		if change.Seq > after && len(result) < limit {
+			result = append(result, change)
+		}
+	}
+	return result, nil
+}
+
+type attachments Store
+
+func (r *attachments) Create(ctx context.Context, attachment *database.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attachment.ID == "" {
+		attachment.ID = database.NewID()
+	}
+	r.attachments[attachment.ID] = copyAttachment(attachment)
+	return nil
+}
+
+func (r *attachments) Get(ctx context.Context, id string) (*database.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attachment, ok := r.attachments[id]
+	if !ok {
+		return nil, fmt.Errorf("attachment %s: %w", id, database.ErrNotFound)
+	}
+	attachment = copyAttachment(&attachment)
+	return &attachment, nil
+}
+
+func (r *attachments) Update(ctx context.Context, attachment *database.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.attachments[attachment.ID]; !ok {
+		return fmt.Errorf("attachment %s: %w", attachment.ID, database.ErrNotFound)
+	}
+	r.attachments[attachment.ID] = copyAttachment(attachment)
+	return nil
+}
+
+func (r *attachments) ListByChat(ctx context.Context, chatID string) ([]database.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var result []database.Attachment
+	for _, attachment := range r.attachments {
+		if attachment.ChatID == chatID {
+			result = append(result, copyAttachment(&attachment))
+		}
+	}
+	return result, nil
+}
+
+func (r *attachments) KeyInUse(ctx context.Context, key string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, attachment := range r.attachments {
+		if attachment.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *attachments) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attachments, id)
+	return nil
+}
+
+func copyAttachment(attachment *database.Attachment) database.Attachment {
+	result := *attachment
+	result.Waveform = append([]int(nil), attachment.Waveform...)
+	if attachment.Thumbnail != nil {
+		thumbnail := *attachment.Thumbnail
+		result.Thumbnail = &thumbnail
+	}
+	return result
+}
+
+type search Store
+
+func searchKey(chatID, messageID string) string {
+	return chatID + "/" + messageID
+}
+
+func (r *search) Index(ctx context.Context, entry *database.SearchEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *entry
+	stored.Terms = append([]string(nil), entry.Terms...)
+	r.search[searchKey(entry.ChatID, entry.MessageID)] = stored
+	return nil
+}
+
+func (r *search) Remove(ctx context.Context, chatID, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.search, searchKey(chatID, messageID))
+	return nil
+}
+
+func (r *search) Query(ctx context.Context, chatIDs, terms []string, limit int) ([]database.SearchEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.SearchEntry{}
+	for _, entry := range r.search {
+		if !slices.Contains(chatIDs, entry.ChatID) {
+			continue
+		}
+		matches := true
+		for _, term := range terms {
+			if !slices.Contains(entry.Terms, term) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			entry.Terms = nil
+			result = append(result, entry)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.After(result[j].Timestamp)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (r *search) Clear(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clear(r.search)
+	return nil
+}
+
+type invites Store
+
+func (r *invites) Create(ctx context.Context, invite *database.Invite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.invites[invite.Token]; ok {
+		return fmt.Errorf("invite %s already exists", invite.Token)
+	}
+	r.invites[invite.Token] = copyInvite(invite)
+	return nil
+}
+
+func (r *invites) Get(ctx context.Context, token string) (*database.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invite, ok := r.invites[token]
+	if !ok {
+		return nil, fmt.Errorf("invite %s: %w", token, database.ErrNotFound)
+	}
+	invite = copyInvite(&invite)
+	return &invite, nil
+}
+
+func (r *invites) ListByChat(ctx context.Context, chatID string) ([]database.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Invite{}
+	for _, invite := range r.invites {
+		if invite.ChatID == chatID {
+			result = append(result, copyInvite(&invite))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (r *invites) Update(ctx context.Context, token string, fn func(invite *database.Invite) error) (*database.Invite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.invites[token]
+	if !ok {
+		return nil, fmt.Errorf("invite %s: %w", token, database.ErrNotFound)
+	}
+
+	invite := copyInvite(&stored)
+	if err := fn(&invite); err != nil {
+		return nil, err
+	}
+	r.invites[token] = copyInvite(&invite)
+	return &invite, nil
+}
+
+func (r *invites) Delete(ctx context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.invites, token)
+	return nil
+}
+
+func copyInvite(invite *database.Invite) database.Invite {
+	result := *invite
+	result.Pending = append([]string(nil), invite.Pending...)
+	return result
+}
+
+type subscriptions Store
+
+func subscriptionKey(chatID, uid string) string {
+	return chatID + "/" + uid
+}
+
+func (r *subscriptions) Subscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatID]
+	if !ok {
+		return false, fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+	key := subscriptionKey(chatID, uid)
+	if _, ok := r.subs[key]; ok {
+		return false, nil
+	}
+
+	r.subs[key] = database.Subscription{ChatID: chatID, UserID: uid, SubscribedAt: time.Now()}
+	chat.SubscriberCount++
+	r.chats[chatID] = chat
+	return true, nil
+}
+
+func (r *subscriptions) Unsubscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := subscriptionKey(chatID, uid)
+	if _, ok := r.subs[key]; !ok {
+		return false, nil
+	}
+
+	delete(r.subs, key)
+	if chat, ok := r.chats[chatID]; ok {
+		chat.SubscriberCount--
+		r.chats[chatID] = chat
+	}
+	return true, nil
+}
+
+func (r *subscriptions) Get(ctx context.Context, chatID, uid string) (*database.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscription, ok := r.subs[subscriptionKey(chatID, uid)]
+	if !ok {
+		return nil, fmt.Errorf("subscription %s/%s: %w", chatID, uid, database.ErrNotFound)
+	}
+	return &subscription, nil
+}
+
+func (r *subscriptions) ListByUser(ctx context.Context, uid string) ([]database.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Subscription{}
+	for _, subscription := range r.subs {
+		if subscription.UserID == uid {
+			result = append(result, subscription)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ChatID < result[j].ChatID
+	})
+	return result, nil
+}
+
+func (r *subscriptions) ListByChat(ctx context.Context, chatID, afterUID string, limit int) ([]database.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.Subscription{}
+	for _, subscription := range r.subs {
+		if subscription.ChatID == chatID && subscription.UserID > afterUID {
+			result = append(result, subscription)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UserID < result[j].UserID
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (r *subscriptions) AdvanceViewed(ctx context.Context, chatID, uid string, at time.Time) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := subscriptionKey(chatID, uid)
+	subscription, ok := r.subs[key]
+	if !ok {
+		return time.Time{}, fmt.Errorf("subscription %s/%s: %w", chatID, uid, database.ErrNotFound)
+	}
+
+	previous := subscription.ViewedAt
+	if at.After(previous) {
+		subscription.ViewedAt = at
+		r.subs[key] = subscription
+	}
+	return previous, nil
+}
+
+type chatSettings Store
+
+func (r *chatSettings) Get(ctx context.Context, uid, chatID string) (*database.ChatSettings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	settings, ok := r.settings[uid+"/"+chatID]
+	if !ok {
+		return nil, fmt.Errorf("chat settings %s/%s: %w", uid, chatID, database.ErrNotFound)
+	}
+	result := copyChatSettings(&settings)
+	return &result, nil
+}
+
+func (r *chatSettings) ListByUser(ctx context.Context, uid string) ([]database.ChatSettings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := []database.ChatSettings{}
+	for _, settings := range r.settings {
+		if settings.UserID == uid {
+			result = append(result, copyChatSettings(&settings))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ChatID < result[j].ChatID
+	})
+	return result, nil
+}
+
+func (r *chatSettings) Save(ctx context.Context, settings *database.ChatSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.settings[settings.UserID+"/"+settings.ChatID] = copyChatSettings(settings)
+	return nil
+}
+
+func (r *chatSettings) Delete(ctx context.Context, uid, chatID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.settings, uid+"/"+chatID)
+	return nil
+}
+
+func copyChatSettings(settings *database.ChatSettings) database.ChatSettings {
+	result := *settings
+	result.Folders = append([]string(nil), settings.Folders...)
+	return result
+}
@@ -0,0 +1,137 @@
+package database
+
+import "slices"
+
+// Roles of a participant in a group chat, from most to least
+// privileged.
+const (
+	ChatRoleOwner    = "owner"
+	ChatRoleAdmin    = "admin"
+	ChatRoleMember   = "member"
+	ChatRoleReadOnly = "read_only"
+)
+
+// ChatPermission is something a participant may be allowed to do in
+// a chat.
+type ChatPermission string
+
+const (
+	PermRead             ChatPermission = "read"
+	PermSend             ChatPermission = "send"
+	PermRename           ChatPermission = "rename"
+	PermInvite           ChatPermission = "invite"
+	PermRemove           ChatPermission = "remove"
+	PermPin              ChatPermission = "pin"
+	PermDeleteAnyMessage ChatPermission = "delete_any_message"
+	PermChangeSettings   ChatPermission = "change_settings"
+	PermChangeRoles      ChatPermission = "change_roles"
+	PermDeleteChat       ChatPermission = "delete_chat"
+)
+
+// chatPermissions is the permission matrix of group chats.
+var chatPermissions = map[string][]ChatPermission{
+	ChatRoleOwner: {
+		PermRead, PermSend, PermRename, PermInvite, PermRemove, PermPin,
+		PermDeleteAnyMessage, PermChangeSettings, PermChangeRoles, PermDeleteChat,
+	},
+	ChatRoleAdmin: {
+		PermRead, PermSend, PermRename, PermInvite, PermRemove, PermPin,
+		PermDeleteAnyMessage, PermChangeSettings,
+	},
+	ChatRoleMember:   {PermRead, PermSend},
+	ChatRoleReadOnly: {PermRead},
+}
+
+// privatePermissions apply to both sides of a private chat, which
+// has no roles and no member list to change.
+var privatePermissions = []ChatPermission{PermRead, PermSend, PermPin, PermChangeSettings, PermDeleteChat}
+
+// ValidChatRole reports whether role can be given to a participant.
+func ValidChatRole(role string) bool {
+	_, ok := chatPermissions[role]
+	return ok
+}
+
+// Role returns uid's role in the chat, or "" if uid is not a
+// participant.
+func (c *Chat) Role(uid string) string {
+	if !slices.Contains(c.Participants, uid) {
+		return ""
+	}
+	if role, ok := c.Roles[uid]; ok && role != "" {
+		return role
+	}
+	if c.CreatedBy == uid && !c.ownerAssigned() {
+		return ChatRoleOwner
+	}
+	return ChatRoleMember
+}
+
+// ownerAssigned reports whether ownership was given explicitly, which
+// happens once it is transferred away from the creator.
+func (c *Chat) ownerAssigned() bool {
+	for _, role := range c.Roles {
+		if role == ChatRoleOwner {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether uid's role allows perm in the chat.
+func (c *Chat) Can(uid string, perm ChatPermission) bool {
+	role := c.Role(uid)
+	if role == "" {
+		return false
+	}
+	if c.Type == "private" {
+		return slices.Contains(privatePermissions, perm)
+	}
+	// Only the owner and admins post in a channel; everyone else
+	// there just reads.
+	if c.Type == "channel" && !c.IsAdmin(uid) {
+		return perm == PermRead
+	}
+	return slices.Contains(chatPermissions[role], perm)
+}
+
+// IsAdmin reports whether uid moderates the chat as its owner or an
+// admin.
+func (c *Chat) IsAdmin(uid string) bool {
+	role := c.Role(uid)
+	return c.Type != "private" && (role == ChatRoleOwner || role == ChatRoleAdmin)
+}
+
+// ParticipantRoles returns the role of every participant of a group
+// chat, or nil for a private chat.
+func (c *Chat) ParticipantRoles() map[string]string {
+	if c.Type == "private" {
+		return nil
+	}
+
+	roles := make(map[string]string, len(c.Participants))
+	for _, uid := range c.Participants {
+		roles[uid] = c.Role(uid)
+	}
+	return roles
+}
+
+// Outranks reports whether uid's role is above other's, which is
+// needed to remove other or change their role.
+func (c *Chat) Outranks(uid, other string) bool {
+	return chatRoleRank(c.Role(uid)) > chatRoleRank(c.Role(other))
+}
+
+func chatRoleRank(role string) int {
+	switch role {
+	case ChatRoleOwner:
+		return 4
+	case ChatRoleAdmin:
+		return 3
+	case ChatRoleMember:
+		return 2
+	case ChatRoleReadOnly:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,6 @@
+DROP TABLE message_reads;
+DROP TABLE messages;
+DROP TABLE chat_participants;
+DROP TABLE chats;
+DROP TABLE contacts;
+DROP TABLE users;
@@ -0,0 +1,60 @@
+CREATE TABLE users (
+    uid        TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    email      TEXT NOT NULL UNIQUE,
+    created_at BIGINT NOT NULL,
+    is_banned  BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX users_name_idx ON users (name);
+
+CREATE TABLE contacts (
+    id            TEXT PRIMARY KEY,
+    owner_uid     TEXT NOT NULL,
+    contact_uid   TEXT NOT NULL,
+    contact_email TEXT NOT NULL,
+    contact_name  TEXT NOT NULL,
+    notes         TEXT NOT NULL DEFAULT '',
+    created_at    BIGINT NOT NULL
+);
+
+CREATE INDEX contacts_owner_idx ON contacts (owner_uid, created_at);
+
+CREATE TABLE chats (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    type         TEXT NOT NULL,
+    created_by   TEXT NOT NULL,
+    created_at   BIGINT NOT NULL,
+    updated_at   BIGINT NOT NULL,
+    last_message TEXT
+);
+
+CREATE TABLE chat_participants (
+    chat_id  TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    user_id  TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX chat_participants_user_idx ON chat_participants (user_id);
+
+CREATE TABLE messages (
+    chat_id   TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    id        TEXT NOT NULL,
+    sender_id TEXT NOT NULL,
+    text      TEXT NOT NULL,
+    sent_at   BIGINT NOT NULL,
+    PRIMARY KEY (chat_id, id)
+);
+
+CREATE INDEX messages_chat_sent_idx ON messages (chat_id, sent_at);
+
+CREATE TABLE message_reads (
+    chat_id    TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    read_at    BIGINT NOT NULL,
+    PRIMARY KEY (chat_id, message_id, user_id),
+    FOREIGN KEY (chat_id, message_id) REFERENCES messages (chat_id, id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+CREATE TABLE credentials (
+    uid           TEXT PRIMARY KEY,
+    email         TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at    BIGINT NOT NULL
+);
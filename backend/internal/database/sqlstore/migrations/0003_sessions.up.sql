@@ -0,0 +1,10 @@
+CREATE TABLE sessions (
+    id           TEXT PRIMARY KEY,
+    uid          TEXT NOT NULL,
+    device       TEXT NOT NULL,
+    ip           TEXT NOT NULL,
+    created_at   BIGINT NOT NULL,
+    last_used_at BIGINT NOT NULL
+);
+
+CREATE INDEX sessions_uid_idx ON sessions (uid, last_used_at);
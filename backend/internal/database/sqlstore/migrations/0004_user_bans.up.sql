@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN ban_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN banned_until BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT 'null';
@@ -0,0 +1,2 @@
+DROP INDEX messages_chat_sent_idx;
+CREATE INDEX messages_chat_sent_idx ON messages (chat_id, sent_at);
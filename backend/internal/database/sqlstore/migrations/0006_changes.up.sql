@@ -0,0 +1,11 @@
+CREATE TABLE changes (
+    uid        TEXT NOT NULL,
+    seq        BIGINT NOT NULL,
+    type       TEXT NOT NULL,
+    chat_id    TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    contact_id TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (uid, seq)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN hidden_for;
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE messages DROP COLUMN edits;
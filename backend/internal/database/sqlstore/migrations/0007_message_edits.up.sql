@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN edits TEXT NOT NULL DEFAULT 'null';
+ALTER TABLE messages ADD COLUMN deleted_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN hidden_for TEXT NOT NULL DEFAULT 'null';
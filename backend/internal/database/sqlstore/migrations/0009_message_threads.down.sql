@@ -0,0 +1,7 @@
+DROP INDEX messages_chat_sent_idx;
+CREATE INDEX messages_chat_sent_idx ON messages (chat_id, sent_at, id);
+
+ALTER TABLE messages DROP COLUMN last_reply_at;
+ALTER TABLE messages DROP COLUMN reply_count;
+ALTER TABLE messages DROP COLUMN thread_id;
+ALTER TABLE messages DROP COLUMN reply_to;
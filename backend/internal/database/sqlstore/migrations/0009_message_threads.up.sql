@@ -0,0 +1,7 @@
+ALTER TABLE messages ADD COLUMN reply_to TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN thread_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN last_reply_at BIGINT NOT NULL DEFAULT 0;
+
+DROP INDEX messages_chat_sent_idx;
+CREATE INDEX messages_chat_sent_idx ON messages (chat_id, thread_id, sent_at, id);
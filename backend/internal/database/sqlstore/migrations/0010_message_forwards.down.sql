@@ -0,0 +1 @@
+ALTER TABLE messages DROP COLUMN forwarded_from;
@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN forwarded_from TEXT NOT NULL DEFAULT 'null';
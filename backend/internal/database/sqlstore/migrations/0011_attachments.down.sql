@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN attachments;
+
+DROP TABLE attachments;
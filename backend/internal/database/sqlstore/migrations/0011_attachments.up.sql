@@ -0,0 +1,13 @@
+CREATE TABLE attachments (
+    id          TEXT PRIMARY KEY,
+    chat_id     TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    uploader_id TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    mime_type   TEXT NOT NULL,
+    size        BIGINT NOT NULL,
+    checksum    TEXT NOT NULL,
+    blob_key    TEXT NOT NULL,
+    created_at  BIGINT NOT NULL
+);
+
+ALTER TABLE messages ADD COLUMN attachments TEXT NOT NULL DEFAULT 'null';
@@ -0,0 +1,5 @@
+ALTER TABLE attachments DROP COLUMN thumbnail_key;
+ALTER TABLE attachments DROP COLUMN thumbnail;
+ALTER TABLE attachments DROP COLUMN blurhash;
+ALTER TABLE attachments DROP COLUMN height;
+ALTER TABLE attachments DROP COLUMN width;
@@ -0,0 +1,5 @@
+ALTER TABLE attachments ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN blurhash TEXT NOT NULL DEFAULT '';
+ALTER TABLE attachments ADD COLUMN thumbnail TEXT NOT NULL DEFAULT 'null';
+ALTER TABLE attachments ADD COLUMN thumbnail_key TEXT NOT NULL DEFAULT '';
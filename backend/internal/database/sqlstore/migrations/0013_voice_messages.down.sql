@@ -0,0 +1,5 @@
+ALTER TABLE messages DROP COLUMN listened_by;
+
+ALTER TABLE attachments DROP COLUMN waveform;
+ALTER TABLE attachments DROP COLUMN duration_ms;
+ALTER TABLE attachments DROP COLUMN kind;
@@ -0,0 +1,5 @@
+ALTER TABLE attachments ADD COLUMN kind TEXT NOT NULL DEFAULT '';
+ALTER TABLE attachments ADD COLUMN duration_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN waveform TEXT NOT NULL DEFAULT 'null';
+
+ALTER TABLE messages ADD COLUMN listened_by TEXT NOT NULL DEFAULT 'null';
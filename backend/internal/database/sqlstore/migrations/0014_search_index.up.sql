@@ -0,0 +1,9 @@
+CREATE TABLE search_terms (
+    chat_id    TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    message_id TEXT NOT NULL,
+    term       TEXT NOT NULL,
+    timestamp  BIGINT NOT NULL,
+    PRIMARY KEY (chat_id, message_id, term)
+);
+
+CREATE INDEX search_terms_term_idx ON search_terms (term, chat_id);
@@ -0,0 +1,3 @@
+ALTER TABLE chat_participants DROP COLUMN unread_mentions;
+
+ALTER TABLE messages DROP COLUMN mentions;
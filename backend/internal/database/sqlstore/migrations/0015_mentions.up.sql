@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN mentions TEXT NOT NULL DEFAULT 'null';
+
+ALTER TABLE chat_participants ADD COLUMN unread_mentions INTEGER NOT NULL DEFAULT 0;
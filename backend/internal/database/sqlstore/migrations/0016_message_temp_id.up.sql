@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN temp_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE chat_participants DROP COLUMN role;
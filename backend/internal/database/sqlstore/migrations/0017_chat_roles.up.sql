@@ -0,0 +1 @@
+ALTER TABLE chat_participants ADD COLUMN role TEXT NOT NULL DEFAULT '';
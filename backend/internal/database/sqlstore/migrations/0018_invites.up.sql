@@ -0,0 +1,13 @@
+CREATE TABLE invites (
+    token             TEXT PRIMARY KEY,
+    chat_id           TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    created_by        TEXT NOT NULL,
+    created_at        BIGINT NOT NULL,
+    expires_at        BIGINT NOT NULL DEFAULT 0,
+    max_uses          INTEGER NOT NULL DEFAULT 0,
+    uses              INTEGER NOT NULL DEFAULT 0,
+    requires_approval BOOLEAN NOT NULL DEFAULT FALSE,
+    pending           TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX invites_chat_idx ON invites (chat_id);
@@ -0,0 +1,8 @@
+DROP TABLE subscriptions;
+
+ALTER TABLE messages DROP COLUMN views;
+
+DROP INDEX chats_handle_idx;
+
+ALTER TABLE chats DROP COLUMN subscriber_count;
+ALTER TABLE chats DROP COLUMN handle;
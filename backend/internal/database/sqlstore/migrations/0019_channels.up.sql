@@ -0,0 +1,16 @@
+ALTER TABLE chats ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN subscriber_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX chats_handle_idx ON chats (handle) WHERE handle <> '';
+
+ALTER TABLE messages ADD COLUMN views INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE subscriptions (
+    chat_id       TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    user_id       TEXT NOT NULL,
+    subscribed_at BIGINT NOT NULL,
+    viewed_at     BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX subscriptions_user_idx ON subscriptions (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE chat_settings (
+    user_id     TEXT NOT NULL,
+    chat_id     TEXT NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    muted_until BIGINT NOT NULL DEFAULT 0,
+    archived    BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned      BOOLEAN NOT NULL DEFAULT FALSE,
+    pin_order   INTEGER NOT NULL DEFAULT 0,
+    folders     TEXT NOT NULL DEFAULT '[]',
+    updated_at  BIGINT NOT NULL,
+    PRIMARY KEY (user_id, chat_id)
+);
@@ -0,0 +1,2 @@
+DROP INDEX attachments_blob_key_idx;
+DROP INDEX attachments_chat_idx;
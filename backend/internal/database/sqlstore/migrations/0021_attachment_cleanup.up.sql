@@ -0,0 +1,2 @@
+CREATE INDEX attachments_chat_idx ON attachments (chat_id);
+CREATE INDEX attachments_blob_key_idx ON attachments (blob_key);
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN pinned_by;
+ALTER TABLE messages DROP COLUMN pinned_at;
@@ -0,0 +1,2 @@
+ALTER TABLE messages ADD COLUMN pinned_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN pinned_by TEXT NOT NULL DEFAULT '';
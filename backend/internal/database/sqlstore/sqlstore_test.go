@@ -0,0 +1,672 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	ctx := context.Background()
+	store, err := Open(ctx, "sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := NewMigrator(store).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	return store
+}
+
+func TestMigrationsUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+	migrator := NewMigrator(store)
+
+	all, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	version, _ := migrator.Version(ctx)
+	if version != all[len(all)-1].Version {
+		t.Errorf("Version = %d, want %d", version, all[len(all)-1].Version)
+	}
+
+	if applied, _ := migrator.Up(ctx); applied != 0 {
+		t.Errorf("second Up applied %d migrations, want 0", applied)
+	}
+
+	rolledBack, err := migrator.Down(ctx, len(all))
+	if err != nil || rolledBack != len(all) {
+		t.Fatalf("Down = %d, %v; want %d", rolledBack, err, len(all))
+	}
+	if version, _ := migrator.Version(ctx); version != 0 {
+		t.Errorf("Version after Down = %d, want 0", version)
+	}
+
+	if applied, err := migrator.Up(ctx); err != nil || applied != len(all) {
+		t.Errorf("Up after Down = %d, %v; want %d", applied, err, len(all))
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "-- comment\nCREATE TABLE a (\n    id TEXT\n);\n\nCREATE INDEX a_idx ON a (id);\n"
+	got := splitStatements(script)
+	if len(got) != 2 {
+		t.Fatalf("got %d statements, want 2: %q", len(got), got)
+	}
+	if got[1] != "CREATE INDEX a_idx ON a (id)" {
+		t.Errorf("second statement = %q", got[1])
+	}
+}
+
+func TestRebind(t *testing.T) {
+	got := Postgres.rebind("SELECT * FROM t WHERE a = ? AND b = ?")
+	if got != "SELECT * FROM t WHERE a = $1 AND b = $2" {
+		t.Errorf("rebind = %q", got)
+	}
+	if got := SQLite.rebind("a = ?"); got != "a = ?" {
+		t.Errorf("sqlite rebind = %q, want unchanged", got)
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+	now := time.Now()
+
+	store.Users().Save(ctx, &database.User{UID: "u1", Name: "Alice", Email: "alice@test.com", CreatedAt: now})
+	store.Users().Save(ctx, &database.User{UID: "u2", Name: "Bob", Email: "bob@test.com", CreatedAt: now})
+
+	user, err := store.Users().GetByEmail(ctx, "bob@test.com")
+	if err != nil || user.UID != "u2" {
+		t.Fatalf("GetByEmail = %+v, %v", user, err)
+	}
+	if !user.CreatedAt.Equal(now) {
+		t.Errorf("CreatedAt = %v, want %v", user.CreatedAt, now)
+	}
+	if _, err := store.Users().Get(ctx, "nobody"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get(nobody) error = %v, want ErrNotFound", err)
+	}
+	store.Credentials().Save(ctx, &database.Credential{UID: "u1", Email: "alice@test.com", PasswordHash: "$argon2id$x", CreatedAt: now})
+	if cred, err := store.Credentials().GetByEmail(ctx, "alice@test.com"); err != nil || cred.UID != "u1" {
+		t.Errorf("Credentials().GetByEmail = %+v, %v", cred, err)
+	}
+	store.Credentials().Delete(ctx, "u1")
+	if _, err := store.Credentials().GetByEmail(ctx, "alice@test.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetByEmail after Delete error = %v, want ErrNotFound", err)
+	}
+
+	store.Sessions().Save(ctx, &database.Session{ID: "s1", UID: "u1", Device: "phone", CreatedAt: now, LastUsedAt: now})
+	store.Sessions().Save(ctx, &database.Session{ID: "s2", UID: "u1", Device: "laptop", CreatedAt: now, LastUsedAt: now.Add(time.Minute)})
+	if sessions, _ := store.Sessions().ListByUser(ctx, "u1"); len(sessions) != 2 || sessions[0].ID != "s2" {
+		t.Errorf("ListByUser = %+v, want s2 first", sessions)
+	}
+	store.Sessions().DeleteByUser(ctx, "u1")
+	if _, err := store.Sessions().Get(ctx, "s1"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after DeleteByUser error = %v, want ErrNotFound", err)
+	}
+
+	if found, _ := store.Users().SearchByName(ctx, "Al"); len(found) != 1 {
+		t.Errorf("SearchByName(Al) = %+v, want one user", found)
+	}
+
+	store.Contacts().Save(ctx, &database.Contact{ID: "u1_u2", OwnerUID: "u1", ContactUID: "u2", ContactName: "Bob", CreatedAt: now})
+	contacts, _ := store.Contacts().ListByOwner(ctx, "u1")
+	if len(contacts) != 1 || contacts[0].ContactName != "Bob" {
+		t.Errorf("ListByOwner = %+v", contacts)
+	}
+
+	chatID, err := store.Chats().Create(ctx, &database.Chat{
+		Name:         "Team",
+		Type:         "group",
+		Participants: []string{"u2", "u1"},
+		CreatedBy:    "u2",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	if err != nil {
+		t.Fatalf("Create chat: %v", err)
+	}
+
+	chats, _ := store.Chats().ListByParticipant(ctx, "u1")
+	if len(chats) != 1 || len(chats[0].Participants) != 2 || chats[0].Participants[0] != "u2" {
+		t.Fatalf("ListByParticipant = %+v, want participants in insertion order", chats)
+	}
+
+	msg := &database.Message{SenderID: "u1", Text: "hi", Timestamp: now, ReadBy: []string{"u1"}}
+	msgID, err := store.Messages().Add(ctx, chatID, msg)
+	if err != nil {
+		t.Fatalf("Add message: %v", err)
+	}
+	if err := store.Messages().MarkRead(ctx, chatID, msgID, "u2"); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if err := store.Messages().MarkRead(ctx, chatID, "missing", "u2"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("MarkRead(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := store.Chats().SetLastMessage(ctx, chatID, msg); err != nil {
+		t.Fatalf("SetLastMessage: %v", err)
+	}
+
+	list, _ := store.Messages().List(ctx, chatID)
+	if len(list) != 1 || len(list[0].ReadBy) != 2 || list[0].ReadBy[1] != "u2" {
+		t.Errorf("List = %+v, want one message read by u1 and u2", list)
+	}
+
+	chat, _ := store.Chats().Get(ctx, chatID)
+	if chat.LastMessage == nil || chat.LastMessage.Text != "hi" {
+		t.Errorf("LastMessage = %+v", chat.LastMessage)
+	}
+}
+
+func TestWatchIsDrivenByWritePath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Name: "c", Type: "private", Participants: []string{"u1", "u2"}})
+
+	changes, err := store.Messages().Watch(ctx, chatID)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	store.Messages().Add(ctx, chatID, &database.Message{SenderID: "u1", Text: "ping", Timestamp: time.Now()})
+
+	select {
+	case change := <-changes:
+		if change.Kind != database.MessageAdded || change.Message.Text != "ping" {
+			t.Errorf("change = %+v, want added ping", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change delivered after Add")
+	}
+}
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	base := time.Now()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		store.Messages().Add(ctx, chatID, &database.Message{ID: id, SenderID: "u1", Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	// "e" shares its timestamp with "d" and sorts after it by ID.
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "e", SenderID: "u1", Timestamp: base.Add(3 * time.Second)})
+	store.Messages().MarkRead(ctx, chatID, "b", "u2")
+
+	at := func(id string, i int) *database.MessageCursor {
+		return &database.MessageCursor{ID: id, Timestamp: base.Add(time.Duration(i) * time.Second)}
+	}
+
+	tests := []struct {
+		name string
+		page database.MessagePage
+		want string
+	}{
+		{"latest", database.MessagePage{Limit: 2}, "de"},
+		{"before", database.MessagePage{Before: at("d", 3), Limit: 2}, "bc"},
+		{"after", database.MessagePage{After: at("a", 0), Limit: 2}, "bc"},
+		{"after tie", database.MessagePage{After: at("d", 3), Limit: 5}, "e"},
+		{"between", database.MessagePage{After: at("a", 0), Before: at("e", 3), Limit: 10}, "bcd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := store.Messages().ListPage(ctx, chatID, tt.page)
+			if err != nil {
+				t.Fatalf("ListPage: %v", err)
+			}
+			got := ""
+			for _, m := range list {
+				got += m.ID
+			}
+			if got != tt.want {
+				t.Errorf("ListPage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "t1", SenderID: "u1", Timestamp: base.Add(4 * time.Second), ThreadID: "a"})
+	if list, _ := store.Messages().ListPage(ctx, chatID, database.MessagePage{Limit: 1}); len(list) != 1 || list[0].ID != "e" {
+		t.Errorf("timeline = %+v, want thread replies left out", list)
+	}
+	if list, _ := store.Messages().ListPage(ctx, chatID, database.MessagePage{Limit: 5, ThreadID: "a"}); len(list) != 1 || list[0].ID != "t1" {
+		t.Errorf("thread = %+v, want only t1", list)
+	}
+
+	msg, err := store.Messages().Get(ctx, chatID, "b")
+	if err != nil || len(msg.ReadBy) != 1 {
+		t.Errorf("Get(b) = %+v, %v; want one reader", msg, err)
+	}
+	if _, err := store.Messages().Get(ctx, chatID, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestChangesJournal(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	for i := 1; i <= 3; i++ {
+		change := &database.Change{Seq: int64(i), Type: database.ChangeMessageNew, ChatID: "c1", MessageID: "m"}
+		if err := store.Changes().Append(ctx, change, []string{"u1", "u2"}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	store.Changes().Append(ctx, &database.Change{Type: database.ChangeContactSaved, ContactID: "u2_u1"}, []string{"u2"})
+
+	changes, err := store.Changes().ListSince(ctx, "u1", 1, 10)
+	if err != nil {
+		t.Fatalf("ListSince: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Seq != 2 || changes[1].ChatID != "c1" {
+		t.Errorf("ListSince(u1, 1) = %+v, want seq 2 and 3", changes)
+	}
+
+	changes, _ = store.Changes().ListSince(ctx, "u2", 0, 3)
+	if len(changes) != 3 || changes[2].Seq != 3 {
+		t.Errorf("ListSince(u2, 0, 3) = %+v, want the first three", changes)
+	}
+}
+
+func TestUpdateMessage(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "m1", SenderID: "u1", Text: "v1", Timestamp: time.Now(), ReadBy: []string{"u1"}})
+
+	_, err := store.Messages().Update(ctx, chatID, "m1", func(msg *database.Message) error {
+		msg.Edits = append(msg.Edits, database.MessageEdit{Text: msg.Text, EditedAt: time.Now()})
+		msg.Text = "v2"
+		msg.HiddenFor = []string{"u2"}
+		msg.PinnedAt, msg.PinnedBy = time.Now(), "u1"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	failed := errors.New("rejected")
+	if _, err := store.Messages().Update(ctx, chatID, "m1", func(msg *database.Message) error {
+		msg.Text = "dropped"
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("Update error = %v, want the callback's error", err)
+	}
+
+	msg, _ := store.Messages().Get(ctx, chatID, "m1")
+	if msg.Text != "v2" || len(msg.Edits) != 1 || msg.Edits[0].Text != "v1" || !msg.HiddenFrom("u2") || len(msg.ReadBy) != 1 ||
+		!msg.Pinned() || msg.PinnedBy != "u1" {
+		t.Errorf("after Update = %+v", msg)
+	}
+
+	if _, err := store.Messages().Update(ctx, chatID, "missing", func(*database.Message) error { return nil }); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Update(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAddAllMessages(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	origin := &database.MessageOrigin{ChatID: "c0", MessageID: "m0", SenderID: "u2", Timestamp: time.Now().Add(-time.Hour).UTC()}
+	now := time.Now()
+	msgs := []*database.Message{
+		{SenderID: "u1", Text: "one", Timestamp: now, ForwardedFrom: origin},
+		{SenderID: "u1", Text: "two", Timestamp: now.Add(time.Microsecond)},
+	}
+	if err := store.Messages().AddAll(ctx, chatID, msgs); err != nil {
+		t.Fatalf("AddAll: %v", err)
+	}
+
+	list, _ := store.Messages().List(ctx, chatID)
+	if len(list) != 2 || list[0].ID != msgs[0].ID || list[1].ForwardedFrom != nil {
+		t.Fatalf("List = %+v, want both messages in order", list)
+	}
+	if got := list[0].ForwardedFrom; got == nil || got.MessageID != "m0" || !got.Timestamp.Equal(origin.Timestamp) {
+		t.Errorf("ForwardedFrom = %+v, want %+v", got, origin)
+	}
+
+	_, err := store.Messages().Add(ctx, chatID, &database.Message{ID: msgs[0].ID, SenderID: "u1", Text: "again", Timestamp: now})
+	if !errors.Is(err, database.ErrAlreadyExists) {
+		t.Errorf("Add with a taken ID = %v, want ErrAlreadyExists", err)
+	}
+	if msg, _ := store.Messages().Get(ctx, chatID, msgs[0].ID); msg.Text != "one" {
+		t.Errorf("text = %q, want the first message kept", msg.Text)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	original := &database.Attachment{ChatID: chatID, UploaderID: "u1", Name: "a.png", MIMEType: "image/png",
+		Size: 3, Key: "attachments/a", CreatedAt: time.Now(), Thumbnail: &database.Thumbnail{Width: 2}}
+	if err := store.Attachments().Create(ctx, original); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	copied := *original
+	copied.ID = ""
+	store.Attachments().Create(ctx, &copied)
+
+	list, err := store.Attachments().ListByChat(ctx, chatID)
+	if err != nil || len(list) != 2 || list[0].Thumbnail == nil {
+		t.Fatalf("ListByChat = %+v, %v; want both attachments", list, err)
+	}
+
+	inUse := func() bool {
+		used, err := store.Attachments().KeyInUse(ctx, "attachments/a")
+		if err != nil {
+			t.Fatalf("KeyInUse: %v", err)
+		}
+		return used
+	}
+	store.Attachments().Delete(ctx, original.ID)
+	if !inUse() {
+		t.Error("key unused while the copy still refers to it")
+	}
+	store.Attachments().Delete(ctx, copied.ID)
+	if inUse() {
+		t.Error("key in use after both attachments were deleted")
+	}
+	if err := store.Attachments().Delete(ctx, copied.ID); err != nil {
+		t.Errorf("Delete twice = %v, want nil", err)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	c1, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	c2, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u2"}})
+	now := time.Now()
+	entries := []database.SearchEntry{
+		{ChatID: c1, MessageID: "m1", Terms: []string{"meet", "tomorrow"}, Timestamp: now},
+		{ChatID: c1, MessageID: "m2", Terms: []string{"meet"}, Timestamp: now.Add(time.Second)},
+		{ChatID: c2, MessageID: "m3", Terms: []string{"meet", "tomorrow"}, Timestamp: now},
+	}
+	for i := range entries {
+		if err := store.Search().Index(ctx, &entries[i]); err != nil {
+			t.Fatalf("Index: %v", err)
+		}
+	}
+
+	got, err := store.Search().Query(ctx, []string{c1}, []string{"meet"}, 10)
+	if err != nil || len(got) != 2 || got[0].MessageID != "m2" {
+		t.Fatalf("Query(meet) = %+v, %v; want m2 then m1", got, err)
+	}
+	got, _ = store.Search().Query(ctx, []string{c1, c2}, []string{"meet", "tomorrow"}, 1)
+	if len(got) != 1 {
+		t.Errorf("Query with limit 1 = %+v, want one entry", got)
+	}
+
+	entries[0].Terms = []string{"cancel"}
+	store.Search().Index(ctx, &entries[0])
+	store.Search().Remove(ctx, c2, "m3")
+	if got, _ := store.Search().Query(ctx, []string{c1, c2}, []string{"tomorrow"}, 10); len(got) != 0 {
+		t.Errorf("Query after reindex and remove = %+v, want none", got)
+	}
+
+	store.Search().Clear(ctx)
+	if got, _ := store.Search().Query(ctx, []string{c1}, []string{"meet"}, 10); len(got) != 0 {
+		t.Errorf("Query after Clear = %+v, want none", got)
+	}
+
+	chats, err := store.Chats().List(ctx)
+	if err != nil || len(chats) != 2 || len(chats[0].Participants) != 1 {
+		t.Errorf("Chats().List = %+v, %v; want both chats with participants", chats, err)
+	}
+}
+
+func TestUnreadMentions(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	store.Chats().AddUnreadMentions(ctx, chatID, []string{"u1", "u2"}, 1)
+	store.Chats().AddUnreadMentions(ctx, chatID, []string{"u1"}, 1)
+	store.Chats().AddUnreadMentions(ctx, chatID, []string{"u2"}, -3)
+
+	chat, err := store.Chats().Get(ctx, chatID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if chat.UnreadMentions["u1"] != 2 || chat.UnreadMentions["u2"] != 0 || len(chat.Participants) != 2 {
+		t.Errorf("chat = %+v, want u1 with 2 unread mentions and u2 with none", chat)
+	}
+
+	msg := &database.Message{SenderID: "u2", Text: "@u1", Timestamp: time.Now(), Mentions: []string{"u1"}}
+	store.Messages().Add(ctx, chatID, msg)
+	if got, _ := store.Messages().Get(ctx, chatID, msg.ID); len(got.Mentions) != 1 || got.Mentions[0] != "u1" {
+		t.Errorf("Mentions = %v, want [u1]", got.Mentions)
+	}
+}
+
+func TestChatMembership(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	store.Chats().AddUnreadMentions(ctx, chatID, []string{"u2"}, 1)
+
+	if err := store.Chats().AddParticipants(ctx, chatID, []string{"u2", "u3", "u4"}); err != nil {
+		t.Fatalf("AddParticipants: %v", err)
+	}
+	store.Chats().SetRoles(ctx, chatID, map[string]string{"u2": database.ChatRoleAdmin, "u3": database.ChatRoleReadOnly})
+	if err := store.Chats().RemoveParticipant(ctx, chatID, "u2"); err != nil {
+		t.Fatalf("RemoveParticipant: %v", err)
+	}
+	if err := store.Chats().Rename(ctx, chatID, "renamed"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	chat, _ := store.Chats().Get(ctx, chatID)
+	if !slices.Equal(chat.Participants, []string{"u1", "u3", "u4"}) || chat.UnreadMentions["u2"] != 0 {
+		t.Errorf("chat = %+v, want participants [u1 u3 u4] and no counter for u2", chat)
+	}
+	if chat.Name != "renamed" || len(chat.Roles) != 1 || chat.Roles["u3"] != database.ChatRoleReadOnly {
+		t.Errorf("name = %q, roles = %v; want renamed and only u3 read-only", chat.Name, chat.Roles)
+	}
+	if err := store.Chats().AddParticipants(ctx, "missing", []string{"u1"}); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("AddParticipants(missing) = %v, want ErrNotFound", err)
+	}
+
+	msg := &database.Message{SenderID: "u1", Text: "hello world", Timestamp: time.Now()}
+	store.Messages().Add(ctx, chatID, msg)
+	store.Search().Index(ctx, &database.SearchEntry{ChatID: chatID, MessageID: msg.ID, Terms: []string{"hello"}, Timestamp: msg.Timestamp})
+
+	if err := store.Chats().Delete(ctx, chatID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Messages().Get(ctx, chatID, msg.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("message after Delete: err = %v, want ErrNotFound", err)
+	}
+	if hits, _ := store.Search().Query(ctx, []string{chatID}, []string{"hello"}, 10); len(hits) != 0 {
+		t.Errorf("search entries after Delete = %v, want none", hits)
+	}
+	if err := store.Chats().Delete(ctx, chatID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Type: "group", Participants: []string{"u1"}})
+	now := time.Now()
+	store.Invites().Create(ctx, &database.Invite{Token: "old", ChatID: chatID, CreatedBy: "u1", CreatedAt: now.Add(-time.Hour)})
+	err := store.Invites().Create(ctx, &database.Invite{
+		Token: "new", ChatID: chatID, CreatedBy: "u1", CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour), MaxUses: 2, RequiresApproval: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	invite, err := store.Invites().Update(ctx, "new", func(invite *database.Invite) error {
+		invite.Uses++
+		invite.Pending = append(invite.Pending, "u2")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	stored, _ := store.Invites().Get(ctx, "new")
+	if stored.Uses != 1 || !slices.Equal(stored.Pending, []string{"u2"}) || !stored.RequiresApproval || stored.MaxUses != 2 {
+		t.Errorf("Get = %+v, want the update of %+v", stored, invite)
+	}
+	if !stored.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want %v", stored.ExpiresAt, now.Add(time.Hour))
+	}
+
+	invites, _ := store.Invites().ListByChat(ctx, chatID)
+	if len(invites) != 2 || invites[0].Token != "new" || invites[1].Token != "old" {
+		t.Errorf("ListByChat = %+v, want new then old", invites)
+	}
+
+	store.Invites().Delete(ctx, "old")
+	if _, err := store.Invites().Get(ctx, "old"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+
+	store.Chats().Delete(ctx, chatID)
+	if _, err := store.Invites().Update(ctx, "new", func(*database.Invite) error { return nil }); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("invite after chat Delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Type: "channel", Participants: []string{"owner"}})
+	otherID, _ := store.Chats().Create(ctx, &database.Chat{Type: "channel", Participants: []string{"owner"}})
+
+	if err := store.Chats().SetHandle(ctx, chatID, "news_daily"); err != nil {
+		t.Fatalf("SetHandle: %v", err)
+	}
+	if err := store.Chats().SetHandle(ctx, otherID, "news_daily"); !errors.Is(err, database.ErrHandleTaken) {
+		t.Errorf("taken handle: err = %v, want ErrHandleTaken", err)
+	}
+	store.Chats().SetHandle(ctx, otherID, "news_weekly")
+	if chat, err := store.Chats().GetByHandle(ctx, "news_daily"); err != nil || chat.ID != chatID {
+		t.Errorf("GetByHandle = %+v, %v; want %s", chat, err, chatID)
+	}
+	if chats, _ := store.Chats().SearchByHandle(ctx, "news_", 10); len(chats) != 2 {
+		t.Errorf("SearchByHandle found %d channels, want 2", len(chats))
+	}
+	store.Chats().SetHandle(ctx, otherID, "")
+	if _, err := store.Chats().GetByHandle(ctx, "news_weekly"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("cleared handle: err = %v, want ErrNotFound", err)
+	}
+
+	for _, uid := range []string{"u1", "u2", "u3"} {
+		if created, err := store.Subscriptions().Subscribe(ctx, chatID, uid); err != nil || !created {
+			t.Fatalf("Subscribe(%s) = %v, %v", uid, created, err)
+		}
+	}
+	if created, _ := store.Subscriptions().Subscribe(ctx, chatID, "u1"); created {
+		t.Error("second Subscribe reported a new subscription")
+	}
+	if chat, _ := store.Chats().Get(ctx, chatID); chat.SubscriberCount != 3 {
+		t.Errorf("SubscriberCount = %d, want 3", chat.SubscriberCount)
+	}
+
+	page, _ := store.Subscriptions().ListByChat(ctx, chatID, "u1", 10)
+	if len(page) != 2 || page[0].UserID != "u2" || page[1].UserID != "u3" {
+		t.Errorf("ListByChat after u1 = %+v, want u2 and u3", page)
+	}
+	if subs, _ := store.Subscriptions().ListByUser(ctx, "u2"); len(subs) != 1 || subs[0].ChatID != chatID {
+		t.Errorf("ListByUser = %+v, want one subscription", subs)
+	}
+
+	seen := time.Now()
+	if previous, err := store.Subscriptions().AdvanceViewed(ctx, chatID, "u1", seen); err != nil || !previous.IsZero() {
+		t.Errorf("first AdvanceViewed = %v, %v; want zero time", previous, err)
+	}
+	if previous, _ := store.Subscriptions().AdvanceViewed(ctx, chatID, "u1", seen.Add(-time.Minute)); !previous.Equal(seen) {
+		t.Errorf("AdvanceViewed backwards returned %v, want %v", previous, seen)
+	}
+	if _, err := store.Subscriptions().AdvanceViewed(ctx, chatID, "stranger", seen); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("AdvanceViewed without a subscription: err = %v, want ErrNotFound", err)
+	}
+
+	msgID, _ := store.Messages().Add(ctx, chatID, &database.Message{SenderID: "owner", Text: "post", Timestamp: seen})
+	store.Messages().AddViews(ctx, chatID, []string{msgID})
+	store.Messages().AddViews(ctx, chatID, []string{msgID})
+	if msg, _ := store.Messages().Get(ctx, chatID, msgID); msg.Views != 2 {
+		t.Errorf("Views = %d, want 2", msg.Views)
+	}
+
+	if removed, _ := store.Subscriptions().Unsubscribe(ctx, chatID, "u3"); !removed {
+		t.Error("Unsubscribe reported no subscription")
+	}
+	if removed, _ := store.Subscriptions().Unsubscribe(ctx, chatID, "u3"); removed {
+		t.Error("second Unsubscribe reported a removal")
+	}
+	if chat, _ := store.Chats().Get(ctx, chatID); chat.SubscriberCount != 2 {
+		t.Errorf("SubscriberCount after Unsubscribe = %d, want 2", chat.SubscriberCount)
+	}
+
+	store.Chats().Delete(ctx, chatID)
+	if subs, _ := store.Subscriptions().ListByUser(ctx, "u1"); len(subs) != 0 {
+		t.Errorf("subscriptions after chat Delete = %+v, want none", subs)
+	}
+}
+
+func TestChatSettings(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Type: "group", Participants: []string{"u1"}})
+	if _, err := store.ChatSettings().Get(ctx, "u1", chatID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get before Save: err = %v, want ErrNotFound", err)
+	}
+
+	mutedUntil := time.Now().Add(time.Hour)
+	settings := &database.ChatSettings{
+		UserID: "u1", ChatID: chatID, MutedUntil: mutedUntil, Pinned: true, PinOrder: 2,
+		Folders: []string{"work"}, UpdatedAt: time.Now(),
+	}
+	if err := store.ChatSettings().Save(ctx, settings); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	settings.Archived = true
+	settings.Folders = nil
+	if err := store.ChatSettings().Save(ctx, settings); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	stored, err := store.ChatSettings().Get(ctx, "u1", chatID)
+	if err != nil || !stored.Archived || !stored.Pinned || stored.PinOrder != 2 || len(stored.Folders) != 0 {
+		t.Fatalf("Get = %+v, %v; want the second Save", stored, err)
+	}
+	if !stored.MutedUntil.Equal(mutedUntil) {
+		t.Errorf("MutedUntil = %v, want %v", stored.MutedUntil, mutedUntil)
+	}
+	if all, _ := store.ChatSettings().ListByUser(ctx, "u1"); len(all) != 1 {
+		t.Errorf("ListByUser = %+v, want one entry", all)
+	}
+
+	store.Chats().Delete(ctx, chatID)
+	if all, _ := store.ChatSettings().ListByUser(ctx, "u1"); len(all) != 0 {
+		t.Errorf("settings after chat Delete = %+v, want none", all)
+	}
+}
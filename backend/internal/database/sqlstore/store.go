@@ -0,0 +1,1794 @@
+// Package sqlstore implements database.Store on top of PostgreSQL or SQLite.
+// The schema is managed by the versioned migrations in migrations/.
+// Realtime notifications are published from the write path through an
+// in-process database.MessageHub, so every server instance only sees
+// messages written through itself.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+type dialect string
+
+const (
+	Postgres dialect = "postgres"
+	SQLite   dialect = "sqlite"
+)
+
+// rebind rewrites ? placeholders into the dialect's native form.
+func (d dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type Store struct {
+	db      *sql.DB
+	dialect dialect
+	hub     *database.MessageHub
+}
+
+var _ database.Store = (*Store)(nil)
+
+// Open connects to a "postgres" or "sqlite" database. It does not
+// run migrations; use Migrator for that.
+func Open(ctx context.Context, driver, dsn string) (*Store, error) {
+	var db *sql.DB
+	var err error
+
+	switch dialect(driver) {
+	case Postgres:
+		db, err = sql.Open("pgx", dsn)
+	case SQLite:
+		db, err = sql.Open("sqlite", sqliteDSN(dsn))
+		if err == nil {
+			// SQLite allows a single writer; serialize access instead
+			// of failing with "database is locked".
+			db.SetMaxOpenConns(1)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &Store{
+		db:      db,
+		dialect: dialect(driver),
+		hub:     database.NewMessageHub(),
+	}, nil
+}
+
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_pragma=foreign_keys") {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Users() database.UserRepository {
+	return (*users)(s)
+}
+
+func (s *Store) Credentials() database.CredentialRepository {
+	return (*credentials)(s)
+}
+
+func (s *Store) Sessions() database.SessionRepository {
+	return (*sessions)(s)
+}
+
+func (s *Store) Contacts() database.ContactRepository {
+	return (*contacts)(s)
+}
+
+func (s *Store) Chats() database.ChatRepository {
+	return (*chats)(s)
+}
+
+func (s *Store) Messages() database.MessageRepository {
+	return (*messages)(s)
+}
+
+func (s *Store) Attachments() database.AttachmentRepository {
+	return (*attachments)(s)
+}
+
+func (s *Store) Changes() database.ChangeRepository {
+	return (*changes)(s)
+}
+
+func (s *Store) Search() database.SearchRepository {
+	return (*search)(s)
+}
+
+func (s *Store) Invites() database.InviteRepository {
+	return (*invites)(s)
+}
+
+func (s *Store) Subscriptions() database.SubscriptionRepository {
+	return (*subscriptions)(s)
+}
+
+func (s *Store) ChatSettings() database.ChatSettingsRepository {
+	return (*chatSettings)(s)
+}
+
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// inTx runs fn in a transaction and commits it when fn succeeds.
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) txExec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *Store) txQueryRow(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func notFound(kind, id string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s %s: %w", kind, id, database.ErrNotFound)
+	}
+	return err
+}
+
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnix(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+type users Store
+
+const userColumns = `uid, name, email, created_at, is_banned, ban_reason, banned_until, roles`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*database.User, error) {
+	var user database.User
+	var createdAt, bannedUntil int64
+	var roles string
+	err := row.Scan(&user.UID, &user.Name, &user.Email, &createdAt,
+		&user.IsBanned, &user.BanReason, &bannedUntil, &roles)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = fromUnix(createdAt)
+	user.BannedUntil = fromUnix(bannedUntil)
+	if err := json.Unmarshal([]byte(roles), &user.Roles); err != nil {
+		return nil, fmt.Errorf("invalid roles of user %s: %v", user.UID, err)
+	}
+	return &user, nil
+}
+
+func (r *users) Save(ctx context.Context, user *database.User) error {
+	roles, err := json.Marshal(user.Roles)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*Store)(r).exec(ctx, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (uid) DO UPDATE SET
+    name = excluded.name,
+    email = excluded.email,
+    created_at = excluded.created_at,
+    is_banned = excluded.is_banned,
+    ban_reason = excluded.ban_reason,
+    banned_until = excluded.banned_until,
+    roles = excluded.roles`,
+		user.UID, user.Name, user.Email, toUnix(user.CreatedAt),
+		user.IsBanned, user.BanReason, toUnix(user.BannedUntil), string(roles))
+	return err
+}
+
+func (r *users) Get(ctx context.Context, uid string) (*database.User, error) {
+	user, err := scanUser((*Store)(r).queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE uid = ?`, uid))
+	if err != nil {
+		return nil, notFound("user", uid, err)
+	}
+	return user, nil
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (*database.User, error) {
+	user, err := scanUser((*Store)(r).queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+	if err != nil {
+		return nil, notFound("user", email, err)
+	}
+	return user, nil
+}
+
+func (r *users) SearchByName(ctx context.Context, prefix string) ([]database.User, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+userColumns+` FROM users
+WHERE name >= ? AND name <= ?
+ORDER BY name`, prefix, prefix+"\uf8ff")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *user)
+	}
+	return result, rows.Err()
+}
+
+type credentials Store
+
+func (r *credentials) Save(ctx context.Context, credential *database.Credential) error {
+	_, err := (*Store)(r).exec(ctx, `INSERT INTO credentials (uid, email, password_hash, created_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (uid) DO UPDATE SET
+    email = excluded.email,
+    password_hash = excluded.password_hash`,
+		credential.UID, credential.Email, credential.PasswordHash, toUnix(credential.CreatedAt))
+	return err
+}
+
+func (r *credentials) GetByEmail(ctx context.Context, email string) (*database.Credential, error) {
+	var credential database.Credential
+	var createdAt int64
+
+	err := (*Store)(r).queryRow(ctx, `SELECT uid, email, password_hash, created_at FROM credentials WHERE email = ?`, email).
+		Scan(&credential.UID, &credential.Email, &credential.PasswordHash, &createdAt)
+	if err != nil {
+		return nil, notFound("credential", email, err)
+	}
+
+	credential.CreatedAt = fromUnix(createdAt)
+	return &credential, nil
+}
+
+func (r *credentials) Delete(ctx context.Context, uid string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM credentials WHERE uid = ?`, uid)
+	return err
+}
+
+type sessions Store
+
+const sessionColumns = `id, uid, device, ip, created_at, last_used_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*database.Session, error) {
+	var session database.Session
+	var createdAt, lastUsedAt int64
+
+	err := row.Scan(&session.ID, &session.UID, &session.Device, &session.IP, &createdAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	session.CreatedAt = fromUnix(createdAt)
+	session.LastUsedAt = fromUnix(lastUsedAt)
+	return &session, nil
+}
+
+func (r *sessions) Save(ctx context.Context, session *database.Session) error {
+	_, err := (*Store)(r).exec(ctx, `INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    device = excluded.device,
+    ip = excluded.ip,
+    last_used_at = excluded.last_used_at`,
+		session.ID, session.UID, session.Device, session.IP, toUnix(session.CreatedAt), toUnix(session.LastUsedAt))
+	return err
+}
+
+func (r *sessions) Get(ctx context.Context, id string) (*database.Session, error) {
+	session, err := scanSession((*Store)(r).queryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if err != nil {
+		return nil, notFound("session", id, err)
+	}
+	return session, nil
+}
+
+func (r *sessions) ListByUser(ctx context.Context, uid string) ([]database.Session, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+sessionColumns+` FROM sessions
+WHERE uid = ?
+ORDER BY last_used_at DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *session)
+	}
+	return result, rows.Err()
+}
+
+func (r *sessions) Delete(ctx context.Context, id string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+func (r *sessions) DeleteByUser(ctx context.Context, uid string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM sessions WHERE uid = ?`, uid)
+	return err
+}
+
+type contacts Store
+
+const contactColumns = `id, owner_uid, contact_uid, contact_email, contact_name, notes, created_at`
+
+func scanContact(row interface{ Scan(...interface{}) error }) (*database.Contact, error) {
+	var contact database.Contact
+	var createdAt int64
+	err := row.Scan(&contact.ID, &contact.OwnerUID, &contact.ContactUID,
+		&contact.ContactEmail, &contact.ContactName, &contact.Notes, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	contact.CreatedAt = fromUnix(createdAt)
+	return &contact, nil
+}
+
+func (r *contacts) Save(ctx context.Context, contact *database.Contact) error {
+	_, err := (*Store)(r).exec(ctx, `INSERT INTO contacts (`+contactColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+    owner_uid = excluded.owner_uid,
+    contact_uid = excluded.contact_uid,
+    contact_email = excluded.contact_email,
+    contact_name = excluded.contact_name,
+    notes = excluded.notes,
+    created_at = excluded.created_at`,
+		contact.ID, contact.OwnerUID, contact.ContactUID, contact.ContactEmail,
+		contact.ContactName, contact.Notes, toUnix(contact.CreatedAt))
+	return err
+}
+
+func (r *contacts) Get(ctx context.Context, id string) (*database.Contact, error) {
+	contact, err := scanContact((*Store)(r).queryRow(ctx, `SELECT `+contactColumns+` FROM contacts WHERE id = ?`, id))
+	if err != nil {
+		return nil, notFound("contact", id, err)
+	}
+	return contact, nil
+}
+
+func (r *contacts) ListByOwner(ctx context.Context, ownerUID string) ([]database.Contact, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+contactColumns+` FROM contacts
+WHERE owner_uid = ?
+ORDER BY created_at DESC`, ownerUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Contact{}
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *contact)
+	}
+	return result, rows.Err()
+}
+
+func (r *contacts) Delete(ctx context.Context, id string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM contacts WHERE id = ?`, id)
+	return err
+}
+
+type chats Store
+
+const chatColumns = `id, name, type, created_by, created_at, updated_at, last_message, handle, subscriber_count`
+
+func scanChat(row interface{ Scan(...interface{}) error }) (*database.Chat, error) {
+	var chat database.Chat
+	var createdAt, updatedAt int64
+	var lastMessage sql.NullString
+
+	err := row.Scan(&chat.ID, &chat.Name, &chat.Type, &chat.CreatedBy, &createdAt, &updatedAt, &lastMessage,
+		&chat.Handle, &chat.SubscriberCount)
+	if err != nil {
+		return nil, err
+	}
+
+	chat.CreatedAt = fromUnix(createdAt)
+	chat.UpdatedAt = fromUnix(updatedAt)
+	chat.Participants = []string{}
+
+	if lastMessage.Valid && lastMessage.String != "" {
+		var msg database.Message
+		if err := json.Unmarshal([]byte(lastMessage.String), &msg); err != nil {
+			return nil, fmt.Errorf("invalid last_message of chat %s: %v", chat.ID, err)
+		}
+		chat.LastMessage = &msg
+	}
+
+	return &chat, nil
+}
+
+func (r *chats) Create(ctx context.Context, chat *database.Chat) (string, error) {
+	s := (*Store)(r)
+	id := database.NewID()
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := s.txExec(ctx, tx, `INSERT INTO chats (id, name, type, created_by, created_at, updated_at, handle)
+VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, chat.Name, chat.Type, chat.CreatedBy, toUnix(chat.CreatedAt), toUnix(chat.UpdatedAt), chat.Handle)
+		if err != nil {
+			return err
+		}
+
+		for i, uid := range chat.Participants {
+			_, err := s.txExec(ctx, tx, `INSERT INTO chat_participants (chat_id, user_id, position, role) VALUES (?, ?, ?, ?)`,
+				id, uid, i, chat.Roles[uid])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	chat.ID = id
+	return id, nil
+}
+
+func (r *chats) Get(ctx context.Context, id string) (*database.Chat, error) {
+	s := (*Store)(r)
+
+	chat, err := scanChat(s.queryRow(ctx, `SELECT `+chatColumns+` FROM chats WHERE id = ?`, id))
+	if err != nil {
+		return nil, notFound("chat", id, err)
+	}
+
+	participants, err := s.participants(ctx, `chat_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	participants[id].fill(chat)
+
+	return chat, nil
+}
+
+func (r *chats) ListByParticipant(ctx context.Context, uid string) ([]database.Chat, error) {
+	s := (*Store)(r)
+
+	rows, err := s.query(ctx, `SELECT `+chatColumns+` FROM chats
+WHERE id IN (SELECT chat_id FROM chat_participants WHERE user_id = ?)`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Chat{}
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	participants, err := s.participants(ctx, `chat_id IN (SELECT chat_id FROM chat_participants WHERE user_id = ?)`, uid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		participants[result[i].ID].fill(&result[i])
+	}
+
+	return result, nil
+}
+
+func (r *chats) List(ctx context.Context) ([]database.Chat, error) {
+	s := (*Store)(r)
+
+	rows, err := s.query(ctx, `SELECT `+chatColumns+` FROM chats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Chat{}
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	participants, err := s.participants(ctx, `1 = 1`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		participants[result[i].ID].fill(&result[i])
+	}
+
+	return result, nil
+}
+
+// members are the ordered participants of a chat, their unread
+// mention counters and roles.
+type members struct {
+	uids           []string
+	unreadMentions map[string]int
+	roles          map[string]string
+}
+
+func (m members) fill(chat *database.Chat) {
+	chat.Participants = append(chat.Participants, m.uids...)
+	chat.UnreadMentions = m.unreadMentions
+	chat.Roles = m.roles
+}
+
+// participants returns the members of the chats matched by the
+// given condition on chat_participants.
+func (s *Store) participants(ctx context.Context, condition string, args ...interface{}) (map[string]members, error) {
+	rows, err := s.query(ctx, `SELECT chat_id, user_id, unread_mentions, role FROM chat_participants
+WHERE `+condition+`
+ORDER BY chat_id, position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]members)
+	for rows.Next() {
+		var chatID, userID, role string
+		var unread int
+		if err := rows.Scan(&chatID, &userID, &unread, &role); err != nil {
+			return nil, err
+		}
+
+		m := result[chatID]
+		m.uids = append(m.uids, userID)
+		if unread > 0 {
+			if m.unreadMentions == nil {
+				m.unreadMentions = make(map[string]int)
+			}
+			m.unreadMentions[userID] = unread
+		}
+		if role != "" {
+			if m.roles == nil {
+				m.roles = make(map[string]string)
+			}
+			m.roles[userID] = role
+		}
+		result[chatID] = m
+	}
+	return result, rows.Err()
+}
+
+func (r *chats) SetLastMessage(ctx context.Context, chatID string, msg *database.Message) error {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	res, err := (*Store)(r).exec(ctx, `UPDATE chats SET last_message = ?, updated_at = ? WHERE id = ?`,
+		string(encoded), time.Now().UnixNano(), chatID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+	return nil
+}
+
+func (r *chats) AddUnreadMentions(ctx context.Context, chatID string, uids []string, delta int) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, uid := range uids {
+			_, err := s.txExec(ctx, tx, `UPDATE chat_participants
+SET unread_mentions = CASE WHEN unread_mentions + ? > 0 THEN unread_mentions + ? ELSE 0 END
+WHERE chat_id = ? AND user_id = ?`, delta, delta, chatID, uid)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *chats) AddParticipants(ctx context.Context, chatID string, uids []string) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := s.txExec(ctx, tx, `UPDATE chats SET updated_at = ? WHERE id = ?`, time.Now().UnixNano(), chatID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+		}
+
+		var position int
+		err = s.txQueryRow(ctx, tx, `SELECT COALESCE(MAX(position), -1) FROM chat_participants WHERE chat_id = ?`,
+			chatID).Scan(&position)
+		if err != nil {
+			return err
+		}
+
+		for _, uid := range uids {
+			res, err := s.txExec(ctx, tx, `INSERT INTO chat_participants (chat_id, user_id, position) VALUES (?, ?, ?)
+ON CONFLICT (chat_id, user_id) DO NOTHING`, chatID, uid, position+1)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				position++
+			}
+		}
+		return nil
+	})
+}
+
+func (r *chats) RemoveParticipant(ctx context.Context, chatID, uid string) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := s.txExec(ctx, tx, `UPDATE chats SET updated_at = ? WHERE id = ?`, time.Now().UnixNano(), chatID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+		}
+
+		_, err = s.txExec(ctx, tx, `DELETE FROM chat_participants WHERE chat_id = ? AND user_id = ?`, chatID, uid)
+		return err
+	})
+}
+
+func (r *chats) SetRoles(ctx context.Context, chatID string, roles map[string]string) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for uid, role := range roles {
+			_, err := s.txExec(ctx, tx, `UPDATE chat_participants SET role = ? WHERE chat_id = ? AND user_id = ?`,
+				role, chatID, uid)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *chats) Rename(ctx context.Context, chatID, name string) error {
+	res, err := (*Store)(r).exec(ctx, `UPDATE chats SET name = ?, updated_at = ? WHERE id = ?`,
+		name, time.Now().UnixNano(), chatID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+	return nil
+}
+
+func (r *chats) SetHandle(ctx context.Context, chatID, handle string) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if handle != "" {
+			var owner string
+			err := s.txQueryRow(ctx, tx, `SELECT id FROM chats WHERE handle = ?`, handle).Scan(&owner)
+			if err == nil && owner != chatID {
+				return database.ErrHandleTaken
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		res, err := s.txExec(ctx, tx, `UPDATE chats SET handle = ?, updated_at = ? WHERE id = ?`,
+			handle, time.Now().UnixNano(), chatID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+		}
+		return nil
+	})
+}
+
+func (r *chats) GetByHandle(ctx context.Context, handle string) (*database.Chat, error) {
+	var id string
+	err := (*Store)(r).queryRow(ctx, `SELECT id FROM chats WHERE handle = ? AND handle <> ''`, handle).Scan(&id)
+	if err != nil {
+		return nil, notFound("chat", "@"+handle, err)
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *chats) SearchByHandle(ctx context.Context, prefix string, limit int) ([]database.Chat, error) {
+	s := (*Store)(r)
+
+	rows, err := s.query(ctx, `SELECT id FROM chats
+WHERE handle <> '' AND substr(handle, 1, ?) = ?
+ORDER BY handle
+LIMIT ?`, len(prefix), prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]database.Chat, 0, len(ids))
+	for _, id := range ids {
+		chat, err := r.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *chat)
+	}
+	return result, nil
+}
+
+// Delete relies on ON DELETE CASCADE to drop the chat's participants,
+// messages, read receipts, attachments, search terms, invites and
+// subscriptions.
+func (r *chats) Delete(ctx context.Context, chatID string) error {
+	res, err := (*Store)(r).exec(ctx, `DELETE FROM chats WHERE id = ?`, chatID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("chat %s: %w", chatID, database.ErrNotFound)
+	}
+	return nil
+}
+
+type messages Store
+
+const messageColumns = `id, sender_id, text, sent_at, edits, deleted_at, hidden_for, reactions,
+    reply_to, thread_id, reply_count, last_reply_at, forwarded_from, attachments, listened_by, mentions, temp_id, views,
+    pinned_at, pinned_by`
+
+func scanMessage(chatID string, row interface{ Scan(...interface{}) error }) (*database.Message, error) {
+	msg := database.Message{ChatID: chatID}
+	var sentAt, deletedAt, lastReplyAt, pinnedAt int64
+	var edits, hiddenFor, reactions, forwardedFrom, attachments, listenedBy, mentions string
+	err := row.Scan(&msg.ID, &msg.SenderID, &msg.Text, &sentAt, &edits, &deletedAt, &hiddenFor, &reactions,
+		&msg.ReplyTo, &msg.ThreadID, &msg.ReplyCount, &lastReplyAt, &forwardedFrom, &attachments, &listenedBy, &mentions, &msg.TempID,
+		&msg.Views, &pinnedAt, &msg.PinnedBy)
+	if err != nil {
+		return nil, err
+	}
+	msg.Timestamp = fromUnix(sentAt)
+	msg.DeletedAt = fromUnix(deletedAt)
+	msg.LastReplyAt = fromUnix(lastReplyAt)
+	msg.PinnedAt = fromUnix(pinnedAt)
+
+	if err := json.Unmarshal([]byte(edits), &msg.Edits); err != nil {
+		return nil, fmt.Errorf("invalid edits of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(hiddenFor), &msg.HiddenFor); err != nil {
+		return nil, fmt.Errorf("invalid hidden_for of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(reactions), &msg.Reactions); err != nil {
+		return nil, fmt.Errorf("invalid reactions of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(forwardedFrom), &msg.ForwardedFrom); err != nil {
+		return nil, fmt.Errorf("invalid forwarded_from of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(attachments), &msg.Attachments); err != nil {
+		return nil, fmt.Errorf("invalid attachments of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(listenedBy), &msg.ListenedBy); err != nil {
+		return nil, fmt.Errorf("invalid listened_by of message %s: %v", msg.ID, err)
+	}
+	if err := json.Unmarshal([]byte(mentions), &msg.Mentions); err != nil {
+		return nil, fmt.Errorf("invalid mentions of message %s: %v", msg.ID, err)
+	}
+	return &msg, nil
+}
+
+// messageJSON holds the message fields stored as JSON text.
+type messageJSON struct {
+	edits         string
+	hiddenFor     string
+	reactions     string
+	forwardedFrom string
+	attachments   string
+	listenedBy    string
+	mentions      string
+}
+
+func encodeMessage(msg *database.Message) (messageJSON, error) {
+	var encoded messageJSON
+	for _, field := range []struct {
+		dst *string
+		src interface{}
+	}{
+		{&encoded.edits, msg.Edits},
+		{&encoded.hiddenFor, msg.HiddenFor},
+		{&encoded.reactions, msg.Reactions},
+		{&encoded.forwardedFrom, msg.ForwardedFrom},
+		{&encoded.attachments, msg.Attachments},
+		{&encoded.listenedBy, msg.ListenedBy},
+		{&encoded.mentions, msg.Mentions},
+	} {
+		raw, err := json.Marshal(field.src)
+		if err != nil {
+			return messageJSON{}, err
+		}
+		*field.dst = string(raw)
+	}
+	return encoded, nil
+}
+
+func (r *messages) Add(ctx context.Context, chatID string, msg *database.Message) (string, error) {
+	if err := r.AddAll(ctx, chatID, []*database.Message{msg}); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+func (r *messages) AddAll(ctx context.Context, chatID string, msgs []*database.Message) error {
+	s := (*Store)(r)
+
+	encoded := make([]messageJSON, len(msgs))
+	for i, msg := range msgs {
+		if msg.ID == "" {
+			msg.ID = database.NewID()
+		}
+		msg.ChatID = chatID
+
+		var err error
+		if encoded[i], err = encodeMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for i, msg := range msgs {
+			if err := s.insertMessage(ctx, tx, msg, encoded[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		published := *msg
+		published.ReadBy = append([]string(nil), msg.ReadBy...)
+		s.hub.Publish(chatID, database.MessageChange{Kind: database.MessageAdded, Message: published})
+	}
+	return nil
+}
+
+func (s *Store) insertMessage(ctx context.Context, tx *sql.Tx, msg *database.Message, encoded messageJSON) error {
+	res, err := s.txExec(ctx, tx, `INSERT INTO messages (chat_id, `+messageColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (chat_id, id) DO NOTHING`,
+		msg.ChatID, msg.ID, msg.SenderID, msg.Text, toUnix(msg.Timestamp), encoded.edits, toUnix(msg.DeletedAt),
+		encoded.hiddenFor, encoded.reactions, msg.ReplyTo, msg.ThreadID, msg.ReplyCount, toUnix(msg.LastReplyAt),
+		encoded.forwardedFrom, encoded.attachments, encoded.listenedBy, encoded.mentions, msg.TempID, msg.Views,
+		toUnix(msg.PinnedAt), msg.PinnedBy)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("message %s: %w", msg.ID, database.ErrAlreadyExists)
+	}
+
+	readAt := time.Now().UnixNano()
+	for i, uid := range msg.ReadBy {
+		_, err := s.txExec(ctx, tx, `INSERT INTO message_reads (chat_id, message_id, user_id, read_at) VALUES (?, ?, ?, ?)
+ON CONFLICT DO NOTHING`, msg.ChatID, msg.ID, uid, readAt+int64(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *messages) List(ctx context.Context, chatID string) ([]database.Message, error) {
+	s := (*Store)(r)
+
+	rows, err := s.query(ctx, `SELECT `+messageColumns+` FROM messages
+WHERE chat_id = ?
+ORDER BY sent_at, id`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(chatID, rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	readers, err := s.readers(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].ReadBy = readers[result[i].ID]
+	}
+
+	return result, nil
+}
+
+// readers returns read receipts of the chat keyed by message ID,
+// restricted to messageIDs when any are given.
+func (s *Store) readers(ctx context.Context, chatID string, messageIDs ...string) (map[string][]string, error) {
+	query := `SELECT message_id, user_id FROM message_reads WHERE chat_id = ?`
+	args := []interface{}{chatID}
+	if len(messageIDs) > 0 {
+		query += ` AND message_id IN (?` + strings.Repeat(`, ?`, len(messageIDs)-1) + `)`
+		for _, id := range messageIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := s.query(ctx, query+` ORDER BY read_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string][]string)
+	for rows.Next() {
+		var id, uid string
+		if err := rows.Scan(&id, &uid); err != nil {
+			return nil, err
+		}
+		result[id] = append(result[id], uid)
+	}
+	return result, rows.Err()
+}
+
+func (r *messages) Get(ctx context.Context, chatID, id string) (*database.Message, error) {
+	s := (*Store)(r)
+
+	msg, err := scanMessage(chatID, s.queryRow(ctx, `SELECT `+messageColumns+` FROM messages
+WHERE chat_id = ? AND id = ?`, chatID, id))
+	if err != nil {
+		return nil, notFound("message", id, err)
+	}
+
+	readers, err := s.readers(ctx, chatID, id)
+	if err != nil {
+		return nil, err
+	}
+	msg.ReadBy = readers[id]
+
+	return msg, nil
+}
+
+func (r *messages) ListPage(ctx context.Context, chatID string, page database.MessagePage) ([]database.Message, error) {
+	s := (*Store)(r)
+
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE chat_id = ? AND thread_id = ?`
+	args := []interface{}{chatID, page.ThreadID}
+	if page.After != nil {
+		at := toUnix(page.After.Timestamp)
+		query += ` AND (sent_at > ? OR (sent_at = ? AND id > ?))`
+		args = append(args, at, at, page.After.ID)
+	}
+	if page.Before != nil {
+		at := toUnix(page.Before.Timestamp)
+		query += ` AND (sent_at < ? OR (sent_at = ? AND id < ?))`
+		args = append(args, at, at, page.Before.ID)
+	}
+
+	descending := page.After == nil
+	if descending {
+		query += ` ORDER BY sent_at DESC, id DESC LIMIT ?`
+	} else {
+		query += ` ORDER BY sent_at, id LIMIT ?`
+	}
+	args = append(args, page.Limit)
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Message{}
+	ids := []string{}
+	for rows.Next() {
+		msg, err := scanMessage(chatID, rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *msg)
+		ids = append(ids, msg.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if descending {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	if len(ids) > 0 {
+		readers, err := s.readers(ctx, chatID, ids...)
+		if err != nil {
+			return nil, err
+		}
+		for i := range result {
+			result[i].ReadBy = readers[result[i].ID]
+		}
+	}
+
+	return result, nil
+}
+
+func (r *messages) latest(ctx context.Context, chatID string) (*database.Message, error) {
+	s := (*Store)(r)
+
+	msg, err := scanMessage(chatID, s.queryRow(ctx, `SELECT `+messageColumns+` FROM messages
+WHERE chat_id = ?
+ORDER BY sent_at DESC, id DESC
+LIMIT 1`, chatID))
+	if err != nil {
+		return nil, err
+	}
+
+	readers, err := s.readers(ctx, chatID, msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	msg.ReadBy = readers[msg.ID]
+
+	return msg, nil
+}
+
+func (r *messages) Update(ctx context.Context, chatID, id string, fn func(msg *database.Message) error) (*database.Message, error) {
+	s := (*Store)(r)
+
+	var msg *database.Message
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		msg, err = scanMessage(chatID, s.txQueryRow(ctx, tx, `SELECT `+messageColumns+` FROM messages
+WHERE chat_id = ? AND id = ?`, chatID, id))
+		if err != nil {
+			return notFound("message", id, err)
+		}
+
+		if err := fn(msg); err != nil {
+			return err
+		}
+
+		encoded, err := encodeMessage(msg)
+		if err != nil {
+			return err
+		}
+		_, err = s.txExec(ctx, tx, `UPDATE messages
+SET text = ?, edits = ?, deleted_at = ?, hidden_for = ?, reactions = ?, reply_count = ?, last_reply_at = ?,
+    attachments = ?, listened_by = ?, mentions = ?, pinned_at = ?, pinned_by = ?
+WHERE chat_id = ? AND id = ?`, msg.Text, encoded.edits, toUnix(msg.DeletedAt), encoded.hiddenFor, encoded.reactions,
+			msg.ReplyCount, toUnix(msg.LastReplyAt), encoded.attachments, encoded.listenedBy, encoded.mentions,
+			toUnix(msg.PinnedAt), msg.PinnedBy, chatID, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	readers, err := s.readers(ctx, chatID, id)
+	if err != nil {
+		return nil, err
+	}
+	msg.ReadBy = readers[id]
+
+	if latest, err := r.latest(ctx, chatID); err == nil && latest.ID == id {
+		s.hub.Publish(chatID, database.MessageChange{Kind: database.MessageModified, Message: *latest})
+	}
+	return msg, nil
+}
+
+func (r *messages) MarkRead(ctx context.Context, chatID, messageID, uid string) error {
+	s := (*Store)(r)
+
+	res, err := s.exec(ctx, `INSERT INTO message_reads (chat_id, message_id, user_id, read_at)
+SELECT chat_id, id, ?, ? FROM messages WHERE chat_id = ? AND id = ?
+ON CONFLICT DO NOTHING`, uid, time.Now().UnixNano(), chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		err := s.queryRow(ctx, `SELECT 1 FROM messages WHERE chat_id = ? AND id = ?`, chatID, messageID).Scan(&exists)
+		return notFound("message", messageID, err)
+	}
+
+	if latest, err := r.latest(ctx, chatID); err == nil && latest.ID == messageID {
+		s.hub.Publish(chatID, database.MessageChange{Kind: database.MessageModified, Message: *latest})
+	}
+	return nil
+}
+
+func (r *messages) AddViews(ctx context.Context, chatID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, chatID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	_, err := (*Store)(r).exec(ctx, `UPDATE messages SET views = views + 1
+WHERE chat_id = ? AND id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`, args...)
+	return err
+}
+
+// Watch delivers the chat's latest message first, then every change
+// published by this store's write path.
+func (r *messages) Watch(ctx context.Context, chatID string) (<-chan database.MessageChange, error) {
+	var initial []database.MessageChange
+
+	latest, err := r.latest(ctx, chatID)
+	switch {
+	case err == nil:
+		initial = append(initial, database.MessageChange{Kind: database.MessageAdded, Message: *latest})
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	return r.hub.Subscribe(ctx, chatID, initial...), nil
+}
+
+type attachments Store
+
+const attachmentColumns = `id, chat_id, uploader_id, name, mime_type, size, checksum, blob_key, created_at,
+    width, height, blurhash, thumbnail, thumbnail_key, kind, duration_ms, waveform`
+
+func (r *attachments) Create(ctx context.Context, attachment *database.Attachment) error {
+	if attachment.ID == "" {
+		attachment.ID = database.NewID()
+	}
+
+	thumbnail, waveform, err := encodeAttachment(attachment)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*Store)(r).exec(ctx, `INSERT INTO attachments (`+attachmentColumns+`)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		attachment.ID, attachment.ChatID, attachment.UploaderID, attachment.Name, attachment.MIMEType,
+		attachment.Size, attachment.Checksum, attachment.Key, toUnix(attachment.CreatedAt),
+		attachment.Width, attachment.Height, attachment.Blurhash, thumbnail, attachment.ThumbnailKey,
+		attachment.Kind, attachment.DurationMS, waveform)
+	return err
+}
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*database.Attachment, error) {
+	var attachment database.Attachment
+	var createdAt int64
+	var thumbnail, waveform string
+
+	err := row.Scan(
+		&attachment.ID, &attachment.ChatID, &attachment.UploaderID, &attachment.Name, &attachment.MIMEType,
+		&attachment.Size, &attachment.Checksum, &attachment.Key, &createdAt,
+		&attachment.Width, &attachment.Height, &attachment.Blurhash, &thumbnail, &attachment.ThumbnailKey,
+		&attachment.Kind, &attachment.DurationMS, &waveform)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.CreatedAt = fromUnix(createdAt)
+	if err := json.Unmarshal([]byte(thumbnail), &attachment.Thumbnail); err != nil {
+		return nil, fmt.Errorf("invalid thumbnail of attachment %s: %v", attachment.ID, err)
+	}
+	if err := json.Unmarshal([]byte(waveform), &attachment.Waveform); err != nil {
+		return nil, fmt.Errorf("invalid waveform of attachment %s: %v", attachment.ID, err)
+	}
+	return &attachment, nil
+}
+
+func (r *attachments) Get(ctx context.Context, id string) (*database.Attachment, error) {
+	attachment, err := scanAttachment((*Store)(r).queryRow(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+	if err != nil {
+		return nil, notFound("attachment", id, err)
+	}
+	return attachment, nil
+}
+
+func (r *attachments) ListByChat(ctx context.Context, chatID string) ([]database.Attachment, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []database.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *attachment)
+	}
+	return result, rows.Err()
+}
+
+func (r *attachments) KeyInUse(ctx context.Context, key string) (bool, error) {
+	var one int
+	err := (*Store)(r).queryRow(ctx, `SELECT 1 FROM attachments WHERE blob_key = ? LIMIT 1`, key).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *attachments) Delete(ctx context.Context, id string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM attachments WHERE id = ?`, id)
+	return err
+}
+
+func (r *attachments) Update(ctx context.Context, attachment *database.Attachment) error {
+	thumbnail, waveform, err := encodeAttachment(attachment)
+	if err != nil {
+		return err
+	}
+
+	res, err := (*Store)(r).exec(ctx, `UPDATE attachments
+SET name = ?, mime_type = ?, size = ?, checksum = ?, blob_key = ?,
+    width = ?, height = ?, blurhash = ?, thumbnail = ?, thumbnail_key = ?,
+    kind = ?, duration_ms = ?, waveform = ?
+WHERE id = ?`,
+		attachment.Name, attachment.MIMEType, attachment.Size, attachment.Checksum, attachment.Key,
+		attachment.Width, attachment.Height, attachment.Blurhash, thumbnail, attachment.ThumbnailKey,
+		attachment.Kind, attachment.DurationMS, waveform, attachment.ID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("attachment %s: %w", attachment.ID, database.ErrNotFound)
+	}
+	return nil
+}
+
+// encodeAttachment returns the JSON columns of an attachment.
+func encodeAttachment(attachment *database.Attachment) (thumbnail, waveform string, err error) {
+	encodedThumbnail, err := json.Marshal(attachment.Thumbnail)
+	if err != nil {
+		return "", "", err
+	}
+	encodedWaveform, err := json.Marshal(attachment.Waveform)
+	if err != nil {
+		return "", "", err
+	}
+	return string(encodedThumbnail), string(encodedWaveform), nil
+}
+
+type changes Store
+
+const changeColumns = `seq, type, chat_id, message_id, contact_id, user_id, created_at`
+
+func (r *changes) Append(ctx context.Context, change *database.Change, uids []string) error {
+	s := (*Store)(r)
+
+	if change.Seq == 0 {
+		change.Seq = database.NewChangeSeq()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, uid := range uids {
+			_, err := s.txExec(ctx, tx, `INSERT INTO changes (uid, `+changeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT DO NOTHING`,
+				uid, change.Seq, change.Type, change.ChatID, change.MessageID, change.ContactID, change.UserID, toUnix(change.CreatedAt))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *changes) ListSince(ctx context.Context, uid string, after int64, limit int) ([]database.Change, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+changeColumns+` FROM changes
+WHERE uid = ? AND seq > ?
+ORDER BY seq
+LIMIT ?`, uid, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Change{}
+	for rows.Next() {
+		var change database.Change
+		var createdAt int64
+		err := rows.Scan(&change.Seq, &change.Type, &change.ChatID, &change.MessageID,
+			&change.ContactID, &change.UserID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		change.CreatedAt = fromUnix(createdAt)
+		result = append(result, change)
+	}
+	return result, rows.Err()
+}
+
+// search keeps one row per (message, term) pair; a message matches
+// when it has a row for every queried term.
+type search Store
+
+func (r *search) Index(ctx context.Context, entry *database.SearchEntry) error {
+	s := (*Store)(r)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := s.txExec(ctx, tx, `DELETE FROM search_terms WHERE chat_id = ? AND message_id = ?`,
+			entry.ChatID, entry.MessageID)
+		if err != nil {
+			return err
+		}
+
+		for _, term := range entry.Terms {
+			_, err := s.txExec(ctx, tx, `INSERT INTO search_terms (chat_id, message_id, term, timestamp) VALUES (?, ?, ?, ?)
+ON CONFLICT DO NOTHING`,
+				entry.ChatID, entry.MessageID, term, toUnix(entry.Timestamp))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *search) Remove(ctx context.Context, chatID, messageID string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM search_terms WHERE chat_id = ? AND message_id = ?`, chatID, messageID)
+	return err
+}
+
+func (r *search) Query(ctx context.Context, chatIDs, terms []string, limit int) ([]database.SearchEntry, error) {
+	if len(chatIDs) == 0 || len(terms) == 0 {
+		return []database.SearchEntry{}, nil
+	}
+
+	args := make([]interface{}, 0, len(terms)+len(chatIDs)+2)
+	for _, term := range terms {
+		args = append(args, term)
+	}
+	for _, chatID := range chatIDs {
+		args = append(args, chatID)
+	}
+	args = append(args, len(terms), limit)
+
+	rows, err := (*Store)(r).query(ctx, `SELECT chat_id, message_id, MAX(timestamp) FROM search_terms
+WHERE term IN (?`+strings.Repeat(`, ?`, len(terms)-1)+`)
+AND chat_id IN (?`+strings.Repeat(`, ?`, len(chatIDs)-1)+`)
+GROUP BY chat_id, message_id
+HAVING COUNT(*) = ?
+ORDER BY MAX(timestamp) DESC, message_id
+LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.SearchEntry{}
+	for rows.Next() {
+		var entry database.SearchEntry
+		var timestamp int64
+		if err := rows.Scan(&entry.ChatID, &entry.MessageID, &timestamp); err != nil {
+			return nil, err
+		}
+		entry.Timestamp = fromUnix(timestamp)
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+func (r *search) Clear(ctx context.Context) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM search_terms`)
+	return err
+}
+
+type invites Store
+
+const inviteColumns = `token, chat_id, created_by, created_at, expires_at, max_uses, uses, requires_approval, pending`
+
+func scanInvite(row interface{ Scan(...interface{}) error }) (*database.Invite, error) {
+	var invite database.Invite
+	var createdAt, expiresAt int64
+	var pending string
+	err := row.Scan(&invite.Token, &invite.ChatID, &invite.CreatedBy, &createdAt, &expiresAt,
+		&invite.MaxUses, &invite.Uses, &invite.RequiresApproval, &pending)
+	if err != nil {
+		return nil, err
+	}
+
+	invite.CreatedAt = fromUnix(createdAt)
+	invite.ExpiresAt = fromUnix(expiresAt)
+	if err := json.Unmarshal([]byte(pending), &invite.Pending); err != nil {
+		return nil, fmt.Errorf("invalid pending users of invite %s: %v", invite.Token, err)
+	}
+	return &invite, nil
+}
+
+func (r *invites) Create(ctx context.Context, invite *database.Invite) error {
+	pending, err := json.Marshal(invite.Pending)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*Store)(r).exec(ctx, `INSERT INTO invites (`+inviteColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invite.Token, invite.ChatID, invite.CreatedBy, toUnix(invite.CreatedAt), toUnix(invite.ExpiresAt),
+		invite.MaxUses, invite.Uses, invite.RequiresApproval, string(pending))
+	return err
+}
+
+func (r *invites) Get(ctx context.Context, token string) (*database.Invite, error) {
+	invite, err := scanInvite((*Store)(r).queryRow(ctx, `SELECT `+inviteColumns+` FROM invites WHERE token = ?`, token))
+	if err != nil {
+		return nil, notFound("invite", token, err)
+	}
+	return invite, nil
+}
+
+func (r *invites) ListByChat(ctx context.Context, chatID string) ([]database.Invite, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+inviteColumns+` FROM invites
+WHERE chat_id = ?
+ORDER BY created_at DESC, token`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *invite)
+	}
+	return result, rows.Err()
+}
+
+func (r *invites) Update(ctx context.Context, token string, fn func(invite *database.Invite) error) (*database.Invite, error) {
+	s := (*Store)(r)
+
+	var invite *database.Invite
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		invite, err = scanInvite(s.txQueryRow(ctx, tx, `SELECT `+inviteColumns+` FROM invites WHERE token = ?`, token))
+		if err != nil {
+			return notFound("invite", token, err)
+		}
+
+		if err := fn(invite); err != nil {
+			return err
+		}
+
+		pending, err := json.Marshal(invite.Pending)
+		if err != nil {
+			return err
+		}
+		_, err = s.txExec(ctx, tx, `UPDATE invites SET uses = ?, pending = ? WHERE token = ?`,
+			invite.Uses, string(pending), token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (r *invites) Delete(ctx context.Context, token string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM invites WHERE token = ?`, token)
+	return err
+}
+
+type subscriptions Store
+
+const subscriptionColumns = `chat_id, user_id, subscribed_at, viewed_at`
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*database.Subscription, error) {
+	var subscription database.Subscription
+	var subscribedAt, viewedAt int64
+	if err := row.Scan(&subscription.ChatID, &subscription.UserID, &subscribedAt, &viewedAt); err != nil {
+		return nil, err
+	}
+	subscription.SubscribedAt = fromUnix(subscribedAt)
+	subscription.ViewedAt = fromUnix(viewedAt)
+	return &subscription, nil
+}
+
+func (r *subscriptions) Subscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	s := (*Store)(r)
+
+	created := false
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var exists int
+		err := s.txQueryRow(ctx, tx, `SELECT 1 FROM chats WHERE id = ?`, chatID).Scan(&exists)
+		if err != nil {
+			return notFound("chat", chatID, err)
+		}
+
+		res, err := s.txExec(ctx, tx, `INSERT INTO subscriptions (chat_id, user_id, subscribed_at) VALUES (?, ?, ?)
+ON CONFLICT (chat_id, user_id) DO NOTHING`, chatID, uid, time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		created = true
+		_, err = s.txExec(ctx, tx, `UPDATE chats SET subscriber_count = subscriber_count + 1 WHERE id = ?`, chatID)
+		return err
+	})
+	return created, err
+}
+
+func (r *subscriptions) Unsubscribe(ctx context.Context, chatID, uid string) (bool, error) {
+	s := (*Store)(r)
+
+	removed := false
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := s.txExec(ctx, tx, `DELETE FROM subscriptions WHERE chat_id = ? AND user_id = ?`, chatID, uid)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		removed = true
+		_, err = s.txExec(ctx, tx, `UPDATE chats SET subscriber_count = subscriber_count - 1 WHERE id = ?`, chatID)
+		return err
+	})
+	return removed, err
+}
+
+func (r *subscriptions) Get(ctx context.Context, chatID, uid string) (*database.Subscription, error) {
+	subscription, err := scanSubscription((*Store)(r).queryRow(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions
+WHERE chat_id = ? AND user_id = ?`, chatID, uid))
+	if err != nil {
+		return nil, notFound("subscription", chatID+"/"+uid, err)
+	}
+	return subscription, nil
+}
+
+func (r *subscriptions) ListByUser(ctx context.Context, uid string) ([]database.Subscription, error) {
+	return r.list(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions
+WHERE user_id = ?
+ORDER BY chat_id`, uid)
+}
+
+func (r *subscriptions) ListByChat(ctx context.Context, chatID, afterUID string, limit int) ([]database.Subscription, error) {
+	return r.list(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions
+WHERE chat_id = ? AND user_id > ?
+ORDER BY user_id
+LIMIT ?`, chatID, afterUID, limit)
+}
+
+func (r *subscriptions) list(ctx context.Context, query string, args ...interface{}) ([]database.Subscription, error) {
+	rows, err := (*Store)(r).query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *subscription)
+	}
+	return result, rows.Err()
+}
+
+func (r *subscriptions) AdvanceViewed(ctx context.Context, chatID, uid string, at time.Time) (time.Time, error) {
+	s := (*Store)(r)
+
+	var previous time.Time
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var viewedAt int64
+		err := s.txQueryRow(ctx, tx, `SELECT viewed_at FROM subscriptions WHERE chat_id = ? AND user_id = ?`,
+			chatID, uid).Scan(&viewedAt)
+		if err != nil {
+			return notFound("subscription", chatID+"/"+uid, err)
+		}
+		previous = fromUnix(viewedAt)
+		if !at.After(previous) {
+			return nil
+		}
+
+		_, err = s.txExec(ctx, tx, `UPDATE subscriptions SET viewed_at = ? WHERE chat_id = ? AND user_id = ?`,
+			toUnix(at), chatID, uid)
+		return err
+	})
+	return previous, err
+}
+
+type chatSettings Store
+
+const chatSettingsColumns = `user_id, chat_id, muted_until, archived, pinned, pin_order, folders, updated_at`
+
+func scanChatSettings(row interface{ Scan(...interface{}) error }) (*database.ChatSettings, error) {
+	var settings database.ChatSettings
+	var mutedUntil, updatedAt int64
+	var folders string
+	err := row.Scan(&settings.UserID, &settings.ChatID, &mutedUntil, &settings.Archived,
+		&settings.Pinned, &settings.PinOrder, &folders, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.MutedUntil = fromUnix(mutedUntil)
+	settings.UpdatedAt = fromUnix(updatedAt)
+	if err := json.Unmarshal([]byte(folders), &settings.Folders); err != nil {
+		return nil, fmt.Errorf("invalid folders of chat %s: %v", settings.ChatID, err)
+	}
+	return &settings, nil
+}
+
+func (r *chatSettings) Get(ctx context.Context, uid, chatID string) (*database.ChatSettings, error) {
+	settings, err := scanChatSettings((*Store)(r).queryRow(ctx, `SELECT `+chatSettingsColumns+` FROM chat_settings
+WHERE user_id = ? AND chat_id = ?`, uid, chatID))
+	if err != nil {
+		return nil, notFound("chat settings", uid+"/"+chatID, err)
+	}
+	return settings, nil
+}
+
+func (r *chatSettings) ListByUser(ctx context.Context, uid string) ([]database.ChatSettings, error) {
+	rows, err := (*Store)(r).query(ctx, `SELECT `+chatSettingsColumns+` FROM chat_settings
+WHERE user_id = ?
+ORDER BY chat_id`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []database.ChatSettings{}
+	for rows.Next() {
+		settings, err := scanChatSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *settings)
+	}
+	return result, rows.Err()
+}
+
+func (r *chatSettings) Save(ctx context.Context, settings *database.ChatSettings) error {
+	folders, err := json.Marshal(settings.Folders)
+	if err != nil {
+		return err
+	}
+
+	_, err = (*Store)(r).exec(ctx, `INSERT INTO chat_settings (`+chatSettingsColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (user_id, chat_id) DO UPDATE SET
+    muted_until = excluded.muted_until,
+    archived = excluded.archived,
+    pinned = excluded.pinned,
+    pin_order = excluded.pin_order,
+    folders = excluded.folders,
+    updated_at = excluded.updated_at`,
+		settings.UserID, settings.ChatID, toUnix(settings.MutedUntil), settings.Archived,
+		settings.Pinned, settings.PinOrder, string(folders), toUnix(settings.UpdatedAt))
+	return err
+}
+
+func (r *chatSettings) Delete(ctx context.Context, uid, chatID string) error {
+	_, err := (*Store)(r).exec(ctx, `DELETE FROM chat_settings WHERE user_id = ? AND chat_id = ?`, uid, chatID)
+	return err
+}
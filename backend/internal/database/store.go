@@ -0,0 +1,717 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound is returned by repositories when the requested
+// document does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrHandleTaken is returned when a channel handle belongs to another
+// chat.
+var ErrHandleTaken = errors.New("handle is already taken")
+
+// ErrAlreadyExists is returned when a document is created with an ID
+// that is already taken.
+var ErrAlreadyExists = errors.New("already exists")
+
+// RoleAdmin lets a user call the /api/admin endpoints.
+const RoleAdmin = "admin"
+
+type User struct {
+	UID       string    `firestore:"uid"`
+	Name      string    `firestore:"name"`
+	Email     string    `firestore:"email"`
+	CreatedAt time.Time `firestore:"created_at"`
+	IsBanned  bool      `firestore:"is_banned"`
+	BanReason string    `firestore:"ban_reason"`
+	// BannedUntil is zero for a ban without expiry.
+	BannedUntil time.Time `firestore:"banned_until"`
+	Roles       []string  `firestore:"roles,omitempty"`
+}
+
+// Banned reports whether the user's ban is in effect at now.
+func (u *User) Banned(now time.Time) bool {
+	return u.IsBanned && (u.BannedUntil.IsZero() || now.Before(u.BannedUntil))
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Contact field names match the documents already stored in the
+// "contacts" collection, which were written without firestore tags.
+type Contact struct {
+	ID           string    `firestore:"ID"`
+	OwnerUID     string    `firestore:"OwnerUID"`
+	ContactUID   string    `firestore:"ContactUID"`
+	ContactEmail string    `firestore:"ContactEmail"`
+	ContactName  string    `firestore:"ContactName"`
+	CreatedAt    time.Time `firestore:"CreatedAt"`
+	Notes        string    `firestore:"Notes"`
+}
+
+type Chat struct {
+	ID           string    `firestore:"-"`
+	Name         string    `firestore:"name"`
+	Type         string    `firestore:"type"`
+	Participants []string  `firestore:"participants"`
+	CreatedBy    string    `firestore:"created_by"`
+	CreatedAt    time.Time `firestore:"created_at"`
+	UpdatedAt    time.Time `firestore:"updated_at"`
+	LastMessage  *Message  `firestore:"last_message,omitempty"`
+	// UnreadMentions counts, per participant, the messages that
+	// mention them and that they have not read yet.
+	UnreadMentions map[string]int `firestore:"unread_mentions,omitempty"`
+	// Roles holds the participants' roles in a group chat. Anyone
+	// missing is a member, except the creator, who is the owner
+	// until someone else is made owner.
+	Roles map[string]string `firestore:"roles,omitempty"`
+	// Handle makes a channel public: it can be found and joined by
+	// it. It is unique among chats.
+	Handle string `firestore:"handle,omitempty"`
+	// SubscriberCount counts a channel's subscribers, who are kept
+	// out of Participants.
+	SubscriberCount int `firestore:"subscriber_count,omitempty"`
+}
+
+type Message struct {
+	ID        string    `firestore:"-"`
+	ChatID    string    `firestore:"-"`
+	SenderID  string    `firestore:"sender_id"`
+	Text      string    `firestore:"text"`
+	Timestamp time.Time `firestore:"timestamp"`
+	ReadBy    []string  `firestore:"read_by,omitempty"`
+	// Edits keeps the earlier versions of Text, oldest first.
+	Edits []MessageEdit `firestore:"edits,omitempty"`
+	// DeletedAt is set when the message is deleted for everyone.
+	// Text and Edits are cleared at that point.
+	DeletedAt time.Time `firestore:"deleted_at"`
+	// HiddenFor lists users who deleted the message for themselves.
+	HiddenFor []string `firestore:"hidden_for,omitempty"`
+	// Reactions maps an emoji to the users who reacted with it.
+	Reactions map[string][]string `firestore:"reactions,omitempty"`
+	// ReplyTo is the ID of the message this one quotes.
+	ReplyTo string `firestore:"reply_to,omitempty"`
+	// ThreadID is the ID of the root message when this one is
+	// posted in a thread rather than in the chat itself.
+	ThreadID string `firestore:"thread_id,omitempty"`
+	// ReplyCount and LastReplyAt describe the thread rooted at
+	// this message.
+	ReplyCount  int       `firestore:"reply_count,omitempty"`
+	LastReplyAt time.Time `firestore:"last_reply_at"`
+	// ForwardedFrom points at the original message of a forward.
+	ForwardedFrom *MessageOrigin `firestore:"forwarded_from,omitempty"`
+	// Attachments copy the metadata of the files sent with the
+	// message, so listing messages needs no extra reads.
+	Attachments []MessageAttachment `firestore:"attachments,omitempty"`
+	// ListenedBy lists who played a voice message, apart from its
+	// sender. It is kept separate from ReadBy.
+	ListenedBy []string `firestore:"listened_by,omitempty"`
+	// Mentions lists the participants mentioned in Text, resolved
+	// when the message was sent.
+	Mentions []string `firestore:"mentions,omitempty"`
+	// TempID is the ID the sender's client gave the message before
+	// it was stored, echoed back so that the client can match it.
+	TempID string `firestore:"temp_id,omitempty"`
+	// Views counts the subscribers who have seen a channel post.
+	Views int `firestore:"views,omitempty"`
+	// PinnedAt is set while the message is pinned in its chat, by
+	// PinnedBy.
+	PinnedAt time.Time `firestore:"pinned_at"`
+	PinnedBy string    `firestore:"pinned_by,omitempty"`
+}
+
+// MessageOrigin identifies where a forwarded message was first sent.
+// Forwarding a forward keeps the first origin.
+type MessageOrigin struct {
+	ChatID    string    `firestore:"chat_id" json:"chat_id"`
+	MessageID string    `firestore:"message_id" json:"message_id"`
+	SenderID  string    `firestore:"sender_id" json:"sender_id"`
+	Timestamp time.Time `firestore:"timestamp" json:"timestamp"`
+}
+
+// QuoteLength is how many characters of a quoted message are kept.
+const QuoteLength = 100
+
+// MessageQuote quotes the message a reply answers. Deleted is set
+// when it is no longer visible.
+type MessageQuote struct {
+	ID       string `json:"id"`
+	SenderID string `json:"sender_id,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// QuoteFor quotes the message id as seen by uid; msg is nil when it
+// no longer exists.
+func QuoteFor(id string, msg *Message, uid string) *MessageQuote {
+	quote := &MessageQuote{ID: id, Deleted: true}
+	if msg == nil || msg.Deleted() || msg.HiddenFrom(uid) {
+		return quote
+	}
+	quote.SenderID = msg.SenderID
+	quote.Text = msg.Text
+	if runes := []rune(msg.Text); len(runes) > QuoteLength {
+		quote.Text = string(runes[:QuoteLength]) + "…"
+	}
+	quote.Deleted = false
+	return quote
+}
+
+// MessageAttachment references an uploaded Attachment from a message.
+type MessageAttachment struct {
+	ID       string `firestore:"id" json:"id"`
+	Name     string `firestore:"name" json:"name"`
+	MIMEType string `firestore:"mime_type" json:"mime_type"`
+	Size     int64  `firestore:"size" json:"size"`
+	Checksum string `firestore:"checksum" json:"checksum"`
+	// Width, Height and Blurhash are set for images; Thumbnail once
+	// it has been generated.
+	Width     int        `firestore:"width,omitempty" json:"width,omitempty"`
+	Height    int        `firestore:"height,omitempty" json:"height,omitempty"`
+	Blurhash  string     `firestore:"blurhash,omitempty" json:"blurhash,omitempty"`
+	Thumbnail *Thumbnail `firestore:"thumbnail,omitempty" json:"thumbnail,omitempty"`
+	// Kind, DurationMS and Waveform describe voice recordings.
+	Kind       string `firestore:"kind,omitempty" json:"kind,omitempty"`
+	DurationMS int64  `firestore:"duration_ms,omitempty" json:"duration_ms,omitempty"`
+	Waveform   []int  `firestore:"waveform,omitempty" json:"waveform,omitempty"`
+}
+
+// Thumbnail describes the downscaled copy of an image attachment.
+type Thumbnail struct {
+	MIMEType string `firestore:"mime_type" json:"mime_type"`
+	Width    int    `firestore:"width" json:"width"`
+	Height   int    `firestore:"height" json:"height"`
+	Size     int64  `firestore:"size" json:"size"`
+}
+
+// Attachment is a file uploaded to a chat. The bytes live in the blob
+// store under Key; Checksum is the hex SHA-256 of the content.
+type Attachment struct {
+	ID         string    `firestore:"-"`
+	ChatID     string    `firestore:"chat_id"`
+	UploaderID string    `firestore:"uploader_id"`
+	Name       string    `firestore:"name"`
+	MIMEType   string    `firestore:"mime_type"`
+	Size       int64     `firestore:"size"`
+	Checksum   string    `firestore:"checksum"`
+	Key        string    `firestore:"key"`
+	CreatedAt  time.Time `firestore:"created_at"`
+
+	Width        int        `firestore:"width,omitempty"`
+	Height       int        `firestore:"height,omitempty"`
+	Blurhash     string     `firestore:"blurhash,omitempty"`
+	Thumbnail    *Thumbnail `firestore:"thumbnail,omitempty"`
+	ThumbnailKey string     `firestore:"thumbnail_key,omitempty"`
+
+	Kind       string `firestore:"kind,omitempty"`
+	DurationMS int64  `firestore:"duration_ms,omitempty"`
+	// Waveform holds the loudness of evenly spaced slices of a voice
+	// recording, from 0 to 100.
+	Waveform []int `firestore:"waveform,omitempty"`
+}
+
+// Attachment kinds with extra metadata. Other files have no kind.
+const (
+	AttachmentImage = "image"
+	AttachmentVoice = "voice"
+)
+
+// Ref returns the metadata a message keeps about the attachment.
+func (a *Attachment) Ref() MessageAttachment {
+	ref := MessageAttachment{
+		ID:       a.ID,
+		Name:     a.Name,
+		MIMEType: a.MIMEType,
+		Size:     a.Size,
+		Checksum: a.Checksum,
+		Width:    a.Width,
+		Height:   a.Height,
+		Blurhash: a.Blurhash,
+
+		Kind:       a.Kind,
+		DurationMS: a.DurationMS,
+		Waveform:   append([]int(nil), a.Waveform...),
+	}
+	if a.Thumbnail != nil {
+		thumbnail := *a.Thumbnail
+		ref.Thumbnail = &thumbnail
+	}
+	return ref
+}
+
+// Reaction is one emoji on a message as seen by a given user.
+type Reaction struct {
+	Emoji   string
+	Count   int
+	Reacted bool
+}
+
+// ReactionsFor aggregates the message's reactions for uid, most
+// popular first.
+func (m *Message) ReactionsFor(uid string) []Reaction {
+	reactions := make([]Reaction, 0, len(m.Reactions))
+	for emoji, users := range m.Reactions {
+		if len(users) == 0 {
+			continue
+		}
+		reaction := Reaction{Emoji: emoji, Count: len(users)}
+		for _, u := range users {
+			if u == uid {
+				reaction.Reacted = true
+				break
+			}
+		}
+		reactions = append(reactions, reaction)
+	}
+
+	sort.Slice(reactions, func(i, j int) bool {
+		if reactions[i].Count != reactions[j].Count {
+			return reactions[i].Count > reactions[j].Count
+		}
+		return reactions[i].Emoji < reactions[j].Emoji
+	})
+	return reactions
+}
+
+// MessageEdit is a replaced version of a message's text.
+type MessageEdit struct {
+	Text     string    `firestore:"text" json:"text"`
+	EditedAt time.Time `firestore:"edited_at" json:"edited_at"`
+}
+
+// Voice returns the recording of a voice message, or nil for other
+// messages.
+func (m *Message) Voice() *MessageAttachment {
+	for i := range m.Attachments {
+		if m.Attachments[i].Kind == AttachmentVoice {
+			return &m.Attachments[i]
+		}
+	}
+	return nil
+}
+
+func (m *Message) Deleted() bool {
+	return !m.DeletedAt.IsZero()
+}
+
+func (m *Message) Pinned() bool {
+	return !m.PinnedAt.IsZero()
+}
+
+// EditedAt returns when the text was last changed, or zero.
+func (m *Message) EditedAt() time.Time {
+	if len(m.Edits) == 0 {
+		return time.Time{}
+	}
+	return m.Edits[len(m.Edits)-1].EditedAt
+}
+
+func (m *Message) HiddenFrom(uid string) bool {
+	for _, u := range m.HiddenFor {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageCursor is a position in a chat's history. Messages are
+// ordered by timestamp, then by ID.
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+func (m *Message) Cursor() MessageCursor {
+	return MessageCursor{Timestamp: m.Timestamp, ID: m.ID}
+}
+
+// Before reports whether c sorts before other.
+func (c MessageCursor) Before(other MessageCursor) bool {
+	if !c.Timestamp.Equal(other.Timestamp) {
+		return c.Timestamp.Before(other.Timestamp)
+	}
+	return c.ID < other.ID
+}
+
+// MessagePage selects a window of at most Limit messages. With After
+// set it holds the messages right after that position, otherwise the
+// ones right before Before, or the latest when neither is set. Both
+// bounds are exclusive. Only messages of the thread rooted at
+// ThreadID are included, or those outside any thread when it is empty.
+type MessagePage struct {
+	Before   *MessageCursor
+	After    *MessageCursor
+	Limit    int
+	ThreadID string
+}
+
+// Credential holds the password hash of a user managed by the
+// built-in identity provider.
+type Credential struct {
+	UID          string    `firestore:"uid"`
+	Email        string    `firestore:"email"`
+	PasswordHash string    `firestore:"password_hash"`
+	CreatedAt    time.Time `firestore:"created_at"`
+}
+
+// Session is one signed-in device. Revoking a session deletes it,
+// so tokens that carry its ID stop being accepted.
+type Session struct {
+	ID         string    `firestore:"-"`
+	UID        string    `firestore:"uid"`
+	Device     string    `firestore:"device"`
+	IP         string    `firestore:"ip"`
+	CreatedAt  time.Time `firestore:"created_at"`
+	LastUsedAt time.Time `firestore:"last_used_at"`
+}
+
+// Change types recorded in the per-user change journal.
+const (
+	ChangeMessageNew     = "message_new"
+	ChangeMessageEdited  = "message_edited"
+	ChangeMessageDeleted = "message_deleted"
+	ChangeMessageRead    = "message_read"
+	ChangeChatCreated    = "chat_created"
+	ChangeChatLeft       = "chat_left"
+	ChangeChatUpdated    = "chat_updated"
+	ChangeContactSaved   = "contact_saved"
+	ChangeContactRemoved = "contact_removed"
+)
+
+// Change is an entry of a user's change journal, read back by the
+// sync endpoint. It only references the affected documents; their
+// current state is loaded when the journal is replayed.
+type Change struct {
+	Seq       int64  `firestore:"seq"`
+	Type      string `firestore:"type"`
+	ChatID    string `firestore:"chat_id,omitempty"`
+	MessageID string `firestore:"message_id,omitempty"`
+	ContactID string `firestore:"contact_id,omitempty"`
+	// UserID is the user who caused the change, e.g. the reader
+	// of a message.
+	UserID    string    `firestore:"user_id,omitempty"`
+	CreatedAt time.Time `firestore:"created_at"`
+}
+
+// SearchEntry is a message in the full-text index: the normalized
+// terms of its text (see package search).
+type SearchEntry struct {
+	ChatID    string    `firestore:"chat_id"`
+	MessageID string    `firestore:"message_id"`
+	Terms     []string  `firestore:"terms"`
+	Timestamp time.Time `firestore:"timestamp"`
+}
+
+// Invite is a link that lets users join a group chat.
+type Invite struct {
+	Token     string    `firestore:"-"`
+	ChatID    string    `firestore:"chat_id"`
+	CreatedBy string    `firestore:"created_by"`
+	CreatedAt time.Time `firestore:"created_at"`
+	// ExpiresAt is zero for a link that does not expire.
+	ExpiresAt time.Time `firestore:"expires_at"`
+	// MaxUses is zero for a link that can be used any number of
+	// times.
+	MaxUses int `firestore:"max_uses"`
+	Uses    int `firestore:"uses"`
+	// RequiresApproval keeps users who redeem the link in Pending
+	// until an admin lets them in.
+	RequiresApproval bool     `firestore:"requires_approval"`
+	Pending          []string `firestore:"pending"`
+}
+
+// Expired reports whether the link can no longer be used at now.
+func (i *Invite) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// UsedUp reports whether the link has let in MaxUses users.
+func (i *Invite) UsedUp() bool {
+	return i.MaxUses > 0 && i.Uses >= i.MaxUses
+}
+
+// Subscription makes a user a reader of a channel.
+type Subscription struct {
+	ChatID       string    `firestore:"chat_id"`
+	UserID       string    `firestore:"user_id"`
+	SubscribedAt time.Time `firestore:"subscribed_at"`
+	// ViewedAt is the timestamp of the latest post the subscriber
+	// has seen; posts up to it were counted in their views.
+	ViewedAt time.Time `firestore:"viewed_at"`
+}
+
+// ChatSettings is how one user has arranged a chat in their list.
+// Chats the user never changed have no settings stored.
+type ChatSettings struct {
+	ChatID string `firestore:"chat_id"`
+	UserID string `firestore:"user_id"`
+	// MutedUntil is zero for a chat that is not muted.
+	MutedUntil time.Time `firestore:"muted_until"`
+	Archived   bool      `firestore:"archived"`
+	Pinned     bool      `firestore:"pinned"`
+	// PinOrder sorts pinned chats, lowest first.
+	PinOrder  int       `firestore:"pin_order"`
+	Folders   []string  `firestore:"folders"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+// Muted reports whether the chat is muted at now.
+func (s *ChatSettings) Muted(now time.Time) bool {
+	return now.Before(s.MutedUntil)
+}
+
+var lastChangeSeq atomic.Int64
+
+// NewChangeSeq returns the wall clock in nanoseconds, bumped when
+// needed so that sequence numbers handed out by this process are
+// strictly increasing.
+func NewChangeSeq() int64 {
+	for {
+		last := lastChangeSeq.Load()
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if lastChangeSeq.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+type ChangeKind int
+
+const (
+	MessageAdded ChangeKind = iota
+	MessageModified
+	MessageRemoved
+)
+
+// MessageChange is a single entry of a snapshot-style notification
+// delivered by MessageRepository.Watch.
+type MessageChange struct {
+	Kind    ChangeKind
+	Message Message
+}
+
+type UserRepository interface {
+	Save(ctx context.Context, user *User) error
+	Get(ctx context.Context, uid string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	SearchByName(ctx context.Context, prefix string) ([]User, error)
+}
+
+type CredentialRepository interface {
+	Save(ctx context.Context, credential *Credential) error
+	GetByEmail(ctx context.Context, email string) (*Credential, error)
+	Delete(ctx context.Context, uid string) error
+}
+
+type SessionRepository interface {
+	Save(ctx context.Context, session *Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	// ListByUser returns the user's sessions, most recently used first.
+	ListByUser(ctx context.Context, uid string) ([]Session, error)
+	Delete(ctx context.Context, id string) error
+	DeleteByUser(ctx context.Context, uid string) error
+}
+
+type ContactRepository interface {
+	Save(ctx context.Context, contact *Contact) error
+	Get(ctx context.Context, id string) (*Contact, error)
+	// ListByOwner returns the owner's contacts, newest first.
+	ListByOwner(ctx context.Context, ownerUID string) ([]Contact, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type ChatRepository interface {
+	Create(ctx context.Context, chat *Chat) (string, error)
+	Get(ctx context.Context, id string) (*Chat, error)
+	ListByParticipant(ctx context.Context, uid string) ([]Chat, error)
+	// List returns every chat. It is meant for maintenance commands
+	// that rebuild derived data.
+	List(ctx context.Context) ([]Chat, error)
+	// SetLastMessage stores msg as the chat's last_message
+	// and bumps updated_at.
+	SetLastMessage(ctx context.Context, chatID string, msg *Message) error
+	// AddUnreadMentions adds delta to the unread mention counters of
+	// uids in the chat. Counters never drop below zero.
+	AddUnreadMentions(ctx context.Context, chatID string, uids []string, delta int) error
+	// AddParticipants appends uids that are not in the chat yet to
+	// its participants.
+	AddParticipants(ctx context.Context, chatID string, uids []string) error
+	// RemoveParticipant drops uid from the chat's participants along
+	// with its unread mention counter and role.
+	RemoveParticipant(ctx context.Context, chatID, uid string) error
+	// SetRoles changes the roles of the given participants in one
+	// write. Other participants keep theirs.
+	SetRoles(ctx context.Context, chatID string, roles map[string]string) error
+	// Rename changes the chat's name and bumps updated_at.
+	Rename(ctx context.Context, chatID, name string) error
+	// SetHandle gives the chat a unique handle, or clears it when
+	// handle is empty. It fails with ErrHandleTaken when another chat
+	// has it.
+	SetHandle(ctx context.Context, chatID, handle string) error
+	GetByHandle(ctx context.Context, handle string) (*Chat, error)
+	// SearchByHandle returns up to limit chats whose handle starts
+	// with prefix, ordered by handle.
+	SearchByHandle(ctx context.Context, prefix string, limit int) ([]Chat, error)
+	// Delete removes the chat with its messages, attachment records,
+	// search index entries, invites, subscriptions and per-user
+	// settings.
+	Delete(ctx context.Context, chatID string) error
+}
+
+type MessageRepository interface {
+	// Add stores msg in the chat. A new ID is generated
+	// unless msg.ID is already set; it fails with ErrAlreadyExists
+	// when the chat has a message with that ID.
+	Add(ctx context.Context, chatID string, msg *Message) (string, error)
+	// AddAll stores msgs in the chat in one atomic write, generating
+	// IDs and failing like Add.
+	AddAll(ctx context.Context, chatID string, msgs []*Message) error
+	Get(ctx context.Context, chatID, id string) (*Message, error)
+	// List returns every message of the chat, oldest first.
+	List(ctx context.Context, chatID string) ([]Message, error)
+	// ListPage returns the messages selected by page, oldest first.
+	ListPage(ctx context.Context, chatID string, page MessagePage) ([]Message, error)
+	// Update loads the message, applies fn and stores the text,
+	// edit history, deletion fields, reactions, thread counters,
+	// attachments, listeners, mentions and pin it left behind. The change is dropped
+	// when fn returns an error.
+	Update(ctx context.Context, chatID, id string, fn func(msg *Message) error) (*Message, error)
+	MarkRead(ctx context.Context, chatID, messageID, uid string) error
+	// AddViews adds one view to each of the messages.
+	AddViews(ctx context.Context, chatID string, ids []string) error
+	// Watch streams changes of the chat's latest message until ctx is done.
+	Watch(ctx context.Context, chatID string) (<-chan MessageChange, error)
+}
+
+type AttachmentRepository interface {
+	// Create stores the metadata of an uploaded file, generating an
+	// ID when it is empty.
+	Create(ctx context.Context, attachment *Attachment) error
+	Get(ctx context.Context, id string) (*Attachment, error)
+	// Update overwrites the metadata of an existing attachment.
+	Update(ctx context.Context, attachment *Attachment) error
+	// ListByChat returns the attachments of the chat.
+	ListByChat(ctx context.Context, chatID string) ([]Attachment, error)
+	// KeyInUse reports whether any attachment still keeps its file
+	// under key. Forwarded attachments share the file of the original.
+	KeyInUse(ctx context.Context, key string) (bool, error)
+	// Delete removes the metadata of an attachment. Deleting a
+	// missing attachment is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+type ChangeRepository interface {
+	// Append records change in the journal of every user in uids.
+	// Seq and CreatedAt are filled in when unset.
+	Append(ctx context.Context, change *Change, uids []string) error
+	// ListSince returns up to limit changes of the user with Seq
+	// greater than after, oldest first.
+	ListSince(ctx context.Context, uid string, after int64, limit int) ([]Change, error)
+}
+
+type SearchRepository interface {
+	// Index replaces the terms stored for the entry's message.
+	Index(ctx context.Context, entry *SearchEntry) error
+	Remove(ctx context.Context, chatID, messageID string) error
+	// Query returns up to limit entries of the given chats that
+	// contain every one of terms, newest first. Terms of the
+	// returned entries are not filled in.
+	Query(ctx context.Context, chatIDs, terms []string, limit int) ([]SearchEntry, error)
+	// Clear drops the whole index before a rebuild.
+	Clear(ctx context.Context) error
+}
+
+type InviteRepository interface {
+	// Create stores invite under its Token.
+	Create(ctx context.Context, invite *Invite) error
+	Get(ctx context.Context, token string) (*Invite, error)
+	// ListByChat returns the chat's invites, newest first.
+	ListByChat(ctx context.Context, chatID string) ([]Invite, error)
+	// Update loads the invite, applies fn and stores its uses and
+	// pending users. The change is dropped when fn returns an error.
+	Update(ctx context.Context, token string, fn func(invite *Invite) error) (*Invite, error)
+	Delete(ctx context.Context, token string) error
+}
+
+type SubscriptionRepository interface {
+	// Subscribe makes uid a subscriber of the chat and bumps its
+	// subscriber count. It reports false when uid already was one.
+	Subscribe(ctx context.Context, chatID, uid string) (bool, error)
+	// Unsubscribe is the reverse of Subscribe. It reports false when
+	// uid was not a subscriber.
+	Unsubscribe(ctx context.Context, chatID, uid string) (bool, error)
+	Get(ctx context.Context, chatID, uid string) (*Subscription, error)
+	// ListByUser returns the user's subscriptions.
+	ListByUser(ctx context.Context, uid string) ([]Subscription, error)
+	// ListByChat returns up to limit subscriptions of the chat with
+	// a user ID after afterUID, ordered by user ID.
+	ListByChat(ctx context.Context, chatID, afterUID string, limit int) ([]Subscription, error)
+	// AdvanceViewed moves the subscription's ViewedAt to at when it
+	// is later and returns the previous value.
+	AdvanceViewed(ctx context.Context, chatID, uid string, at time.Time) (time.Time, error)
+}
+
+type ChatSettingsRepository interface {
+	// Get returns ErrNotFound when the user has no settings for the
+	// chat.
+	Get(ctx context.Context, uid, chatID string) (*ChatSettings, error)
+	// ListByUser returns the settings of all the user's chats.
+	ListByUser(ctx context.Context, uid string) ([]ChatSettings, error)
+	// Save stores settings under their UserID and ChatID.
+	Save(ctx context.Context, settings *ChatSettings) error
+	Delete(ctx context.Context, uid, chatID string) error
+}
+
+// Store groups the repositories every service works with.
+// Client is the Firestore-backed implementation.
+type Store interface {
+	Users() UserRepository
+	Credentials() CredentialRepository
+	Sessions() SessionRepository
+	Contacts() ContactRepository
+	Chats() ChatRepository
+	Messages() MessageRepository
+	Attachments() AttachmentRepository
+	Changes() ChangeRepository
+	Search() SearchRepository
+	Invites() InviteRepository
+	Subscriptions() SubscriptionRepository
+	ChatSettings() ChatSettingsRepository
+}
+
+const idAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// NewID returns a random 20 character identifier in the same
+// shape as Firestore auto IDs, for backends that generate their own.
+func NewID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return IDFromBytes(b)
+}
+
+// IDFromBytes shapes the first 20 bytes of b, e.g. a hash, like
+// NewID does, for IDs that must be derived rather than random.
+func IDFromBytes(b []byte) string {
+	id := make([]byte, 20)
+	for i := range id {
+		id[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(id)
+}
@@ -0,0 +1,147 @@
+package delta
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+)
+
+func TestSyncReplaysJournal(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Name: "Team", Participants: []string{"u1", "u2"}})
+	renamedID, _ := store.Chats().Create(ctx, &database.Chat{Name: "Renamed", Type: "group", Participants: []string{"u1"}})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "m1", SenderID: "u2", Text: "edited", Timestamp: time.Now()})
+	store.Contacts().Save(ctx, &database.Contact{ID: "u1_u2", OwnerUID: "u1", ContactUID: "u2"})
+
+	base := time.Now().Add(-time.Hour).UnixNano()
+	journal := []database.Change{
+		{Type: database.ChangeChatCreated, ChatID: chatID},
+		{Type: database.ChangeChatUpdated, ChatID: chatID},
+		{Type: database.ChangeChatUpdated, ChatID: renamedID},
+		{Type: database.ChangeChatUpdated, ChatID: renamedID},
+		{Type: database.ChangeMessageNew, ChatID: chatID, MessageID: "m1"},
+		{Type: database.ChangeMessageNew, ChatID: chatID, MessageID: "m2"},
+		{Type: database.ChangeMessageEdited, ChatID: chatID, MessageID: "m1"},
+		{Type: database.ChangeMessageDeleted, ChatID: chatID, MessageID: "m2"},
+		{Type: database.ChangeMessageRead, ChatID: chatID, MessageID: "m1", UserID: "u2"},
+		{Type: database.ChangeMessageRead, ChatID: chatID, MessageID: "m1", UserID: "u2"},
+		{Type: database.ChangeContactSaved, ContactID: "u1_u2"},
+		{Type: database.ChangeContactSaved, ContactID: "u1_u3"},
+		{Type: database.ChangeContactRemoved, ContactID: "u1_u3"},
+		{Type: database.ChangeChatLeft, ChatID: "old"},
+		{Type: database.ChangeMessageNew, ChatID: "old", MessageID: "x"},
+	}
+	for i := range journal {
+		journal[i].Seq = base + int64(i) + 1
+		store.Changes().Append(ctx, &journal[i], []string{"u1"})
+	}
+	// Too recent to be replayed yet.
+	store.Changes().Append(ctx, &database.Change{Type: database.ChangeChatLeft, ChatID: chatID}, []string{"u1"})
+
+	resp, err := service.Sync(ctx, "u1", encodeToken("u1", base))
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if len(resp.Chats.Created) != 1 || resp.Chats.Created[0].ID != chatID {
+		t.Errorf("Chats.Created = %+v, want %s", resp.Chats.Created, chatID)
+	}
+	if len(resp.Chats.Updated) != 1 || resp.Chats.Updated[0].Name != "Renamed" || resp.Chats.Updated[0].Roles["u1"] != database.ChatRoleMember {
+		t.Errorf("Chats.Updated = %+v, want %s with u1 as member", resp.Chats.Updated, renamedID)
+	}
+	if len(resp.Chats.Left) != 1 || resp.Chats.Left[0] != "old" {
+		t.Errorf("Chats.Left = %v, want [old]", resp.Chats.Left)
+	}
+	if len(resp.Messages.New) != 1 || resp.Messages.New[0].Text != "edited" || len(resp.Messages.Edited) != 0 {
+		t.Errorf("Messages.New = %+v, Edited = %+v; want m1 as new only", resp.Messages.New, resp.Messages.Edited)
+	}
+	if len(resp.Messages.Deleted) != 1 || resp.Messages.Deleted[0].ID != "m2" {
+		t.Errorf("Messages.Deleted = %+v, want m2", resp.Messages.Deleted)
+	}
+	if len(resp.ReadReceipts) != 1 {
+		t.Errorf("ReadReceipts = %+v, want one", resp.ReadReceipts)
+	}
+	if len(resp.Contacts.Saved) != 1 || len(resp.Contacts.Removed) != 1 || resp.Contacts.Removed[0] != "u1_u3" {
+		t.Errorf("Contacts = %+v, want u1_u2 saved and u1_u3 removed", resp.Contacts)
+	}
+
+	next, err := service.Sync(ctx, "u1", resp.SyncToken)
+	if err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if len(next.Chats.Left) != 0 || len(next.Messages.New) != 0 {
+		t.Errorf("second Sync = %+v, want nothing settled yet", next)
+	}
+}
+
+func TestSyncThreads(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Name: "Team", Participants: []string{"u1", "u2"}})
+	repliedAt := time.Now().Add(-2 * time.Hour)
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "root", SenderID: "u2", Text: "question", Timestamp: repliedAt.Add(-time.Minute), ReplyCount: 1, LastReplyAt: repliedAt})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "r1", SenderID: "u1", Text: "answer", Timestamp: repliedAt, ReplyTo: "root", ThreadID: "root"})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "r2", SenderID: "u1", Text: "late", Timestamp: repliedAt, ReplyTo: "gone"})
+
+	base := time.Now().Add(-time.Hour).UnixNano()
+	for i, id := range []string{"root", "r1", "r2"} {
+		store.Changes().Append(ctx, &database.Change{Seq: base + int64(i) + 1, Type: database.ChangeMessageNew, ChatID: chatID, MessageID: id}, []string{"u1"})
+	}
+
+	resp, err := service.Sync(ctx, "u1", encodeToken("u1", base))
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(resp.Messages.New) != 3 {
+		t.Fatalf("Messages.New = %+v, want root, r1 and r2", resp.Messages.New)
+	}
+
+	root, reply, orphan := resp.Messages.New[0], resp.Messages.New[1], resp.Messages.New[2]
+	if root.ReplyCount != 1 || root.LastReplyAt == nil || !root.LastReplyAt.Equal(repliedAt) || root.ReplyTo != nil {
+		t.Errorf("root = %+v, want one reply at %v", root, repliedAt)
+	}
+	if reply.ThreadID != "root" || reply.ReplyCount != 0 || reply.LastReplyAt != nil {
+		t.Errorf("reply = %+v, want it in the root thread", reply)
+	}
+	if quote := reply.ReplyTo; quote == nil || quote.ID != "root" || quote.SenderID != "u2" || quote.Text != "question" || quote.Deleted {
+		t.Errorf("reply.ReplyTo = %+v, want a quote of root", quote)
+	}
+	if quote := orphan.ReplyTo; quote == nil || quote.ID != "gone" || !quote.Deleted || quote.Text != "" {
+		t.Errorf("orphan.ReplyTo = %+v, want a deleted quote", quote)
+	}
+}
+
+func TestSyncTokens(t *testing.T) {
+	ctx := context.Background()
+	service := NewService(memory.NewStore())
+
+	start, err := service.Sync(ctx, "u1", "")
+	if err != nil || !start.Reset || start.SyncToken == "" {
+		t.Fatalf("Sync without token = %+v, %v; want a fresh token and Reset", start, err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"garbage", "%%%"},
+		{"other user", encodeToken("u2", 1)},
+		{"bad seq", encodeToken("u1", -1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.Sync(ctx, "u1", tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Sync(%q) error = %v, want ErrInvalidToken", tt.token, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package delta
+
+import (
+	"errors"
+	"net/http"
+
+	"MyChatServer/internal/authentication"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Sync handles GET /api/sync?since=<token>.
+func (h *Handler) Sync(c echo.Context) error {
+	uid := authentication.CurrentUser(c).UID
+
+	resp, err := h.service.Sync(c.Request().Context(), uid, c.QueryParam("since"))
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
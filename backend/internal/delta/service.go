@@ -0,0 +1,491 @@
+package delta
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrInvalidToken = errors.New("invalid sync token")
+
+const (
+	// changesLimit caps the journal entries replayed per request;
+	// the rest are returned by the next call.
+	changesLimit = 500
+	// settleDelay holds back the newest journal entries so that a
+	// write committed slightly out of order is not skipped.
+	settleDelay = 2 * time.Second
+)
+
+// Service replays a user's change journal into the current state
+// of the messages, chats and contacts it references.
+type Service struct {
+	db database.Store
+}
+
+type Message struct {
+	ID        string     `json:"id"`
+	ChatID    string     `json:"chat_id"`
+	SenderID  string     `json:"sender_id"`
+	Text      string     `json:"text"`
+	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	ReadBy    []string   `json:"read_by"`
+	Reactions []Reaction `json:"reactions"`
+
+	ReplyTo       *database.MessageQuote       `json:"reply_to,omitempty"`
+	ThreadID      string                       `json:"thread_id,omitempty"`
+	ReplyCount    int                          `json:"reply_count,omitempty"`
+	LastReplyAt   *time.Time                   `json:"last_reply_at,omitempty"`
+	ForwardedFrom *database.MessageOrigin      `json:"forwarded_from,omitempty"`
+	Attachments   []database.MessageAttachment `json:"attachments,omitempty"`
+	ListenedBy    []string                     `json:"listened_by,omitempty"`
+	Mentions      []string                     `json:"mentions,omitempty"`
+	TempID        string                       `json:"temp_id,omitempty"`
+	Views         int                          `json:"views,omitempty"`
+	PinnedAt      *time.Time                   `json:"pinned_at,omitempty"`
+	PinnedBy      string                       `json:"pinned_by,omitempty"`
+}
+
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+type MessageRef struct {
+	ID     string `json:"id"`
+	ChatID string `json:"chat_id"`
+}
+
+type Chat struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Participants []string  `json:"participants"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Roles maps each participant of a group chat to its role.
+	Roles map[string]string `json:"roles,omitempty"`
+	// Handle and SubscriberCount are set for channels.
+	Handle          string `json:"handle,omitempty"`
+	SubscriberCount int    `json:"subscriber_count,omitempty"`
+
+	UnreadMentions int `json:"unread_mentions"`
+
+	// The user's own settings of the chat.
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	PinOrder   int        `json:"pin_order,omitempty"`
+	Folders    []string   `json:"folders"`
+}
+
+type Contact struct {
+	ID           string    `json:"id"`
+	ContactUID   string    `json:"contact_uid"`
+	ContactEmail string    `json:"contact_email"`
+	ContactName  string    `json:"contact_name"`
+	CreatedAt    time.Time `json:"created_at"`
+	Notes        string    `json:"notes,omitempty"`
+}
+
+type ReadReceipt struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+}
+
+type MessageChanges struct {
+	New     []Message    `json:"new"`
+	Edited  []Message    `json:"edited"`
+	Deleted []MessageRef `json:"deleted"`
+}
+
+type ChatChanges struct {
+	Created []Chat `json:"created"`
+	// Updated holds chats the client already has whose name, member
+	// list or roles changed.
+	Updated []Chat   `json:"updated"`
+	Left    []string `json:"left"`
+}
+
+type ContactChanges struct {
+	Saved   []Contact `json:"saved"`
+	Removed []string  `json:"removed"`
+}
+
+// Response is what changed since the token passed to Sync. Reset
+// asks the client to reload everything through
+// /api/auth/initial-data before applying later syncs.
+type Response struct {
+	SyncToken    string         `json:"sync_token"`
+	HasMore      bool           `json:"has_more"`
+	Reset        bool           `json:"reset"`
+	Messages     MessageChanges `json:"messages"`
+	Chats        ChatChanges    `json:"chats"`
+	ReadReceipts []ReadReceipt  `json:"read_receipts"`
+	Contacts     ContactChanges `json:"contacts"`
+}
+
+func NewService(db database.Store) *Service {
+	return &Service{db: db}
+}
+
+// Sync returns the user's changes after token. An empty token
+// starts a new sync: the response only carries a fresh token and
+// Reset.
+func (s *Service) Sync(ctx context.Context, uid, token string) (*Response, error) {
+	cutoff := time.Now().Add(-settleDelay).UnixNano()
+	resp := newResponse()
+
+	if token == "" {
+		resp.SyncToken = encodeToken(uid, cutoff)
+		resp.Reset = true
+		return resp, nil
+	}
+
+	after, err := decodeToken(uid, token)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := s.db.Changes().ListSince(ctx, uid, after, changesLimit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %v", err)
+	}
+
+	for i, change := range changes {
+		if change.Seq > cutoff {
+			changes = changes[:i]
+			break
+		}
+	}
+
+	position := after
+	if len(changes) > changesLimit {
+		changes = changes[:changesLimit]
+		resp.HasMore = true
+		position = changes[len(changes)-1].Seq
+	} else if cutoff > position {
+		position = cutoff
+	}
+	resp.SyncToken = encodeToken(uid, position)
+
+	if err := s.replay(ctx, uid, changes, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type messageKey struct {
+	chatID string
+	id     string
+}
+
+// replay folds changes into their latest effect per document and
+// loads the current state of whatever still exists.
+func (s *Service) replay(ctx context.Context, uid string, changes []database.Change, resp *Response) error {
+	var messageOrder []messageKey
+	messageState := make(map[messageKey]string)
+	var chatOrder, contactOrder []string
+	chatState := make(map[string]string)
+	contactState := make(map[string]string)
+	seenReceipts := make(map[ReadReceipt]bool)
+	var receipts []ReadReceipt
+
+	for _, change := range changes {
+		switch change.Type {
+		case database.ChangeMessageNew, database.ChangeMessageEdited, database.ChangeMessageDeleted:
+			key := messageKey{change.ChatID, change.MessageID}
+			state, seen := messageState[key]
+			if !seen {
+				messageOrder = append(messageOrder, key)
+			}
+			// An edit of a message the client has not seen yet is
+			// still new to it.
+			if change.Type == database.ChangeMessageEdited && state == database.ChangeMessageNew {
+				continue
+			}
+			messageState[key] = change.Type
+
+		case database.ChangeMessageRead:
+			receipt := ReadReceipt{ChatID: change.ChatID, MessageID: change.MessageID, UserID: change.UserID}
+			if !seenReceipts[receipt] {
+				seenReceipts[receipt] = true
+				receipts = append(receipts, receipt)
+			}
+
+		case database.ChangeChatCreated, database.ChangeChatLeft:
+			if _, seen := chatState[change.ChatID]; !seen {
+				chatOrder = append(chatOrder, change.ChatID)
+			}
+			chatState[change.ChatID] = change.Type
+
+		case database.ChangeChatUpdated:
+			// Created and left already cover an update.
+			if _, seen := chatState[change.ChatID]; !seen {
+				chatOrder = append(chatOrder, change.ChatID)
+				chatState[change.ChatID] = change.Type
+			}
+
+		case database.ChangeContactSaved, database.ChangeContactRemoved:
+			if _, seen := contactState[change.ContactID]; !seen {
+				contactOrder = append(contactOrder, change.ContactID)
+			}
+			contactState[change.ContactID] = change.Type
+		}
+	}
+
+	for _, chatID := range chatOrder {
+		if state := chatState[chatID]; state == database.ChangeChatCreated || state == database.ChangeChatUpdated {
+			chat, err := s.db.Chats().Get(ctx, chatID)
+			if errors.Is(err, database.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load chat %s: %v", chatID, err)
+			}
+			member, err := s.isMember(ctx, chat, uid)
+			if err != nil {
+				return err
+			}
+			if member {
+				settings, err := s.db.ChatSettings().Get(ctx, uid, chatID)
+				if err != nil && !errors.Is(err, database.ErrNotFound) {
+					return fmt.Errorf("failed to load settings of chat %s: %v", chatID, err)
+				}
+				if state == database.ChangeChatCreated {
+					resp.Chats.Created = append(resp.Chats.Created, chatFromRecord(uid, chat, settings))
+				} else {
+					resp.Chats.Updated = append(resp.Chats.Updated, chatFromRecord(uid, chat, settings))
+				}
+				continue
+			}
+			chatState[chatID] = database.ChangeChatLeft
+		}
+		resp.Chats.Left = append(resp.Chats.Left, chatID)
+	}
+
+	for _, key := range messageOrder {
+		if chatState[key.chatID] == database.ChangeChatLeft {
+			continue
+		}
+
+		state := messageState[key]
+		if state != database.ChangeMessageDeleted {
+			msg, err := s.db.Messages().Get(ctx, key.chatID, key.id)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return fmt.Errorf("failed to load message %s: %v", key.id, err)
+			}
+			if err == nil && !msg.Deleted() && !msg.HiddenFrom(uid) {
+				result := messageFromRecord(uid, msg)
+				if msg.ReplyTo != "" {
+					if result.ReplyTo, err = s.replyQuote(ctx, uid, msg); err != nil {
+						return err
+					}
+				}
+				if state == database.ChangeMessageNew {
+					resp.Messages.New = append(resp.Messages.New, result)
+				} else {
+					resp.Messages.Edited = append(resp.Messages.Edited, result)
+				}
+				continue
+			}
+		}
+		resp.Messages.Deleted = append(resp.Messages.Deleted, MessageRef{ID: key.id, ChatID: key.chatID})
+	}
+
+	for _, receipt := range receipts {
+		if chatState[receipt.ChatID] != database.ChangeChatLeft {
+			resp.ReadReceipts = append(resp.ReadReceipts, receipt)
+		}
+	}
+
+	for _, contactID := range contactOrder {
+		if contactState[contactID] == database.ChangeContactSaved {
+			contact, err := s.db.Contacts().Get(ctx, contactID)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return fmt.Errorf("failed to load contact %s: %v", contactID, err)
+			}
+			if err == nil {
+				resp.Contacts.Saved = append(resp.Contacts.Saved, contactFromRecord(contact))
+				continue
+			}
+		}
+		resp.Contacts.Removed = append(resp.Contacts.Removed, contactID)
+	}
+
+	return nil
+}
+
+func newResponse() *Response {
+	return &Response{
+		Messages: MessageChanges{
+			New:     []Message{},
+			Edited:  []Message{},
+			Deleted: []MessageRef{},
+		},
+		Chats: ChatChanges{
+			Created: []Chat{},
+			Updated: []Chat{},
+			Left:    []string{},
+		},
+		ReadReceipts: []ReadReceipt{},
+		Contacts: ContactChanges{
+			Saved:   []Contact{},
+			Removed: []string{},
+		},
+	}
+}
+
+// isMember reports whether uid still has the chat: as a participant
+// or, for a channel, as a subscriber.
+func (s *Service) isMember(ctx context.Context, chat *database.Chat, uid string) (bool, error) {
+	for _, p := range chat.Participants {
+		if p == uid {
+			return true, nil
+		}
+	}
+	if chat.Type != "channel" {
+		return false, nil
+	}
+
+	_, err := s.db.Subscriptions().Get(ctx, chat.ID, uid)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load subscription: %v", err)
+	}
+	return true, nil
+}
+
+// replyQuote quotes the message msg replies to as seen by uid.
+func (s *Service) replyQuote(ctx context.Context, uid string, msg *database.Message) (*database.MessageQuote, error) {
+	quoted, err := s.db.Messages().Get(ctx, msg.ChatID, msg.ReplyTo)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load message %s: %v", msg.ReplyTo, err)
+	}
+	return database.QuoteFor(msg.ReplyTo, quoted, uid), nil
+}
+
+func messageFromRecord(uid string, msg *database.Message) Message {
+	readBy := msg.ReadBy
+	if readBy == nil {
+		readBy = []string{}
+	}
+	result := Message{
+		ID:        msg.ID,
+		ChatID:    msg.ChatID,
+		SenderID:  msg.SenderID,
+		Text:      msg.Text,
+		Timestamp: msg.Timestamp,
+		ReadBy:    readBy,
+		Reactions: []Reaction{},
+
+		ThreadID:      msg.ThreadID,
+		ForwardedFrom: msg.ForwardedFrom,
+		TempID:        msg.TempID,
+		Views:         msg.Views,
+	}
+	if msg.ReplyCount > 0 {
+		lastReplyAt := msg.LastReplyAt
+		result.ReplyCount = msg.ReplyCount
+		result.LastReplyAt = &lastReplyAt
+	}
+	// A message deleted for everyone keeps none of its content.
+	if !msg.Deleted() {
+		result.Attachments = msg.Attachments
+		result.ListenedBy = msg.ListenedBy
+		result.Mentions = msg.Mentions
+	}
+	for _, r := range msg.ReactionsFor(uid) {
+		result.Reactions = append(result.Reactions, Reaction{Emoji: r.Emoji, Count: r.Count, Reacted: r.Reacted})
+	}
+	if editedAt := msg.EditedAt(); !editedAt.IsZero() {
+		result.EditedAt = &editedAt
+	}
+	if msg.Pinned() {
+		pinnedAt := msg.PinnedAt
+		result.PinnedAt = &pinnedAt
+		result.PinnedBy = msg.PinnedBy
+	}
+	return result
+}
+
+// chatFromRecord converts a chat as seen by uid; settings is nil when
+// uid never changed theirs.
+func chatFromRecord(uid string, chat *database.Chat, settings *database.ChatSettings) Chat {
+	result := Chat{
+		ID:           chat.ID,
+		Name:         chat.Name,
+		Type:         chat.Type,
+		Participants: chat.Participants,
+		CreatedBy:    chat.CreatedBy,
+		CreatedAt:    chat.CreatedAt,
+		UpdatedAt:    chat.UpdatedAt,
+		Roles:        chat.ParticipantRoles(),
+
+		Handle:          chat.Handle,
+		SubscriberCount: chat.SubscriberCount,
+
+		UnreadMentions: chat.UnreadMentions[uid],
+		Folders:        []string{},
+	}
+	if settings != nil {
+		if settings.Muted(time.Now()) {
+			mutedUntil := settings.MutedUntil
+			result.MutedUntil = &mutedUntil
+		}
+		result.Archived = settings.Archived
+		result.Pinned = settings.Pinned
+		result.PinOrder = settings.PinOrder
+		if len(settings.Folders) > 0 {
+			result.Folders = settings.Folders
+		}
+	}
+	return result
+}
+
+func contactFromRecord(contact *database.Contact) Contact {
+	return Contact{
+		ID:           contact.ID,
+		ContactUID:   contact.ContactUID,
+		ContactEmail: contact.ContactEmail,
+		ContactName:  contact.ContactName,
+		CreatedAt:    contact.CreatedAt,
+		Notes:        contact.Notes,
+	}
+}
+
+// Tokens are opaque to clients: base64url of "uid:seq". The user ID
+// keeps a token from being replayed against another account.
+func encodeToken(uid string, seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(uid + ":" + strconv.FormatInt(seq, 10)))
+}
+
+func decodeToken(uid, token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	owner, seq, ok := strings.Cut(string(raw), ":")
+	if !ok || owner != uid {
+		return 0, ErrInvalidToken
+	}
+
+	after, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || after < 0 {
+		return 0, ErrInvalidToken
+	}
+	return after, nil
+}
@@ -0,0 +1,103 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrUserBanned = errors.New("user is banned")
+
+// BanError is returned for a user whose ban is in effect.
+// It matches ErrUserBanned with errors.Is.
+type BanError struct {
+	Reason string
+	// Until is zero for a ban without expiry.
+	Until time.Time
+}
+
+func (e *BanError) Error() string {
+	return ErrUserBanned.Error()
+}
+
+func (e *BanError) Is(target error) bool {
+	return target == ErrUserBanned
+}
+
+// banChecking rejects banned users at sign-in, on refresh and on
+// every token check, so each caller of the provider enforces bans.
+type banChecking struct {
+	Provider
+	db  database.Store
+	now func() time.Time
+}
+
+// WithBans wraps p so banned users are rejected with a *BanError.
+func WithBans(p Provider, db database.Store) Provider {
+	return &banChecking{Provider: p, db: db, now: time.Now}
+}
+
+func (b *banChecking) SignInWithPassword(ctx context.Context, email, password string, device Device) (*SignInResult, error) {
+	signIn, err := b.Provider.SignInWithPassword(ctx, email, password, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.checkBan(ctx, signIn.UID); err != nil {
+		b.Provider.RevokeSession(ctx, signIn.UID, signIn.SessionID)
+		return nil, err
+	}
+	return signIn, nil
+}
+
+func (b *banChecking) RefreshIdToken(ctx context.Context, refreshToken string) (*SignInResult, error) {
+	refreshed, err := b.Provider.RefreshIdToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.checkBan(ctx, refreshed.UID); err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+func (b *banChecking) ValidateIdToken(ctx context.Context, idToken string) (string, error) {
+	token, err := b.VerifyIdToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+
+	return token.UID, nil
+}
+
+func (b *banChecking) VerifyIdToken(ctx context.Context, idToken string) (*Token, error) {
+	token, err := b.Provider.VerifyIdToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.checkBan(ctx, token.UID); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkBan lets users without a profile through: registration
+// creates the profile only after the identity.
+func (b *banChecking) checkBan(ctx context.Context, uid string) error {
+	user, err := b.db.Users().Get(ctx, uid)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Banned(b.now()) {
+		return &BanError{Reason: user.BanReason, Until: user.BannedUntil}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+
+	"firebase.google.com/go/auth"
+)
+
+// Firebase keeps users in Firebase Authentication. Sign-in goes
+// through the Identity Toolkit REST API, so it needs network access.
+// Sessions are tracked in db; a Firebase session is identified by the
+// user and the auth_time claim, which survives token refreshes.
+type Firebase struct {
+	sessions
+	auth   *auth.Client
+	apiKey string
+}
+
+var _ Provider = (*Firebase)(nil)
+
+type firebaseAuthResponse struct {
+	IDToken      string `json:"idToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    string `json:"expiresIn"`
+	LocalID      string `json:"localId"`
+	Email        string `json:"email"`
+}
+
+type firebaseRefreshResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    string `json:"expires_in"`
+	UserID       string `json:"user_id"`
+}
+
+func NewFirebase(db database.Store, authClient *auth.Client, apiKey string) *Firebase {
+	return &Firebase{sessions: sessions{db: db}, auth: authClient, apiKey: apiKey}
+}
+
+func (f *Firebase) CreateUserInAuth(ctx context.Context, email, password, name string) (string, error) {
+	user, err := f.auth.CreateUser(ctx, (&auth.UserToCreate{}).
+		Email(email).
+		Password(password).
+		DisplayName(name))
+	if err != nil {
+		return "", err
+	}
+
+	return user.UID, nil
+}
+
+func (f *Firebase) DeleteAuthUser(ctx context.Context, uid string) error {
+	if err := f.sessions.RevokeAllSessions(ctx, uid); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	return f.auth.DeleteUser(ctx, uid)
+}
+
+// SignInWithPassword exchanges email and password for an ID token
+// through the Identity Toolkit REST API.
+func (f *Firebase) SignInWithPassword(ctx context.Context, email, password string, device Device) (*SignInResult, error) {
+	endpoint := fmt.Sprintf("https://identitytoolkit.googleapis.com/v1/accounts:signInWithPassword?key=%s", f.apiKey)
+
+	payload := map[string]interface{}{
+		"email":             email,
+		"password":          password,
+		"returnSecureToken": true,
+	}
+
+	payloadBytes, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(string(payloadBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Firebase API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &errorResp)
+		return nil, fmt.Errorf("firebase auth error: %s (code: %d)", errorResp.Error.Message, errorResp.Error.Code)
+	}
+
+	var authResp firebaseAuthResponse
+	if err := json.Unmarshal(body, &authResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	token, err := f.auth.VerifyIDToken(ctx, authResp.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idToken: %v", err)
+	}
+
+	sessionID := firebaseSessionID(token)
+	if err := f.start(ctx, sessionID, token.UID, device, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &SignInResult{
+		IDToken:      authResp.IDToken,
+		RefreshToken: authResp.RefreshToken,
+		ExpiresIn:    authResp.ExpiresIn,
+		UID:          authResp.LocalID,
+		Email:        authResp.Email,
+		SessionID:    sessionID,
+	}, nil
+}
+
+// RefreshIdToken exchanges a refresh token through the Secure Token
+// REST API.
+func (f *Firebase) RefreshIdToken(ctx context.Context, refreshToken string) (*SignInResult, error) {
+	endpoint := fmt.Sprintf("https://securetoken.googleapis.com/v1/token?key=%s", f.apiKey)
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Firebase API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			} `json:"error"`
+		}
+		json.Unmarshal(body, &errorResp)
+		if resp.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRefresh, errorResp.Error.Message)
+		}
+		return nil, fmt.Errorf("firebase auth error: %s (code: %d)", errorResp.Error.Message, errorResp.Error.Code)
+	}
+
+	var refreshResp firebaseRefreshResponse
+	if err := json.Unmarshal(body, &refreshResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	token, err := f.auth.VerifyIDToken(ctx, refreshResp.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idToken: %v", err)
+	}
+
+	sessionID := firebaseSessionID(token)
+	session, err := f.check(ctx, sessionID, token.UID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefresh, err)
+	}
+	f.touch(ctx, session, time.Now())
+
+	return &SignInResult{
+		IDToken:      refreshResp.IDToken,
+		RefreshToken: refreshResp.RefreshToken,
+		ExpiresIn:    refreshResp.ExpiresIn,
+		UID:          refreshResp.UserID,
+		SessionID:    sessionID,
+	}, nil
+}
+
+func (f *Firebase) ValidateIdToken(ctx context.Context, idToken string) (string, error) {
+	token, err := f.VerifyIdToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+
+	return token.UID, nil
+}
+
+func (f *Firebase) VerifyIdToken(ctx context.Context, idToken string) (*Token, error) {
+	token, err := f.auth.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idToken: %v", err)
+	}
+
+	sessionID := firebaseSessionID(token)
+	if _, err := f.check(ctx, sessionID, token.UID); err != nil {
+		return nil, fmt.Errorf("invalid idToken: %w", err)
+	}
+
+	return &Token{
+		UID:       token.UID,
+		SessionID: sessionID,
+		Expires:   time.Unix(token.Expires, 0),
+	}, nil
+}
+
+// RevokeAllSessions also revokes the user's Firebase refresh tokens,
+// so no device can obtain a new ID token.
+func (f *Firebase) RevokeAllSessions(ctx context.Context, uid string) error {
+	if err := f.auth.RevokeRefreshTokens(ctx, uid); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return f.sessions.RevokeAllSessions(ctx, uid)
+}
+
+func firebaseSessionID(token *auth.Token) string {
+	authTime, _ := token.Claims["auth_time"].(float64)
+	return fmt.Sprintf("%s_%d", token.UID, int64(authTime))
+}
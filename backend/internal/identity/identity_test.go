@@ -0,0 +1,179 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database/memory"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	argonHash, err := HashPassword("secret123")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$") {
+		t.Fatalf("HashPassword = %q, want argon2id PHC string", argonHash)
+	}
+
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{"argon2id match", argonHash, "secret123", true, false},
+		{"argon2id mismatch", argonHash, "wrong", false, false},
+		{"bcrypt match", string(bcryptHash), "secret123", true, false},
+		{"bcrypt mismatch", string(bcryptHash), "wrong", false, false},
+		{"unknown format", "plaintext", "plaintext", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckPassword(tt.hash, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckPassword error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPassword = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+
+	token, err := signToken(secret, Claims{Issuer: localIssuer, Subject: "u1", ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("signToken: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged, _ := signToken([]byte("other"), Claims{Issuer: localIssuer, Subject: "admin", ExpiresAt: now.Add(time.Hour).Unix()})
+	forgedParts := strings.Split(forged, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", token, now, false},
+		{"expired", token, now.Add(2 * time.Hour), true},
+		{"wrong secret", forged, now, true},
+		{"swapped payload", parts[0] + "." + forgedParts[1] + "." + parts[2], now, true},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + ".", now, true},
+		{"garbage", "not-a-token", now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := parseToken(secret, tt.token, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseToken error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != "u1" {
+				t.Errorf("Subject = %q, want %q", claims.Subject, "u1")
+			}
+		})
+	}
+}
+
+func TestLocalProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := NewLocal(memory.NewStore(), []byte("test-secret"))
+
+	uid, err := provider.CreateUserInAuth(ctx, "Alice@Test.com", "secret123", "Alice")
+	if err != nil {
+		t.Fatalf("CreateUserInAuth: %v", err)
+	}
+
+	if _, err := provider.CreateUserInAuth(ctx, "alice@test.com", "other", "Alice"); !errors.Is(err, ErrEmailExists) {
+		t.Errorf("duplicate CreateUserInAuth error = %v, want ErrEmailExists", err)
+	}
+
+	if _, err := provider.SignInWithPassword(ctx, "alice@test.com", "wrong", Device{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("SignInWithPassword(wrong) error = %v, want ErrInvalidCredentials", err)
+	}
+
+	signIn, err := provider.SignInWithPassword(ctx, "alice@test.com", "secret123", Device{})
+	if err != nil {
+		t.Fatalf("SignInWithPassword: %v", err)
+	}
+	if signIn.UID != uid || signIn.ExpiresIn != "3600" {
+		t.Errorf("SignInWithPassword = %+v, want uid %s and 3600s expiry", signIn, uid)
+	}
+
+	got, err := provider.ValidateIdToken(ctx, signIn.IDToken)
+	if err != nil || got != uid {
+		t.Errorf("ValidateIdToken = %q, %v, want %q", got, err, uid)
+	}
+
+	if _, err := provider.ValidateIdToken(ctx, signIn.RefreshToken); err == nil {
+		t.Error("refresh token was accepted as an ID token")
+	}
+	if _, err := provider.RefreshIdToken(ctx, signIn.IDToken); !errors.Is(err, ErrInvalidRefresh) {
+		t.Errorf("RefreshIdToken(ID token) error = %v, want ErrInvalidRefresh", err)
+	}
+
+	refreshed, err := provider.RefreshIdToken(ctx, signIn.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshIdToken: %v", err)
+	}
+	if got, err := provider.ValidateIdToken(ctx, refreshed.IDToken); err != nil || got != uid {
+		t.Errorf("ValidateIdToken(refreshed) = %q, %v, want %q", got, err, uid)
+	}
+
+	other := NewLocal(memory.NewStore(), []byte("another-secret"))
+	if _, err := other.ValidateIdToken(ctx, signIn.IDToken); err == nil {
+		t.Error("token signed with another secret was accepted")
+	}
+
+	sessions, _ := provider.ListSessions(ctx, uid)
+	if len(sessions) != 1 || sessions[0].ID != signIn.SessionID {
+		t.Fatalf("ListSessions = %+v, want session %s", sessions, signIn.SessionID)
+	}
+
+	if err := provider.RevokeSession(ctx, "someone-else", signIn.SessionID); err == nil {
+		t.Error("RevokeSession allowed revoking another user's session")
+	}
+	if err := provider.RevokeSession(ctx, uid, signIn.SessionID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := provider.ValidateIdToken(ctx, refreshed.IDToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("ValidateIdToken after revoke error = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := provider.RefreshIdToken(ctx, refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefresh) {
+		t.Errorf("RefreshIdToken after revoke error = %v, want ErrInvalidRefresh", err)
+	}
+
+	provider.SignInWithPassword(ctx, "alice@test.com", "secret123", Device{Name: "phone"})
+	provider.SignInWithPassword(ctx, "alice@test.com", "secret123", Device{Name: "laptop"})
+	provider.RevokeAllSessions(ctx, uid)
+	if sessions, _ := provider.ListSessions(ctx, uid); len(sessions) != 0 {
+		t.Errorf("ListSessions after RevokeAllSessions = %+v, want none", sessions)
+	}
+
+	live, _ := provider.SignInWithPassword(ctx, "alice@test.com", "secret123", Device{})
+	provider.DeleteAuthUser(ctx, uid)
+	if _, err := provider.ValidateIdToken(ctx, live.IDToken); err == nil {
+		t.Error("token of deleted user was accepted")
+	}
+	if _, err := provider.RefreshIdToken(ctx, live.RefreshToken); err == nil {
+		t.Error("refresh token of deleted user was accepted")
+	}
+	if _, err := provider.SignInWithPassword(ctx, "alice@test.com", "secret123", Device{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("SignInWithPassword after delete error = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,200 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+const (
+	localIssuer     = "mychat"
+	localTokenTTL   = time.Hour
+	localRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Local is the built-in provider. Password hashes are kept in the
+// store's credentials repository and ID tokens are HS256 JWTs signed
+// with secret, so nothing leaves the server.
+type Local struct {
+	sessions
+	db     database.Store
+	secret []byte
+	now    func() time.Time
+}
+
+var _ Provider = (*Local)(nil)
+
+func NewLocal(db database.Store, secret []byte) *Local {
+	return &Local{sessions: sessions{db: db}, db: db, secret: secret, now: time.Now}
+}
+
+func (l *Local) CreateUserInAuth(ctx context.Context, email, password, name string) (string, error) {
+	email = normalizeEmail(email)
+
+	_, err := l.db.Credentials().GetByEmail(ctx, email)
+	if err == nil {
+		return "", ErrEmailExists
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return "", err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	credential := &database.Credential{
+		UID:          database.NewID(),
+		Email:        email,
+		PasswordHash: hash,
+		CreatedAt:    l.now(),
+	}
+	if err := l.db.Credentials().Save(ctx, credential); err != nil {
+		return "", fmt.Errorf("failed to save credentials: %v", err)
+	}
+
+	return credential.UID, nil
+}
+
+// DeleteAuthUser removes the user's credentials and revokes their
+// sessions, so that tokens already issued stop working too.
+func (l *Local) DeleteAuthUser(ctx context.Context, uid string) error {
+	if err := l.RevokeAllSessions(ctx, uid); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	return l.db.Credentials().Delete(ctx, uid)
+}
+
+func (l *Local) SignInWithPassword(ctx context.Context, email, password string, device Device) (*SignInResult, error) {
+	credential, err := l.db.Credentials().GetByEmail(ctx, normalizeEmail(email))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := CheckPassword(credential.PasswordHash, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check password: %v", err)
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	sessionID := database.NewID()
+	if err := l.start(ctx, sessionID, credential.UID, device, l.now()); err != nil {
+		return nil, err
+	}
+
+	return l.issueTokens(credential.UID, credential.Email, sessionID)
+}
+
+// RefreshIdToken accepts a refresh token issued by SignInWithPassword
+// or a previous refresh and returns a new pair of tokens.
+func (l *Local) RefreshIdToken(ctx context.Context, refreshToken string) (*SignInResult, error) {
+	claims, err := l.parseClaims(refreshToken, refreshTokenType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefresh, err)
+	}
+
+	session, err := l.check(ctx, claims.SessionID, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefresh, err)
+	}
+	l.touch(ctx, session, l.now())
+
+	return l.issueTokens(claims.Subject, claims.Email, claims.SessionID)
+}
+
+func (l *Local) issueTokens(uid, email, sessionID string) (*SignInResult, error) {
+	now := l.now()
+
+	idToken, err := signToken(l.secret, Claims{
+		Type:      idTokenType,
+		Issuer:    localIssuer,
+		Subject:   uid,
+		Email:     email,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(localTokenTTL).Unix(),
+		ID:        database.NewID(),
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	refreshToken, err := signToken(l.secret, Claims{
+		Type:      refreshTokenType,
+		Issuer:    localIssuer,
+		Subject:   uid,
+		Email:     email,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(localRefreshTTL).Unix(),
+		ID:        database.NewID(),
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	return &SignInResult{
+		IDToken:      idToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    strconv.Itoa(int(localTokenTTL.Seconds())),
+		UID:          uid,
+		Email:        email,
+		SessionID:    sessionID,
+	}, nil
+}
+
+func (l *Local) ValidateIdToken(ctx context.Context, idToken string) (string, error) {
+	token, err := l.VerifyIdToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+
+	return token.UID, nil
+}
+
+func (l *Local) VerifyIdToken(ctx context.Context, idToken string) (*Token, error) {
+	claims, err := l.parseClaims(idToken, idTokenType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idToken: %v", err)
+	}
+
+	if _, err := l.check(ctx, claims.SessionID, claims.Subject); err != nil {
+		return nil, fmt.Errorf("invalid idToken: %w", err)
+	}
+
+	return &Token{
+		UID:       claims.Subject,
+		SessionID: claims.SessionID,
+		Expires:   time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+func (l *Local) parseClaims(token, tokenType string) (*Claims, error) {
+	claims, err := parseToken(l.secret, token, l.now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.Type)
+	}
+	if claims.Issuer != localIssuer || claims.Subject == "" {
+		return nil, fmt.Errorf("unexpected issuer or subject")
+	}
+
+	return claims, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
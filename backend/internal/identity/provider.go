@@ -0,0 +1,57 @@
+// Package identity abstracts where users and their passwords live.
+// Firebase delegates to Firebase Authentication, Local keeps password
+// hashes in our own storage and issues JWTs signed by this server, so
+// it works without any outside service.
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	// ErrInvalidCredentials uses the same message as the Identity
+	// Toolkit so the login handler treats both providers alike.
+	ErrInvalidCredentials = errors.New("INVALID_LOGIN_CREDENTIALS")
+	ErrEmailExists        = errors.New("email already exists")
+	ErrInvalidRefresh     = errors.New("INVALID_REFRESH_TOKEN")
+)
+
+// SignInResult is returned by a successful password sign-in.
+type SignInResult struct {
+	IDToken      string
+	RefreshToken string
+	ExpiresIn    string
+	UID          string
+	Email        string
+	SessionID    string
+}
+
+// Token is a verified ID token.
+type Token struct {
+	UID       string
+	SessionID string
+	Expires   time.Time
+}
+
+// Provider manages user credentials and ID tokens.
+type Provider interface {
+	CreateUserInAuth(ctx context.Context, email, password, name string) (string, error)
+	DeleteAuthUser(ctx context.Context, uid string) error
+	// SignInWithPassword starts a new session for device.
+	SignInWithPassword(ctx context.Context, email, password string, device Device) (*SignInResult, error)
+	// RefreshIdToken exchanges a refresh token for a new ID token.
+	// The result may carry a new refresh token that replaces the old one.
+	RefreshIdToken(ctx context.Context, refreshToken string) (*SignInResult, error)
+	// ValidateIdToken and VerifyIdToken reject tokens whose session
+	// has been revoked.
+	ValidateIdToken(ctx context.Context, idToken string) (string, error)
+	VerifyIdToken(ctx context.Context, idToken string) (*Token, error)
+
+	ListSessions(ctx context.Context, uid string) ([]database.Session, error)
+	RevokeSession(ctx context.Context, uid, sessionID string) error
+	RevokeAllSessions(ctx context.Context, uid string) error
+}
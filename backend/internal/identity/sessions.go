@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrSessionRevoked = errors.New("session revoked")
+
+// Device describes where a sign-in came from.
+type Device struct {
+	Name string
+	IP   string
+}
+
+// sessions keeps one database.Session per sign-in. Both providers
+// embed it and look the session up on every token check, so deleting
+// it revokes the tokens that carry its ID.
+type sessions struct {
+	db database.Store
+}
+
+func (s sessions) start(ctx context.Context, id, uid string, device Device, now time.Time) error {
+	err := s.db.Sessions().Save(ctx, &database.Session{
+		ID:         id,
+		UID:        uid,
+		Device:     device.Name,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return nil
+}
+
+func (s sessions) check(ctx context.Context, id, uid string) (*database.Session, error) {
+	session, err := s.db.Sessions().Get(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.UID != uid {
+		return nil, ErrSessionRevoked
+	}
+	return session, nil
+}
+
+func (s sessions) touch(ctx context.Context, session *database.Session, now time.Time) {
+	session.LastUsedAt = now
+	s.db.Sessions().Save(ctx, session)
+}
+
+func (s sessions) ListSessions(ctx context.Context, uid string) ([]database.Session, error) {
+	return s.db.Sessions().ListByUser(ctx, uid)
+}
+
+func (s sessions) RevokeSession(ctx context.Context, uid, sessionID string) error {
+	session, err := s.db.Sessions().Get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UID != uid {
+		return fmt.Errorf("session %s: %w", sessionID, database.ErrNotFound)
+	}
+	return s.db.Sessions().Delete(ctx, sessionID)
+}
+
+func (s sessions) RevokeAllSessions(ctx context.Context, uid string) error {
+	return s.db.Sessions().DeleteByUser(ctx, uid)
+}
@@ -6,12 +6,14 @@ import (
 	"time"
 
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
 )
 
 // Service is responsible for new user registration:
 // creates user in Firebase Auth and saves profile in Firestore.
 type Service struct {
-	db *database.Client
+	db   database.Store
+	auth identity.Provider
 }
 
 type RegisterRequest struct {
@@ -26,33 +28,33 @@ type RegisterResponse struct {
 	Name   string `json:"name"`
 }
 
-func NewService(db *database.Client) *Service {
-	return &Service{db: db}
+func NewService(db database.Store, auth identity.Provider) *Service {
+	return &Service{db: db, auth: auth}
 }
 
 func (s *Service) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
-	authUser, err := s.db.CreateUserInAuth(ctx, req.Email, req.Password, req.Username)
+	uid, err := s.auth.CreateUserInAuth(ctx, req.Email, req.Password, req.Username)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
 	userProfile := &database.User{
-		UID:       authUser.UID,
+		UID:       uid,
 		Name:      req.Username,
 		Email:     req.Email,
 		CreatedAt: time.Now(),
 		IsBanned:  false,
 	}
 
-	err = s.db.SaveUserInFirestore(ctx, userProfile)
+	err = s.db.Users().Save(ctx, userProfile)
 	if err != nil {
-		s.db.DeleteAuthUser(ctx, authUser.UID)
+		s.auth.DeleteAuthUser(ctx, uid)
 		return nil, fmt.Errorf("failed to save user profile: %v", err)
 	}
 
 	return &RegisterResponse{
-		UserID: authUser.UID,
-		Email:  authUser.Email,
+		UserID: uid,
+		Email:  req.Email,
 		Name:   req.Username,
 	}, nil
 }
@@ -0,0 +1,245 @@
+package search
+
+// stemEnglish reduces a lower-case ASCII word to its stem with the
+// Porter algorithm, e.g. "connections" and "connected" to "connect".
+func stemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+
+	p := &porter{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porter holds the word being stemmed: b[0..k] is the current word
+// and j marks the end of the stem once a suffix has been matched.
+type porter struct {
+	b    []byte
+	k, j int
+}
+
+func (p *porter) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m counts the vowel-consonant sequences in b[0..j].
+func (p *porter) m() int {
+	n, i := 0, 0
+	for ; i <= p.j && p.cons(i); i++ {
+	}
+	for {
+		for ; i <= p.j && !p.cons(i); i++ {
+		}
+		if i > p.j {
+			return n
+		}
+		n++
+		for ; i <= p.j && p.cons(i); i++ {
+		}
+		if i > p.j {
+			return n
+		}
+	}
+}
+
+func (p *porter) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec reports whether b[i-1..i] is a double consonant.
+func (p *porter) doublec(i int) bool {
+	return i >= 1 && p.b[i] == p.b[i-1] && p.cons(i)
+}
+
+// cvc reports whether b[i-2..i] is consonant-vowel-consonant and the
+// last consonant is not w, x or y, as in "hop" but not "snow".
+func (p *porter) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	switch p.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (p *porter) ends(suffix string) bool {
+	n := len(suffix)
+	if n > p.k+1 || string(p.b[p.k-n+1:p.k+1]) != suffix {
+		return false
+	}
+	p.j = p.k - n
+	return true
+}
+
+// setTo replaces b[j+1..k] with s.
+func (p *porter) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+// replace swaps the first matching suffix of pairs (suffix,
+// replacement) when the remaining stem has m() > 0.
+func (p *porter) replace(pairs ...string) {
+	for i := 0; i < len(pairs); i += 2 {
+		if p.ends(pairs[i]) {
+			if p.m() > 0 {
+				p.setTo(pairs[i+1])
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing.
+func (p *porter) step1ab() {
+	if p.b[p.k] == 's' {
+		switch {
+		case p.ends("sses"):
+			p.k -= 2
+		case p.ends("ies"):
+			p.setTo("i")
+		case p.b[p.k-1] != 's':
+			p.k--
+		}
+	}
+
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+		return
+	}
+	if !(p.ends("ed") || p.ends("ing")) || !p.vowelInStem() {
+		return
+	}
+
+	p.k = p.j
+	switch {
+	case p.ends("at"):
+		p.setTo("ate")
+	case p.ends("bl"):
+		p.setTo("ble")
+	case p.ends("iz"):
+		p.setTo("ize")
+	case p.doublec(p.k):
+		switch p.b[p.k] {
+		case 'l', 's', 'z':
+		default:
+			p.k--
+		}
+	default:
+		p.j = p.k
+		if p.m() == 1 && p.cvc(p.k) {
+			p.setTo("e")
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel.
+func (p *porter) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, e.g. -ization to -ize.
+func (p *porter) step2() {
+	switch p.b[p.k-1] {
+	case 'a':
+		p.replace("ational", "ate", "tional", "tion")
+	case 'c':
+		p.replace("enci", "ence", "anci", "ance")
+	case 'e':
+		p.replace("izer", "ize")
+	case 'l':
+		p.replace("bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous")
+	case 'o':
+		p.replace("ization", "ize", "ation", "ate", "ator", "ate")
+	case 's':
+		p.replace("alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous")
+	case 't':
+		p.replace("aliti", "al", "iviti", "ive", "biliti", "ble")
+	case 'g':
+		p.replace("logi", "log")
+	}
+}
+
+// step3 handles -ic-, -full, -ness and similar.
+func (p *porter) step3() {
+	switch p.b[p.k] {
+	case 'e':
+		p.replace("icate", "ic", "ative", "", "alize", "al")
+	case 'i':
+		p.replace("iciti", "ic")
+	case 'l':
+		p.replace("ical", "ic", "ful", "")
+	case 's':
+		p.replace("ness", "")
+	}
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step4 removes -ant, -ence and the like when m() > 1.
+func (p *porter) step4() {
+	for _, suffix := range step4Suffixes[p.b[p.k-1]] {
+		if !p.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (p.j < 0 || (p.b[p.j] != 's' && p.b[p.j] != 't')) {
+			continue
+		}
+		if p.m() > 1 {
+			p.k = p.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and reduces -ll to -l when m() > 1.
+func (p *porter) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		if m := p.m(); m > 1 || m == 1 && !p.cvc(p.k-1) {
+			p.k--
+		}
+		return
+	}
+	if p.b[p.k] == 'l' && p.doublec(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
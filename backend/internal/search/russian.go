@@ -0,0 +1,141 @@
+package search
+
+// Ending groups of the Snowball Russian stemmer. Endings of the
+// "afterA" groups only count when preceded by а or я, which stay.
+var (
+	gerundAfterA = []string{"в", "вши", "вшись"}
+	gerund       = []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"}
+
+	adjective = []string{
+		"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+		"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею",
+	}
+	participleAfterA = []string{"ем", "нн", "вш", "ющ", "щ"}
+	participle       = []string{"ивш", "ывш", "ующ"}
+
+	reflexive = []string{"ся", "сь"}
+
+	verbAfterA = []string{
+		"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно",
+	}
+	verb = []string{
+		"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю",
+	}
+
+	noun = []string{
+		"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+		"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я",
+	}
+
+	superlative   = []string{"ейш", "ейше"}
+	derivational  = []string{"ост", "ость"}
+	russianVowels = "аеиоуыэюя"
+)
+
+// stemRussian reduces a lower-case Russian word to its stem with the
+// Snowball algorithm, e.g. "машины" and "машиной" to "машин". ё is
+// expected to be folded to е already.
+func stemRussian(word string) string {
+	w := []rune(word)
+	rv, r2 := russianRegions(w)
+	if rv >= len(w) {
+		return word
+	}
+
+	var ok bool
+	if w, ok = cutEnding(w, rv, gerundAfterA, gerund); !ok {
+		w, _ = cutEnding(w, rv, nil, reflexive)
+		if w, ok = cutEnding(w, rv, nil, adjective); ok {
+			w, _ = cutEnding(w, rv, participleAfterA, participle)
+		} else if w, ok = cutEnding(w, rv, verbAfterA, verb); !ok {
+			w, _ = cutEnding(w, rv, nil, noun)
+		}
+	}
+
+	w, _ = cutEnding(w, rv, nil, []string{"и"})
+	w, _ = cutEnding(w, max(rv, r2), nil, derivational)
+
+	switch {
+	case hasEnding(w, rv, "нн"):
+		w = w[:len(w)-1]
+	default:
+		if w, ok = cutEnding(w, rv, nil, superlative); ok {
+			if hasEnding(w, rv, "нн") {
+				w = w[:len(w)-1]
+			}
+		} else {
+			w, _ = cutEnding(w, rv, nil, []string{"ь"})
+		}
+	}
+
+	return string(w)
+}
+
+// russianRegions returns where RV (after the first vowel) and R2
+// (R1 of R1, where R1 follows the first non-vowel after a vowel)
+// start.
+func russianRegions(w []rune) (rv, r2 int) {
+	rv = len(w)
+	for i, r := range w {
+		if isRussianVowel(r) {
+			rv = i + 1
+			break
+		}
+	}
+
+	r1 := regionAfter(w, 0)
+	return rv, regionAfter(w, r1)
+}
+
+func regionAfter(w []rune, start int) int {
+	for i := start + 1; i < len(w); i++ {
+		if !isRussianVowel(w[i]) && isRussianVowel(w[i-1]) {
+			return i + 1
+		}
+	}
+	return len(w)
+}
+
+func isRussianVowel(r rune) bool {
+	for _, v := range russianVowels {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// cutEnding removes the longest ending of either group that lies
+// within w[start:]. Endings of afterA must follow а or я in that
+// region too.
+func cutEnding(w []rune, start int, afterA, plain []string) ([]rune, bool) {
+	best := 0
+	for _, ending := range afterA {
+		n := len([]rune(ending))
+		if n > best && hasEnding(w, start+1, ending) {
+			if prev := w[len(w)-n-1]; prev == 'а' || prev == 'я' {
+				best = n
+			}
+		}
+	}
+	for _, ending := range plain {
+		if n := len([]rune(ending)); n > best && hasEnding(w, start, ending) {
+			best = n
+		}
+	}
+
+	if best == 0 {
+		return w, false
+	}
+	return w[:len(w)-best], true
+}
+
+// hasEnding reports whether w ends with ending inside w[start:].
+func hasEnding(w []rune, start int, ending string) bool {
+	e := []rune(ending)
+	if len(w)-len(e) < start {
+		return false
+	}
+	return string(w[len(w)-len(e):]) == ending
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"Running", "run"},
+		{"hopping", "hop"},
+		{"connected", "connect"},
+		{"connections", "connect"},
+		{"relational", "relat"},
+		{"generalization", "gener"},
+		{"happy", "happi"},
+		{"машина", "машин"},
+		{"Машины", "машин"},
+		{"машиной", "машин"},
+		{"красивая", "красив"},
+		{"столы", "стол"},
+		{"программирование", "программирован"},
+		{"ёлка", "елк"},
+		{"x2", "x2"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.word); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("Hi, мир! hi")
+	if len(tokens) != 3 || tokens[1].Term != "мир" || tokens[1].Start != 4 || tokens[1].End != 10 {
+		t.Fatalf("Tokenize = %+v, want three tokens with byte offsets", tokens)
+	}
+	if terms := Terms("Hi, мир! hi"); len(terms) != 2 {
+		t.Errorf("Terms = %v, want two distinct terms", terms)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	service := NewService(store)
+
+	own, _ := store.Chats().Create(ctx, &database.Chat{Name: "Team", Participants: []string{"u1", "u2"}})
+	other, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u2", "u3"}})
+	now := time.Now()
+	msgs := map[string]*database.Message{
+		"m1": {ID: "m1", ChatID: own, SenderID: "u2", Text: "Meeting about машины", Timestamp: now},
+		"m2": {ID: "m2", ChatID: own, SenderID: "u2", Text: "the machines are meeting", Timestamp: now.Add(time.Second)},
+		"m3": {ID: "m3", ChatID: own, SenderID: "u2", Text: "hidden meeting", Timestamp: now, HiddenFor: []string{"u1"}},
+		"m4": {ID: "m4", ChatID: other, SenderID: "u2", Text: "secret meeting", Timestamp: now},
+	}
+	for _, msg := range msgs {
+		store.Messages().Add(ctx, msg.ChatID, msg)
+		if err := service.Index(ctx, msg.ChatID, msg); err != nil {
+			t.Fatalf("Index: %v", err)
+		}
+	}
+
+	hits, err := service.Search(ctx, "u1", "meetings", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 2 || hits[0].MessageID != "m2" || hits[0].ChatName != "Team" {
+		t.Fatalf("Search(meetings) = %+v, want m2 then m1 from Team", hits)
+	}
+	want := []Fragment{{Text: "the machines are "}, {Text: "meeting", Match: true}}
+	if len(hits[0].Highlights) != 2 || hits[0].Highlights[0] != want[0] || hits[0].Highlights[1] != want[1] {
+		t.Errorf("Highlights = %+v, want %+v", hits[0].Highlights, want)
+	}
+
+	if hits, _ := service.Search(ctx, "u1", "МАШИНА meeting", 10); len(hits) != 1 || hits[0].MessageID != "m1" {
+		t.Errorf("Search(МАШИНА meeting) = %+v, want m1", hits)
+	}
+
+	// An edit that did not reach the index is not reported.
+	store.Messages().Update(ctx, own, "m1", func(msg *database.Message) error {
+		msg.Text = "changed"
+		return nil
+	})
+	if hits, _ := service.Search(ctx, "u1", "машина", 10); len(hits) != 0 {
+		t.Errorf("Search after edit = %+v, want none", hits)
+	}
+
+	if _, err := service.Search(ctx, "u1", "?!", 10); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("Search(?!) error = %v, want ErrEmptyQuery", err)
+	}
+
+	store.Search().Clear(ctx)
+	indexed, err := service.Rebuild(ctx)
+	if err != nil || indexed != 4 {
+		t.Fatalf("Rebuild = %d, %v; want 4 messages", indexed, err)
+	}
+	if hits, _ := service.Search(ctx, "u3", "secret", 10); len(hits) != 1 {
+		t.Errorf("Search after Rebuild = %+v, want m4", hits)
+	}
+}
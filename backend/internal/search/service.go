@@ -0,0 +1,206 @@
+// Package search keeps a full-text index of message text. Words are
+// case-folded and stemmed (English and Russian) into terms, and a
+// message matches a query when it has every term of the query.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var ErrEmptyQuery = errors.New("search query has no words")
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+type Service struct {
+	db database.Store
+}
+
+// Hit is a message matching a query.
+type Hit struct {
+	ChatID    string    `json:"chat_id"`
+	ChatName  string    `json:"chat_name"`
+	MessageID string    `json:"message_id"`
+	SenderID  string    `json:"sender_id"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+	// Highlights splits Text into consecutive fragments and marks
+	// the words that matched the query.
+	Highlights []Fragment `json:"highlights"`
+}
+
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+func NewService(db database.Store) *Service {
+	return &Service{db: db}
+}
+
+// Index stores the terms of msg, or removes it from the index when
+// it was deleted or has no words left.
+func (s *Service) Index(ctx context.Context, chatID string, msg *database.Message) error {
+	terms := Terms(msg.Text)
+	if msg.Deleted() || len(terms) == 0 {
+		return s.db.Search().Remove(ctx, chatID, msg.ID)
+	}
+
+	return s.db.Search().Index(ctx, &database.SearchEntry{
+		ChatID:    chatID,
+		MessageID: msg.ID,
+		Terms:     terms,
+		Timestamp: msg.Timestamp,
+	})
+}
+
+// Search returns up to limit messages from uid's chats that contain
+// every word of query, newest first.
+func (s *Service) Search(ctx context.Context, uid, query string, limit int) ([]Hit, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	chats, err := s.db.Chats().ListByParticipant(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chats: %v", err)
+	}
+
+	chatIDs := make([]string, 0, len(chats))
+	names := make(map[string]string, len(chats))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+		names[chat.ID] = chat.Name
+	}
+
+	// Subscribed channels are searched too.
+	subscriptions, err := s.db.Subscriptions().ListByUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %v", err)
+	}
+	for _, subscription := range subscriptions {
+		chat, err := s.db.Chats().Get(ctx, subscription.ChatID)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chat %s: %v", subscription.ChatID, err)
+		}
+		chatIDs = append(chatIDs, chat.ID)
+		names[chat.ID] = chat.Name
+	}
+
+	entries, err := s.db.Search().Query(ctx, chatIDs, terms, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query search index: %v", err)
+	}
+
+	hits := []Hit{}
+	for _, entry := range entries {
+		msg, err := s.db.Messages().Get(ctx, entry.ChatID, entry.MessageID)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load message %s: %v", entry.MessageID, err)
+		}
+		if msg.Deleted() || msg.HiddenFrom(uid) {
+			continue
+		}
+
+		// The index can lag behind an edit; only report what the
+		// message says now.
+		fragments, ok := highlight(msg.Text, terms)
+		if !ok {
+			continue
+		}
+
+		hits = append(hits, Hit{
+			ChatID:     entry.ChatID,
+			ChatName:   names[entry.ChatID],
+			MessageID:  msg.ID,
+			SenderID:   msg.SenderID,
+			Text:       msg.Text,
+			Timestamp:  msg.Timestamp,
+			Highlights: fragments,
+		})
+	}
+
+	return hits, nil
+}
+
+// Rebuild empties the index and indexes every message of every chat
+// again. It returns the number of messages indexed.
+func (s *Service) Rebuild(ctx context.Context) (int, error) {
+	if err := s.db.Search().Clear(ctx); err != nil {
+		return 0, fmt.Errorf("failed to clear search index: %v", err)
+	}
+
+	chats, err := s.db.Chats().List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chats: %v", err)
+	}
+
+	indexed := 0
+	for _, chat := range chats {
+		messages, err := s.db.Messages().List(ctx, chat.ID)
+		if err != nil {
+			return indexed, fmt.Errorf("failed to list messages of chat %s: %v", chat.ID, err)
+		}
+
+		for i := range messages {
+			msg := &messages[i]
+			if msg.Deleted() || len(Terms(msg.Text)) == 0 {
+				continue
+			}
+			if err := s.Index(ctx, chat.ID, msg); err != nil {
+				return indexed, fmt.Errorf("failed to index message %s: %v", msg.ID, err)
+			}
+			indexed++
+		}
+	}
+
+	return indexed, nil
+}
+
+// highlight splits text into fragments, marking the words whose term
+// is one of terms. It reports whether every term was found.
+func highlight(text string, terms []string) ([]Fragment, bool) {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = false
+	}
+
+	fragments := []Fragment{}
+	pos := 0
+	for _, token := range Tokenize(text) {
+		if _, ok := wanted[token.Term]; !ok {
+			continue
+		}
+		wanted[token.Term] = true
+
+		if token.Start > pos {
+			fragments = append(fragments, Fragment{Text: text[pos:token.Start]})
+		}
+		fragments = append(fragments, Fragment{Text: text[token.Start:token.End], Match: true})
+		pos = token.End
+	}
+	if pos < len(text) {
+		fragments = append(fragments, Fragment{Text: text[pos:]})
+	}
+
+	for _, found := range wanted {
+		if !found {
+			return nil, false
+		}
+	}
+	return fragments, true
+}
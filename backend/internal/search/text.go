@@ -0,0 +1,88 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxTermBytes drops tokens that are too long to be words, such as
+// pasted hashes or base64.
+const maxTermBytes = 64
+
+// Token is a word of a text with its byte offsets and normalized term.
+type Token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// Tokenize splits text into runs of letters and digits and
+// normalizes each of them with Normalize.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range text + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if term := Normalize(text[start:i]); term != "" {
+				tokens = append(tokens, Token{Term: term, Start: start, End: i})
+			}
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// Terms returns the distinct terms of text in order of appearance.
+func Terms(text string) []string {
+	terms := []string{}
+	seen := make(map[string]bool)
+	for _, token := range Tokenize(text) {
+		if !seen[token.Term] {
+			seen[token.Term] = true
+			terms = append(terms, token.Term)
+		}
+	}
+	return terms
+}
+
+// Normalize case-folds a word and stems it as Russian when it has
+// Cyrillic letters, or as English when it is plain ASCII. Anything
+// else is only folded.
+func Normalize(word string) string {
+	if len(word) > maxTermBytes {
+		return ""
+	}
+
+	word = strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+	switch {
+	case isCyrillic(word):
+		return stemRussian(word)
+	case isASCIILetters(word):
+		return stemEnglish(word)
+	}
+	return word
+}
+
+func isCyrillic(word string) bool {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCIILetters(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,386 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNotChannel     = errors.New("chat is not a channel")
+	ErrPrivateChannel = errors.New("this channel can only be joined by an invite link")
+	ErrNotSubscribed  = errors.New("you are not subscribed to this channel")
+	ErrInvalidHandle  = errors.New("handle must be 5 to 32 lowercase latin letters, digits or underscores")
+	ErrHandleTaken    = database.ErrHandleTaken
+)
+
+// handlePattern is what a channel handle may look like once
+// normalized.
+var handlePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{4,31}$`)
+
+const (
+	// maxViewedPosts bounds the posts one view report counts.
+	maxViewedPosts = 100
+	// subscriberBatch is the page size used to walk a channel's
+	// subscribers.
+	subscriberBatch = 500
+)
+
+// NormalizeHandle strips a leading @ and folds case. It returns
+// ErrInvalidHandle for anything that is not a valid handle.
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+	if !handlePattern.MatchString(handle) {
+		return "", ErrInvalidHandle
+	}
+	return handle, nil
+}
+
+// CreateChannel makes userID the owner of a new channel. A non-empty
+// handle makes it public.
+func (s *Server) CreateChannel(ctx context.Context, userID, name, handle string) (*database.Chat, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxChatNameLength {
+		return nil, ErrInvalidName
+	}
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	chat := &database.Chat{
+		Name:         name,
+		Type:         "channel",
+		Participants: []string{userID},
+		CreatedBy:    userID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if _, err := s.db.Chats().Create(ctx, chat); err != nil {
+		return nil, fmt.Errorf("failed to create channel: %v", err)
+	}
+
+	if handle != "" {
+		if err := s.db.Chats().SetHandle(ctx, chat.ID, handle); err != nil {
+			if deleteErr := s.db.Chats().Delete(ctx, chat.ID); deleteErr != nil {
+				log.Printf("Failed to drop channel %s: %v", chat.ID, deleteErr)
+			}
+			if errors.Is(err, database.ErrHandleTaken) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to set handle: %v", err)
+		}
+		chat.Handle = handle
+	}
+
+	err := s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatCreated,
+		ChatID: chat.ID,
+		UserID: userID,
+	}, []string{userID})
+	if err != nil {
+		log.Printf("Failed to record chat_created change: %v", err)
+	}
+	s.syncChatListener(chat.ID)
+
+	return chat, nil
+}
+
+// SetHandle changes or, with an empty handle, clears the handle of a
+// channel. The caller needs the change_settings permission.
+func (s *Server) SetHandle(ctx context.Context, userID, chatID, handle string) (*database.Chat, error) {
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return nil, err
+		}
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermChangeSettings)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Type != "channel" {
+		return nil, ErrNotChannel
+	}
+	if chat.Handle == handle {
+		return chat, nil
+	}
+
+	if err := s.db.Chats().SetHandle(ctx, chatID, handle); err != nil {
+		if errors.Is(err, database.ErrHandleTaken) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to set handle: %v", err)
+	}
+	chat.Handle = handle
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:   database.ChangeChatUpdated,
+		ChatID: chatID,
+		UserID: userID,
+	})
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "chat_handle_changed",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"chat_id":    chatID,
+			"handle":     handle,
+			"changed_by": userID,
+		},
+	}, "")
+
+	return chat, nil
+}
+
+// Subscribe makes userID a subscriber of a public channel. Channels
+// without a handle are joined through invite links instead.
+func (s *Server) Subscribe(ctx context.Context, userID, chatID string) (*database.Chat, error) {
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrNotChannel
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+	if chat.Type != "channel" {
+		return nil, ErrNotChannel
+	}
+	if chat.Handle == "" && !slices.Contains(chat.Participants, userID) {
+		return nil, ErrPrivateChannel
+	}
+
+	return s.subscribe(ctx, chat, userID)
+}
+
+// Unsubscribe stops userID from following a channel.
+func (s *Server) Unsubscribe(ctx context.Context, userID, chatID string) error {
+	removed, err := s.db.Subscriptions().Unsubscribe(ctx, chatID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe: %v", err)
+	}
+	if !removed {
+		return ErrNotSubscribed
+	}
+	if err := s.db.ChatSettings().Delete(ctx, userID, chatID); err != nil {
+		log.Printf("Failed to drop chat settings of %s: %v", userID, err)
+	}
+
+	err = s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatLeft,
+		ChatID: chatID,
+		UserID: userID,
+	}, []string{userID})
+	if err != nil {
+		log.Printf("Failed to record chat_left change: %v", err)
+	}
+
+	s.removeOnlineSubscriber(chatID, userID)
+	s.syncChatListener(chatID)
+	return nil
+}
+
+// subscribe adds uid to the channel's subscribers and sends them
+// chat_created. Participants of the channel are left as they are.
+func (s *Server) subscribe(ctx context.Context, chat *database.Chat, uid string) (*database.Chat, error) {
+	if slices.Contains(chat.Participants, uid) {
+		return chat, nil
+	}
+
+	created, err := s.db.Subscriptions().Subscribe(ctx, chat.ID, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %v", err)
+	}
+
+	chat, err = s.db.Chats().Get(ctx, chat.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+	if !created {
+		return chat, nil
+	}
+
+	err = s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatCreated,
+		ChatID: chat.ID,
+		UserID: uid,
+	}, []string{uid})
+	if err != nil {
+		log.Printf("Failed to record chat_created change: %v", err)
+	}
+
+	if s.isOnline(uid) {
+		s.addOnlineSubscriber(chat.ID, uid)
+	}
+	s.SendChatCreated(chat.ID, chatCreatedData(chat), []string{uid})
+	s.syncChatListener(chat.ID)
+
+	return chat, nil
+}
+
+// AuthorizeRead loads a chat that userID may read, see canRead. It is
+// the check every read of chat history goes through.
+func (s *Server) AuthorizeRead(ctx context.Context, userID, chatID string) (*database.Chat, error) {
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrNotParticipant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+
+	ok, err := s.canRead(ctx, chat, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotParticipant
+	}
+	return chat, nil
+}
+
+// canRead reports whether uid may read the chat: as a participant or,
+// for a channel, as a subscriber.
+func (s *Server) canRead(ctx context.Context, chat *database.Chat, uid string) (bool, error) {
+	if chat.Can(uid, database.PermRead) {
+		return true, nil
+	}
+	if chat.Type != "channel" {
+		return false, nil
+	}
+
+	_, err := s.db.Subscriptions().Get(ctx, chat.ID, uid)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load subscription: %v", err)
+	}
+	return true, nil
+}
+
+// ViewPosts records that a subscriber has seen the channel up to
+// messageID. Every post between their previous position and this one
+// gets a view, at most maxViewedPosts of them, so that each subscriber
+// counts once per post. Views by participants are not counted.
+func (s *Server) ViewPosts(ctx context.Context, userID, chatID, messageID string) error {
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load chat: %v", err)
+	}
+	if chat.Type != "channel" {
+		return ErrNotChannel
+	}
+
+	msg, err := s.db.Messages().Get(ctx, chatID, messageID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load message: %v", err)
+	}
+
+	previous, err := s.db.Subscriptions().AdvanceViewed(ctx, chatID, userID, msg.Timestamp)
+	if errors.Is(err, database.ErrNotFound) {
+		if slices.Contains(chat.Participants, userID) {
+			return nil
+		}
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record view: %v", err)
+	}
+	if !msg.Timestamp.After(previous) {
+		return nil
+	}
+
+	cursor := msg.Cursor()
+	earlier, err := s.db.Messages().ListPage(ctx, chatID, database.MessagePage{
+		Before:   &cursor,
+		Limit:    maxViewedPosts,
+		ThreadID: msg.ThreadID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list posts: %v", err)
+	}
+
+	ids := []string{msg.ID}
+	for _, post := range earlier {
+		if post.Timestamp.After(previous) {
+			ids = append(ids, post.ID)
+		}
+	}
+
+	if err := s.db.Messages().AddViews(ctx, chatID, ids); err != nil {
+		return fmt.Errorf("failed to count views: %v", err)
+	}
+	return nil
+}
+
+// forEachSubscriber calls fn with the subscribers of a channel, page
+// by page.
+func (s *Server) forEachSubscriber(ctx context.Context, chatID string, fn func(uids []string)) error {
+	after := ""
+	for {
+		page, err := s.db.Subscriptions().ListByChat(ctx, chatID, after, subscriberBatch)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		uids := make([]string, len(page))
+		for i, subscription := range page {
+			uids[i] = subscription.UserID
+		}
+		fn(uids)
+
+		if len(page) < subscriberBatch {
+			return nil
+		}
+		after = uids[len(uids)-1]
+	}
+}
+
+func (s *Server) isOnline(uid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.clients[uid]
+	return ok
+}
+
+func (s *Server) addOnlineSubscriber(chatID, uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscribers[chatID] == nil {
+		s.subscribers[chatID] = make(map[string]bool)
+	}
+	s.subscribers[chatID][uid] = true
+}
+
+func (s *Server) removeOnlineSubscriber(chatID, uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers[chatID], uid)
+	if len(s.subscribers[chatID]) == 0 {
+		delete(s.subscribers, chatID)
+	}
+}
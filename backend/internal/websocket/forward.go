@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNothingToForward = errors.New("message_ids and target_chat_ids are required")
+	ErrTooManyForwards  = errors.New("too many messages or target chats")
+)
+
+const (
+	maxForwardMessages = 100
+	maxForwardTargets  = 20
+)
+
+// ForwardResult reports the copies created in one target chat.
+type ForwardResult struct {
+	ChatID     string   `json:"chat_id"`
+	MessageIDs []string `json:"message_ids"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// ForwardMessages copies messages from fromChatID into every target
+// chat. The user must take part in all of them. Each target receives
+// all copies in one write, so a failure in one chat leaves the others
+// untouched and is reported in its result.
+func (s *Server) ForwardMessages(ctx context.Context, userID, fromChatID string, messageIDs, targetChatIDs []string) ([]ForwardResult, error) {
+	messageIDs = uniqueIDs(messageIDs)
+	targetChatIDs = uniqueIDs(targetChatIDs)
+	if len(messageIDs) == 0 || len(targetChatIDs) == 0 {
+		return nil, ErrNothingToForward
+	}
+	if len(messageIDs) > maxForwardMessages || len(targetChatIDs) > maxForwardTargets {
+		return nil, ErrTooManyForwards
+	}
+
+	if _, err := s.authorize(ctx, userID, fromChatID, database.PermRead); err != nil {
+		return nil, err
+	}
+	for _, chatID := range targetChatIDs {
+		if _, err := s.authorize(ctx, userID, chatID, database.PermSend); err != nil {
+			return nil, err
+		}
+	}
+
+	originals := make([]*database.Message, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		msg, err := s.db.Messages().Get(ctx, fromChatID, id)
+		if err != nil {
+			return nil, messageError(err)
+		}
+		if msg.HiddenFrom(userID) {
+			return nil, ErrMessageNotFound
+		}
+		if msg.Deleted() {
+			return nil, ErrMessageDeleted
+		}
+		originals = append(originals, msg)
+	}
+
+	results := make([]ForwardResult, 0, len(targetChatIDs))
+	for _, chatID := range targetChatIDs {
+		copies, err := s.forwardTo(ctx, userID, chatID, originals)
+		result := ForwardResult{ChatID: chatID, MessageIDs: []string{}}
+		if err != nil {
+			log.Printf("Failed to forward messages from chat %s to %s: %v", fromChatID, chatID, err)
+			result.Error = "Failed to forward messages"
+		}
+		for _, msg := range copies {
+			result.MessageIDs = append(result.MessageIDs, msg.ID)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (s *Server) forwardTo(ctx context.Context, userID, chatID string, originals []*database.Message) ([]*database.Message, error) {
+	now := time.Now()
+	copies := make([]*database.Message, len(originals))
+	// copied collects the attachment records created for the copies,
+	// removed again if the copies are not saved.
+	var copied []database.MessageAttachment
+	for i, original := range originals {
+		origin := original.ForwardedFrom
+		if origin == nil {
+			origin = &database.MessageOrigin{
+				ChatID:    original.ChatID,
+				MessageID: original.ID,
+				SenderID:  original.SenderID,
+				Timestamp: original.Timestamp,
+			}
+		}
+
+		attachments, err := s.copyAttachments(ctx, userID, chatID, original.Attachments)
+		if err != nil {
+			s.removeAttachments(ctx, copied)
+			return nil, err
+		}
+		copied = append(copied, attachments...)
+		copies[i] = &database.Message{
+			SenderID:    userID,
+			Text:        original.Text,
+			Attachments: attachments,
+			// Spread the copies so that they keep their order in the
+			// target chat's timeline.
+			Timestamp:     now.Add(time.Duration(i) * time.Microsecond),
+			ReadBy:        []string{userID},
+			ForwardedFrom: origin,
+		}
+	}
+
+	if err := s.db.Messages().AddAll(ctx, chatID, copies); err != nil {
+		s.removeAttachments(ctx, copied)
+		return nil, fmt.Errorf("failed to save forwarded messages: %v", err)
+	}
+
+	if err := s.db.Chats().SetLastMessage(ctx, chatID, copies[len(copies)-1]); err != nil {
+		log.Printf("Failed to update last_message: %v", err)
+	}
+
+	for _, msg := range copies {
+		s.indexMessage(ctx, chatID, msg)
+		s.recordChatChange(ctx, &database.Change{
+			Type:      database.ChangeMessageNew,
+			ChatID:    chatID,
+			MessageID: msg.ID,
+			UserID:    userID,
+		})
+		s.BroadcastToChat(chatID, WSEvent{
+			Type:   "new_message",
+			ChatID: chatID,
+			Data:   messageData(msg),
+		}, "")
+	}
+
+	return copies, nil
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// copyAttachments registers the files of a forwarded message with the
+// target chat, so that its participants may download them. The blobs
+// themselves are shared. On failure the records already created are
+// removed.
+func (s *Server) copyAttachments(ctx context.Context, userID, chatID string, refs []database.MessageAttachment) ([]database.MessageAttachment, error) {
+	var result []database.MessageAttachment
+	for _, ref := range refs {
+		original, err := s.db.Attachments().Get(ctx, ref.ID)
+		if err != nil {
+			s.removeAttachments(ctx, result)
+			return nil, fmt.Errorf("failed to load attachment %s: %v", ref.ID, err)
+		}
+
+		attachment := *original
+		attachment.ID = ""
+		attachment.ChatID = chatID
+		attachment.UploaderID = userID
+		attachment.CreatedAt = time.Now()
+		if err := s.db.Attachments().Create(ctx, &attachment); err != nil {
+			s.removeAttachments(ctx, result)
+			return nil, fmt.Errorf("failed to copy attachment %s: %v", ref.ID, err)
+		}
+		result = append(result, attachment.Ref())
+	}
+	return result, nil
+}
@@ -2,11 +2,13 @@ package websocket
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"time"
 
-	"cloud.google.com/go/firestore"
+	"MyChatServer/internal/database"
 )
 
 func (s *Server) handleSendMessage(userID string, event WSEvent) {
@@ -20,28 +22,53 @@ func (s *Server) handleSendMessage(userID string, event WSEvent) {
 		return
 	}
 
-	chatID, chatOK := data["chat_id"].(string)
-	text, textOK := data["text"].(string)
+	chatID, _ := data["chat_id"].(string)
+	text, _ := data["text"].(string)
+	attachmentIDs := stringList(data["attachment_ids"])
 
-	if !chatOK || !textOK || chatID == "" || text == "" {
+	if chatID == "" || (text == "" && len(attachmentIDs) == 0) {
 		s.SendToUser(userID, WSEvent{
 			Type: "error",
-			Data: map[string]string{"error": "chat_id and text are required"},
+			Data: map[string]string{"error": "chat_id and text or attachment_ids are required"},
 		})
 		return
 	}
 
-	isParticipant, err := s.isUserInChat(userID, chatID)
-	if err != nil || !isParticipant {
-		log.Printf("User %s is not in chat %s", userID, chatID)
-		s.SendToUser(userID, WSEvent{
-			Type: "error",
-			Data: map[string]string{"error": "Not a chat participant"},
-		})
+	if _, err := s.authorize(context.Background(), userID, chatID, database.PermSend); err != nil {
+		log.Printf("User %s cannot send to chat %s: %v", userID, chatID, err)
+		s.sendError(userID, err.Error())
+		return
+	}
+
+	tempID, _ := data["temp_id"].(string)
+	if len(tempID) > maxTempIDLength {
+		s.sendError(userID, ErrInvalidTempID.Error())
+		return
+	}
+	replyTo, _ := data["reply_to"].(string)
+	threadID, _ := data["thread_id"].(string)
+	if err := s.validateReply(context.Background(), chatID, replyTo, threadID); err != nil {
+		s.sendError(userID, err.Error())
 		return
 	}
 
-	messageID, err := s.saveMessageToFirestore(chatID, userID, text)
+	attachments, err := s.resolveAttachments(context.Background(), userID, chatID, attachmentIDs)
+	if err != nil {
+		s.sendError(userID, err.Error())
+		return
+	}
+
+	message, err := s.saveMessageToFirestore(chatID, userID, text, replyTo, threadID, tempID, attachments)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		// A retry of a send that already went through: acknowledge
+		// the stored message again and leave everything else alone.
+		existing, err := s.db.Messages().Get(context.Background(), chatID, clientMessageID(userID, tempID))
+		if err == nil && existing.SenderID == userID {
+			s.sendMessageSent(userID, chatID, existing)
+			return
+		}
+		log.Printf("Failed to load resent message from user %s: %v", userID, err)
+	}
 	if err != nil {
 		log.Printf("Failed to save message from user %s: %v", userID, err)
 		s.SendToUser(userID, WSEvent{
@@ -53,65 +80,216 @@ func (s *Server) handleSendMessage(userID string, event WSEvent) {
 
 	log.Printf("User %s sent message to chat %s: %s", userID, chatID, text)
 
-	s.SendToUser(userID, WSEvent{
-		Type: "message_sent",
-		Data: map[string]string{
-			"message_id": messageID,
-			"chat_id":    chatID,
-		},
-	})
-
-	messageData := map[string]interface{}{
-		"id":        messageID,
-		"chat_id":   chatID,
-		"sender_id": userID,
-		"text":      text,
-		"timestamp": time.Now(),
-	}
+	s.sendMessageSent(userID, chatID, message)
 
 	broadcastEvent := WSEvent{
 		Type:   "new_message",
 		ChatID: chatID,
-		Data:   messageData,
+		Data:   messageData(message),
 	}
 
 	s.BroadcastToChat(chatID, broadcastEvent, userID)
 
 }
 
-func (s *Server) saveMessageToFirestore(chatID, userID, text string) (string, error) {
+// sendMessageSent acknowledges a stored message to its sender.
+func (s *Server) sendMessageSent(userID, chatID string, message *database.Message) {
+	data := map[string]string{
+		"message_id": message.ID,
+		"chat_id":    chatID,
+	}
+	if message.TempID != "" {
+		data["temp_id"] = message.TempID
+	}
+
+	s.SendToUser(userID, WSEvent{
+		Type: "message_sent",
+		Data: data,
+	})
+}
+
+// saveMessageToFirestore stores a new message. Messages posted in a
+// thread bump the root's reply counters instead of the chat's
+// last_message. A non-empty tempID fixes the message ID: storing the
+// same send twice fails with database.ErrAlreadyExists before any of
+// that happens.
+func (s *Server) saveMessageToFirestore(chatID, userID, text, replyTo, threadID, tempID string, attachments []database.MessageAttachment) (*database.Message, error) {
 	ctx := context.Background()
 
-	message := map[string]interface{}{
-		"sender_id": userID,
-		"text":      text,
-		"timestamp": firestore.ServerTimestamp,
-		"read_by":   []string{userID},
+	message := &database.Message{
+		SenderID:    userID,
+		Text:        text,
+		Timestamp:   time.Now(),
+		ReadBy:      []string{userID},
+		ReplyTo:     replyTo,
+		ThreadID:    threadID,
+		Attachments: attachments,
+		Mentions:    s.resolveMentions(ctx, chatID, userID, text),
+	}
+	if tempID != "" {
+		message.ID = clientMessageID(userID, tempID)
+		message.TempID = tempID
 	}
 
-	docRef, _, err := s.db.Firestore.Collection("chats").Doc(chatID).
-		Collection("messages").Add(ctx, message)
-
+	messageID, err := s.db.Messages().Add(ctx, chatID, message)
 	if err != nil {
-		return "", fmt.Errorf("failed to save message: %v", err)
+		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
 
-	_, err = s.db.Firestore.Collection("chats").Doc(chatID).Update(ctx, []firestore.Update{
-		{
-			Path:  "last_message",
-			Value: message,
-		},
-		{
-			Path:  "updated_at",
-			Value: firestore.ServerTimestamp,
-		},
+	s.indexMessage(ctx, chatID, message)
+
+	if threadID == "" {
+		err = s.db.Chats().SetLastMessage(ctx, chatID, message)
+		if err != nil {
+			log.Printf("Failed to update last_message: %v", err)
+		}
+	} else {
+		s.bumpThread(ctx, chatID, threadID, message.Timestamp)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageNew,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
 	})
+	s.notifyMentions(ctx, chatID, message)
+
+	return message, nil
+}
 
+// recordChatChange adds change to the sync journal of every
+// participant of the chat. Failures are only logged: the journal
+// must never block the write it describes.
+func (s *Server) recordChatChange(ctx context.Context, change *database.Change) {
+	participants, err := s.getChatParticipants(change.ChatID)
 	if err != nil {
-		log.Printf("Failed to update last_message: %v", err)
+		log.Printf("Failed to record %s change: %v", change.Type, err)
+		return
 	}
 
-	return docRef.ID, nil
+	if err := s.db.Changes().Append(ctx, change, participants); err != nil {
+		log.Printf("Failed to record %s change: %v", change.Type, err)
+	}
+}
+
+// indexMessage brings the search index up to date with msg. Like the
+// journal, the index never blocks the write it follows.
+func (s *Server) indexMessage(ctx context.Context, chatID string, msg *database.Message) {
+	if err := s.search.Index(ctx, chatID, msg); err != nil {
+		log.Printf("Failed to index message %s: %v", msg.ID, err)
+	}
+}
+
+func (s *Server) handleEditMessage(userID string, event WSEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.sendError(userID, "Invalid message format")
+		return
+	}
+
+	chatID, _ := data["chat_id"].(string)
+	messageID, _ := data["message_id"].(string)
+	text, _ := data["text"].(string)
+	if chatID == "" || messageID == "" {
+		s.sendError(userID, "chat_id and message_id are required")
+		return
+	}
+
+	if _, err := s.EditMessage(context.Background(), userID, chatID, messageID, text); err != nil {
+		log.Printf("Failed to edit message %s from user %s: %v", messageID, userID, err)
+		s.sendError(userID, err.Error())
+	}
+}
+
+func (s *Server) handleDeleteMessage(userID string, event WSEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.sendError(userID, "Invalid message format")
+		return
+	}
+
+	chatID, _ := data["chat_id"].(string)
+	messageID, _ := data["message_id"].(string)
+	forEveryone, _ := data["for_everyone"].(bool)
+	if chatID == "" || messageID == "" {
+		s.sendError(userID, "chat_id and message_id are required")
+		return
+	}
+
+	if err := s.DeleteMessage(context.Background(), userID, chatID, messageID, forEveryone); err != nil {
+		log.Printf("Failed to delete message %s from user %s: %v", messageID, userID, err)
+		s.sendError(userID, err.Error())
+	}
+}
+
+func (s *Server) handleReaction(userID string, event WSEvent, add bool) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.sendError(userID, "Invalid message format")
+		return
+	}
+
+	chatID, _ := data["chat_id"].(string)
+	messageID, _ := data["message_id"].(string)
+	emoji, _ := data["emoji"].(string)
+	if chatID == "" || messageID == "" {
+		s.sendError(userID, "chat_id and message_id are required")
+		return
+	}
+
+	if _, err := s.React(context.Background(), userID, chatID, messageID, emoji, add); err != nil {
+		log.Printf("Failed to update reaction on message %s from user %s: %v", messageID, userID, err)
+		s.sendError(userID, err.Error())
+	}
+}
+
+func (s *Server) handleForwardMessages(userID string, event WSEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.sendError(userID, "Invalid message format")
+		return
+	}
+
+	chatID, _ := data["chat_id"].(string)
+	if chatID == "" {
+		s.sendError(userID, "chat_id is required")
+		return
+	}
+
+	results, err := s.ForwardMessages(context.Background(), userID, chatID,
+		stringList(data["message_ids"]), stringList(data["target_chat_ids"]))
+	if err != nil {
+		log.Printf("Failed to forward messages from user %s: %v", userID, err)
+		s.sendError(userID, err.Error())
+		return
+	}
+
+	s.SendToUser(userID, WSEvent{
+		Type:   "messages_forwarded",
+		ChatID: chatID,
+		Data:   map[string]interface{}{"results": results},
+	})
+}
+
+// stringList reads a JSON array of strings from a decoded event,
+// skipping anything that is not a string.
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (s *Server) sendError(userID, message string) {
+	s.SendToUser(userID, WSEvent{
+		Type: "error",
+		Data: map[string]string{"error": message},
+	})
 }
 
 func (s *Server) handleTyping(userID string, event WSEvent) {
@@ -127,8 +305,7 @@ func (s *Server) handleTyping(userID string, event WSEvent) {
 		return
 	}
 
-	isParticipant, err := s.isUserInChat(userID, chatID)
-	if err != nil || !isParticipant {
+	if _, err := s.authorize(context.Background(), userID, chatID, database.PermSend); err != nil {
 		return
 	}
 
@@ -159,24 +336,52 @@ func (s *Server) handleMessageRead(userID string, event WSEvent) {
 		return
 	}
 
-	isParticipant, err := s.isUserInChat(userID, chatID)
-	if err != nil || !isParticipant {
+	ctx := context.Background()
+	if _, err := s.authorize(ctx, userID, chatID, database.PermRead); err != nil {
 		return
 	}
 
-	ctx := context.Background()
-	messageRef := s.db.Firestore.Collection("chats").Doc(chatID).
-		Collection("messages").Doc(messageID)
-
-	_, err = messageRef.Update(ctx, []firestore.Update{
-		{
-			Path:  "read_by",
-			Value: firestore.ArrayUnion(userID),
-		},
-	})
+	msg, err := s.db.Messages().Get(ctx, chatID, messageID)
+	if err != nil {
+		log.Printf("Failed to mark message as read: %v", err)
+		return
+	}
 
+	err = s.db.Messages().MarkRead(ctx, chatID, messageID, userID)
 	if err != nil {
 		log.Printf("Failed to mark message as read: %v", err)
+		return
+	}
+
+	if slices.Contains(unreadMentions(msg), userID) {
+		s.readMentions(ctx, chatID, []string{userID})
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageRead,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+}
+
+func (s *Server) handleMessageListened(userID string, event WSEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.sendError(userID, "Invalid message format")
+		return
+	}
+
+	chatID, _ := data["chat_id"].(string)
+	messageID, _ := data["message_id"].(string)
+	if chatID == "" || messageID == "" {
+		s.sendError(userID, "chat_id and message_id are required")
+		return
+	}
+
+	if err := s.MarkListened(context.Background(), userID, chatID, messageID); err != nil {
+		log.Printf("Failed to mark message %s as listened by user %s: %v", messageID, userID, err)
+		s.sendError(userID, err.Error())
 	}
 }
 
@@ -186,3 +391,48 @@ func (s *Server) handlePing(userID string, event WSEvent) {
 		Data: event.Data,
 	})
 }
+
+// handleReauth swaps the connection's ID token for a fresh one
+// obtained through /api/auth/refresh. The token must belong to the
+// same user the connection was opened for.
+func (s *Server) handleReauth(userID string, event WSEvent) {
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		s.SendToUser(userID, WSEvent{
+			Type: "error",
+			Data: map[string]string{"error": "Invalid reauth format"},
+		})
+		return
+	}
+
+	token, _ := data["token"].(string)
+	if token == "" {
+		s.SendToUser(userID, WSEvent{
+			Type: "error",
+			Data: map[string]string{"error": "token is required"},
+		})
+		return
+	}
+
+	verified, err := s.auth.VerifyIdToken(context.Background(), token)
+	if err != nil || verified.UID != userID {
+		log.Printf("Reauth failed for user %s: %v", userID, err)
+		s.SendToUser(userID, WSEvent{
+			Type: "reauth_failed",
+			Data: map[string]string{"error": "Invalid token"},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	if client, exists := s.clients[userID]; exists {
+		client.TokenExpires = verified.Expires
+		client.SessionID = verified.SessionID
+	}
+	s.mu.Unlock()
+
+	s.SendToUser(userID, WSEvent{
+		Type: "reauthenticated",
+		Data: map[string]interface{}{"expires_at": verified.Expires},
+	})
+}
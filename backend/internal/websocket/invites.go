@@ -0,0 +1,319 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite link not found")
+	ErrInviteExpired  = errors.New("invite link has expired")
+	ErrInviteUsedUp   = errors.New("invite link has reached its usage limit")
+	ErrInvalidInvite  = errors.New("expires_in and max_uses must not be negative")
+	ErrNoJoinRequest  = errors.New("no pending join request from this user")
+)
+
+// InviteOptions limits how an invite link can be used. Zero values
+// mean no expiry, no usage limit and no approval.
+type InviteOptions struct {
+	ExpiresIn        time.Duration
+	MaxUses          int
+	RequiresApproval bool
+}
+
+// CreateInvite makes a new invite link for a group chat or a channel.
+// The caller needs the invite permission.
+func (s *Server) CreateInvite(ctx context.Context, userID, chatID string, opts InviteOptions) (*database.Invite, error) {
+	if opts.ExpiresIn < 0 || opts.MaxUses < 0 {
+		return nil, ErrInvalidInvite
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermInvite)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Type == "private" {
+		return nil, ErrNotGroup
+	}
+
+	now := time.Now()
+	invite := &database.Invite{
+		Token:            database.NewID(),
+		ChatID:           chatID,
+		CreatedBy:        userID,
+		CreatedAt:        now,
+		MaxUses:          opts.MaxUses,
+		RequiresApproval: opts.RequiresApproval,
+	}
+	if opts.ExpiresIn > 0 {
+		invite.ExpiresAt = now.Add(opts.ExpiresIn)
+	}
+
+	if err := s.db.Invites().Create(ctx, invite); err != nil {
+		return nil, fmt.Errorf("failed to create invite: %v", err)
+	}
+	return invite, nil
+}
+
+// ListInvites returns the chat's invite links, newest first. Users
+// with the invite permission see every link; other participants only
+// the ones they created.
+func (s *Server) ListInvites(ctx context.Context, userID, chatID string) ([]database.Invite, error) {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := s.db.Invites().ListByChat(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %v", err)
+	}
+	if chat.Can(userID, database.PermInvite) {
+		return invites, nil
+	}
+
+	own := []database.Invite{}
+	for _, invite := range invites {
+		if invite.CreatedBy == userID {
+			own = append(own, invite)
+		}
+	}
+	return own, nil
+}
+
+// RevokeInvite deletes an invite link so that it can no longer be
+// used. Its creator and users with the invite permission may do it.
+func (s *Server) RevokeInvite(ctx context.Context, userID, chatID, token string) error {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRead)
+	if err != nil {
+		return err
+	}
+
+	invite, err := s.chatInvite(ctx, chatID, token)
+	if err != nil {
+		return err
+	}
+	if invite.CreatedBy != userID && !chat.Can(userID, database.PermInvite) {
+		return ErrNoPermission
+	}
+
+	if err := s.db.Invites().Delete(ctx, token); err != nil {
+		return fmt.Errorf("failed to delete invite: %v", err)
+	}
+	return nil
+}
+
+// JoinByInvite redeems an invite link for userID: they become a
+// member of a group chat or a subscriber of a channel. It returns the
+// chat, or pending = true when the link needs an admin's approval
+// first. Redeeming a link of a chat the user already reads returns
+// that chat without using the link up.
+func (s *Server) JoinByInvite(ctx context.Context, userID, token string) (chat *database.Chat, pending bool, err error) {
+	invite, err := s.db.Invites().Get(ctx, token)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, false, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load invite: %v", err)
+	}
+
+	chat, err = s.db.Chats().Get(ctx, invite.ChatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, false, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load chat: %v", err)
+	}
+	if ok, err := s.canRead(ctx, chat, userID); err != nil || ok {
+		if err != nil {
+			return nil, false, err
+		}
+		return chat, false, nil
+	}
+
+	// The use is taken before admitting userID, so that concurrent joins
+	// cannot exceed max_uses, and given back if admitting fails.
+	now := time.Now()
+	invite, err = s.db.Invites().Update(ctx, token, func(invite *database.Invite) error {
+		switch {
+		case invite.Expired(now):
+			return ErrInviteExpired
+		case invite.UsedUp():
+			return ErrInviteUsedUp
+		case !invite.RequiresApproval:
+			invite.Uses++
+		case !slices.Contains(invite.Pending, userID):
+			invite.Pending = append(invite.Pending, userID)
+		}
+		return nil
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, false, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if invite.RequiresApproval {
+		s.notifyJoinRequest(ctx, chat, invite, userID)
+		return nil, true, nil
+	}
+
+	chat, err = s.admit(ctx, chat, userID, userID, fmt.Sprintf("%s joined via invite link", s.userName(ctx, userID)))
+	if err != nil {
+		s.returnInviteUse(ctx, token, userID, false)
+		return nil, false, err
+	}
+	return chat, false, nil
+}
+
+// ApproveJoinRequest lets uid, who redeemed an invite link that needs
+// approval, into the chat. The caller needs the invite permission.
+func (s *Server) ApproveJoinRequest(ctx context.Context, userID, chatID, token, uid string) (*database.Chat, error) {
+	chat, err := s.takeJoinRequest(ctx, userID, chatID, token, uid, true)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := s.canRead(ctx, chat, uid); err != nil || ok {
+		s.returnInviteUse(ctx, token, uid, err != nil)
+		return chat, err
+	}
+
+	text := fmt.Sprintf("%s joined via invite link, approved by %s", s.userName(ctx, uid), s.userName(ctx, userID))
+	chat, err = s.admit(ctx, chat, userID, uid, text)
+	if err != nil {
+		s.returnInviteUse(ctx, token, uid, true)
+		return nil, err
+	}
+	return chat, nil
+}
+
+// admit lets uid into the chat on behalf of actorID: as a subscriber
+// of a channel, which posts nothing, or as a member of a group chat,
+// which gets text as a system message.
+func (s *Server) admit(ctx context.Context, chat *database.Chat, actorID, uid, text string) (*database.Chat, error) {
+	if chat.Type == "channel" {
+		return s.subscribe(ctx, chat, uid)
+	}
+	return s.addMembers(ctx, chat, actorID, []string{uid}, text)
+}
+
+// DeclineJoinRequest turns down uid's request to join through an
+// invite link. The caller needs the invite permission.
+func (s *Server) DeclineJoinRequest(ctx context.Context, userID, chatID, token, uid string) error {
+	if _, err := s.takeJoinRequest(ctx, userID, chatID, token, uid, false); err != nil {
+		return err
+	}
+
+	s.SendToUser(uid, WSEvent{
+		Type:   "join_declined",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"chat_id":     chatID,
+			"declined_by": userID,
+		},
+	})
+	return nil
+}
+
+// takeJoinRequest removes uid from the invite's pending users and,
+// when approve is set, counts it as a use of the link. Approving
+// fails once the link has expired or is used up.
+func (s *Server) takeJoinRequest(ctx context.Context, userID, chatID, token, uid string, approve bool) (*database.Chat, error) {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermInvite)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.chatInvite(ctx, chatID, token); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = s.db.Invites().Update(ctx, token, func(invite *database.Invite) error {
+		i := slices.Index(invite.Pending, uid)
+		if i < 0 {
+			return ErrNoJoinRequest
+		}
+		if approve {
+			switch {
+			case invite.Expired(now):
+				return ErrInviteExpired
+			case invite.UsedUp():
+				return ErrInviteUsedUp
+			}
+		}
+		invite.Pending = slices.Delete(invite.Pending, i, i+1)
+		if approve {
+			invite.Uses++
+		}
+		return nil
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// returnInviteUse gives back the use of an invite link taken for uid
+// when uid did not join after all. With requeue, uid's join request
+// becomes pending again so that it can be approved later.
+func (s *Server) returnInviteUse(ctx context.Context, token, uid string, requeue bool) {
+	_, err := s.db.Invites().Update(ctx, token, func(invite *database.Invite) error {
+		if invite.Uses > 0 {
+			invite.Uses--
+		}
+		if requeue && !slices.Contains(invite.Pending, uid) {
+			invite.Pending = append(invite.Pending, uid)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Printf("Failed to return a use of invite %s: %v", token, err)
+	}
+}
+
+// chatInvite loads an invite link that belongs to chatID.
+func (s *Server) chatInvite(ctx context.Context, chatID, token string) (*database.Invite, error) {
+	invite, err := s.db.Invites().Get(ctx, token)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invite: %v", err)
+	}
+	if invite.ChatID != chatID {
+		return nil, ErrInviteNotFound
+	}
+	return invite, nil
+}
+
+// notifyJoinRequest sends join_requested to the participants who can
+// approve uid's request.
+func (s *Server) notifyJoinRequest(ctx context.Context, chat *database.Chat, invite *database.Invite, uid string) {
+	event := WSEvent{
+		Type:   "join_requested",
+		ChatID: chat.ID,
+		UserID: uid,
+		Data: map[string]interface{}{
+			"chat_id":   chat.ID,
+			"token":     invite.Token,
+			"user_id":   uid,
+			"user_name": s.userName(ctx, uid),
+		},
+	}
+	for _, participant := range chat.Participants {
+		if chat.Can(participant, database.PermInvite) {
+			s.SendToUser(participant, event)
+		}
+	}
+}
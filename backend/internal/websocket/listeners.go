@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"MyChatServer/internal/database"
+)
+
+func (s *Server) setupUserListeners(userID string) {
+	chats, err := s.getUserChats(userID)
+	if err != nil {
+		log.Printf("Failed to get chats for users %s: %v", userID, err)
+	}
+
+	for _, chatID := range chats {
+		s.startChatListener(chatID)
+	}
+
+	subscriptions, err := s.db.Subscriptions().ListByUser(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to get subscriptions of user %s: %v", userID, err)
+	}
+	for _, subscription := range subscriptions {
+		s.addOnlineSubscriber(subscription.ChatID, userID)
+		s.startChatListener(subscription.ChatID)
+	}
+}
+
+func (s *Server) stopUserListeners(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for chatID, online := range s.subscribers {
+		delete(online, userID)
+		if len(online) == 0 {
+			delete(s.subscribers, chatID)
+		}
+	}
+}
+
+func (s *Server) startChatListener(chatID string) {
+	s.mu.Lock()
+	if _, exists := s.chatListeners[chatID]; exists {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.chatListeners[chatID] = cancel
+	s.mu.Unlock()
+
+	go s.listenToChatMessages(ctx, chatID)
+}
+
+func (s *Server) stopChatListener(chatID string) {
+	s.mu.Lock()
+	cancel, exists := s.chatListeners[chatID]
+	delete(s.chatListeners, chatID)
+	s.mu.Unlock()
+
+	if exists {
+		cancel()
+	}
+}
+
+// syncChatListener runs the chat's listener while at least one of its
+// participants or subscribers is connected, e.g. after the member list
+// changed.
+func (s *Server) syncChatListener(chatID string) {
+	participants, err := s.getChatParticipants(chatID)
+	if err != nil {
+		log.Printf("Failed to sync listener for chat %s: %v", chatID, err)
+		return
+	}
+
+	s.mu.RLock()
+	online := len(s.subscribers[chatID]) > 0
+	for _, uid := range participants {
+		if _, ok := s.clients[uid]; ok {
+			online = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if online {
+		s.startChatListener(chatID)
+	} else {
+		s.stopChatListener(chatID)
+	}
+}
+
+func (s *Server) listenToChatMessages(ctx context.Context, chatID string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.chatListeners, chatID)
+		s.mu.Unlock()
+	}()
+
+	changes, err := s.db.Messages().Watch(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to watch chat %s: %v", chatID, err)
+		return
+	}
+
+	for change := range changes {
+		if change.Kind == database.MessageAdded {
+			s.broadcastNewMessage(chatID, messageData(&change.Message))
+		}
+	}
+
+	log.Printf("Stopped listener for chat %s", chatID)
+}
+
+func (s *Server) broadcastNewMessage(chatID string, messageData map[string]interface{}) {
+	senderID, _ := messageData["sender_id"].(string)
+
+	event := WSEvent{
+		Type:   "new_message",
+		ChatID: chatID,
+		Data:   messageData,
+	}
+
+	sent, err := s.BroadcastToChat(chatID, event, senderID)
+	if err != nil {
+		log.Printf("Failed to broadcast message in chat %s: %v", chatID, err)
+		return
+	}
+
+	log.Printf("Broadcasted message in chat %s to %d users", chatID, sent)
+}
+
+func (s *Server) getUserChats(userID string) ([]string, error) {
+	ctx := context.Background()
+
+	chats, err := s.db.Chats().ListByParticipant(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user chats: %v", err)
+	}
+
+	chatIDs := make([]string, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+
+	return chatIDs, nil
+}
+
+func (s *Server) getChatParticipants(chatID string) ([]string, error) {
+	ctx := context.Background()
+
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("chat not found: %v", err)
+	}
+
+	return chat.Participants, nil
+}
+
+func messageData(msg *database.Message) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":        msg.ID,
+		"chat_id":   msg.ChatID,
+		"sender_id": msg.SenderID,
+		"text":      msg.Text,
+		"timestamp": msg.Timestamp,
+		"read_by":   msg.ReadBy,
+	}
+	if editedAt := msg.EditedAt(); !editedAt.IsZero() {
+		data["edited_at"] = editedAt
+	}
+	if msg.Deleted() {
+		data["deleted"] = true
+	}
+	if len(msg.Reactions) > 0 {
+		data["reactions"] = msg.Reactions
+	}
+	if msg.ReplyTo != "" {
+		data["reply_to"] = msg.ReplyTo
+	}
+	if msg.ThreadID != "" {
+		data["thread_id"] = msg.ThreadID
+	}
+	if msg.ReplyCount > 0 {
+		data["reply_count"] = msg.ReplyCount
+		data["last_reply_at"] = msg.LastReplyAt
+	}
+	if msg.ForwardedFrom != nil {
+		data["forwarded_from"] = msg.ForwardedFrom
+	}
+	// A message deleted for everyone keeps none of its content.
+	if !msg.Deleted() {
+		if len(msg.Attachments) > 0 {
+			data["attachments"] = msg.Attachments
+		}
+		if len(msg.ListenedBy) > 0 {
+			data["listened_by"] = msg.ListenedBy
+		}
+		if len(msg.Mentions) > 0 {
+			data["mentions"] = msg.Mentions
+		}
+	}
+	if msg.Views > 0 {
+		data["views"] = msg.Views
+	}
+	if msg.Pinned() {
+		data["pinned_at"] = msg.PinnedAt
+		data["pinned_by"] = msg.PinnedBy
+	}
+	if msg.TempID != "" {
+		data["temp_id"] = msg.TempID
+	}
+	return data
+}
@@ -0,0 +1,362 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNotGroup          = errors.New("only group chats have a changeable member list")
+	ErrNoUsers           = errors.New("user_ids are required")
+	ErrTooManyUsers      = errors.New("too many users to add at once")
+	ErrUnknownUser       = errors.New("user not found")
+	ErrNotMember         = errors.New("user is not a participant of this chat")
+	ErrCannotRemoveOwner = errors.New("the chat owner cannot be removed")
+	ErrOwnerCannotLeave  = errors.New("the chat owner cannot leave while others remain; transfer ownership first")
+)
+
+// maxAddParticipants bounds the users added in one request.
+const maxAddParticipants = 50
+
+// systemSenderID is the sender of messages the server writes itself,
+// like the welcome message or membership notices.
+const systemSenderID = "system"
+
+// AddParticipants adds users to a group chat as members, or to a
+// channel as admins, since a channel's participants are the ones who
+// post. The caller needs the invite permission; users already in the
+// chat are skipped. It returns the chat as it is afterwards.
+func (s *Server) AddParticipants(ctx context.Context, userID, chatID string, uids []string) (*database.Chat, error) {
+	uids = uniqueIDs(uids)
+	if len(uids) == 0 {
+		return nil, ErrNoUsers
+	}
+	if len(uids) > maxAddParticipants {
+		return nil, ErrTooManyUsers
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermInvite)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Type == "private" {
+		return nil, ErrNotGroup
+	}
+
+	var added, names []string
+	for _, uid := range uids {
+		if slices.Contains(chat.Participants, uid) {
+			continue
+		}
+		user, err := s.db.Users().Get(ctx, uid)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownUser, uid)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load user %s: %v", uid, err)
+		}
+		added = append(added, uid)
+		names = append(names, displayName(user))
+	}
+	if len(added) == 0 {
+		return chat, nil
+	}
+
+	text := fmt.Sprintf("%s added %s", s.userName(ctx, userID), strings.Join(names, ", "))
+	return s.addMembers(ctx, chat, userID, added, text)
+}
+
+// addMembers adds uids to chat on behalf of actorID, posts text as a
+// system message, tells the participants and sends the new members
+// chat_created. It returns the chat as it is afterwards.
+func (s *Server) addMembers(ctx context.Context, chat *database.Chat, actorID string, uids []string, text string) (*database.Chat, error) {
+	chatID := chat.ID
+	if err := s.db.Chats().AddParticipants(ctx, chatID, uids); err != nil {
+		return nil, fmt.Errorf("failed to add participants: %v", err)
+	}
+	if chat.Type == "channel" {
+		roles := make(map[string]string, len(uids))
+		for _, uid := range uids {
+			roles[uid] = database.ChatRoleAdmin
+		}
+		if err := s.db.Chats().SetRoles(ctx, chatID, roles); err != nil {
+			return nil, fmt.Errorf("failed to make admins: %v", err)
+		}
+	}
+
+	err := s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatCreated,
+		ChatID: chatID,
+		UserID: actorID,
+	}, uids)
+	if err != nil {
+		log.Printf("Failed to record chat_created change: %v", err)
+	}
+	err = s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatUpdated,
+		ChatID: chatID,
+		UserID: actorID,
+	}, chat.Participants)
+	if err != nil {
+		log.Printf("Failed to record chat_updated change: %v", err)
+	}
+
+	s.postSystemMessage(ctx, chatID, text)
+
+	chat, err = s.db.Chats().Get(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "participant_added",
+		ChatID: chatID,
+		UserID: actorID,
+		Data: map[string]interface{}{
+			"chat_id":      chatID,
+			"chat_name":    chat.Name,
+			"chat_type":    chat.Type,
+			"user_ids":     uids,
+			"added_by":     actorID,
+			"participants": chat.Participants,
+		},
+	}, "")
+	s.SendChatCreated(chatID, chatCreatedData(chat), uids)
+	s.syncChatListener(chatID)
+
+	return chat, nil
+}
+
+// chatCreatedData is the payload of chat_created for an existing chat.
+func chatCreatedData(chat *database.Chat) map[string]interface{} {
+	data := map[string]interface{}{
+		"chat_id":      chat.ID,
+		"name":         chat.Name,
+		"type":         chat.Type,
+		"participants": chat.Participants,
+		"created_by":   chat.CreatedBy,
+		"created_at":   chat.CreatedAt,
+		"updated_at":   chat.UpdatedAt,
+		"roles":        chat.ParticipantRoles(),
+	}
+	if chat.Type == "channel" {
+		data["handle"] = chat.Handle
+		data["subscriber_count"] = chat.SubscriberCount
+	}
+	return data
+}
+
+// RemoveParticipant removes uid from a group chat. The caller needs
+// the remove permission and a role above uid's, so the owner cannot
+// be removed; removing yourself is the same as LeaveChat.
+func (s *Server) RemoveParticipant(ctx context.Context, userID, chatID, uid string) error {
+	if uid == userID {
+		return s.LeaveChat(ctx, userID, chatID)
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRemove)
+	if err != nil {
+		return err
+	}
+	if chat.Type == "private" {
+		return ErrNotGroup
+	}
+	if !slices.Contains(chat.Participants, uid) {
+		return ErrNotMember
+	}
+	if chat.Role(uid) == database.ChatRoleOwner {
+		return ErrCannotRemoveOwner
+	}
+	if !chat.Outranks(userID, uid) {
+		return ErrNoPermission
+	}
+
+	text := fmt.Sprintf("%s removed %s", s.userName(ctx, userID), s.userName(ctx, uid))
+	return s.removeParticipant(ctx, chatID, userID, uid, text)
+}
+
+// LeaveChat takes userID out of a group chat or a channel's
+// participants. The owner can only leave as the last participant,
+// which deletes the chat; otherwise they have to transfer ownership
+// first. Subscribers leave a channel with Unsubscribe.
+func (s *Server) LeaveChat(ctx context.Context, userID, chatID string) error {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRead)
+	if err != nil {
+		return err
+	}
+	if chat.Type == "private" {
+		return ErrNotGroup
+	}
+	if len(chat.Participants) == 1 {
+		return s.deleteChat(ctx, chat, userID)
+	}
+	if chat.Role(userID) == database.ChatRoleOwner {
+		return ErrOwnerCannotLeave
+	}
+
+	return s.removeParticipant(ctx, chatID, userID, userID, fmt.Sprintf("%s left the chat", s.userName(ctx, userID)))
+}
+
+// DeleteChat removes a chat with all its messages for everyone. A
+// group chat can only be deleted by its owner, a private chat by
+// either side.
+func (s *Server) DeleteChat(ctx context.Context, userID, chatID string) error {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermDeleteChat)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteChat(ctx, chat, userID)
+}
+
+// removeParticipant drops uid from the chat on behalf of actorID,
+// posts text as a system message and tells both the remaining
+// participants and uid.
+func (s *Server) removeParticipant(ctx context.Context, chatID, actorID, uid, text string) error {
+	if err := s.db.Chats().RemoveParticipant(ctx, chatID, uid); err != nil {
+		return fmt.Errorf("failed to remove participant: %v", err)
+	}
+	if err := s.db.ChatSettings().Delete(ctx, uid, chatID); err != nil {
+		log.Printf("Failed to drop chat settings of %s: %v", uid, err)
+	}
+
+	err := s.db.Changes().Append(ctx, &database.Change{
+		Type:   database.ChangeChatLeft,
+		ChatID: chatID,
+		UserID: actorID,
+	}, []string{uid})
+	if err != nil {
+		log.Printf("Failed to record chat_left change: %v", err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:   database.ChangeChatUpdated,
+		ChatID: chatID,
+		UserID: actorID,
+	})
+	s.postSystemMessage(ctx, chatID, text)
+
+	event := WSEvent{
+		Type:   "participant_removed",
+		ChatID: chatID,
+		UserID: actorID,
+		Data: map[string]interface{}{
+			"chat_id":    chatID,
+			"user_id":    uid,
+			"removed_by": actorID,
+		},
+	}
+	s.BroadcastToChat(chatID, event, "")
+	s.SendToUser(uid, event)
+	s.syncChatListener(chatID)
+
+	return nil
+}
+
+func (s *Server) deleteChat(ctx context.Context, chat *database.Chat, userID string) error {
+	// Subscriptions go with the chat, so collect who has to be told
+	// first.
+	var subscribers [][]string
+	if chat.Type == "channel" {
+		err := s.forEachSubscriber(ctx, chat.ID, func(uids []string) {
+			subscribers = append(subscribers, uids)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list subscribers: %v", err)
+		}
+	}
+
+	// So do the attachments, whose files are freed afterwards.
+	attachments, err := s.db.Attachments().ListByChat(ctx, chat.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list attachments: %v", err)
+	}
+
+	if err := s.db.Chats().Delete(ctx, chat.ID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return ErrNotParticipant
+		}
+		return fmt.Errorf("failed to delete chat: %v", err)
+	}
+	s.releaseFiles(ctx, attachments)
+
+	change := &database.Change{
+		Type:   database.ChangeChatLeft,
+		ChatID: chat.ID,
+		UserID: userID,
+	}
+	if err := s.db.Changes().Append(ctx, change, chat.Participants); err != nil {
+		log.Printf("Failed to record chat_left change: %v", err)
+	}
+	for _, uids := range subscribers {
+		if err := s.db.Changes().Append(ctx, change, uids); err != nil {
+			log.Printf("Failed to record chat_left change: %v", err)
+		}
+	}
+
+	s.stopChatListener(chat.ID)
+
+	event := WSEvent{
+		Type:   "chat_deleted",
+		ChatID: chat.ID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"chat_id":    chat.ID,
+			"deleted_by": userID,
+		},
+	}
+	for _, uid := range chat.Participants {
+		s.SendToUser(uid, event)
+	}
+
+	s.mu.Lock()
+	online := s.subscribers[chat.ID]
+	delete(s.subscribers, chat.ID)
+	s.mu.Unlock()
+	for uid := range online {
+		s.SendToUser(uid, event)
+	}
+
+	return nil
+}
+
+// postSystemMessage stores text as a message from the server and
+// delivers it like any other new message.
+func (s *Server) postSystemMessage(ctx context.Context, chatID, text string) {
+	msg, err := s.saveMessageToFirestore(chatID, systemSenderID, text, "", "", "", nil)
+	if err != nil {
+		log.Printf("Failed to post system message in chat %s: %v", chatID, err)
+		return
+	}
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "new_message",
+		ChatID: chatID,
+		Data:   messageData(msg),
+	}, "")
+}
+
+// userName returns how uid is called in system messages.
+func (s *Server) userName(ctx context.Context, uid string) string {
+	user, err := s.db.Users().Get(ctx, uid)
+	if err != nil {
+		return uid
+	}
+	return displayName(user)
+}
+
+func displayName(user *database.User) string {
+	if name := strings.TrimSpace(user.Name); name != "" {
+		return name
+	}
+	if user.Email != "" {
+		return user.Email
+	}
+	return user.UID
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"MyChatServer/internal/database"
+)
+
+// mentionPattern matches @name at the start of the text or after a
+// character that cannot be part of a name, so that e-mail addresses
+// are not taken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([\p{L}\p{N}_.]+)`)
+
+// mentionAll mentions every participant of the chat.
+const mentionAll = "all"
+
+// resolveMentions returns the participants mentioned in text, apart
+// from the sender, in chat order. A name matches a participant's
+// display name without spaces or the local part of their e-mail,
+// ignoring case.
+func (s *Server) resolveMentions(ctx context.Context, chatID, senderID, text string) []string {
+	names := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		names[strings.ToLower(strings.TrimRight(match[1], "."))] = true
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	participants, err := s.getChatParticipants(chatID)
+	if err != nil {
+		log.Printf("Failed to resolve mentions in chat %s: %v", chatID, err)
+		return nil
+	}
+
+	var mentioned []string
+	for _, uid := range participants {
+		if uid == senderID {
+			continue
+		}
+		if names[mentionAll] {
+			mentioned = append(mentioned, uid)
+			continue
+		}
+
+		user, err := s.db.Users().Get(ctx, uid)
+		if err != nil {
+			continue
+		}
+		for _, handle := range usernames(user) {
+			if names[handle] {
+				mentioned = append(mentioned, uid)
+				break
+			}
+		}
+	}
+	return mentioned
+}
+
+// usernames returns the lower-case handles a user can be mentioned by.
+func usernames(user *database.User) []string {
+	var handles []string
+	if name := strings.Join(strings.Fields(user.Name), ""); name != "" {
+		handles = append(handles, strings.ToLower(name))
+	}
+	if local, _, ok := strings.Cut(user.Email, "@"); ok && local != "" {
+		handles = append(handles, strings.ToLower(local))
+	}
+	return handles
+}
+
+// notifyMentions bumps the unread mention counters of the users
+// mentioned in msg and sends each of them a mentioned event on top
+// of the regular new_message.
+func (s *Server) notifyMentions(ctx context.Context, chatID string, msg *database.Message) {
+	if len(msg.Mentions) == 0 {
+		return
+	}
+
+	if err := s.db.Chats().AddUnreadMentions(ctx, chatID, msg.Mentions, 1); err != nil {
+		log.Printf("Failed to count mentions of message %s: %v", msg.ID, err)
+		return
+	}
+
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if err != nil {
+		log.Printf("Failed to load chat %s: %v", chatID, err)
+		return
+	}
+
+	for _, uid := range msg.Mentions {
+		s.SendToUser(uid, WSEvent{
+			Type:   "mentioned",
+			ChatID: chatID,
+			UserID: msg.SenderID,
+			Data: map[string]interface{}{
+				"chat_id":         chatID,
+				"message":         messageData(msg),
+				"unread_mentions": chat.UnreadMentions[uid],
+			},
+		})
+	}
+}
+
+// unreadMentions returns the users mentioned in msg who have not
+// read it yet.
+func unreadMentions(msg *database.Message) []string {
+	var unread []string
+	for _, uid := range msg.Mentions {
+		if !slices.Contains(msg.ReadBy, uid) {
+			unread = append(unread, uid)
+		}
+	}
+	return unread
+}
+
+// readMentions takes a message that is no longer unread for uids off
+// their unread mention counters.
+func (s *Server) readMentions(ctx context.Context, chatID string, uids []string) {
+	if len(uids) == 0 {
+		return
+	}
+	if err := s.db.Chats().AddUnreadMentions(ctx, chatID, uids, -1); err != nil {
+		log.Printf("Failed to update unread mentions in chat %s: %v", chatID, err)
+	}
+}
@@ -0,0 +1,638 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNotParticipant    = errors.New("not a chat participant")
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrNotAllowed        = errors.New("only the sender or a chat admin can change this message")
+	ErrMessageDeleted    = errors.New("message was deleted")
+	ErrEmptyText         = errors.New("text is required")
+	ErrInvalidEmoji      = errors.New("invalid emoji")
+	ErrInvalidReply      = errors.New("reply_to must reference a message in this chat")
+	ErrInvalidThread     = errors.New("thread_id must reference a top-level message in this chat")
+	ErrInvalidAttachment = errors.New("attachment_ids must reference your uploads to this chat")
+	ErrNotVoice          = errors.New("not a voice message")
+	ErrInvalidTempID     = errors.New("temp_id is too long")
+)
+
+// maxTempIDLength bounds the client-generated ID of a message.
+const maxTempIDLength = 64
+
+// maxAttachments bounds the files sent with one message.
+const maxAttachments = 10
+
+// maxEmojiBytes leaves room for multi-codepoint emoji such as flags
+// and skin-tone or ZWJ sequences.
+const maxEmojiBytes = 32
+
+// clientMessageID derives the ID of a message from its sender and
+// the client-generated tempID. No backend stores a message with an
+// ID that is already taken, so a retried send cannot duplicate it.
+func clientMessageID(senderID, tempID string) string {
+	sum := sha256.Sum256([]byte(senderID + "\x00" + tempID))
+	return database.IDFromBytes(sum[:])
+}
+
+// lastMessageScan bounds how far back a replacement last_message is
+// looked for after the latest message is deleted.
+const lastMessageScan = 20
+
+// SendMessage stores a new message from userID and notifies the rest
+// of the chat, like the send_message event does.
+func (s *Server) SendMessage(ctx context.Context, userID, chatID, text string, attachments []database.MessageAttachment) (*database.Message, error) {
+	text = strings.TrimSpace(text)
+	if text == "" && len(attachments) == 0 {
+		return nil, ErrEmptyText
+	}
+	if _, err := s.authorize(ctx, userID, chatID, database.PermSend); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.saveMessageToFirestore(chatID, userID, text, "", "", "", attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "new_message",
+		ChatID: chatID,
+		Data:   messageData(msg),
+	}, userID)
+
+	return msg, nil
+}
+
+// UpdateAttachment refreshes the copy of attachment kept in a message,
+// e.g. once its thumbnail is ready, and sends message_updated.
+func (s *Server) UpdateAttachment(ctx context.Context, chatID, messageID string, attachment *database.Attachment) error {
+	msg, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		for i := range msg.Attachments {
+			if msg.Attachments[i].ID == attachment.ID {
+				msg.Attachments[i] = attachment.Ref()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return messageError(err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: messageID,
+	})
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "message_updated",
+		ChatID: chatID,
+		Data:   messageData(msg),
+	}, "")
+
+	return nil
+}
+
+// EditMessage replaces the text of a message, keeping the previous
+// version in its edit history, and notifies the chat.
+func (s *Server) EditMessage(ctx context.Context, userID, chatID, messageID, text string) (*database.Message, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, ErrEmptyText
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermSend)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		if msg.HiddenFrom(userID) {
+			return ErrMessageNotFound
+		}
+		if msg.Deleted() {
+			return ErrMessageDeleted
+		}
+		if msg.SenderID != userID && !chat.IsAdmin(userID) {
+			return ErrNotAllowed
+		}
+		if msg.Text == text {
+			return nil
+		}
+
+		msg.Edits = append(msg.Edits, database.MessageEdit{Text: msg.Text, EditedAt: time.Now()})
+		msg.Text = text
+		return nil
+	})
+	if err != nil {
+		return nil, messageError(err)
+	}
+
+	s.refreshLastMessage(ctx, chat, msg)
+	s.indexMessage(ctx, chatID, msg)
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "message_edited",
+		ChatID: chatID,
+		UserID: userID,
+		Data:   messageData(msg),
+	}, "")
+
+	return msg, nil
+}
+
+// DeleteMessage removes a message for everyone in the chat, or only
+// hides it from userID. Deleting twice is not an error.
+func (s *Server) DeleteMessage(ctx context.Context, userID, chatID, messageID string, forEveryone bool) error {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRead)
+	if err != nil {
+		return err
+	}
+
+	var unread []string
+	var removed []database.MessageAttachment
+	deleted := false
+	msg, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		if msg.HiddenFrom(userID) {
+			return ErrMessageNotFound
+		}
+
+		if !forEveryone {
+			if slices.Contains(unreadMentions(msg), userID) {
+				unread = []string{userID}
+			}
+			msg.HiddenFor = append(msg.HiddenFor, userID)
+			return nil
+		}
+
+		if msg.SenderID != userID && !chat.Can(userID, database.PermDeleteAnyMessage) {
+			return ErrNotAllowed
+		}
+		if !msg.Deleted() {
+			unread = unreadMentions(msg)
+			removed = msg.Attachments
+			deleted = true
+			msg.DeletedAt = time.Now()
+			msg.Text = ""
+			msg.Edits = nil
+			msg.Reactions = nil
+			msg.Attachments = nil
+			msg.ListenedBy = nil
+			msg.Mentions = nil
+			msg.PinnedAt, msg.PinnedBy = time.Time{}, ""
+		}
+		return nil
+	})
+	if err != nil {
+		return messageError(err)
+	}
+
+	event := WSEvent{
+		Type:   "message_deleted",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"id":           messageID,
+			"chat_id":      chatID,
+			"for_everyone": forEveryone,
+		},
+	}
+	change := &database.Change{
+		Type:      database.ChangeMessageDeleted,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	}
+
+	if !forEveryone {
+		if err := s.db.Changes().Append(ctx, change, []string{userID}); err != nil {
+			log.Printf("Failed to record %s change: %v", change.Type, err)
+		}
+		s.readMentions(ctx, chatID, unread)
+		s.SendToUser(userID, event)
+		return nil
+	}
+
+	s.refreshLastMessage(ctx, chat, msg)
+	s.indexMessage(ctx, chatID, msg)
+	s.readMentions(ctx, chatID, unread)
+	s.recordChatChange(ctx, change)
+	s.BroadcastToChat(chatID, event, "")
+	s.removeAttachments(ctx, removed)
+	if deleted && msg.ThreadID != "" {
+		s.unbumpThread(ctx, chatID, msg)
+	}
+
+	return nil
+}
+
+// React adds or removes userID's emoji reaction on a message and
+// broadcasts the new reaction state to the chat.
+func (s *Server) React(ctx context.Context, userID, chatID, messageID, emoji string, add bool) (*database.Message, error) {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || len(emoji) > maxEmojiBytes || strings.ContainsAny(emoji, " \t\n") {
+		return nil, ErrInvalidEmoji
+	}
+
+	if _, err := s.authorize(ctx, userID, chatID, database.PermRead); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		if msg.HiddenFrom(userID) {
+			return ErrMessageNotFound
+		}
+		if msg.Deleted() {
+			return ErrMessageDeleted
+		}
+
+		users := msg.Reactions[emoji]
+		at := -1
+		for i, u := range users {
+			if u == userID {
+				at = i
+				break
+			}
+		}
+
+		switch {
+		case add && at < 0:
+			if msg.Reactions == nil {
+				msg.Reactions = make(map[string][]string)
+			}
+			msg.Reactions[emoji] = append(users, userID)
+		case !add && at >= 0:
+			users = append(users[:at], users[at+1:]...)
+			if len(users) == 0 {
+				delete(msg.Reactions, emoji)
+			} else {
+				msg.Reactions[emoji] = users
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, messageError(err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+
+	reactions := msg.Reactions
+	if reactions == nil {
+		reactions = map[string][]string{}
+	}
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "reaction_updated",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"id":        messageID,
+			"chat_id":   chatID,
+			"emoji":     emoji,
+			"added":     add,
+			"reactions": reactions,
+		},
+	}, "")
+
+	return msg, nil
+}
+
+// PinMessage pins a message in its chat or unpins it, and tells the
+// chat. Pinning a pinned message again changes nothing.
+func (s *Server) PinMessage(ctx context.Context, userID, chatID, messageID string, pin bool) (*database.Message, error) {
+	if _, err := s.authorize(ctx, userID, chatID, database.PermPin); err != nil {
+		return nil, err
+	}
+
+	changed := false
+	msg, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		if msg.HiddenFrom(userID) {
+			return ErrMessageNotFound
+		}
+		if msg.Deleted() {
+			return ErrMessageDeleted
+		}
+		if msg.Pinned() == pin {
+			return nil
+		}
+
+		msg.PinnedAt, msg.PinnedBy = time.Time{}, ""
+		if pin {
+			msg.PinnedAt, msg.PinnedBy = time.Now(), userID
+		}
+		changed = true
+		return nil
+	})
+	if err != nil || !changed {
+		return msg, messageError(err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+
+	data := map[string]interface{}{
+		"id":      messageID,
+		"chat_id": chatID,
+		"pinned":  pin,
+	}
+	if pin {
+		data["pinned_at"] = msg.PinnedAt
+		data["pinned_by"] = userID
+	}
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "message_pinned",
+		ChatID: chatID,
+		UserID: userID,
+		Data:   data,
+	}, "")
+
+	return msg, nil
+}
+
+// MarkListened records that userID played a voice message and tells
+// the chat. Listening to one's own message or listening again changes
+// nothing.
+func (s *Server) MarkListened(ctx context.Context, userID, chatID, messageID string) error {
+	if _, err := s.authorize(ctx, userID, chatID, database.PermRead); err != nil {
+		return err
+	}
+
+	changed := false
+	_, err := s.db.Messages().Update(ctx, chatID, messageID, func(msg *database.Message) error {
+		if msg.HiddenFrom(userID) {
+			return ErrMessageNotFound
+		}
+		if msg.Deleted() {
+			return ErrMessageDeleted
+		}
+		if msg.Voice() == nil {
+			return ErrNotVoice
+		}
+		if msg.SenderID == userID {
+			return nil
+		}
+		for _, uid := range msg.ListenedBy {
+			if uid == userID {
+				return nil
+			}
+		}
+
+		msg.ListenedBy = append(msg.ListenedBy, userID)
+		changed = true
+		return nil
+	})
+	if err != nil || !changed {
+		return messageError(err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "message_listened",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"id":      messageID,
+			"chat_id": chatID,
+			"user_id": userID,
+		},
+	}, "")
+
+	return nil
+}
+
+// validateReply checks the optional reply_to and thread_id of a new
+// message. Threads are one level deep, and a message quoted inside a
+// thread must belong to it or be its root.
+func (s *Server) validateReply(ctx context.Context, chatID, replyTo, threadID string) error {
+	if threadID != "" {
+		root, err := s.db.Messages().Get(ctx, chatID, threadID)
+		if err != nil || root.ThreadID != "" || root.Deleted() {
+			return ErrInvalidThread
+		}
+	}
+
+	if replyTo != "" {
+		quoted, err := s.db.Messages().Get(ctx, chatID, replyTo)
+		if err != nil || quoted.Deleted() {
+			return ErrInvalidReply
+		}
+		if threadID != "" && quoted.ID != threadID && quoted.ThreadID != threadID {
+			return ErrInvalidReply
+		}
+	}
+
+	return nil
+}
+
+// resolveAttachments loads the metadata of files userID uploaded to
+// the chat, in the order given.
+func (s *Server) resolveAttachments(ctx context.Context, userID, chatID string, ids []string) ([]database.MessageAttachment, error) {
+	ids = uniqueIDs(ids)
+	if len(ids) > maxAttachments {
+		return nil, ErrInvalidAttachment
+	}
+
+	var result []database.MessageAttachment
+	for _, id := range ids {
+		attachment, err := s.db.Attachments().Get(ctx, id)
+		if err != nil || attachment.ChatID != chatID || attachment.UploaderID != userID {
+			return nil, ErrInvalidAttachment
+		}
+		// A voice message carries its recording alone.
+		if attachment.Kind == database.AttachmentVoice && len(ids) > 1 {
+			return nil, ErrInvalidAttachment
+		}
+		result = append(result, attachment.Ref())
+	}
+	return result, nil
+}
+
+// bumpThread counts a new reply on the thread root and tells the
+// chat about it.
+func (s *Server) bumpThread(ctx context.Context, chatID, threadID string, at time.Time) {
+	s.updateThread(ctx, chatID, threadID, func(root *database.Message) {
+		root.ReplyCount++
+		if at.After(root.LastReplyAt) {
+			root.LastReplyAt = at
+		}
+	})
+}
+
+// unbumpThread uncounts a reply deleted for everyone. When it was the
+// latest reply, the thread's last reply becomes the newest one still
+// visible, or none.
+func (s *Server) unbumpThread(ctx context.Context, chatID string, reply *database.Message) {
+	latest, err := s.latestReply(ctx, chatID, reply.ThreadID)
+	if err != nil {
+		log.Printf("Failed to load replies of thread %s: %v", reply.ThreadID, err)
+		return
+	}
+
+	s.updateThread(ctx, chatID, reply.ThreadID, func(root *database.Message) {
+		root.ReplyCount = max(root.ReplyCount-1, 0)
+		if !reply.Timestamp.Before(root.LastReplyAt) {
+			root.LastReplyAt = latest
+		}
+	})
+}
+
+// latestReply returns when the newest reply of the thread that is not
+// deleted was sent, or the zero time when there is none.
+func (s *Server) latestReply(ctx context.Context, chatID, threadID string) (time.Time, error) {
+	page := database.MessagePage{Limit: lastMessageScan, ThreadID: threadID}
+	for {
+		replies, err := s.db.Messages().ListPage(ctx, chatID, page)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for i := len(replies) - 1; i >= 0; i-- {
+			if !replies[i].Deleted() {
+				return replies[i].Timestamp, nil
+			}
+		}
+		if len(replies) < page.Limit {
+			return time.Time{}, nil
+		}
+		cursor := replies[0].Cursor()
+		page.Before = &cursor
+	}
+}
+
+// updateThread applies fn to the counters of the thread root, records
+// the change and sends thread_updated to the chat.
+func (s *Server) updateThread(ctx context.Context, chatID, threadID string, fn func(root *database.Message)) {
+	root, err := s.db.Messages().Update(ctx, chatID, threadID, func(msg *database.Message) error {
+		fn(msg)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update thread %s: %v", threadID, err)
+		return
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:      database.ChangeMessageEdited,
+		ChatID:    chatID,
+		MessageID: threadID,
+	})
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "thread_updated",
+		ChatID: chatID,
+		Data: map[string]interface{}{
+			"id":            threadID,
+			"chat_id":       chatID,
+			"reply_count":   root.ReplyCount,
+			"last_reply_at": root.LastReplyAt,
+		},
+	}, "")
+}
+
+// removeAttachments deletes the attachments of a message deleted for
+// everyone, so that they can no longer be downloaded, and frees their
+// files.
+func (s *Server) removeAttachments(ctx context.Context, refs []database.MessageAttachment) {
+	var removed []database.Attachment
+	for _, ref := range refs {
+		attachment, err := s.db.Attachments().Get(ctx, ref.ID)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			err = s.db.Attachments().Delete(ctx, ref.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to remove attachment %s: %v", ref.ID, err)
+			continue
+		}
+		removed = append(removed, *attachment)
+	}
+	s.releaseFiles(ctx, removed)
+}
+
+// releaseFiles hands removed attachments to the file releaser, if any.
+func (s *Server) releaseFiles(ctx context.Context, attachments []database.Attachment) {
+	if s.files != nil && len(attachments) > 0 {
+		s.files.Release(ctx, attachments)
+	}
+}
+
+func (s *Server) participantChat(ctx context.Context, userID, chatID string) (*database.Chat, error) {
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrNotParticipant
+		}
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+
+	for _, p := range chat.Participants {
+		if p == userID {
+			return chat, nil
+		}
+	}
+	return nil, ErrNotParticipant
+}
+
+// refreshLastMessage rewrites the chat's last_message when msg is the
+// latest message. A message deleted for everyone is replaced by the
+// newest one still visible, if any.
+func (s *Server) refreshLastMessage(ctx context.Context, chat *database.Chat, msg *database.Message) {
+	recent, err := s.db.Messages().ListPage(ctx, chat.ID, database.MessagePage{Limit: lastMessageScan})
+	if err != nil || len(recent) == 0 || recent[len(recent)-1].ID != msg.ID {
+		return
+	}
+
+	last := msg
+	if msg.Deleted() {
+		for i := len(recent) - 1; i >= 0; i-- {
+			if !recent[i].Deleted() {
+				last = &recent[i]
+				break
+			}
+		}
+	}
+
+	if err := s.db.Chats().SetLastMessage(ctx, chat.ID, last); err != nil {
+		log.Printf("Failed to update last_message: %v", err)
+	}
+}
+
+func messageError(err error) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrMessageNotFound
+	}
+	return err
+}
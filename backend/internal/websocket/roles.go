@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"MyChatServer/internal/database"
+)
+
+var (
+	ErrNoPermission = errors.New("your role in this chat does not allow this")
+	ErrInvalidRole  = errors.New("role must be admin, member or read_only")
+	ErrInvalidName  = errors.New("chat name must be 1 to 100 characters")
+)
+
+// maxChatNameLength bounds a chat name in characters.
+const maxChatNameLength = 100
+
+// authorize loads the chat and checks that userID takes part in it
+// and that their role allows perm. Every chat operation goes through
+// it.
+func (s *Server) authorize(ctx context.Context, userID, chatID string, perm database.ChatPermission) (*database.Chat, error) {
+	chat, err := s.participantChat(ctx, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !chat.Can(userID, perm) {
+		return nil, ErrNoPermission
+	}
+	return chat, nil
+}
+
+// SetRole gives uid another role in a group chat. Only the owner may
+// do it; ownership itself moves with TransferOwnership.
+func (s *Server) SetRole(ctx context.Context, userID, chatID, uid, role string) (*database.Chat, error) {
+	if role == database.ChatRoleOwner || !database.ValidChatRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermChangeRoles)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Role(uid) == "" {
+		return nil, ErrNotMember
+	}
+	if !chat.Outranks(userID, uid) {
+		return nil, ErrNoPermission
+	}
+	if chat.Role(uid) == role {
+		return chat, nil
+	}
+
+	text := fmt.Sprintf("%s made %s %s", s.userName(ctx, userID), s.userName(ctx, uid), roleTitle(role))
+	return s.changeRoles(ctx, chatID, userID, map[string]string{uid: role}, text)
+}
+
+// TransferOwnership makes uid the owner of a group chat. The previous
+// owner stays on as an admin.
+func (s *Server) TransferOwnership(ctx context.Context, userID, chatID, uid string) (*database.Chat, error) {
+	chat, err := s.authorize(ctx, userID, chatID, database.PermChangeRoles)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Role(uid) == "" {
+		return nil, ErrNotMember
+	}
+	if uid == userID {
+		return chat, nil
+	}
+
+	roles := map[string]string{
+		uid:    database.ChatRoleOwner,
+		userID: database.ChatRoleAdmin,
+	}
+	text := fmt.Sprintf("%s made %s the owner", s.userName(ctx, userID), s.userName(ctx, uid))
+	return s.changeRoles(ctx, chatID, userID, roles, text)
+}
+
+// changeRoles stores roles, posts text as a system message and sends
+// chat_roles_changed with every participant's role.
+func (s *Server) changeRoles(ctx context.Context, chatID, userID string, roles map[string]string, text string) (*database.Chat, error) {
+	if err := s.db.Chats().SetRoles(ctx, chatID, roles); err != nil {
+		return nil, fmt.Errorf("failed to change roles: %v", err)
+	}
+
+	chat, err := s.db.Chats().Get(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:   database.ChangeChatUpdated,
+		ChatID: chatID,
+		UserID: userID,
+	})
+	s.postSystemMessage(ctx, chatID, text)
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "chat_roles_changed",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"chat_id":    chatID,
+			"changed":    roles,
+			"roles":      chat.ParticipantRoles(),
+			"changed_by": userID,
+		},
+	}, "")
+
+	return chat, nil
+}
+
+// RenameChat changes the name of a group chat.
+func (s *Server) RenameChat(ctx context.Context, userID, chatID, name string) (*database.Chat, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxChatNameLength {
+		return nil, ErrInvalidName
+	}
+
+	chat, err := s.authorize(ctx, userID, chatID, database.PermRename)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Name == name {
+		return chat, nil
+	}
+
+	if err := s.db.Chats().Rename(ctx, chatID, name); err != nil {
+		return nil, fmt.Errorf("failed to rename chat: %v", err)
+	}
+	chat.Name = name
+
+	s.recordChatChange(ctx, &database.Change{
+		Type:   database.ChangeChatUpdated,
+		ChatID: chatID,
+		UserID: userID,
+	})
+	s.postSystemMessage(ctx, chatID, fmt.Sprintf("%s renamed the chat to '%s'", s.userName(ctx, userID), name))
+
+	s.BroadcastToChat(chatID, WSEvent{
+		Type:   "chat_renamed",
+		ChatID: chatID,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"chat_id":    chatID,
+			"name":       name,
+			"renamed_by": userID,
+		},
+	}, "")
+
+	return chat, nil
+}
+
+func roleTitle(role string) string {
+	switch role {
+	case database.ChatRoleAdmin:
+		return "an admin"
+	case database.ChatRoleReadOnly:
+		return "read-only"
+	}
+	return "a member"
+}
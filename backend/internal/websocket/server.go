@@ -2,23 +2,29 @@ package websocket
 
 import (
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/search"
 	"context"
 	"fmt"
 	"log"
 	"maps"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func NewServer(db *database.Client) *Server {
+func NewServer(db database.Store, auth identity.Provider) *Server {
 	return &Server{
 		clients:       make(map[string]*Client),
 		chatListeners: make(map[string]context.CancelFunc),
+		subscribers:   make(map[string]map[string]bool),
 		mu:            &sync.RWMutex{},
 		db:            db,
+		auth:          auth,
+		search:        search.NewService(db),
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -26,19 +32,22 @@ func NewServer(db *database.Client) *Server {
 	}
 }
 
-func (s *Server) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "Token required", http.StatusUnauthorized)
-		return
-	}
+// FileReleaser deletes the stored files of removed attachments that
+// nothing refers to anymore. attachment.Service implements it.
+type FileReleaser interface {
+	Release(ctx context.Context, attachments []database.Attachment)
+}
 
-	ctx := r.Context()
-	userUID, err := s.db.ValidateIdToken(ctx, token)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+// SetFileReleaser makes the server free the files of the attachments
+// it removes. Without one, only their metadata is deleted.
+func (s *Server) SetFileReleaser(files FileReleaser) {
+	s.files = files
+}
+
+// HandleConnection upgrades a request that was already authenticated
+// (see authentication.RequireSocketAuth) with the given token.
+func (s *Server) HandleConnection(w http.ResponseWriter, r *http.Request, verified *identity.Token) {
+	userUID := verified.UID
 
 	connection, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -48,9 +57,11 @@ func (s *Server) HandleConnection(w http.ResponseWriter, r *http.Request) {
 	defer connection.Close()
 
 	client := &Client{
-		Connection: connection,
-		UserID:     userUID,
-		LastSeen:   time.Now(),
+		Connection:   connection,
+		UserID:       userUID,
+		SessionID:    verified.SessionID,
+		LastSeen:     time.Now(),
+		TokenExpires: verified.Expires,
 	}
 
 	s.mu.Lock()
@@ -124,6 +135,12 @@ func (s *Server) BroadcastToChat(chatID string, event WSEvent, excludeUserID str
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	for userID := range s.subscribers[chatID] {
+		if !slices.Contains(participants, userID) {
+			participants = append(participants, userID)
+		}
+	}
+
 	for _, userID := range participants {
 		if userID == excludeUserID {
 			continue
@@ -163,17 +180,66 @@ func (s *Server) handleIncomingEvent(userID string, event WSEvent) {
 	switch event.Type {
 	case "send_message":
 		s.handleSendMessage(userID, event)
+	case "edit_message":
+		s.handleEditMessage(userID, event)
+	case "delete_message":
+		s.handleDeleteMessage(userID, event)
+	case "add_reaction":
+		s.handleReaction(userID, event, true)
+	case "remove_reaction":
+		s.handleReaction(userID, event, false)
+	case "forward_messages":
+		s.handleForwardMessages(userID, event)
 	case "typing":
 		s.handleTyping(userID, event)
 	case "message_read":
 		s.handleMessageRead(userID, event)
+	case "message_listened":
+		s.handleMessageListened(userID, event)
 	case "ping":
 		s.handlePing(userID, event)
+	case "reauth":
+		s.handleReauth(userID, event)
 	default:
 		log.Printf("Unknown event type from user %s: %s", userID, event.Type)
 	}
 }
 
+// DisconnectSession closes the user's connection if it was opened
+// with sessionID, or whatever connection the user has when sessionID
+// is empty. It is used when sessions are revoked: the token was only
+// checked at upgrade, so the socket would otherwise stay open.
+func (s *Server) DisconnectSession(userID, sessionID string) {
+	s.mu.RLock()
+	client, exists := s.clients[userID]
+	s.mu.RUnlock()
+
+	if !exists || (sessionID != "" && client.SessionID != sessionID) {
+		return
+	}
+
+	s.DisconnectUser(userID, WSEvent{
+		Type: "session_revoked",
+		Data: map[string]string{"session_id": client.SessionID},
+	})
+}
+
+// DisconnectUser sends event to the user and closes the connection.
+func (s *Server) DisconnectUser(userID string, event WSEvent) {
+	s.mu.RLock()
+	client, exists := s.clients[userID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	s.SendToUser(userID, event)
+	client.Connection.Close()
+
+	log.Printf("Disconnected user %s: %s", userID, event.Type)
+}
+
 func (s *Server) GetConnectedUsers() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -201,30 +267,41 @@ func (s *Server) Stop() {
 	}
 }
 
+// BroadcastChatCreated sends chat_created with chatData to every user
+// listed in chatData["participants"].
 func (s *Server) BroadcastChatCreated(chatID string, chatData map[string]interface{}) error {
-	participants, ok := chatData["participants"].([]interface{})
-	if !ok {
+	var participantIDs []string
+	switch participants := chatData["participants"].(type) {
+	case []string:
+		participantIDs = participants
+	case []interface{}:
+		for _, p := range participants {
+			if str, ok := p.(string); ok {
+				participantIDs = append(participantIDs, str)
+			}
+		}
+	default:
 		return fmt.Errorf("invalid participants format")
 	}
 
+	s.SendChatCreated(chatID, chatData, participantIDs)
+	return nil
+}
+
+// SendChatCreated sends chat_created with chatData to the given
+// users only, such as members who have just joined an existing chat.
+func (s *Server) SendChatCreated(chatID string, chatData map[string]interface{}, userIDs []string) {
 	event := WSEvent{
 		Type:   "chat_created",
 		ChatID: chatID,
 		Data:   chatData,
 	}
 
-	participantIDs := make([]string, len(participants))
-	for i, p := range participants {
-		if str, ok := p.(string); ok {
-			participantIDs[i] = str
-		}
-	}
-
 	sentCount := 0
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, userID := range participantIDs {
+	for _, userID := range userIDs {
 		if client, exists := s.clients[userID]; exists {
 			if err := client.Connection.WriteJSON(event); err != nil {
 				log.Printf("Failed to send chat_created to user %s: %v", userID, err)
@@ -236,8 +313,7 @@ func (s *Server) BroadcastChatCreated(chatID string, chatData map[string]interfa
 	}
 
 	log.Printf("Broadcasted chat %s creation to %d/%d users",
-		chatID, sentCount, len(participantIDs))
-	return nil
+		chatID, sentCount, len(userIDs))
 }
 
 func (s *Server) GetClients() map[string]*Client {
@@ -253,19 +329,33 @@ func (s *Server) SendToUserSafe(userID string, event WSEvent) error {
 	return s.SendToUser(userID, event)
 }
 
+// startPing keeps the connection alive and tells the client once
+// when its ID token has expired, so it can refresh the token and
+// send a "reauth" event instead of reconnecting.
 func (s *Server) startPing(client *Client) {
 	ticker := time.NewTicker(20 * time.Second)
 	defer ticker.Stop()
 
+	var notifiedExpiry time.Time
+
 	for range ticker.C {
 		s.mu.RLock()
 		_, exists := s.clients[client.UserID]
+		tokenExpires := client.TokenExpires
 		s.mu.RUnlock()
 
 		if !exists {
 			return
 		}
 
+		if time.Now().After(tokenExpires) && !tokenExpires.Equal(notifiedExpiry) {
+			notifiedExpiry = tokenExpires
+			s.SendToUser(client.UserID, WSEvent{
+				Type: "token_expired",
+				Data: map[string]interface{}{"expired_at": tokenExpires},
+			})
+		}
+
 		client.Connection.SetWriteDeadline(time.Now().Add(5 * time.Second))
 		err := client.Connection.WriteMessage(websocket.PingMessage, []byte{})
 		client.Connection.SetWriteDeadline(time.Time{})
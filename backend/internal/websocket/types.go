@@ -2,6 +2,8 @@ package websocket
 
 import (
 	"MyChatServer/internal/database"
+	"MyChatServer/internal/identity"
+	"MyChatServer/internal/search"
 	"context"
 	"sync"
 	"time"
@@ -19,7 +21,11 @@ type WSEvent struct {
 type Client struct {
 	Connection *websocket.Conn
 	UserID     string
+	SessionID  string
 	LastSeen   time.Time
+	// TokenExpires is when the ID token the client authenticated
+	// with expires. A "reauth" event moves it forward.
+	TokenExpires time.Time
 }
 
 // Server manages all WebSocket connections:
@@ -29,8 +35,15 @@ type Server struct {
 	mu            *sync.RWMutex
 	clients       map[string]*Client
 	chatListeners map[string]context.CancelFunc
-	upgrader      *websocket.Upgrader
-	db            *database.Client
+	// subscribers holds, per channel, the subscribers connected to
+	// this server. Channel events reach them through it rather than
+	// the chat's participants.
+	subscribers map[string]map[string]bool
+	upgrader    *websocket.Upgrader
+	db          database.Store
+	auth        identity.Provider
+	search      *search.Service
+	files       FileReleaser
 }
 
 type Message struct {
@@ -1,8 +1,16 @@
 package websocket
 
 import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"MyChatServer/internal/database"
+	"MyChatServer/internal/database/memory"
 )
 
 func TestMessageTypes(t *testing.T) {
@@ -16,6 +24,18 @@ func TestMessageTypes(t *testing.T) {
 		"message_sent",
 		"error",
 		"chat_created",
+		"reauth",
+		"reauthenticated",
+		"reauth_failed",
+		"token_expired",
+		"session_revoked",
+		"edit_message",
+		"delete_message",
+		"message_edited",
+		"message_deleted",
+		"add_reaction",
+		"remove_reaction",
+		"reaction_updated",
 	}
 
 	for _, msgType := range validTypes {
@@ -82,3 +102,902 @@ func TestClientStruct(t *testing.T) {
 
 	t.Log(" Client struct works")
 }
+
+func TestEditAndDeleteMessage(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{CreatedBy: "owner", Participants: []string{"owner", "u1", "u2"}})
+	first := &database.Message{ID: "m1", SenderID: "u1", Text: "helo", Timestamp: time.Now()}
+	last := &database.Message{ID: "m2", SenderID: "u2", Text: "latest", Timestamp: time.Now().Add(time.Second)}
+	store.Messages().Add(ctx, chatID, first)
+	store.Messages().Add(ctx, chatID, last)
+	store.Chats().SetLastMessage(ctx, chatID, last)
+
+	msg, err := server.EditMessage(ctx, "u1", chatID, "m1", "hello")
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if msg.Text != "hello" || len(msg.Edits) != 1 || msg.Edits[0].Text != "helo" {
+		t.Errorf("edited message = %+v, want text hello with one edit", msg)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		run  func() error
+	}{
+		{"other user edits", ErrNotAllowed, func() error {
+			_, err := server.EditMessage(ctx, "u2", chatID, "m1", "hijack")
+			return err
+		}},
+		{"stranger edits", ErrNotParticipant, func() error {
+			_, err := server.EditMessage(ctx, "stranger", chatID, "m1", "x")
+			return err
+		}},
+		{"empty text", ErrEmptyText, func() error {
+			_, err := server.EditMessage(ctx, "u1", chatID, "m1", "  ")
+			return err
+		}},
+		{"missing message", ErrMessageNotFound, func() error {
+			return server.DeleteMessage(ctx, "u1", chatID, "missing", true)
+		}},
+		{"other user deletes for everyone", ErrNotAllowed, func() error {
+			return server.DeleteMessage(ctx, "u2", chatID, "m1", true)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(); !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if err := server.DeleteMessage(ctx, "u2", chatID, "m1", false); err != nil {
+		t.Fatalf("delete for me: %v", err)
+	}
+	hidden, _ := store.Messages().Get(ctx, chatID, "m1")
+	if !hidden.HiddenFrom("u2") || hidden.Deleted() {
+		t.Errorf("after delete for me = %+v, want hidden from u2 only", hidden)
+	}
+
+	if err := server.DeleteMessage(ctx, "owner", chatID, "m2", true); err != nil {
+		t.Fatalf("admin delete for everyone: %v", err)
+	}
+	chat, _ := store.Chats().Get(ctx, chatID)
+	if chat.LastMessage == nil || chat.LastMessage.Text != "hello" {
+		t.Errorf("last_message = %+v, want the edited m1", chat.LastMessage)
+	}
+	if _, err := server.EditMessage(ctx, "u2", chatID, "m2", "again"); !errors.Is(err, ErrMessageDeleted) {
+		t.Errorf("edit after delete error = %v, want ErrMessageDeleted", err)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2", "u3"}})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "m1", SenderID: "u1", Text: "hi", Timestamp: time.Now()})
+
+	server.React(ctx, "u1", chatID, "m1", "👍", true)
+	server.React(ctx, "u2", chatID, "m1", "👍", true)
+	server.React(ctx, "u2", chatID, "m1", "👍", true)
+	server.React(ctx, "u3", chatID, "m1", "🎉", true)
+	msg, err := server.React(ctx, "u3", chatID, "m1", "🎉", false)
+	if err != nil {
+		t.Fatalf("React: %v", err)
+	}
+
+	reactions := msg.ReactionsFor("u2")
+	if len(reactions) != 1 || reactions[0].Emoji != "👍" || reactions[0].Count != 2 || !reactions[0].Reacted {
+		t.Errorf("ReactionsFor(u2) = %+v, want 👍 x2 reacted", reactions)
+	}
+	if msg.ReactionsFor("u3")[0].Reacted {
+		t.Error("u3 should not be marked as reacted")
+	}
+
+	if _, err := server.React(ctx, "u1", chatID, "m1", "", true); !errors.Is(err, ErrInvalidEmoji) {
+		t.Errorf("empty emoji error = %v, want ErrInvalidEmoji", err)
+	}
+	if _, err := server.React(ctx, "stranger", chatID, "m1", "👍", true); !errors.Is(err, ErrNotParticipant) {
+		t.Errorf("stranger error = %v, want ErrNotParticipant", err)
+	}
+}
+
+func TestValidateReply(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	otherID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "root", SenderID: "u1", Timestamp: time.Now()})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "reply", SenderID: "u1", Timestamp: time.Now(), ThreadID: "root"})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "plain", SenderID: "u1", Timestamp: time.Now()})
+	store.Messages().Add(ctx, otherID, &database.Message{ID: "elsewhere", SenderID: "u1", Timestamp: time.Now()})
+
+	tests := []struct {
+		name     string
+		replyTo  string
+		threadID string
+		want     error
+	}{
+		{"plain message", "", "", nil},
+		{"quote", "plain", "", nil},
+		{"thread reply", "", "root", nil},
+		{"quote inside thread", "reply", "root", nil},
+		{"quote root inside thread", "root", "root", nil},
+		{"quote from another chat", "elsewhere", "", ErrInvalidReply},
+		{"quote outside thread", "plain", "root", ErrInvalidReply},
+		{"nested thread", "", "reply", ErrInvalidThread},
+		{"missing thread", "", "missing", ErrInvalidThread},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := server.validateReply(ctx, chatID, tt.replyTo, tt.threadID); !errors.Is(err, tt.want) {
+				t.Errorf("validateReply(%q, %q) = %v, want %v", tt.replyTo, tt.threadID, err, tt.want)
+			}
+		})
+	}
+
+	first, err := server.saveMessageToFirestore(chatID, "u1", "in thread", "", "root", "", nil)
+	if err != nil {
+		t.Fatalf("saveMessageToFirestore: %v", err)
+	}
+	root, _ := store.Messages().Get(ctx, chatID, "root")
+	if root.ReplyCount != 1 || root.LastReplyAt.IsZero() {
+		t.Errorf("root = %+v, want one reply counted", root)
+	}
+	chat, _ := store.Chats().Get(ctx, chatID)
+	if chat.LastMessage != nil {
+		t.Errorf("last_message = %+v, thread replies should not change it", chat.LastMessage)
+	}
+
+	second, err := server.saveMessageToFirestore(chatID, "u1", "later", "", "root", "", nil)
+	if err != nil {
+		t.Fatalf("saveMessageToFirestore: %v", err)
+	}
+	for range 2 {
+		if err := server.DeleteMessage(ctx, "u1", chatID, second.ID, true); err != nil {
+			t.Fatalf("DeleteMessage: %v", err)
+		}
+	}
+	root, _ = store.Messages().Get(ctx, chatID, "root")
+	if root.ReplyCount != 1 || !root.LastReplyAt.Equal(first.Timestamp) {
+		t.Errorf("root = %+v, want the deleted reply uncounted once", root)
+	}
+
+	if err := server.DeleteMessage(ctx, "u1", chatID, first.ID, true); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	// Only the "reply" fixture, which was never counted, is left.
+	fixture, _ := store.Messages().Get(ctx, chatID, "reply")
+	root, _ = store.Messages().Get(ctx, chatID, "root")
+	if root.ReplyCount != 0 || !root.LastReplyAt.Equal(fixture.Timestamp) {
+		t.Errorf("root = %+v, want the last reply to fall back to %v", root, fixture.Timestamp)
+	}
+}
+
+func TestForwardMessages(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	fromID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	toID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u3"}})
+	foreignID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u2", "u3"}})
+	sent := time.Now().Add(-time.Hour)
+	store.Messages().Add(ctx, fromID, &database.Message{ID: "m1", SenderID: "u2", Text: "first", Timestamp: sent})
+	store.Messages().Add(ctx, fromID, &database.Message{ID: "m2", SenderID: "u1", Text: "second", Timestamp: sent.Add(time.Second)})
+	store.Messages().Add(ctx, fromID, &database.Message{ID: "gone", SenderID: "u2", Timestamp: sent, DeletedAt: sent})
+
+	results, err := server.ForwardMessages(ctx, "u1", fromID, []string{"m1", "m2", "m1"}, []string{toID})
+	if err != nil {
+		t.Fatalf("ForwardMessages: %v", err)
+	}
+	if len(results) != 1 || len(results[0].MessageIDs) != 2 || results[0].Error != "" {
+		t.Fatalf("results = %+v, want two copies in %s", results, toID)
+	}
+
+	copies, _ := store.Messages().List(ctx, toID)
+	if len(copies) != 2 || copies[0].Text != "first" || copies[1].Text != "second" {
+		t.Fatalf("target messages = %+v, want first and second in order", copies)
+	}
+	origin := copies[0].ForwardedFrom
+	if copies[0].SenderID != "u1" || origin == nil || origin.SenderID != "u2" || origin.ChatID != fromID || origin.MessageID != "m1" {
+		t.Errorf("copy = %+v, origin = %+v; want u1 forwarding m1 of u2", copies[0], origin)
+	}
+
+	again, err := server.ForwardMessages(ctx, "u3", toID, []string{copies[0].ID}, []string{foreignID})
+	if err != nil {
+		t.Fatalf("second ForwardMessages: %v", err)
+	}
+	chained, _ := store.Messages().Get(ctx, foreignID, again[0].MessageIDs[0])
+	if chained.ForwardedFrom == nil || chained.ForwardedFrom.MessageID != "m1" {
+		t.Errorf("forward of a forward origin = %+v, want m1", chained.ForwardedFrom)
+	}
+
+	tests := []struct {
+		name    string
+		ids     []string
+		targets []string
+		want    error
+	}{
+		{"not in target", []string{"m1"}, []string{foreignID}, ErrNotParticipant},
+		{"missing message", []string{"missing"}, []string{toID}, ErrMessageNotFound},
+		{"deleted message", []string{"gone"}, []string{toID}, ErrMessageDeleted},
+		{"no targets", []string{"m1"}, nil, ErrNothingToForward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := server.ForwardMessages(ctx, "u1", fromID, tt.ids, tt.targets); !errors.Is(err, tt.want) {
+				t.Errorf("ForwardMessages error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// failingMessages fails every batch write.
+type failingMessages struct {
+	database.MessageRepository
+}
+
+func (failingMessages) AddAll(ctx context.Context, chatID string, messages []*database.Message) error {
+	return errors.New("write failed")
+}
+
+// failingStore is a memory store whose batch message writes fail.
+type failingStore struct {
+	*memory.Store
+}
+
+func (s failingStore) Messages() database.MessageRepository {
+	return failingMessages{s.Store.Messages()}
+}
+
+func TestForwardFailureRemovesAttachments(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+
+	fromID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	toID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u3"}})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "a1", ChatID: fromID, UploaderID: "u2", Key: "k1"})
+	store.Messages().Add(ctx, fromID, &database.Message{
+		ID: "m1", SenderID: "u2", Timestamp: time.Now(),
+		Attachments: []database.MessageAttachment{{ID: "a1"}},
+	})
+	store.Messages().Add(ctx, fromID, &database.Message{
+		ID: "m2", SenderID: "u2", Timestamp: time.Now(),
+		Attachments: []database.MessageAttachment{{ID: "a1"}, {ID: "missing"}},
+	})
+
+	tests := []struct {
+		name  string
+		store database.Store
+		ids   []string
+	}{
+		{"save fails", failingStore{store}, []string{"m1"}},
+		{"copy fails", store, []string{"m1", "m2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(tt.store, nil)
+			var released releasedFiles
+			server.SetFileReleaser(&released)
+
+			results, err := server.ForwardMessages(ctx, "u1", fromID, tt.ids, []string{toID})
+			if err != nil || len(results) != 1 || results[0].Error == "" {
+				t.Fatalf("ForwardMessages = %+v, %v; want a failed result", results, err)
+			}
+			if left, _ := store.Attachments().ListByChat(ctx, toID); len(left) != 0 {
+				t.Errorf("attachments in target = %+v, want none", left)
+			}
+			if len(released) == 0 {
+				t.Error("removed copies were not released")
+			}
+		})
+	}
+}
+
+func TestResolveAttachments(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "mine", ChatID: chatID, UploaderID: "u1", MIMEType: "image/png", Size: 3})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "theirs", ChatID: chatID, UploaderID: "u2"})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "elsewhere", ChatID: "other", UploaderID: "u1"})
+
+	refs, err := server.resolveAttachments(ctx, "u1", chatID, []string{"mine", "mine"})
+	if err != nil || len(refs) != 1 || refs[0].MIMEType != "image/png" || refs[0].Size != 3 {
+		t.Fatalf("resolveAttachments = %+v, %v; want one image/png ref", refs, err)
+	}
+
+	for _, id := range []string{"theirs", "elsewhere", "missing"} {
+		if _, err := server.resolveAttachments(ctx, "u1", chatID, []string{id}); !errors.Is(err, ErrInvalidAttachment) {
+			t.Errorf("resolveAttachments(%s) error = %v, want ErrInvalidAttachment", id, err)
+		}
+	}
+
+	msg, _ := server.saveMessageToFirestore(chatID, "u1", "", "", "", "", refs)
+	targetID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	results, err := server.ForwardMessages(ctx, "u1", chatID, []string{msg.ID}, []string{targetID})
+	if err != nil {
+		t.Fatalf("ForwardMessages: %v", err)
+	}
+	copied, _ := store.Messages().Get(ctx, targetID, results[0].MessageIDs[0])
+	if len(copied.Attachments) != 1 || copied.Attachments[0].ID == "mine" {
+		t.Fatalf("forwarded attachments = %+v, want a copy registered with the target chat", copied.Attachments)
+	}
+	if attachment, err := store.Attachments().Get(ctx, copied.Attachments[0].ID); err != nil || attachment.ChatID != targetID {
+		t.Errorf("copied attachment = %+v, %v; want it in %s", attachment, err, targetID)
+	}
+}
+
+// releasedFiles records what the server hands to its FileReleaser.
+type releasedFiles []database.Attachment
+
+func (r *releasedFiles) Release(ctx context.Context, attachments []database.Attachment) {
+	*r = append(*r, attachments...)
+}
+
+func TestDeleteRemovesAttachments(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+	var released releasedFiles
+	server.SetFileReleaser(&released)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Type: "private", Participants: []string{"u1", "u2"}})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "voice", ChatID: chatID, UploaderID: "u1", Key: "k1", Kind: database.AttachmentVoice})
+	store.Attachments().Create(ctx, &database.Attachment{ID: "kept", ChatID: chatID, UploaderID: "u1", Key: "k2"})
+	store.Messages().Add(ctx, chatID, &database.Message{
+		ID: "m1", SenderID: "u1", Text: "hi @u2", Timestamp: time.Now(),
+		Attachments: []database.MessageAttachment{{ID: "voice", Kind: database.AttachmentVoice}},
+		ListenedBy:  []string{"u2"},
+		Mentions:    []string{"u2"},
+	})
+
+	if err := server.DeleteMessage(ctx, "u1", chatID, "m1", true); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	msg, _ := store.Messages().Get(ctx, chatID, "m1")
+	if len(msg.Attachments) != 0 || len(msg.ListenedBy) != 0 || len(msg.Mentions) != 0 {
+		t.Errorf("deleted message = %+v, want no attachments, listeners or mentions", msg)
+	}
+	if _, err := store.Attachments().Get(ctx, "voice"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("attachment of a deleted message = %v, want it removed", err)
+	}
+	if len(released) != 1 || released[0].Key != "k1" {
+		t.Errorf("released = %+v, want the voice file", released)
+	}
+
+	// Messages deleted before their content was cleared still hide it.
+	stale := &database.Message{ID: "m0", DeletedAt: time.Now(), Mentions: []string{"u2"},
+		Attachments: []database.MessageAttachment{{ID: "kept"}}}
+	if data := messageData(stale); data["attachments"] != nil || data["mentions"] != nil {
+		t.Errorf("messageData of a deleted message = %v", data)
+	}
+
+	if err := server.DeleteChat(ctx, "u2", chatID); err != nil {
+		t.Fatalf("DeleteChat: %v", err)
+	}
+	if len(released) != 2 || released[1].Key != "k2" {
+		t.Errorf("released = %+v, want the chat's remaining file too", released)
+	}
+}
+
+func TestUpdateAttachment(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1"}})
+	image := &database.Attachment{ID: "img", ChatID: chatID, UploaderID: "u1", MIMEType: "image/png", Width: 640, Height: 400}
+	store.Attachments().Create(ctx, image)
+
+	msg, err := server.SendMessage(ctx, "u1", chatID, "", []database.MessageAttachment{image.Ref()})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	image.Blurhash = "LEHV6nWB2yk8"
+	image.Thumbnail = &database.Thumbnail{MIMEType: "image/png", Width: 320, Height: 200}
+	if err := server.UpdateAttachment(ctx, chatID, msg.ID, image); err != nil {
+		t.Fatalf("UpdateAttachment: %v", err)
+	}
+
+	updated, _ := store.Messages().Get(ctx, chatID, msg.ID)
+	if got := updated.Attachments[0]; got.Thumbnail == nil || got.Thumbnail.Width != 320 || got.Blurhash == "" || got.Width != 640 {
+		t.Errorf("attachment = %+v, want the thumbnail and blurhash copied", got)
+	}
+
+	if _, err := server.SendMessage(ctx, "u1", chatID, " ", nil); !errors.Is(err, ErrEmptyText) {
+		t.Errorf("SendMessage without content error = %v, want ErrEmptyText", err)
+	}
+}
+
+func TestMarkListened(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+	voice := &database.Attachment{ID: "rec", ChatID: chatID, UploaderID: "u1", Kind: database.AttachmentVoice, DurationMS: 1500}
+	file := &database.Attachment{ID: "doc", ChatID: chatID, UploaderID: "u1"}
+	store.Attachments().Create(ctx, voice)
+	store.Attachments().Create(ctx, file)
+
+	if _, err := server.resolveAttachments(ctx, "u1", chatID, []string{"rec", "doc"}); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("voice with another file error = %v, want ErrInvalidAttachment", err)
+	}
+
+	msg, _ := server.SendMessage(ctx, "u1", chatID, "", []database.MessageAttachment{voice.Ref()})
+	text, _ := server.SendMessage(ctx, "u1", chatID, "hello", nil)
+
+	for _, uid := range []string{"u1", "u2", "u2"} {
+		if err := server.MarkListened(ctx, uid, chatID, msg.ID); err != nil {
+			t.Fatalf("MarkListened(%s): %v", uid, err)
+		}
+	}
+	got, _ := store.Messages().Get(ctx, chatID, msg.ID)
+	if len(got.ListenedBy) != 1 || got.ListenedBy[0] != "u2" || len(got.ReadBy) != 1 {
+		t.Errorf("ListenedBy = %v, ReadBy = %v; want only u2 listened and read receipts untouched", got.ListenedBy, got.ReadBy)
+	}
+
+	if err := server.MarkListened(ctx, "u2", chatID, text.ID); !errors.Is(err, ErrNotVoice) {
+		t.Errorf("MarkListened(text) error = %v, want ErrNotVoice", err)
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+
+	msg, _ := server.SendMessage(ctx, "u1", chatID, "lunch at noon", nil)
+	if hits, _ := server.search.Search(ctx, "u2", "lunch", 10); len(hits) != 1 {
+		t.Fatalf("Search after send = %+v, want the message", hits)
+	}
+
+	server.EditMessage(ctx, "u1", chatID, msg.ID, "dinner at noon")
+	if hits, _ := server.search.Search(ctx, "u2", "dinner", 10); len(hits) != 1 {
+		t.Errorf("Search after edit = %+v, want the edited message", hits)
+	}
+
+	server.DeleteMessage(ctx, "u1", chatID, msg.ID, true)
+	if entries, _ := store.Search().Query(ctx, []string{chatID}, []string{"noon"}, 10); len(entries) != 0 {
+		t.Errorf("index after delete = %+v, want empty", entries)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	store.Users().Save(ctx, &database.User{UID: "u1", Name: "Ivan Petrov", Email: "ivan@example.com"})
+	store.Users().Save(ctx, &database.User{UID: "u2", Name: "Anna", Email: "anna.k@example.com"})
+	store.Users().Save(ctx, &database.User{UID: "u3", Name: "Oleg", Email: "oleg@example.com"})
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2", "u3"}})
+
+	unread := func(uid string) int {
+		chat, _ := store.Chats().Get(ctx, chatID)
+		return chat.UnreadMentions[uid]
+	}
+
+	first, _ := server.SendMessage(ctx, "u3", chatID, "@ivanpetrov and @Anna.K. see oleg@example.com, @oleg", nil)
+	if len(first.Mentions) != 2 || first.Mentions[0] != "u1" || first.Mentions[1] != "u2" {
+		t.Fatalf("Mentions = %v, want [u1 u2]", first.Mentions)
+	}
+
+	all, _ := server.SendMessage(ctx, "u1", chatID, "@all lunch?", nil)
+	if len(all.Mentions) != 2 || unread("u1") != 1 || unread("u2") != 2 || unread("u3") != 1 {
+		t.Fatalf("@all Mentions = %v, unread = %d/%d/%d; want [u2 u3] and 1/2/1",
+			all.Mentions, unread("u1"), unread("u2"), unread("u3"))
+	}
+
+	read := WSEvent{Data: map[string]interface{}{"chat_id": chatID, "message_id": first.ID}}
+	server.handleMessageRead("u1", read)
+	server.handleMessageRead("u1", read)
+	if unread("u1") != 0 {
+		t.Errorf("unread(u1) after reading = %d, want 0", unread("u1"))
+	}
+
+	server.DeleteMessage(ctx, "u1", chatID, all.ID, true)
+	server.DeleteMessage(ctx, "u2", chatID, first.ID, false)
+	if unread("u2") != 0 || unread("u3") != 0 {
+		t.Errorf("unread after deletes = %d/%d, want 0/0", unread("u2"), unread("u3"))
+	}
+}
+
+func TestSendMessageDedupesTempID(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{Participants: []string{"u1", "u2"}})
+
+	send := func(userID, tempID string) {
+		server.handleSendMessage(userID, WSEvent{Data: map[string]interface{}{
+			"chat_id": chatID,
+			"text":    "hello",
+			"temp_id": tempID,
+		}})
+	}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send("u1", "t1")
+		}()
+	}
+	wg.Wait()
+	send("u2", "t1")
+	send("u1", strings.Repeat("x", maxTempIDLength+1))
+
+	list, _ := store.Messages().List(ctx, chatID)
+	if len(list) != 2 {
+		t.Fatalf("stored %d messages, want one per sender", len(list))
+	}
+	for _, msg := range list {
+		if msg.TempID != "t1" || msg.ID != clientMessageID(msg.SenderID, "t1") {
+			t.Errorf("message %+v, want temp_id t1 and a derived ID", msg)
+		}
+	}
+	if data := messageData(&list[0]); data["temp_id"] != "t1" {
+		t.Errorf("messageData temp_id = %v, want t1", data["temp_id"])
+	}
+	// Resends must not be journaled as new messages.
+	if changes, _ := store.Changes().ListSince(ctx, "u2", 0, 100); len(changes) != 2 {
+		t.Errorf("u2 journal has %d changes, want one per stored message", len(changes))
+	}
+}
+
+func TestMembership(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	store.Users().Save(ctx, &database.User{UID: "owner", Name: "Anna"})
+	store.Users().Save(ctx, &database.User{UID: "u2", Name: "Oleg"})
+	store.Users().Save(ctx, &database.User{UID: "u3", Email: "ivan@example.com"})
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{
+		Type: "group", CreatedBy: "owner", Participants: []string{"owner", "u2"},
+	})
+	privateID, _ := store.Chats().Create(ctx, &database.Chat{
+		Type: "private", CreatedBy: "owner", Participants: []string{"owner", "u2"},
+	})
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"member adds", func() error { _, err := server.AddParticipants(ctx, "u2", chatID, []string{"u3"}); return err }, ErrNoPermission},
+		{"unknown user", func() error { _, err := server.AddParticipants(ctx, "owner", chatID, []string{"ghost"}); return err }, ErrUnknownUser},
+		{"private chat", func() error { _, err := server.AddParticipants(ctx, "owner", privateID, []string{"u3"}); return err }, ErrNoPermission},
+		{"no users", func() error { _, err := server.AddParticipants(ctx, "owner", chatID, nil); return err }, ErrNoUsers},
+		{"remove owner", func() error { return server.RemoveParticipant(ctx, "u2", chatID, "owner") }, ErrNoPermission},
+		{"owner leaves", func() error { return server.LeaveChat(ctx, "owner", chatID) }, ErrOwnerCannotLeave},
+		{"member deletes", func() error { return server.DeleteChat(ctx, "u2", chatID) }, ErrNoPermission},
+		{"outsider leaves", func() error { return server.LeaveChat(ctx, "u3", chatID) }, ErrNotParticipant},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	chat, err := server.AddParticipants(ctx, "owner", chatID, []string{"u3", "u2"})
+	if err != nil || !slices.Equal(chat.Participants, []string{"owner", "u2", "u3"}) {
+		t.Fatalf("AddParticipants = %v, %v; want [owner u2 u3]", chat, err)
+	}
+	if err := server.RemoveParticipant(ctx, "owner", chatID, "u3"); err != nil {
+		t.Fatalf("RemoveParticipant: %v", err)
+	}
+	if err := server.LeaveChat(ctx, "u2", chatID); err != nil {
+		t.Fatalf("LeaveChat: %v", err)
+	}
+
+	list, _ := store.Messages().List(ctx, chatID)
+	var notices []string
+	for _, msg := range list {
+		if msg.SenderID == systemSenderID {
+			notices = append(notices, msg.Text)
+		}
+	}
+	want := []string{"Anna added ivan@example.com", "Anna removed ivan@example.com", "Oleg left the chat"}
+	if !slices.Equal(notices, want) {
+		t.Errorf("system messages = %q, want %q", notices, want)
+	}
+
+	if err := server.LeaveChat(ctx, "owner", chatID); err != nil {
+		t.Fatalf("last LeaveChat: %v", err)
+	}
+	if _, err := store.Chats().Get(ctx, chatID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("chat after the last member left: err = %v, want ErrNotFound", err)
+	}
+
+	if err := server.DeleteChat(ctx, "u2", privateID); err != nil {
+		t.Fatalf("DeleteChat(private): %v", err)
+	}
+	if list, _ := store.Messages().List(ctx, privateID); len(list) != 0 {
+		t.Errorf("private chat still has %d messages", len(list))
+	}
+}
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	store.Users().Save(ctx, &database.User{UID: "owner", Name: "Anna"})
+	store.Users().Save(ctx, &database.User{UID: "u2", Name: "Oleg"})
+	store.Users().Save(ctx, &database.User{UID: "u3", Name: "Ivan"})
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{
+		Type: "group", Name: "team", CreatedBy: "owner", Participants: []string{"owner", "u2", "u3"},
+	})
+	store.Messages().Add(ctx, chatID, &database.Message{ID: "m1", SenderID: "owner", Text: "hi", Timestamp: time.Now()})
+
+	if _, err := server.SetRole(ctx, "owner", chatID, "u2", database.ChatRoleAdmin); err != nil {
+		t.Fatalf("SetRole(admin): %v", err)
+	}
+	if _, err := server.SetRole(ctx, "u2", chatID, "u3", database.ChatRoleReadOnly); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("admin changes a role: err = %v, want ErrNoPermission", err)
+	}
+	if _, err := server.SetRole(ctx, "owner", chatID, "u3", database.ChatRoleOwner); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("SetRole(owner): err = %v, want ErrInvalidRole", err)
+	}
+	server.SetRole(ctx, "owner", chatID, "u3", database.ChatRoleReadOnly)
+
+	if _, err := server.SendMessage(ctx, "u3", chatID, "hello", nil); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("read-only sends: err = %v, want ErrNoPermission", err)
+	}
+	if _, err := server.React(ctx, "u3", chatID, "m1", "👍", true); err != nil {
+		t.Errorf("read-only reacts: %v", err)
+	}
+	if _, err := server.RenameChat(ctx, "u2", chatID, "  crew "); err != nil {
+		t.Errorf("admin renames: %v", err)
+	}
+	if _, err := server.PinMessage(ctx, "u3", chatID, "m1", true); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("read-only pins: err = %v, want ErrNoPermission", err)
+	}
+	if msg, err := server.PinMessage(ctx, "u2", chatID, "m1", true); err != nil || !msg.Pinned() || msg.PinnedBy != "u2" {
+		t.Errorf("admin pins: %+v, %v", msg, err)
+	}
+	if err := server.DeleteMessage(ctx, "u2", chatID, "m1", true); err != nil {
+		t.Errorf("admin deletes any message: %v", err)
+	}
+	if msg, _ := store.Messages().Get(ctx, chatID, "m1"); msg.Pinned() {
+		t.Errorf("deleted message is still pinned: %+v", msg)
+	}
+	if _, err := server.PinMessage(ctx, "u2", chatID, "m1", true); !errors.Is(err, ErrMessageDeleted) {
+		t.Errorf("pin a deleted message: err = %v, want ErrMessageDeleted", err)
+	}
+	if err := server.RemoveParticipant(ctx, "u2", chatID, "owner"); !errors.Is(err, ErrCannotRemoveOwner) {
+		t.Errorf("admin removes owner: err = %v, want ErrCannotRemoveOwner", err)
+	}
+
+	chat, err := server.TransferOwnership(ctx, "owner", chatID, "u2")
+	if err != nil {
+		t.Fatalf("TransferOwnership: %v", err)
+	}
+	if chat.Name != "crew" || chat.Role("u2") != database.ChatRoleOwner || chat.Role("owner") != database.ChatRoleAdmin {
+		t.Errorf("chat = %+v, want name crew, u2 owner and the old owner admin", chat)
+	}
+	if err := server.LeaveChat(ctx, "owner", chatID); err != nil {
+		t.Errorf("former owner leaves: %v", err)
+	}
+	if err := server.DeleteChat(ctx, "u3", chatID); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("read-only deletes the chat: err = %v, want ErrNoPermission", err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	for _, uid := range []string{"owner", "u2", "u3", "u4"} {
+		store.Users().Save(ctx, &database.User{UID: uid, Name: uid})
+	}
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{
+		Type: "group", Name: "team", CreatedBy: "owner", Participants: []string{"owner", "u2"},
+	})
+
+	if _, err := server.CreateInvite(ctx, "u2", chatID, InviteOptions{}); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("member creates an invite: err = %v, want ErrNoPermission", err)
+	}
+	if _, err := server.CreateInvite(ctx, "owner", chatID, InviteOptions{MaxUses: -1}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("negative max_uses: err = %v, want ErrInvalidInvite", err)
+	}
+
+	once, err := server.CreateInvite(ctx, "owner", chatID, InviteOptions{MaxUses: 1, ExpiresIn: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	chat, pending, err := server.JoinByInvite(ctx, "u3", once.Token)
+	if err != nil || pending || !slices.Contains(chat.Participants, "u3") {
+		t.Fatalf("JoinByInvite = %+v, %v, %v; want u3 in the chat", chat, pending, err)
+	}
+	if _, _, err := server.JoinByInvite(ctx, "u3", once.Token); err != nil {
+		t.Errorf("member redeems the link again: %v", err)
+	}
+	if _, _, err := server.JoinByInvite(ctx, "u4", once.Token); !errors.Is(err, ErrInviteUsedUp) {
+		t.Errorf("used up link: err = %v, want ErrInviteUsedUp", err)
+	}
+
+	store.Invites().Create(ctx, &database.Invite{
+		Token: "old", ChatID: chatID, CreatedBy: "owner", ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if _, _, err := server.JoinByInvite(ctx, "u4", "old"); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("expired link: err = %v, want ErrInviteExpired", err)
+	}
+	if _, _, err := server.JoinByInvite(ctx, "u4", "missing"); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("unknown link: err = %v, want ErrInviteNotFound", err)
+	}
+
+	approval, _ := server.CreateInvite(ctx, "owner", chatID, InviteOptions{RequiresApproval: true})
+	if _, pending, err := server.JoinByInvite(ctx, "u4", approval.Token); err != nil || !pending {
+		t.Fatalf("JoinByInvite with approval = %v, %v; want pending", pending, err)
+	}
+	if _, err := server.ApproveJoinRequest(ctx, "owner", chatID, approval.Token, "u3"); !errors.Is(err, ErrNoJoinRequest) {
+		t.Errorf("approve without a request: err = %v, want ErrNoJoinRequest", err)
+	}
+	chat, err = server.ApproveJoinRequest(ctx, "owner", chatID, approval.Token, "u4")
+	if err != nil || !slices.Contains(chat.Participants, "u4") {
+		t.Fatalf("ApproveJoinRequest = %+v, %v; want u4 in the chat", chat, err)
+	}
+
+	store.Users().Save(ctx, &database.User{UID: "u5", Name: "u5"})
+	store.Users().Save(ctx, &database.User{UID: "u6", Name: "u6"})
+	single, _ := server.CreateInvite(ctx, "owner", chatID, InviteOptions{MaxUses: 1, RequiresApproval: true})
+	server.JoinByInvite(ctx, "u5", single.Token)
+	server.JoinByInvite(ctx, "u6", single.Token)
+	if _, err := server.ApproveJoinRequest(ctx, "owner", chatID, single.Token, "u5"); err != nil {
+		t.Fatalf("ApproveJoinRequest within the limit: %v", err)
+	}
+	if _, err := server.ApproveJoinRequest(ctx, "owner", chatID, single.Token, "u6"); !errors.Is(err, ErrInviteUsedUp) {
+		t.Errorf("approve over the limit: err = %v, want ErrInviteUsedUp", err)
+	}
+	store.Invites().Create(ctx, &database.Invite{
+		Token: "stale", ChatID: chatID, CreatedBy: "owner", RequiresApproval: true,
+		ExpiresAt: time.Now().Add(-time.Minute), Pending: []string{"u6"},
+	})
+	if _, err := server.ApproveJoinRequest(ctx, "owner", chatID, "stale", "u6"); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("approve after expiry: err = %v, want ErrInviteExpired", err)
+	}
+
+	invites, err := server.ListInvites(ctx, "u2", chatID)
+	if err != nil || len(invites) != 0 {
+		t.Errorf("member lists invites = %v, %v; want none", invites, err)
+	}
+	invites, _ = server.ListInvites(ctx, "owner", chatID)
+	if len(invites) != 5 {
+		t.Errorf("owner lists %d invites, want 5", len(invites))
+	}
+
+	if err := server.RevokeInvite(ctx, "u2", chatID, once.Token); !errors.Is(err, ErrNoPermission) {
+		t.Errorf("member revokes a link: err = %v, want ErrNoPermission", err)
+	}
+	if err := server.RevokeInvite(ctx, "owner", chatID, once.Token); err != nil {
+		t.Fatalf("RevokeInvite: %v", err)
+	}
+	if _, _, err := server.JoinByInvite(ctx, "u4", once.Token); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("revoked link: err = %v, want ErrInviteNotFound", err)
+	}
+}
+
+// failingChats fails to add participants.
+type failingChats struct {
+	database.ChatRepository
+}
+
+func (failingChats) AddParticipants(ctx context.Context, chatID string, uids []string) error {
+	return errors.New("write failed")
+}
+
+// lockedChatStore is a memory store where nobody can join a chat.
+type lockedChatStore struct {
+	*memory.Store
+}
+
+func (s lockedChatStore) Chats() database.ChatRepository {
+	return failingChats{s.Store.Chats()}
+}
+
+func TestFailedJoinKeepsInviteUse(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(lockedChatStore{store}, nil)
+
+	chatID, _ := store.Chats().Create(ctx, &database.Chat{
+		Type: "group", Name: "team", CreatedBy: "owner", Participants: []string{"owner"},
+	})
+	store.Invites().Create(ctx, &database.Invite{Token: "open", ChatID: chatID, CreatedBy: "owner", MaxUses: 1})
+	store.Invites().Create(ctx, &database.Invite{Token: "approval", ChatID: chatID, CreatedBy: "owner", MaxUses: 1, RequiresApproval: true, Pending: []string{"u3"}})
+
+	if _, _, err := server.JoinByInvite(ctx, "u2", "open"); err == nil {
+		t.Fatal("JoinByInvite succeeded, want the write error")
+	}
+	if invite, _ := store.Invites().Get(ctx, "open"); invite.Uses != 0 {
+		t.Errorf("open invite uses = %d after a failed join, want 0", invite.Uses)
+	}
+
+	if _, err := server.ApproveJoinRequest(ctx, "owner", chatID, "approval", "u3"); err == nil {
+		t.Fatal("ApproveJoinRequest succeeded, want the write error")
+	}
+	if invite, _ := store.Invites().Get(ctx, "approval"); invite.Uses != 0 || !slices.Contains(invite.Pending, "u3") {
+		t.Errorf("approval invite = %+v after a failed approval, want no uses and u3 pending", invite)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	server := NewServer(store, nil)
+
+	for _, uid := range []string{"owner", "editor", "reader"} {
+		store.Users().Save(ctx, &database.User{UID: uid, Name: uid})
+	}
+
+	if _, err := server.CreateChannel(ctx, "owner", "News", "no"); !errors.Is(err, ErrInvalidHandle) {
+		t.Errorf("short handle: err = %v, want ErrInvalidHandle", err)
+	}
+	channel, err := server.CreateChannel(ctx, "owner", "News", "@Daily_News")
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	if channel.Handle != "daily_news" || channel.Type != "channel" {
+		t.Errorf("CreateChannel = %+v, want channel @daily_news", channel)
+	}
+	if _, err := server.CreateChannel(ctx, "editor", "Copy", "daily_news"); !errors.Is(err, ErrHandleTaken) {
+		t.Errorf("taken handle: err = %v, want ErrHandleTaken", err)
+	}
+
+	if _, err := server.AddParticipants(ctx, "owner", channel.ID, []string{"editor"}); err != nil {
+		t.Fatalf("AddParticipants: %v", err)
+	}
+	chat, err := server.Subscribe(ctx, "reader", channel.ID)
+	if err != nil || chat.SubscriberCount != 1 || slices.Contains(chat.Participants, "reader") {
+		t.Fatalf("Subscribe = %+v, %v; want one subscriber outside participants", chat, err)
+	}
+
+	first, err := server.SendMessage(ctx, "editor", channel.ID, "first post", nil)
+	if err != nil {
+		t.Fatalf("admin posts: %v", err)
+	}
+	second, _ := server.SendMessage(ctx, "owner", channel.ID, "second post", nil)
+	if _, err := server.SendMessage(ctx, "reader", channel.ID, "hi", nil); err == nil {
+		t.Error("subscriber posted to the channel")
+	}
+
+	if err := server.ViewPosts(ctx, "reader", channel.ID, second.ID); err != nil {
+		t.Fatalf("ViewPosts: %v", err)
+	}
+	server.ViewPosts(ctx, "reader", channel.ID, second.ID)
+	server.ViewPosts(ctx, "editor", channel.ID, second.ID)
+	for _, id := range []string{first.ID, second.ID} {
+		if msg, _ := store.Messages().Get(ctx, channel.ID, id); msg.Views != 1 {
+			t.Errorf("post %s has %d views, want 1", id, msg.Views)
+		}
+	}
+
+	store.Chats().SetHandle(ctx, channel.ID, "")
+	if err := server.Unsubscribe(ctx, "reader", channel.ID); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if err := server.Unsubscribe(ctx, "reader", channel.ID); !errors.Is(err, ErrNotSubscribed) {
+		t.Errorf("second Unsubscribe: err = %v, want ErrNotSubscribed", err)
+	}
+	if _, err := server.Subscribe(ctx, "reader", channel.ID); !errors.Is(err, ErrPrivateChannel) {
+		t.Errorf("private channel: err = %v, want ErrPrivateChannel", err)
+	}
+}